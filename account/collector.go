@@ -0,0 +1,147 @@
+package account
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shopspring/decimal"
+)
+
+// BalanceBuckets are default upper bounds of balance distribution.
+var BalanceBuckets = []float64{0, 10, 100, 1000, 10000, 100000, 1000000}
+
+type balanceCollector struct {
+	accounts Repository
+	buckets  []float64
+	timeout  time.Duration
+	interval time.Duration
+
+	total   *prometheus.Desc
+	balance *prometheus.Desc
+
+	mtx      sync.Mutex
+	metrics  []prometheus.Metric
+	loadedAt time.Time
+}
+
+// CollectorOption sets an optional parameter for balance collector.
+type CollectorOption func(*balanceCollector)
+
+// CollectorInterval sets minimal pause between loads of accounts, scrapes in between report the last loaded
+// balances. Zero makes every scrape load them.
+func CollectorInterval(d time.Duration) CollectorOption {
+	return func(c *balanceCollector) { c.interval = d }
+}
+
+// NewBalanceCollector returns a Prometheus collector, which reports balances of accounts: total money in the system,
+// including deleted accounts, and distribution of balances of not deleted ones, labelled by currency. Transfers
+// must not change the total, so any drift of it is a bug. Accounts are loaded at scrape time, at most once per
+// interval.
+func NewBalanceCollector(namespace string, accounts Repository, buckets []float64,
+	options ...CollectorOption) prometheus.Collector {
+	c := &balanceCollector{
+		accounts: accounts,
+		buckets:  buckets,
+		timeout:  5 * time.Second,
+		interval: 10 * time.Second,
+		total: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "money_total"),
+			"Total money on all accounts.", []string{"currency"}, nil),
+		balance: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "account_balance"),
+			"Distribution of account balances.", []string{"currency"}, nil),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Describe sends descriptors of metrics.
+func (c *balanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+	ch <- c.balance
+}
+
+// Collect sends metrics of balances, loading accounts if the last load is older than interval. Concurrent scrapes
+// wait for one load.
+func (c *balanceCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.metrics == nil || time.Since(c.loadedAt) >= c.interval {
+		metrics, err := c.load()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.total, err)
+			return
+		}
+		c.metrics, c.loadedAt = metrics, time.Now()
+	}
+	for _, val := range c.metrics {
+		ch <- val
+	}
+}
+
+// load returns metrics of balances of all accounts.
+func (c *balanceCollector) load() ([]prometheus.Metric, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	type distribution struct {
+		count   uint64
+		sum     decimal.Decimal
+		total   decimal.Decimal
+		buckets map[float64]uint64
+	}
+	accounts, err := c.accounts.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := c.accounts.FindDeleted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byCurrency := make(map[Currency]*distribution)
+	get := func(currency Currency) *distribution {
+		d, ok := byCurrency[currency]
+		if !ok {
+			d = &distribution{buckets: make(map[float64]uint64, len(c.buckets))}
+			byCurrency[currency] = d
+		}
+		return d
+	}
+	for _, a := range accounts {
+		d := get(a.Currency)
+		val, _ := a.Balance.Float64()
+		d.count++
+		d.sum = d.sum.Add(a.Balance)
+		d.total = d.total.Add(a.Balance)
+		for _, bound := range c.buckets {
+			if val <= bound {
+				d.buckets[bound]++
+			}
+		}
+	}
+	// Money of deleted accounts is still in the system, until they are purged.
+	for _, a := range deleted {
+		d := get(a.Currency)
+		d.total = d.total.Add(a.Balance)
+	}
+
+	currencies := make([]string, 0, len(byCurrency))
+	for currency := range byCurrency {
+		currencies = append(currencies, string(currency))
+	}
+	sort.Strings(currencies)
+	metrics := make([]prometheus.Metric, 0, 2*len(currencies))
+	for _, currency := range currencies {
+		d := byCurrency[Currency(currency)]
+		total, _ := d.total.Float64()
+		sum, _ := d.sum.Float64()
+		metrics = append(metrics,
+			prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, total, currency),
+			prometheus.MustNewConstHistogram(c.balance, d.count, sum, d.buckets, currency))
+	}
+	return metrics, nil
+}
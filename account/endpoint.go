@@ -6,6 +6,7 @@ import (
 	"github.com/asaskevich/govalidator"
 
 	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/shopspring/decimal"
@@ -30,15 +31,22 @@ type idField struct {
 }
 
 type newAccountRequest struct {
-	ID       ID              `json:"id" valid:"alphanum,required,stringlength(1|255)"`
-	Currency Currency        `json:"currency" valid:"in(USD)"`
-	Balance  decimal.Decimal `json:"balance,omitempty" valid:"decimal"`
+	ID          ID                `json:"id" valid:"alphanum,required,stringlength(1|255)"`
+	Currency    Currency          `json:"currency" valid:"in(USD)"`
+	Balance     decimal.Decimal   `json:"balance,omitempty" valid:"decimal"`
+	Name        string            `json:"name,omitempty" valid:"stringlength(0|255),optional"`
+	Description string            `json:"description,omitempty" valid:"stringlength(0|1000),optional"`
+	Reference   string            `json:"reference,omitempty" valid:"stringlength(0|255),optional"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty" valid:"-"`
+	Parent      ID                `json:"parent,omitempty" valid:"alphanum,stringlength(1|255),optional"`
 }
 
 func makeNewAccountEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(newAccountRequest)
-		err := s.New(req.ID, req.Currency, req.Balance)
+		details := Details{Name: req.Name, Description: req.Description, Reference: req.Reference,
+			Metadata: req.Metadata, Parent: req.Parent}
+		err := s.New(ctx, req.ID, req.Currency, req.Balance, details)
 		return errs.ErrorOnlyResponse{Err: err}, nil
 	}
 }
@@ -53,14 +61,30 @@ func (r loadAccountResponse) ErrError() error { return r.Err }
 func makeLoadAccountEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(idField)
-		a, err := s.Load(req.ID)
+		a, err := s.Load(ctx, req.ID)
 		return loadAccountResponse{Account: a, Err: err}, nil
 	}
 }
 
+type loadAllAccountsRequest struct {
+	Filter Filter
+}
+
 func makeLoadAllAccountsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		r := s.LoadAll()
+		req := request.(loadAllAccountsRequest)
+		var (
+			r   []*Account
+			err error
+		)
+		if len(req.Filter.Metadata) > 0 {
+			r, err = s.Search(ctx, req.Filter)
+		} else {
+			r, err = s.LoadAll(ctx)
+		}
+		if err != nil {
+			return errs.ErrorOnlyResponse{Err: err}, nil
+		}
 		return r, nil
 	}
 }
@@ -68,7 +92,36 @@ func makeLoadAllAccountsEndpoint(s Service) endpoint.Endpoint {
 func makeDeleteAccountEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(idField)
-		err := s.Delete(req.ID)
+		err := s.Delete(ctx, req.ID)
 		return errs.ErrorOnlyResponse{Err: err}, nil
 	}
 }
+
+type loadTreeResponse struct {
+	Tree *Tree `json:"tree,omitempty"`
+	Err  error `json:"error,omitempty"`
+}
+
+func (r loadTreeResponse) ErrError() error { return r.Err }
+
+func makeLoadTreeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(idField)
+		t, err := s.Tree(ctx, req.ID)
+		return loadTreeResponse{Tree: t, Err: err}, nil
+	}
+}
+
+type updateAccountRequest struct {
+	ID      ID
+	Patch   Patch
+	Version int64
+}
+
+func makeUpdateAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateAccountRequest)
+		a, err := s.Update(ctx, req.ID, req.Patch, req.Version)
+		return loadAccountResponse{Account: a, Err: err}, nil
+	}
+}
@@ -1,9 +1,12 @@
 package account
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/tracing"
 	"github.com/shopspring/decimal"
 )
 
@@ -18,54 +21,158 @@ func NewLoggingService(logger log.Logger, s Service) Service {
 }
 
 // New is logging wrapper for new account creation.
-func (s *loggingService) New(id ID, currency Currency, balance decimal.Decimal) (err error) {
+func (s *loggingService) New(ctx context.Context, id ID, currency Currency, balance decimal.Decimal,
+	details Details) (err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "new",
 			"id", id,
 			"currency", currency,
 			"balance", balance,
+			"reference", details.Reference,
+			"parent", details.Parent,
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
 			"err", err,
 		)
 	}(time.Now())
-	return s.Service.New(id, currency, balance)
+	return s.Service.New(ctx, id, currency, balance, details)
 }
 
 // Load is logging wrapper for load account.
-func (s *loggingService) Load(id ID) (a *Account, err error) {
+func (s *loggingService) Load(ctx context.Context, id ID) (a *Account, err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "load",
 			"id", id,
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
 			"err", err,
 		)
 	}(time.Now())
-	return s.Service.Load(id)
+	return s.Service.Load(ctx, id)
 }
 
 // LoadAll is logging wrapper for load all accounts.
-func (s *loggingService) LoadAll() (r []*Account) {
+func (s *loggingService) LoadAll(ctx context.Context) (r []*Account, err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "loadAll",
 			"len", len(r),
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.LoadAll(ctx)
+}
+
+// Search is logging wrapper for search accounts.
+func (s *loggingService) Search(ctx context.Context, filter Filter) (r []*Account, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "search",
+			"metadata", len(filter.Metadata),
+			"len", len(r),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
 		)
 	}(time.Now())
-	return s.Service.LoadAll()
+	return s.Service.Search(ctx, filter)
 }
 
 // Delete is logging wrapper for delete account (mark it deleted).
-func (s *loggingService) Delete(id ID) (err error) {
+func (s *loggingService) Delete(ctx context.Context, id ID) (err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "delete",
 			"id", id,
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Delete(ctx, id)
+}
+
+// Tree is logging wrapper for load tree of accounts.
+func (s *loggingService) Tree(ctx context.Context, id ID) (t *Tree, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "tree",
+			"id", id,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Tree(ctx, id)
+}
+
+// LoadDeleted is logging wrapper for load deleted accounts.
+func (s *loggingService) LoadDeleted(ctx context.Context) (r []*Account, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "loadDeleted",
+			"len", len(r),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.LoadDeleted(ctx)
+}
+
+// Restore is logging wrapper for restore deleted account.
+func (s *loggingService) Restore(ctx context.Context, id ID) (a *Account, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "restore",
+			"id", id,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Restore(ctx, id)
+}
+
+// Unfreeze is logging wrapper for unfreeze account.
+func (s *loggingService) Unfreeze(ctx context.Context, id ID) (a *Account, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "unfreeze",
+			"id", id,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Unfreeze(ctx, id)
+}
+
+// Update is logging wrapper for update account.
+func (s *loggingService) Update(ctx context.Context, id ID, patch Patch, version int64) (a *Account, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "update",
+			"id", id,
+			"version", version,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
 			"err", err,
 		)
 	}(time.Now())
-	return s.Service.Delete(id)
+	return s.Service.Update(ctx, id, patch, version)
 }
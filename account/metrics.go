@@ -1,63 +1,121 @@
 package account
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
 	"github.com/shopspring/decimal"
 )
 
+// Metrics are instruments of account service.
+type Metrics struct {
+	// RequestCount counts calls of service methods, labelled by method.
+	RequestCount metrics.Counter
+	// RequestLatency observes duration of calls in seconds, labelled by method.
+	RequestLatency metrics.Histogram
+	// Created counts successfully created accounts, labelled by currency.
+	Created metrics.Counter
+	// Deleted counts successfully deleted accounts.
+	Deleted metrics.Counter
+}
+
 type metricsService struct {
-	requestCount   metrics.Counter
-	requestLatency metrics.Histogram
+	Metrics
 	Service
 }
 
 // NewMetricsService returns an instance of a metrics Service.
-func NewMetricsService(counter metrics.Counter, latency metrics.Histogram, s Service) Service {
+func NewMetricsService(m Metrics, s Service) Service {
 	return &metricsService{
-		requestCount:   counter,
-		requestLatency: latency,
-		Service:        s,
+		Metrics: m,
+		Service: s,
+	}
+}
+
+func (s *metricsService) observe(method string, begin time.Time) {
+	s.RequestCount.With("method", method).Add(1)
+	s.RequestLatency.With("method", method).Observe(time.Since(begin).Seconds())
+}
+
+// New is metrics wrapper for new account creation.
+func (s *metricsService) New(ctx context.Context, id ID, currency Currency, balance decimal.Decimal,
+	details Details) error {
+	defer s.observe("new", time.Now())
+
+	err := s.Service.New(ctx, id, currency, balance, details)
+	if err == nil {
+		if currency == "" {
+			currency = CurrencyUSD
+		}
+		s.Created.With("currency", string(currency)).Add(1)
+	}
+	return err
+}
+
+// Load is metrics wrapper for load account.
+func (s *metricsService) Load(ctx context.Context, id ID) (*Account, error) {
+	defer s.observe("load", time.Now())
+
+	return s.Service.Load(ctx, id)
+}
+
+// LoadAll is metrics wrapper for load all accounts.
+func (s *metricsService) LoadAll(ctx context.Context) ([]*Account, error) {
+	defer s.observe("loadAll", time.Now())
+
+	return s.Service.LoadAll(ctx)
+}
+
+// Search is metrics wrapper for search accounts.
+func (s *metricsService) Search(ctx context.Context, filter Filter) ([]*Account, error) {
+	defer s.observe("search", time.Now())
+
+	return s.Service.Search(ctx, filter)
+}
+
+// Delete is metrics wrapper for delete account (mark it deleted).
+func (s *metricsService) Delete(ctx context.Context, id ID) error {
+	defer s.observe("delete", time.Now())
+
+	err := s.Service.Delete(ctx, id)
+	if err == nil {
+		s.Deleted.Add(1)
 	}
+	return err
+}
+
+// Tree is metrics wrapper for load tree of accounts.
+func (s *metricsService) Tree(ctx context.Context, id ID) (*Tree, error) {
+	defer s.observe("tree", time.Now())
+
+	return s.Service.Tree(ctx, id)
 }
 
-// New is logging wrapper for new account creation.
-func (s *metricsService) New(id ID, currency Currency, balance decimal.Decimal) error {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "new").Add(1)
-		s.requestLatency.With("method", "new").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// LoadDeleted is metrics wrapper for load deleted accounts.
+func (s *metricsService) LoadDeleted(ctx context.Context) ([]*Account, error) {
+	defer s.observe("loadDeleted", time.Now())
 
-	return s.Service.New(id, currency, balance)
+	return s.Service.LoadDeleted(ctx)
 }
 
-// Load is logging wrapper for load account.
-func (s *metricsService) Load(id ID) (*Account, error) {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "load").Add(1)
-		s.requestLatency.With("method", "load").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// Restore is metrics wrapper for restore deleted account.
+func (s *metricsService) Restore(ctx context.Context, id ID) (*Account, error) {
+	defer s.observe("restore", time.Now())
 
-	return s.Service.Load(id)
+	return s.Service.Restore(ctx, id)
 }
 
-// LoadAll is logging wrapper for load all accounts.
-func (s *metricsService) LoadAll() []*Account {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "loadAll").Add(1)
-		s.requestLatency.With("method", "loadAll").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// Unfreeze is metrics wrapper for unfreeze account.
+func (s *metricsService) Unfreeze(ctx context.Context, id ID) (*Account, error) {
+	defer s.observe("unfreeze", time.Now())
 
-	return s.Service.LoadAll()
+	return s.Service.Unfreeze(ctx, id)
 }
 
-// Delete is logging wrapper for delete account (mark it deleted).
-func (s *metricsService) Delete(id ID) error {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "delete").Add(1)
-		s.requestLatency.With("method", "delete").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// Update is metrics wrapper for update account.
+func (s *metricsService) Update(ctx context.Context, id ID, patch Patch, version int64) (*Account, error) {
+	defer s.observe("update", time.Now())
 
-	return s.Service.Delete(id)
+	return s.Service.Update(ctx, id, patch, version)
 }
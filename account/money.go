@@ -0,0 +1,112 @@
+package account
+
+import (
+	"errors"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/errs"
+	"github.com/shopspring/decimal"
+)
+
+// Stored amounts have up to MaxPrecision digits, MaxScale of them after the decimal point, see decimal(16,4) columns.
+const (
+	MaxPrecision = 16
+	MaxScale     = 4
+)
+
+// Rounding is a policy for amounts with more decimal places than their currency has.
+type Rounding string
+
+const (
+	// RoundReject rejects such amounts with validation error.
+	RoundReject Rounding = "reject"
+	// RoundHalfUp rounds half away from zero.
+	RoundHalfUp Rounding = "half_up"
+	// RoundHalfEven rounds half to the even digit (banker's rounding).
+	RoundHalfEven Rounding = "half_even"
+	// RoundDown truncates extra places.
+	RoundDown Rounding = "down"
+)
+
+// scales are numbers of decimal places (minor units, ISO 4217) of currencies.
+var scales = map[Currency]int32{
+	CurrencyUSD: 2,
+}
+
+// maxAmount is the least amount, which does not fit into storage.
+var maxAmount = decimal.New(1, MaxPrecision-MaxScale)
+
+// Scale returns number of decimal places of amounts in currency, MaxScale for unknown currencies.
+func (c Currency) Scale() int32 {
+	if scale, ok := scales[c]; ok {
+		return scale
+	}
+	return MaxScale
+}
+
+// Round returns amount rounded to scale by rounding, ok is false if amount must be rejected. Empty rounding rejects
+// like RoundReject.
+func (r Rounding) Round(amount decimal.Decimal, scale int32) (rounded decimal.Decimal, ok bool) {
+	if amount.Exponent() >= -scale {
+		return amount, true
+	}
+	switch r {
+	case RoundHalfUp:
+		return amount.Round(scale), true
+	case RoundHalfEven:
+		return amount.RoundBank(scale), true
+	case RoundDown:
+		return amount.Truncate(scale), true
+	}
+	// Trailing zeros are not extra places.
+	if truncated := amount.Truncate(scale); truncated.Equal(amount) {
+		return truncated, true
+	}
+	return amount, false
+}
+
+// Storable reports whether amount fits into storage without rounding.
+func Storable(amount decimal.Decimal) bool {
+	_, ok := RoundReject.Round(amount, MaxScale)
+	return ok && amount.Abs().LessThan(maxAmount)
+}
+
+// CheckAmount returns amount of money in currency rounded by r, or validation error of field if amount is not
+// positive, has more decimal places than currency and rounding allow, or does not fit into storage.
+func (r Rounding) CheckAmount(field string, amount decimal.Decimal, currency Currency) (decimal.Decimal, error) {
+	return validMoney(r.checkMoney(field, amount, currency, true))
+}
+
+// CheckBalance is CheckAmount for balances, which may be zero.
+func (r Rounding) CheckBalance(field string, balance decimal.Decimal, currency Currency) (decimal.Decimal, error) {
+	return validMoney(r.checkMoney(field, balance, currency, false))
+}
+
+func validMoney(amount decimal.Decimal, problem *govalidator.Error) (decimal.Decimal, error) {
+	if problem != nil {
+		return amount, errs.ValidationError{Err: govalidator.Errors{*problem}}
+	}
+	return amount, nil
+}
+
+// checkMoney returns rounded amount, or problem of field. Zero amount is valid unless positive is required.
+func (r Rounding) checkMoney(field string, amount decimal.Decimal, currency Currency, positive bool) (decimal.Decimal,
+	*govalidator.Error) {
+	invalid := func(validator, message string) (decimal.Decimal, *govalidator.Error) {
+		return amount, &govalidator.Error{Name: field, Validator: validator, Err: errors.New(message)}
+	}
+	switch {
+	case positive && amount.Sign() <= 0:
+		return invalid("positive", "must be positive")
+	case amount.Sign() < 0:
+		return invalid("nonnegative", "must not be negative")
+	}
+	rounded, ok := r.Round(amount, currency.Scale())
+	if !ok {
+		return invalid("scale", "has more decimal places than currency has")
+	}
+	if !rounded.LessThan(maxAmount) {
+		return invalid("overflow", "is too large")
+	}
+	return rounded, nil
+}
@@ -0,0 +1,93 @@
+package account
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Purger periodically erases accounts, which were deleted longer than horizon ago. Until then deleted accounts may
+// be restored and their ids are not used again.
+type Purger struct {
+	repository Repository
+	horizon    time.Duration
+	logger     log.Logger
+	interval   time.Duration
+	timeout    time.Duration
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// PurgerOption sets an optional parameter for purger.
+type PurgerOption func(*Purger)
+
+// PurgerInterval sets pause between runs.
+func PurgerInterval(d time.Duration) PurgerOption {
+	return func(p *Purger) { p.interval = d }
+}
+
+// PurgerTimeout sets deadline of a run.
+func PurgerTimeout(d time.Duration) PurgerOption {
+	return func(p *Purger) { p.timeout = d }
+}
+
+// NewPurger returns a new purger of accounts deleted longer than horizon ago. Call Start to begin runs.
+func NewPurger(repository Repository, horizon time.Duration, logger log.Logger, options ...PurgerOption) *Purger {
+	p := &Purger{
+		repository: repository,
+		horizon:    horizon,
+		logger:     logger,
+		interval:   time.Hour,
+		timeout:    time.Minute,
+		quit:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// Start runs purge in background, the first run is immediate.
+func (p *Purger) Start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = p.Run()
+			select {
+			case <-p.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop terminates runs and waits for the current one to finish.
+func (p *Purger) Stop() {
+	p.once.Do(func() { close(p.quit) })
+	p.wg.Wait()
+}
+
+// Run purges accounts deleted longer than horizon ago once and returns their ids.
+func (p *Purger) Run() ([]ID, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	begin := time.Now()
+	ids, err := p.repository.Purge(ctx, begin.Add(-p.horizon))
+	if err != nil {
+		_ = p.logger.Log("method", "purge", "err", err)
+		return nil, err
+	}
+	if len(ids) > 0 {
+		_ = p.logger.Log("method", "purge", "accounts", len(ids), "ids", ids, "took", time.Since(begin))
+	}
+	return ids, nil
+}
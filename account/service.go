@@ -2,6 +2,14 @@
 package account
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
 	"github.com/shopspring/decimal"
 )
 
@@ -12,6 +20,16 @@ const (
 	CurrencyUSD Currency = "USD"
 )
 
+// Status of account regarding transfers.
+type Status string
+
+const (
+	// StatusActive account sends and receives transfers.
+	StatusActive Status = "active"
+	// StatusFrozen account neither sends nor receives transfers.
+	StatusFrozen Status = "frozen"
+)
+
 // ID type used for accounts identification.
 type ID string
 
@@ -22,42 +40,258 @@ type Account struct {
 	Balance   decimal.Decimal `json:"balance" sql:"balance,notnull,type:'decimal(16,4)'"`
 	Currency  Currency        `json:"currency" sql:"currency,notnull,type:varchar(3)"`
 	Deleted   bool            `json:"-" sql:"deleted,notnull"`
+	// DeletedAt is a time of deletion, deleted account may be restored until it is purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" sql:"deleted_at"`
+	Name      string     `json:"name,omitempty" sql:"name,notnull,default:''"`
+	Status    Status     `json:"status" sql:"status,notnull,type:varchar(16),default:'active'"`
+	// TransferLimit is a maximum amount of one outgoing transfer, there is no limit if it is nil.
+	TransferLimit *decimal.Decimal `json:"transfer_limit,omitempty" sql:"transfer_limit,type:'decimal(16,4)'"`
+	// TreeTransferLimit is a maximum amount of one outgoing transfer to an account of the same tree, TransferLimit
+	// applies to such transfers if it is nil.
+	TreeTransferLimit *decimal.Decimal `json:"tree_transfer_limit,omitempty" sql:"tree_transfer_limit,type:'decimal(16,4)'"`
+	// Parent is an account, which this one belongs to, in a tree of accounts. It is set at creation only.
+	Parent ID `json:"parent,omitempty" sql:"parent,type:varchar(255)"`
+	// Version is incremented by every update of the account, it is used as ETag for optimistic locking.
+	Version     int64             `json:"version" sql:"version,notnull,default:1"`
+	Description string            `json:"description,omitempty" sql:"description,notnull,default:''"`
+	Reference   string            `json:"reference,omitempty" sql:"reference,notnull,default:''"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty" sql:"metadata,type:jsonb"`
+	// InitialBalance is a balance before all payments of the account, used by reconciliation. It is kept by
+	// repositories, which store current balance (PostgreSQL stores initial one and calculates current by view).
+	InitialBalance decimal.Decimal `json:"-" sql:"-"`
+}
+
+// Details are optional data of a new account.
+type Details struct {
+	// Name is a name of account holder.
+	Name        string
+	Description string
+	// Reference is an identifier of account in client's system, e.g. customer ID.
+	Reference string
+	Metadata  metadata.Metadata
+	// Parent makes account a child of another one in a tree of accounts.
+	Parent ID
+}
+
+// Filter selects accounts, zero fields match any account.
+type Filter struct {
+	// Metadata selects accounts, which metadata contains all its keys with the same values.
+	Metadata metadata.Metadata
+}
+
+// Match reports whether account satisfies filter.
+func (f Filter) Match(a *Account) bool {
+	return a.Metadata.Contains(f.Metadata)
 }
 
 // Service is the interface that provides account methods.
 type Service interface {
 	// New registers a new account in the system, with desired Balance.
-	New(id ID, currency Currency, balance decimal.Decimal) error
+	New(ctx context.Context, id ID, currency Currency, balance decimal.Decimal, details Details) error
 
 	// Load returns a read model of an account.
-	Load(id ID) (*Account, error)
+	Load(ctx context.Context, id ID) (*Account, error)
 
 	// LoadAll returns all accounts registered in the system.
-	LoadAll() []*Account
+	LoadAll(ctx context.Context) ([]*Account, error)
 
-	// Delete uses to delete account from the system. Actually mark it as deleted.
-	Delete(id ID) error
+	// Search returns accounts registered in the system, which satisfy filter.
+	Search(ctx context.Context, filter Filter) ([]*Account, error)
+
+	// Delete uses to delete account from the system. Actually mark it as deleted. Account with not deleted
+	// children can not be deleted.
+	Delete(ctx context.Context, id ID) error
+
+	// Tree returns account with all its not deleted descendants and their total balances.
+	Tree(ctx context.Context, id ID) (*Tree, error)
+
+	// LoadDeleted returns deleted accounts, which are not purged yet and may be restored.
+	LoadDeleted(ctx context.Context) ([]*Account, error)
+
+	// Restore returns deleted account back to the system, with its balance and payments.
+	Restore(ctx context.Context, id ID) (*Account, error)
+
+	// Update changes mutable fields of an account by patch and returns the updated account. If version is not
+	// zero, account is changed only if it has the same version, otherwise errs.ErrVersionMismatch is returned.
+	// Frozen account can not be activated by patch, errs.ErrAccountFrozen is returned.
+	Update(ctx context.Context, id ID, patch Patch, version int64) (*Account, error)
+
+	// Unfreeze activates frozen account, e.g. after review of screening. It is not a part of the public API.
+	Unfreeze(ctx context.Context, id ID) (*Account, error)
+}
+
+// Patch is a change of mutable fields of an account, nil fields stay the same.
+type Patch struct {
+	Name          *string
+	Currency      *Currency
+	Status        *Status
+	TransferLimit *decimal.Decimal
+	// RemoveTransferLimit removes limit of transfers, TransferLimit is ignored then.
+	RemoveTransferLimit bool
+	Description         *string
+	Reference           *string
+	// Metadata sets values of keys, nil values remove keys. It is merged into metadata of account.
+	Metadata map[string]*string
+	// RemoveMetadata removes all keys of metadata before Metadata is merged.
+	RemoveMetadata    bool
+	TreeTransferLimit *decimal.Decimal
+	// RemoveTreeTransferLimit removes limit of transfers within the tree, TreeTransferLimit is ignored then.
+	RemoveTreeTransferLimit bool
+}
+
+// Apply changes fields of account by patch.
+func (p Patch) Apply(a *Account) {
+	if p.Name != nil {
+		a.Name = *p.Name
+	}
+	if p.Currency != nil {
+		a.Currency = *p.Currency
+	}
+	if p.Status != nil {
+		a.Status = *p.Status
+	}
+	if p.TransferLimit != nil {
+		limit := *p.TransferLimit
+		a.TransferLimit = &limit
+	}
+	if p.RemoveTransferLimit {
+		a.TransferLimit = nil
+	}
+	if p.TreeTransferLimit != nil {
+		limit := *p.TreeTransferLimit
+		a.TreeTransferLimit = &limit
+	}
+	if p.RemoveTreeTransferLimit {
+		a.TreeTransferLimit = nil
+	}
+	if p.Description != nil {
+		a.Description = *p.Description
+	}
+	if p.Reference != nil {
+		a.Reference = *p.Reference
+	}
+	if p.RemoveMetadata {
+		a.Metadata = nil
+	}
+	if len(p.Metadata) > 0 {
+		// Metadata of account may be shared with stored one, so it is replaced, not changed.
+		m := a.Metadata.Clone()
+		if m == nil {
+			m = make(metadata.Metadata, len(p.Metadata))
+		}
+		for k, v := range p.Metadata {
+			if v == nil {
+				delete(m, k)
+			} else {
+				m[k] = *v
+			}
+		}
+		if len(m) == 0 {
+			m = nil
+		}
+		a.Metadata = m
+	}
+}
+
+// Screening checks holders of accounts against a list of blocked parties, e.g. sanctions list.
+type Screening interface {
+	// ScreenAccount reports whether holder of new account matches the list, so account must be held for review.
+	ScreenAccount(ctx context.Context, a *Account) (bool, error)
 }
 
 type service struct {
-	accounts Repository
+	accounts  Repository
+	screening Screening
+	rounding  Rounding
+}
+
+// ServiceOption sets optional parameter of the service.
+type ServiceOption func(*service)
+
+// ServiceScreening screens holders of new accounts, matched accounts are frozen until review.
+func ServiceScreening(screening Screening) ServiceOption {
+	return func(s *service) {
+		s.screening = screening
+	}
 }
 
-// New registers a new account in the system, with zero Balance.
-func (s *service) New(id ID, currency Currency, balance decimal.Decimal) error {
+// ServiceRounding sets rounding of balances with more decimal places than currency has, they are rejected by default.
+func ServiceRounding(rounding Rounding) ServiceOption {
+	return func(s *service) {
+		s.rounding = rounding
+	}
+}
+
+// New registers a new account in the system, with zero Balance. Parent must be known, have the same currency and
+// be less than MaxDepth levels deep. Account, which holder matches screening list, is frozen.
+func (s *service) New(ctx context.Context, id ID, currency Currency, balance decimal.Decimal,
+	details Details) error {
 	if currency == "" {
 		currency = CurrencyUSD
 	}
-	return s.accounts.Store(&Account{
-		ID:       id,
-		Balance:  balance,
-		Currency: currency,
-	})
+	balance, err := s.rounding.CheckBalance("balance", balance, currency)
+	if err != nil {
+		return err
+	}
+	if details.Parent != "" {
+		if err := s.checkParent(ctx, details.Parent, currency); err != nil {
+			return err
+		}
+	}
+	a := &Account{
+		ID:          id,
+		Balance:     balance,
+		Currency:    currency,
+		Name:        details.Name,
+		Status:      StatusActive,
+		Version:     1,
+		Description: details.Description,
+		Reference:   details.Reference,
+		Metadata:    details.Metadata,
+		Parent:      details.Parent,
+	}
+	if s.screening != nil {
+		held, err := s.screening.ScreenAccount(ctx, a)
+		if err != nil {
+			return err
+		}
+		if held {
+			a.Status = StatusFrozen
+		}
+	}
+	return s.accounts.Store(ctx, a)
+}
+
+// checkParent returns error if account can not be a child of parent with id, e.g. if their currencies differ.
+// Repository checks again, that parent is not deleted, on Store.
+func (s *service) checkParent(ctx context.Context, id ID, currency Currency) error {
+	parent, err := s.accounts.Find(ctx, id)
+	if err == errs.ErrUnknownAccount {
+		return errs.ErrUnknownParentAccount
+	}
+	if err != nil {
+		return err
+	}
+	invalid := func(validator, message string) error {
+		return errs.ValidationError{Err: govalidator.Errors{govalidator.Error{Name: "parent", Validator: validator,
+			Err: errors.New(message)}}}
+	}
+	if parent.Currency != currency {
+		return invalid("currency", "must have the same currency")
+	}
+	path, err := Path(ctx, s.accounts, parent)
+	if err != nil {
+		return err
+	}
+	if len(path) >= MaxDepth {
+		return invalid("maxdepth", fmt.Sprintf("tree must be up to %d levels deep", MaxDepth))
+	}
+	return nil
 }
 
 // Load returns a read model of an account.
-func (s *service) Load(id ID) (*Account, error) {
-	a, err := s.accounts.Find(id)
+func (s *service) Load(ctx context.Context, id ID) (*Account, error) {
+	a, err := s.accounts.Find(ctx, id)
 	if err != nil {
 		return nil, err
 	}
@@ -65,33 +299,140 @@ func (s *service) Load(id ID) (*Account, error) {
 }
 
 // LoadAll returns all accounts registered in the system.
-func (s *service) LoadAll() []*Account {
-	return s.accounts.FindAll()
+func (s *service) LoadAll(ctx context.Context) ([]*Account, error) {
+	return s.accounts.FindAll(ctx)
+}
+
+// Search returns accounts registered in the system, which satisfy filter.
+func (s *service) Search(ctx context.Context, filter Filter) ([]*Account, error) {
+	return s.accounts.Search(ctx, filter)
 }
 
 // Delete uses to delete account from the system. Actually mark it as deleted.
-func (s *service) Delete(id ID) error {
-	return s.accounts.MarkDeleted(id)
+func (s *service) Delete(ctx context.Context, id ID) error {
+	return s.accounts.MarkDeleted(ctx, id)
+}
+
+// Tree returns account with all its not deleted descendants and their total balances.
+func (s *service) Tree(ctx context.Context, id ID) (*Tree, error) {
+	accounts, err := s.accounts.FindTree(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return NewTree(id, accounts), nil
+}
+
+// LoadDeleted returns deleted accounts, which are not purged yet and may be restored.
+func (s *service) LoadDeleted(ctx context.Context) ([]*Account, error) {
+	return s.accounts.FindDeleted(ctx)
+}
+
+// Restore returns deleted account back to the system, with its balance and payments.
+func (s *service) Restore(ctx context.Context, id ID) (*Account, error) {
+	return s.accounts.Restore(ctx, id)
+}
+
+// Update changes mutable fields of an account by patch and returns the updated account.
+func (s *service) Update(ctx context.Context, id ID, patch Patch, version int64) (*Account, error) {
+	return s.accounts.Update(ctx, id, func(a *Account) error {
+		if version != 0 && a.Version != version {
+			return errs.ErrVersionMismatch
+		}
+		// Account may be frozen by screening, only admin review unfreezes it.
+		if a.Status == StatusFrozen && patch.Status != nil && *patch.Status != StatusFrozen {
+			return errs.ErrAccountFrozen
+		}
+		patch.Apply(a)
+		// Keys of patch are valid, but together with kept ones they may exceed the limit.
+		if err := metadata.Validate(a.Metadata); err != nil {
+			return errs.ValidationError{Err: err}
+		}
+		// Limits are checked against currency, which may be changed by the same patch.
+		return s.roundLimits(a)
+	})
+}
+
+// Unfreeze activates frozen account, e.g. after review of screening. Active account stays as is.
+func (s *service) Unfreeze(ctx context.Context, id ID) (*Account, error) {
+	return s.accounts.Update(ctx, id, func(a *Account) error {
+		a.Status = StatusActive
+		return nil
+	})
+}
+
+// roundLimits rounds transfer limits of account by its currency and rounding of the service.
+func (s *service) roundLimits(a *Account) error {
+	var problems govalidator.Errors
+	round := func(name string, limit *decimal.Decimal) *decimal.Decimal {
+		if limit == nil {
+			return nil
+		}
+		rounded, problem := s.rounding.checkMoney(name, *limit, a.Currency, true)
+		if problem != nil {
+			problems = append(problems, *problem)
+		}
+		// Limit may be shared with stored account, so it is replaced, not changed.
+		return &rounded
+	}
+	a.TransferLimit = round("transfer_limit", a.TransferLimit)
+	a.TreeTransferLimit = round("tree_transfer_limit", a.TreeTransferLimit)
+	if len(problems) > 0 {
+		return errs.ValidationError{Err: problems}
+	}
+	return nil
 }
 
 // NewService creates an account service with necessary dependencies.
-func NewService(accounts Repository) Service {
-	return &service{
+func NewService(accounts Repository, options ...ServiceOption) Service {
+	s := &service{
 		accounts: accounts,
+		rounding: RoundReject,
 	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
 }
 
 // Repository interface for accounts storing and operations.
 type Repository interface {
-	// Store account in the repository
-	Store(account *Account) error
+	// Store account in the repository. Used id, of deleted account too, is reported with errs.ErrAccountExists
+	// until the deleted account is purged. Unknown or deleted parent is reported with errs.ErrUnknownParentAccount.
+	Store(ctx context.Context, account *Account) error
+
+	// Find account in the repository with specified id. Unknown or deleted account is reported with
+	// errs.ErrUnknownAccount.
+	Find(ctx context.Context, id ID) (*Account, error)
+
+	// FindAll returns all not deleted accounts registered in the system, ordered by id.
+	FindAll(ctx context.Context) ([]*Account, error)
+
+	// Search returns not deleted accounts, which satisfy filter, ordered by id.
+	Search(ctx context.Context, filter Filter) ([]*Account, error)
+
+	// MarkDeleted is mark as deleted specified account in the system, remembering time of deletion. Unknown or
+	// already deleted account is reported with errs.ErrUnknownAccount, account with not deleted children is
+	// reported with errs.ErrAccountHasChildren.
+	MarkDeleted(ctx context.Context, id ID) error
+
+	// FindTree returns account and all its not deleted descendants, ordered by id. Unknown or deleted account is
+	// reported with errs.ErrUnknownAccount.
+	FindTree(ctx context.Context, id ID) ([]*Account, error)
+
+	// FindDeleted returns deleted accounts, which are not purged yet, ordered by id.
+	FindDeleted(ctx context.Context) ([]*Account, error)
 
-	// Find account in the repository with specified id
-	Find(id ID) (*Account, error)
+	// Restore clears deletion mark of account and returns it. Unknown or not deleted account is reported with
+	// errs.ErrUnknownAccount, account with deleted or purged parent is reported with errs.ErrUnknownParentAccount.
+	Restore(ctx context.Context, id ID) (*Account, error)
 
-	// FindAll returns all accounts registered in the system
-	FindAll() []*Account
+	// Purge permanently erases accounts deleted before the time, together with their payments, and returns their
+	// ids. Incoming and outgoing payments of other accounts stay, so balances of other accounts do not change.
+	// Ids of purged accounts may be used again. Accounts without time of deletion are not purged.
+	Purge(ctx context.Context, before time.Time) ([]ID, error)
 
-	// MarkDeleted is mark as deleted specified account in the system
-	MarkDeleted(id ID) error
+	// Update calls fn for the current state of account and stores changes of its name, currency, status, transfer
+	// limits, description, reference and metadata, incrementing version. Account is locked while fn runs, nothing
+	// is changed if fn returns error. Unknown or deleted account is reported with errs.ErrUnknownAccount.
+	Update(ctx context.Context, id ID, fn func(a *Account) error) (*Account, error)
 }
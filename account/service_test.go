@@ -1,6 +1,7 @@
 package account_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -11,11 +12,15 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/google/go-cmp/cmp"
 	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
 	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/metadata"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/shopspring/decimal"
 )
 
@@ -48,6 +53,26 @@ const (
 	EndpointURL = "/api/accounts/v1/accounts"
 )
 
+func problem(status int, code errs.Code, title, detail string, invalidParams ...CaseResponse) CaseResponse {
+	p := CaseResponse{
+		"type":   errs.ProblemTypePrefix + string(code),
+		"title":  title,
+		"status": status,
+		"code":   code,
+	}
+	if detail != "" {
+		p["detail"] = detail
+	}
+	if len(invalidParams) > 0 {
+		p["invalid_params"] = invalidParams
+	}
+	return p
+}
+
+func invalidParam(name, rule, reason string) CaseResponse {
+	return CaseResponse{"name": name, "rule": rule, "reason": reason}
+}
+
 func TestAccountApi(t *testing.T) {
 	logger := log.NewLogfmtLogger(os.Stderr)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
@@ -58,8 +83,10 @@ func TestAccountApi(t *testing.T) {
 
 	handler := account.MakeHandler(as, httpLogger)
 
-	_ = accounts.Store(&account.Account{ID: "test1", Balance: decimal.NewFromFloat(1.23), Currency: "USD"})
-	_ = accounts.Store(&account.Account{ID: "test2", Currency: "USD"})
+	ctx := context.Background()
+	_ = accounts.Store(ctx, &account.Account{ID: "test1", Balance: decimal.NewFromFloat(1.23), Currency: "USD",
+		Status: account.StatusActive, Version: 1})
+	_ = accounts.Store(ctx, &account.Account{ID: "test2", Currency: "USD", Status: account.StatusActive, Version: 1})
 
 	cases := []Case{
 		{
@@ -80,12 +107,23 @@ func TestAccountApi(t *testing.T) {
 			Path:   EndpointURL,
 			Method: http.MethodPost,
 			Payload: CaseRequestPayload{
-				"id": account.ID("asd123"),
+				"id": account.ID("asd124"),
 			},
 			Status:    http.StatusOK,
 			Result:    CaseResponse{},
 			CheckRepo: true,
 		},
+		{
+			Name:   "new account:already exists",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"id": account.ID("asd123"),
+			},
+			Status: http.StatusConflict,
+			Result: problem(http.StatusConflict, errs.CodeAccountExists, "Account already exists",
+				"account already exists"),
+		},
 		{
 			Name:   "new account:validation:id required",
 			Path:   EndpointURL,
@@ -94,8 +132,9 @@ func TestAccountApi(t *testing.T) {
 				"balance":  decimal.NewFromFloat(99.99),
 				"currency": account.CurrencyUSD,
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "validation error: id: non zero value required"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("id", "required", "value is required")),
 		},
 		{
 			Name:   "new account:validation:id alphanumeric",
@@ -109,8 +148,9 @@ func TestAccountApi(t *testing.T) {
 				Name:   "id",
 				Values: []string{"abc-def", "abc_def", "фыва", "?^/"},
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "validation error: id: .*? does not validate as alphanum"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("id", "alphanum", "must contain only latin letters and digits")),
 		},
 		{
 			Name:   "new account:validation:id length",
@@ -121,9 +161,35 @@ func TestAccountApi(t *testing.T) {
 				"balance":  decimal.NewFromFloat(99.99),
 				"currency": account.CurrencyUSD,
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "validation error: id: " + strings.Repeat("abcd1234", 33) +
-				" does not validate as stringlength(1|255)"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("id", "stringlength", "has wrong length")),
+		},
+		{
+			Name:   "new account:validation:negative balance",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"id":       account.ID("asd125"),
+				"balance":  decimal.NewFromFloat(-1),
+				"currency": account.CurrencyUSD,
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("balance", "nonnegative", "must not be negative")),
+		},
+		{
+			Name:   "new account:validation:balance scale",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"id":       account.ID("asd125"),
+				"balance":  "99.999",
+				"currency": account.CurrencyUSD,
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("balance", "scale", "has too many decimal places for the currency")),
 		},
 		{
 			Name:   "delete account:normal flow",
@@ -148,6 +214,8 @@ func TestAccountApi(t *testing.T) {
 					"id":       "test1",
 					"balance":  1.23,
 					"currency": "USD",
+					"status":   "active",
+					"version":  1,
 				},
 			},
 		},
@@ -162,7 +230,7 @@ func TestAccountApi(t *testing.T) {
 			Path:   EndpointURL + "/qwe321",
 			Method: http.MethodGet,
 			Status: http.StatusNotFound,
-			Result: CaseResponse{"error": "unknown account"},
+			Result: problem(http.StatusNotFound, errs.CodeUnknownAccount, "Unknown account", "unknown account"),
 		},
 		{
 			Name:   "load all accounts",
@@ -170,8 +238,9 @@ func TestAccountApi(t *testing.T) {
 			Method: http.MethodGet,
 			Status: http.StatusOK,
 			Result: []CaseResponse{
-				{"id": "test1", "balance": 1.23, "currency": "USD"},
-				{"id": "test2", "balance": 0, "currency": "USD"},
+				{"id": "asd124", "balance": 0, "currency": "USD", "status": "active", "version": 1},
+				{"id": "test1", "balance": 1.23, "currency": "USD", "status": "active", "version": 1},
+				{"id": "test2", "balance": 0, "currency": "USD", "status": "active", "version": 1},
 			},
 		},
 	}
@@ -187,11 +256,12 @@ func TestAccountApi(t *testing.T) {
 		rr := httptest.NewRecorder()
 		handler.ServeHTTP(rr, req)
 
-		if status := rr.Code; status != http.StatusInternalServerError {
+		if status := rr.Code; status != http.StatusBadRequest {
 			t.Errorf("handler returned wrong status code: got %v want %v", status,
-				http.StatusInternalServerError)
+				http.StatusBadRequest)
 		}
-		expectedBody := `{"error":"unexpected EOF"}`
+		expectedBody := `{"type":"urn:payments:problem:malformed_request","title":"Malformed request",` +
+			`"status":400,"detail":"unexpected EOF","code":"malformed_request"}`
 		if strings.TrimSpace(rr.Body.String()) != expectedBody {
 			t.Errorf("handler returned wrong body: got %v want %v", rr.Body.String(), expectedBody)
 		}
@@ -270,12 +340,14 @@ func runTests(t *testing.T, handler http.Handler, cases []Case, repository accou
 
 				if item.CheckRepo {
 					payloadStruct := item.Payload.(CaseRequestPayload)
-					a, _ := repository.Find(payloadStruct["id"].(account.ID))
+					a, _ := repository.Find(context.Background(), payloadStruct["id"].(account.ID))
 					expectedAcc := &account.Account{
 						ID:       payloadStruct["id"].(account.ID),
 						Balance:  decimal.NewFromFloat(0.0),
 						Currency: account.CurrencyUSD,
 						Deleted:  false,
+						Status:   account.StatusActive,
+						Version:  1,
 					}
 					if payloadStruct["balance"] != nil {
 						expectedAcc.Balance = payloadStruct["balance"].(decimal.Decimal)
@@ -283,6 +355,7 @@ func runTests(t *testing.T, handler http.Handler, cases []Case, repository accou
 					if payloadStruct["currency"] != nil {
 						expectedAcc.Currency = payloadStruct["currency"].(account.Currency)
 					}
+					expectedAcc.InitialBalance = expectedAcc.Balance
 					if !cmp.Equal(a, expectedAcc) {
 						t.Errorf("[%s] store returned not equal account: got %v want %v", caseName, a, expectedAcc)
 					}
@@ -291,3 +364,477 @@ func runTests(t *testing.T, handler http.Handler, cases []Case, repository accou
 		})
 	}
 }
+
+func TestBalanceCollector(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(5.5), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Balance: decimal.NewFromFloat(150), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Balance: decimal.NewFromFloat(1), Currency: "USD"}))
+	OK(t, accounts.MarkDeleted(ctx, "carol"))
+
+	collector := account.NewBalanceCollector("payments", accounts, []float64{10, 100})
+	expected := `
+# HELP payments_money_total Total money on all accounts.
+# TYPE payments_money_total gauge
+payments_money_total{currency="USD"} 156.5
+# HELP payments_account_balance Distribution of account balances.
+# TYPE payments_account_balance histogram
+payments_account_balance_bucket{currency="USD",le="10"} 1
+payments_account_balance_bucket{currency="USD",le="100"} 1
+payments_account_balance_bucket{currency="USD",le="+Inf"} 2
+payments_account_balance_sum{currency="USD"} 155.5
+payments_account_balance_count{currency="USD"} 2
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// Accounts are loaded once per interval.
+	OK(t, accounts.Store(ctx, &account.Account{ID: "dave", Balance: decimal.NewFromFloat(1), Currency: "USD"}))
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+	collector = account.NewBalanceCollector("payments", accounts, []float64{10, 100}, account.CollectorInterval(0))
+	expected = `
+# HELP payments_money_total Total money on all accounts.
+# TYPE payments_money_total gauge
+payments_money_total{currency="USD"} 157.5
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "payments_money_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateAccount(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	handler := account.MakeHandler(account.NewService(accounts), log.NewNopLogger())
+	ctx := context.Background()
+	OK(t, account.NewService(accounts).New(ctx, "alice", "", decimal.NewFromFloat(10), account.Details{}))
+
+	for _, item := range []struct {
+		Name    string
+		IfMatch string
+		Body    string
+		Status  int
+		ETag    string
+		Result  string
+	}{
+		{
+			Name:    "update:normal flow",
+			IfMatch: `"1"`,
+			Body:    `{"name":"Alice","status":"frozen","transfer_limit":100}`,
+			Status:  http.StatusOK,
+			ETag:    `"2"`,
+			Result: `{"account":{"id":"alice","balance":10,"currency":"USD","name":"Alice","status":"frozen",
+				"transfer_limit":100,"version":2}}`,
+		},
+		{
+			Name:    "update:stale version",
+			IfMatch: `"1"`,
+			Body:    `{"status":"active"}`,
+			Status:  http.StatusPreconditionFailed,
+			Result: `{"type":"urn:payments:problem:version_mismatch","title":"Account was changed by another request",
+				"status":412,"detail":"account was changed, version does not match","code":"version_mismatch"}`,
+		},
+		{
+			Name:    "update:malformed entity tag",
+			IfMatch: `W/"2"`,
+			Body:    `{"status":"active"}`,
+			Status:  http.StatusPreconditionFailed,
+		},
+		{
+			Name:   "update:if-match is required",
+			Body:   `{"status":"active"}`,
+			Status: http.StatusPreconditionRequired,
+			Result: `{"type":"urn:payments:problem:precondition_required","title":"Version of account is required",
+				"status":428,"detail":"If-Match header is required","code":"precondition_required"}`,
+		},
+		{
+			Name:    "update:null removes name and limit, absent fields stay",
+			IfMatch: `"2"`,
+			Body:    `{"name":null,"transfer_limit":null}`,
+			Status:  http.StatusOK,
+			ETag:    `"3"`,
+			Result:  `{"account":{"id":"alice","balance":10,"currency":"USD","status":"frozen","version":3}}`,
+		},
+		{
+			Name:    "update:frozen account can not be activated",
+			IfMatch: `"3"`,
+			Body:    `{"status":"active"}`,
+			Status:  http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:account_frozen","title":"Account is frozen","status":422,
+				"detail":"account is frozen","code":"account_frozen"}`,
+		},
+		{
+			Name:    "update:any version",
+			IfMatch: "*",
+			Body:    `{"description":"Savings"}`,
+			Status:  http.StatusOK,
+			ETag:    `"4"`,
+		},
+		{
+			Name:    "update:invalid fields",
+			IfMatch: "*",
+			Body:    `{"balance":1,"currency":null,"status":"closed","transfer_limit":0}`,
+			Status:  http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"balance","rule":"readonly","reason":"can not be changed"},
+				{"name":"currency","rule":"required","reason":"value is required"},
+				{"name":"status","rule":"in","reason":"is not one of allowed values"},
+				{"name":"transfer_limit","rule":"positive","reason":"must be positive"}]}`,
+		},
+		{
+			Name:    "update:limit has too many decimal places",
+			IfMatch: "*",
+			Body:    `{"transfer_limit":0.001}`,
+			Status:  http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"transfer_limit","rule":"scale","reason":"has too many decimal places for the currency"}]}`,
+		},
+		{
+			Name:    "update:not an object",
+			IfMatch: "*",
+			Body:    `[]`,
+			Status:  http.StatusBadRequest,
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, EndpointURL+"/alice", strings.NewReader(item.Body))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			if item.IfMatch != "" {
+				req.Header.Set("If-Match", item.IfMatch)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if etag := rr.Header().Get("ETag"); etag != item.ETag {
+				t.Errorf("wrong ETag: got %s want %s", etag, item.ETag)
+			}
+			if item.Result == "" {
+				return
+			}
+			var got, want interface{}
+			OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			OK(t, json.Unmarshal([]byte(item.Result), &want))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("wrong body (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, EndpointURL+"/alice", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if etag := rr.Header().Get("ETag"); etag != `"4"` {
+		t.Errorf("load must return ETag of the current version, got %s", etag)
+	}
+}
+
+func TestAccountTree(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	handler := account.MakeHandler(account.NewService(accounts), log.NewNopLogger())
+	ctx := context.Background()
+	OK(t, account.NewService(accounts).New(ctx, "root", "", decimal.NewFromFloat(10), account.Details{}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "euro", Currency: "EUR", Status: account.StatusActive, Version: 1}))
+
+	for _, item := range []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Result string
+	}{
+		{
+			Name:   "new child:unknown parent",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"id":"kid","parent":"nobody"}`,
+			Status: http.StatusNotFound,
+			Result: `{"type":"urn:payments:problem:unknown_parent_account","title":"Unknown parent account",
+				"status":404,"detail":"unknown parent account","code":"unknown_parent_account"}`,
+		},
+		{
+			Name:   "new child:other currency",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"id":"kid","parent":"euro"}`,
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"parent","rule":"currency","reason":"must have the same currency"}]}`,
+		},
+		{
+			Name:   "new child:normal flow",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"id":"kid","parent":"root","balance":2}`,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "new grandchild:normal flow",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"id":"grandkid","parent":"kid","balance":3}`,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "tree:subtotals",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "/root/tree",
+			Status: http.StatusOK,
+			Result: `{"tree":{"account":{"id":"root","balance":10,"currency":"USD","status":"active","version":1},
+				"total":15,"children":[{"account":{"id":"kid","balance":2,"currency":"USD","status":"active",
+				"version":1,"parent":"root"},"total":5,"children":[{"account":{"id":"grandkid","balance":3,
+				"currency":"USD","status":"active","version":1,"parent":"kid"},"total":3}]}]}}`,
+		},
+		{
+			Name:   "tree:unknown account",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "/nobody/tree",
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "delete:parent with children",
+			Method: http.MethodDelete,
+			Path:   EndpointURL + "/kid",
+			Status: http.StatusConflict,
+			Result: `{"type":"urn:payments:problem:account_has_children","title":"Account has child accounts",
+				"status":409,"detail":"account has not deleted child accounts","code":"account_has_children"}`,
+		},
+		{
+			Name:   "update:tree transfer limit",
+			Method: http.MethodPatch,
+			Path:   EndpointURL + "/root",
+			Body:   `{"transfer_limit":1,"tree_transfer_limit":5}`,
+			Status: http.StatusOK,
+			Result: `{"account":{"id":"root","balance":10,"currency":"USD","status":"active","transfer_limit":1,
+				"tree_transfer_limit":5,"version":2}}`,
+		},
+		{
+			Name:   "update:parent is readonly",
+			Method: http.MethodPatch,
+			Path:   EndpointURL + "/kid",
+			Body:   `{"parent":null}`,
+			Status: http.StatusUnprocessableEntity,
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(item.Method, item.Path, strings.NewReader(item.Body))
+			if item.Method == http.MethodPatch {
+				req.Header.Set("Content-Type", "application/merge-patch+json")
+				req.Header.Set("If-Match", "*")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if item.Result == "" {
+				return
+			}
+			var got, want interface{}
+			OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			OK(t, json.Unmarshal([]byte(item.Result), &want))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("wrong body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAccountMetadata(t *testing.T) {
+	handler := account.MakeHandler(account.NewService(inmem.NewAccountRepository()), log.NewNopLogger())
+	tooMany := make([]string, 0, metadata.MaxKeys)
+	for i := 0; i < metadata.MaxKeys; i++ {
+		tooMany = append(tooMany, fmt.Sprintf(`"k%d":"v"`, i))
+	}
+
+	for _, item := range []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Result string
+	}{
+		{
+			Name:   "metadata:new account",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body: `{"id":"alice","description":"main wallet","reference":"C-42",
+				"metadata":{"customer":"42","tier":"gold"}}`,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "metadata:new account without metadata",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"id":"bob"}`,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "metadata:invalid key and too long value",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"id":"carol","metadata":{"bad key":"1","note":"` + strings.Repeat("x", 501) + `"}}`,
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"metadata.bad key","rule":"metadatakey",
+					"reason":"key must contain only latin letters, digits, '_', '-' and '.'"},
+				{"name":"metadata.note","rule":"stringlength","reason":"has wrong length"}]}`,
+		},
+		{
+			Name:   "metadata:filter list",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "?metadata.customer=42",
+			Status: http.StatusOK,
+			Result: `[{"id":"alice","balance":0,"currency":"USD","status":"active","version":1,
+				"description":"main wallet","reference":"C-42","metadata":{"customer":"42","tier":"gold"}}]`,
+		},
+		{
+			Name:   "metadata:filter list by absent value",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "?metadata.customer=42&metadata.tier=silver",
+			Status: http.StatusOK,
+			Result: `[]`,
+		},
+		{
+			Name:   "metadata:invalid filter",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "?metadata.a%20b=1&metadata.tier=gold&metadata.tier=silver",
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"metadata.a b","rule":"metadatakey",
+					"reason":"key must contain only latin letters, digits, '_', '-' and '.'"},
+				{"name":"metadata.tier","rule":"once","reason":"must be given once"}]}`,
+		},
+		{
+			Name:   "metadata:merge patch",
+			Method: http.MethodPatch,
+			Path:   EndpointURL + "/alice",
+			Body:   `{"reference":null,"metadata":{"tier":null,"region":"eu"}}`,
+			Status: http.StatusOK,
+			Result: `{"account":{"id":"alice","balance":0,"currency":"USD","status":"active","version":2,
+				"description":"main wallet","metadata":{"customer":"42","region":"eu"}}}`,
+		},
+		{
+			Name:   "metadata:patch exceeds keys together with kept ones",
+			Method: http.MethodPatch,
+			Path:   EndpointURL + "/alice",
+			Body:   `{"metadata":{` + strings.Join(tooMany, ",") + `}}`,
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"metadata","rule":"maxkeys","reason":"has too many keys"}]}`,
+		},
+		{
+			Name:   "metadata:null removes metadata",
+			Method: http.MethodPatch,
+			Path:   EndpointURL + "/alice",
+			Body:   `{"metadata":null}`,
+			Status: http.StatusOK,
+			Result: `{"account":{"id":"alice","balance":0,"currency":"USD","status":"active","version":3,
+				"description":"main wallet"}}`,
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(item.Method, item.Path, strings.NewReader(item.Body))
+			if item.Method == http.MethodPatch {
+				req.Header.Set("If-Match", "*")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if item.Result == "" {
+				return
+			}
+			var got, want interface{}
+			OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			OK(t, json.Unmarshal([]byte(item.Result), &want))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("wrong body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPurger(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	for _, id := range []account.ID{"alice", "bob"} {
+		OK(t, accounts.Store(ctx, &account.Account{ID: id, Currency: "USD", Status: account.StatusActive}))
+	}
+	OK(t, accounts.MarkDeleted(ctx, "bob"))
+
+	ids, err := account.NewPurger(accounts, time.Hour, log.NewNopLogger()).Run()
+	OK(t, err)
+	if len(ids) != 0 {
+		t.Errorf("account deleted within horizon must be kept, got %v", ids)
+	}
+	// Negative horizon moves the cutoff to the future, so the account is deleted long enough ago.
+	ids, err = account.NewPurger(accounts, -time.Minute, log.NewNopLogger()).Run()
+	OK(t, err)
+	if diff := cmp.Diff([]account.ID{"bob"}, ids); diff != "" {
+		t.Errorf("wrong purged accounts (-want +got):\n%s", diff)
+	}
+	OK(t, account.NewService(accounts).New(ctx, "bob", "", decimal.Zero, account.Details{}))
+}
+
+func TestRounding(t *testing.T) {
+	for _, item := range []struct {
+		Rounding account.Rounding
+		Amount   string
+		Want     string
+		OK       bool
+	}{
+		{Rounding: account.RoundReject, Amount: "1.23", Want: "1.23", OK: true},
+		{Rounding: account.RoundReject, Amount: "1.2300", Want: "1.23", OK: true},
+		{Rounding: account.RoundReject, Amount: "1.001", OK: false},
+		{Rounding: account.RoundHalfUp, Amount: "1.005", Want: "1.01", OK: true},
+		{Rounding: account.RoundHalfUp, Amount: "1.0049", Want: "1", OK: true},
+		{Rounding: account.RoundHalfEven, Amount: "1.005", Want: "1", OK: true},
+		{Rounding: account.RoundHalfEven, Amount: "1.015", Want: "1.02", OK: true},
+		{Rounding: account.RoundDown, Amount: "1.009", Want: "1", OK: true},
+	} {
+		t.Run(fmt.Sprintf("%s:%s", item.Rounding, item.Amount), func(t *testing.T) {
+			got, ok := item.Rounding.Round(decimal.RequireFromString(item.Amount), account.CurrencyUSD.Scale())
+			if ok != item.OK {
+				t.Fatalf("wrong ok: got %v want %v", ok, item.OK)
+			}
+			if ok && !got.Equal(decimal.RequireFromString(item.Want)) {
+				t.Errorf("wrong amount: got %s want %s", got, item.Want)
+			}
+		})
+	}
+}
+
+func TestServiceRounding(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	balance := decimal.RequireFromString("1.005")
+	err := account.NewService(accounts).New(ctx, "alice", account.CurrencyUSD, balance, account.Details{})
+	if _, ok := err.(errs.ValidationError); !ok {
+		t.Errorf("extra places must be rejected by default, got %v", err)
+	}
+
+	s := account.NewService(accounts, account.ServiceRounding(account.RoundHalfUp))
+	OK(t, s.New(ctx, "alice", account.CurrencyUSD, balance, account.Details{}))
+	a, err := accounts.Find(ctx, "alice")
+	OK(t, err)
+	if !a.Balance.Equal(decimal.RequireFromString("1.01")) {
+		t.Errorf("wrong balance: got %s want 1.01", a.Balance)
+	}
+}
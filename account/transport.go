@@ -3,14 +3,21 @@ package account
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/tracing"
 	"github.com/shopspring/decimal"
 
 	"github.com/asaskevich/govalidator"
 	kitlog "github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/transport"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 )
@@ -32,33 +39,48 @@ func init() {
 // MakeHandler returns a handler for the account service.
 func MakeHandler(as Service, logger kitlog.Logger) http.Handler {
 	opts := []kithttp.ServerOption{
-		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
 		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext),
 	}
 
 	newAccountHandler := kithttp.NewServer(
-		makeNewAccountEndpoint(as),
+		tracing.EndpointMiddleware("account.new")(makeNewAccountEndpoint(as)),
 		decodeNewAccountRequest,
 		errs.EncodeResponse,
 		opts...,
 	)
 
 	loadAccountHandler := kithttp.NewServer(
-		makeLoadAccountEndpoint(as),
+		tracing.EndpointMiddleware("account.load")(makeLoadAccountEndpoint(as)),
 		decodeLoadAccountRequest,
-		errs.EncodeResponse,
+		encodeAccountResponse,
+		opts...,
+	)
+
+	updateAccountHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("account.update")(makeUpdateAccountEndpoint(as)),
+		decodeUpdateAccountRequest,
+		encodeAccountResponse,
 		opts...,
 	)
 
 	loadAllAccountsHandler := kithttp.NewServer(
-		makeLoadAllAccountsEndpoint(as),
+		tracing.EndpointMiddleware("account.loadAll")(makeLoadAllAccountsEndpoint(as)),
 		decodeLoadAllAccountsRequest,
 		errs.EncodeResponse,
 		opts...,
 	)
 
+	loadTreeHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("account.tree")(makeLoadTreeEndpoint(as)),
+		decodeLoadAccountRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
 	deleteAccountHandler := kithttp.NewServer(
-		makeDeleteAccountEndpoint(as),
+		tracing.EndpointMiddleware("account.delete")(makeDeleteAccountEndpoint(as)),
 		decodeDeleteAccountRequest,
 		errs.EncodeResponse,
 		opts...,
@@ -70,6 +92,8 @@ func MakeHandler(as Service, logger kitlog.Logger) http.Handler {
 	router.Handle("/api/accounts/v1/accounts", loadAllAccountsHandler).Methods("GET")
 	router.Handle("/api/accounts/v1/accounts/{id}", loadAccountHandler).Methods("GET")
 	router.Handle("/api/accounts/v1/accounts/{id}", deleteAccountHandler).Methods("DELETE")
+	router.Handle("/api/accounts/v1/accounts/{id}", updateAccountHandler).Methods("PATCH")
+	router.Handle("/api/accounts/v1/accounts/{id}/tree", loadTreeHandler).Methods("GET")
 
 	return router
 }
@@ -77,10 +101,17 @@ func MakeHandler(as Service, logger kitlog.Logger) http.Handler {
 func decodeNewAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var body newAccountRequest
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		return nil, err
+		return nil, errs.MalformedRequestError{Err: err}
 	}
+	var problems govalidator.Errors
 	if _, err := govalidator.ValidateStruct(body); err != nil {
-		return nil, errs.ValidationError{Err: err}
+		problems = append(problems, err.(govalidator.Errors)...)
+	}
+	if err := metadata.Validate(body.Metadata); err != nil {
+		problems = append(problems, err.(govalidator.Errors)...)
+	}
+	if len(problems) > 0 {
+		return nil, errs.ValidationError{Err: problems}
 	}
 	return body, nil
 }
@@ -94,8 +125,12 @@ func decodeLoadAccountRequest(_ context.Context, r *http.Request) (interface{},
 	return idField{ID: ID(id)}, nil
 }
 
-func decodeLoadAllAccountsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
-	return nil, nil
+func decodeLoadAllAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	m, err := metadata.FromQuery(r.URL.Query())
+	if err != nil {
+		return nil, errs.ValidationError{Err: err}
+	}
+	return loadAllAccountsRequest{Filter: Filter{Metadata: m}}, nil
 }
 
 func decodeDeleteAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -106,3 +141,167 @@ func decodeDeleteAccountRequest(_ context.Context, r *http.Request) (interface{}
 	}
 	return idField{ID: ID(id)}, nil
 }
+
+// etag returns entity tag of account version.
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// encodeAccountResponse sets ETag header by version of account, so client may send it back in If-Match.
+func encodeAccountResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if r, ok := response.(loadAccountResponse); ok && r.Err == nil && r.Account != nil {
+		w.Header().Set("ETag", etag(r.Account.Version))
+	}
+	return errs.EncodeResponse(ctx, w, response)
+}
+
+// ifMatchVersion returns version required by If-Match header, or zero if any version matches ("*"). The header is
+// required, so concurrent updates are not lost by clients, which forget it. Only one strong entity tag is
+// supported, others can not match.
+func ifMatchVersion(header string) (int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, errs.ErrPreconditionRequired
+	}
+	if header == "*" {
+		return 0, nil
+	}
+	s, err := strconv.Unquote(header)
+	if err != nil || !strings.HasPrefix(header, `"`) {
+		return 0, errs.ErrVersionMismatch
+	}
+	version, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, errs.ErrVersionMismatch
+	}
+	return version, nil
+}
+
+// decodeUpdateAccountRequest decodes JSON merge patch (RFC 7396) of mutable fields: null removes name, transfer
+// limits, description, reference and metadata or its keys, other fields can not be removed. Metadata is merged
+// with the stored one.
+func decodeUpdateAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	version, err := ifMatchVersion(r.Header.Get("If-Match"))
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+	if fields == nil {
+		return nil, errs.MalformedRequestError{Err: errors.New("merge patch must be a JSON object")}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var (
+		patch    Patch
+		problems govalidator.Errors
+	)
+	invalid := func(name, validator string, err error) {
+		problems = append(problems, govalidator.Error{Name: name, Validator: validator, Err: err})
+	}
+	for _, name := range names {
+		value := fields[name]
+		null := string(value) == "null"
+		switch name {
+		case "name", "reference", "description":
+			var v string
+			if !null {
+				if err := json.Unmarshal(value, &v); err != nil {
+					invalid(name, "", errors.New("must be a string"))
+					continue
+				}
+			}
+			limit := 255
+			if name == "description" {
+				limit = 1000
+			}
+			if utf8.RuneCountInString(v) > limit {
+				invalid(name, "stringlength", fmt.Errorf("must be up to %d characters", limit))
+				continue
+			}
+			switch name {
+			case "name":
+				patch.Name = &v
+			case "reference":
+				patch.Reference = &v
+			default:
+				patch.Description = &v
+			}
+		case "currency":
+			var v Currency
+			if null {
+				invalid(name, "required", errors.New("non zero value required"))
+				continue
+			}
+			if err := json.Unmarshal(value, &v); err != nil || v != CurrencyUSD {
+				invalid(name, "in", fmt.Errorf("%s does not validate as in(USD)", value))
+				continue
+			}
+			patch.Currency = &v
+		case "status":
+			var v Status
+			if null {
+				invalid(name, "required", errors.New("non zero value required"))
+				continue
+			}
+			if err := json.Unmarshal(value, &v); err != nil || (v != StatusActive && v != StatusFrozen) {
+				invalid(name, "in", fmt.Errorf("%s does not validate as in(active|frozen)", value))
+				continue
+			}
+			patch.Status = &v
+		case "transfer_limit", "tree_transfer_limit":
+			if null {
+				if name == "transfer_limit" {
+					patch.RemoveTransferLimit = true
+				} else {
+					patch.RemoveTreeTransferLimit = true
+				}
+				continue
+			}
+			var v decimal.Decimal
+			if err := json.Unmarshal(value, &v); err != nil {
+				invalid(name, "decimal", err)
+				continue
+			}
+			if v.Sign() <= 0 {
+				invalid(name, "positive", errors.New("must be positive"))
+				continue
+			}
+			if name == "transfer_limit" {
+				patch.TransferLimit = &v
+			} else {
+				patch.TreeTransferLimit = &v
+			}
+		case "metadata":
+			if null {
+				patch.RemoveMetadata = true
+				continue
+			}
+			var v map[string]*string
+			if err := json.Unmarshal(value, &v); err != nil || v == nil {
+				invalid(name, "", errors.New("must be an object with string or null values"))
+				continue
+			}
+			// Keys and values are validated by service together with kept ones.
+			patch.Metadata = v
+		default:
+			invalid(name, "readonly", errors.New("can not be changed"))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errs.ValidationError{Err: problems}
+	}
+	return updateAccountRequest{ID: ID(id), Patch: patch, Version: version}, nil
+}
@@ -0,0 +1,85 @@
+package account
+
+import (
+	"context"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxDepth is a maximum number of levels in a tree of accounts, root is the first one.
+const MaxDepth = 8
+
+// Tree is an account with its descendants. Total is a balance of the account together with all its descendants,
+// accounts of a tree have the same currency.
+type Tree struct {
+	Account  *Account        `json:"account"`
+	Total    decimal.Decimal `json:"total"`
+	Children []*Tree         `json:"children,omitempty"`
+}
+
+// NewTree builds tree of accounts with root id, children are ordered by id. Accounts, which do not descend from
+// root, are ignored. It returns nil if root is not in the list.
+func NewTree(root ID, accounts []*Account) *Tree {
+	nodes := make(map[ID]*Tree, len(accounts))
+	for _, a := range accounts {
+		nodes[a.ID] = &Tree{Account: a}
+	}
+	sorted := make([]*Account, len(accounts))
+	copy(sorted, accounts)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	for _, a := range sorted {
+		if parent, ok := nodes[a.Parent]; ok && a.ID != root {
+			parent.Children = append(parent.Children, nodes[a.ID])
+		}
+	}
+	t, ok := nodes[root]
+	if !ok {
+		return nil
+	}
+	t.total(0)
+	return t
+}
+
+// total sums balances of the subtree, depth protects from cycles of broken data.
+func (t *Tree) total(depth int) decimal.Decimal {
+	t.Total = t.Account.Balance
+	if depth >= MaxDepth {
+		t.Children = nil
+		return t.Total
+	}
+	for _, c := range t.Children {
+		t.Total = t.Total.Add(c.total(depth + 1))
+	}
+	return t.Total
+}
+
+// Path returns ids of account and its ancestors, from the account up to the root of its tree.
+func Path(ctx context.Context, accounts Repository, a *Account) ([]ID, error) {
+	path := []ID{a.ID}
+	for a.Parent != "" && len(path) <= MaxDepth {
+		parent, err := accounts.Find(ctx, a.Parent)
+		if err != nil {
+			return nil, err
+		}
+		a = parent
+		path = append(path, a.ID)
+	}
+	return path, nil
+}
+
+// SameTree reports whether accounts belong to the same tree, i.e. have the same root.
+func SameTree(ctx context.Context, accounts Repository, a, b *Account) (bool, error) {
+	if a.Parent == "" && b.Parent == "" {
+		return a.ID == b.ID, nil
+	}
+	first, err := Path(ctx, accounts, a)
+	if err != nil {
+		return false, err
+	}
+	second, err := Path(ctx, accounts, b)
+	if err != nil {
+		return false, err
+	}
+	return first[len(first)-1] == second[len(second)-1], nil
+}
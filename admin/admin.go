@@ -0,0 +1,71 @@
+// Package admin provides operational handlers of the system, which are not a part of the public API.
+package admin
+
+import (
+	"context"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/inmem"
+)
+
+// Snapshotter saves state of the storage to disk.
+type Snapshotter interface {
+	Snapshot() (*inmem.SnapshotInfo, error)
+}
+
+// Service is the interface that provides administrative methods.
+type Service interface {
+	// Snapshot saves state of the in-memory storage to disk immediately.
+	Snapshot(ctx context.Context) (*inmem.SnapshotInfo, error)
+
+	// DeletedAccounts returns deleted accounts, which may be restored.
+	DeletedAccounts(ctx context.Context) ([]*account.Account, error)
+
+	// RestoreAccount returns deleted account back to the system.
+	RestoreAccount(ctx context.Context, id account.ID) (*account.Account, error)
+
+	// UnfreezeAccount activates frozen account after review, e.g. of screening match.
+	UnfreezeAccount(ctx context.Context, id account.ID) (*account.Account, error)
+}
+
+type service struct {
+	snapshotter Snapshotter
+	accounts    account.Service
+}
+
+// Snapshot saves state of the in-memory storage to disk immediately. Storage without snapshots is reported with
+// errs.ErrBadRoute.
+func (s *service) Snapshot(ctx context.Context) (*inmem.SnapshotInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.snapshotter == nil {
+		return nil, errs.ErrBadRoute
+	}
+	return s.snapshotter.Snapshot()
+}
+
+// DeletedAccounts returns deleted accounts, which may be restored.
+func (s *service) DeletedAccounts(ctx context.Context) ([]*account.Account, error) {
+	return s.accounts.LoadDeleted(ctx)
+}
+
+// RestoreAccount returns deleted account back to the system.
+func (s *service) RestoreAccount(ctx context.Context, id account.ID) (*account.Account, error) {
+	return s.accounts.Restore(ctx, id)
+}
+
+// UnfreezeAccount activates frozen account after review, e.g. of screening match.
+func (s *service) UnfreezeAccount(ctx context.Context, id account.ID) (*account.Account, error) {
+	return s.accounts.Unfreeze(ctx, id)
+}
+
+// NewService creates an admin service with necessary dependencies. Snapshotter is nil, if storage does not save
+// snapshots.
+func NewService(snapshotter Snapshotter, accounts account.Service) Service {
+	return &service{
+		snapshotter: snapshotter,
+		accounts:    accounts,
+	}
+}
@@ -0,0 +1,117 @@
+package admin_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/admin"
+	"github.com/otetz/payments/inmem"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeletedAccounts(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	for _, id := range []account.ID{"alice", "bob"} {
+		OK(t, accounts.Store(ctx, &account.Account{ID: id, Balance: decimal.NewFromFloat(1), Currency: "USD",
+			Status: account.StatusActive, Version: 1}))
+	}
+	OK(t, accounts.MarkDeleted(ctx, "bob"))
+	handler := admin.MakeHandler(admin.NewService(nil, account.NewService(accounts)), "s3cret",
+		log.NewNopLogger())
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Token  string
+		Status int
+		Result map[string]interface{}
+	}{
+		{"no token", http.MethodGet, "/api/admin/v1/accounts/deleted", "", http.StatusUnauthorized,
+			map[string]interface{}{"code": "unauthorized"}},
+		{"wrong token", http.MethodPost, "/api/admin/v1/accounts/bob/restore", "secret", http.StatusUnauthorized,
+			map[string]interface{}{"code": "unauthorized"}},
+		{"deleted list", http.MethodGet, "/api/admin/v1/accounts/deleted", "s3cret", http.StatusOK,
+			map[string]interface{}{"accounts": []interface{}{"bob"}}},
+		{"restore active", http.MethodPost, "/api/admin/v1/accounts/alice/restore", "s3cret", http.StatusNotFound,
+			map[string]interface{}{"code": "unknown_account"}},
+		{"restore", http.MethodPost, "/api/admin/v1/accounts/bob/restore", "s3cret", http.StatusOK,
+			map[string]interface{}{"account": "bob"}},
+		{"empty deleted list", http.MethodGet, "/api/admin/v1/accounts/deleted", "s3cret", http.StatusOK,
+			map[string]interface{}{"accounts": []interface{}{}}},
+		{"no snapshots", http.MethodPost, "/api/admin/v1/snapshots", "s3cret", http.StatusNotFound,
+			map[string]interface{}{"code": "bad_route"}},
+	}
+	for _, item := range cases {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(item.Method, item.Path, nil)
+			if item.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+item.Token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != item.Status {
+				t.Fatalf("wrong status: got %d want %d: %s", w.Code, item.Status, w.Body)
+			}
+
+			var body struct {
+				Code     string             `json:"code"`
+				Account  *account.Account   `json:"account"`
+				Accounts []*account.Account `json:"accounts"`
+			}
+			OK(t, json.NewDecoder(w.Body).Decode(&body))
+			got := make(map[string]interface{})
+			if body.Code != "" {
+				got["code"] = body.Code
+			}
+			if body.Account != nil {
+				got["account"] = string(body.Account.ID)
+			}
+			if body.Accounts != nil {
+				ids := make([]interface{}, 0)
+				for _, a := range body.Accounts {
+					if a.DeletedAt == nil {
+						t.Errorf("%s: deleted_at is missing", a.ID)
+					}
+					ids = append(ids, string(a.ID))
+				}
+				got["accounts"] = ids
+			}
+			if diff := cmp.Diff(item.Result, got); diff != "" {
+				t.Errorf("wrong response (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEmptyToken(t *testing.T) {
+	handler := admin.MakeHandler(admin.NewService(nil, account.NewService(inmem.NewAccountRepository())), "",
+		log.NewNopLogger())
+
+	// Without configured token admin API is closed for everyone, even for empty bearer token.
+	for _, header := range []string{"", "Bearer ", "Bearer"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/accounts/deleted", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%q: wrong status: got %d want %d: %s", header, w.Code, http.StatusUnauthorized, w.Body)
+		}
+	}
+}
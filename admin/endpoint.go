@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/inmem"
+)
+
+type snapshotResponse struct {
+	*inmem.SnapshotInfo
+	Err error `json:"error,omitempty"`
+}
+
+func (r snapshotResponse) ErrError() error { return r.Err }
+
+func makeSnapshotEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		info, err := s.Snapshot(ctx)
+		return snapshotResponse{SnapshotInfo: info, Err: err}, nil
+	}
+}
+
+type deletedAccountsResponse struct {
+	Accounts []*account.Account `json:"accounts"`
+	Err      error              `json:"error,omitempty"`
+}
+
+func (r deletedAccountsResponse) ErrError() error { return r.Err }
+
+func makeDeletedAccountsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accounts, err := s.DeletedAccounts(ctx)
+		return deletedAccountsResponse{Accounts: accounts, Err: err}, nil
+	}
+}
+
+type accountRequest struct {
+	ID account.ID
+}
+
+type accountResponse struct {
+	Account *account.Account `json:"account,omitempty"`
+	Err     error            `json:"error,omitempty"`
+}
+
+func (r accountResponse) ErrError() error { return r.Err }
+
+func makeRestoreAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountRequest)
+		a, err := s.RestoreAccount(ctx, req.ID)
+		return accountResponse{Account: a, Err: err}, nil
+	}
+}
+
+func makeUnfreezeAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountRequest)
+		a, err := s.UnfreezeAccount(ctx, req.ID)
+		return accountResponse{Account: a, Err: err}, nil
+	}
+}
@@ -0,0 +1,76 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bearer"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/tracing"
+
+	kitlog "github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// MakeHandler returns a handler for the admin service. Requests must have the token in "Authorization: Bearer <token>"
+// header, others are rejected with errs.ErrUnauthorized. All requests are rejected, if token is empty.
+func MakeHandler(s Service, token string, logger kitlog.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext),
+	}
+
+	snapshotHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("admin.snapshot")(makeSnapshotEndpoint(s)),
+		decodeSnapshotRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+	deletedAccountsHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("admin.deletedAccounts")(makeDeletedAccountsEndpoint(s)),
+		decodeDeletedAccountsRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+	restoreAccountHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("admin.restoreAccount")(makeRestoreAccountEndpoint(s)),
+		decodeAccountRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+	unfreezeAccountHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("admin.unfreezeAccount")(makeUnfreezeAccountEndpoint(s)),
+		decodeAccountRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	router := mux.NewRouter()
+
+	router.Handle("/api/admin/v1/snapshots", snapshotHandler).Methods("POST")
+	router.Handle("/api/admin/v1/accounts/deleted", deletedAccountsHandler).Methods("GET")
+	router.Handle("/api/admin/v1/accounts/{id}/restore", restoreAccountHandler).Methods("POST")
+	router.Handle("/api/admin/v1/accounts/{id}/unfreeze", unfreezeAccountHandler).Methods("POST")
+
+	return bearer.Authenticate(token, "admin", router)
+}
+
+func decodeSnapshotRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeDeletedAccountsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	return accountRequest{ID: account.ID(id)}, nil
+}
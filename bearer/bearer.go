@@ -0,0 +1,23 @@
+// Package bearer authenticates requests of privileged APIs by a static bearer token.
+package bearer
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/otetz/payments/errs"
+)
+
+// Authenticate passes requests with the token in "Authorization: Bearer <token>" header to next, others are
+// rejected with errs.ErrUnauthorized. All requests are rejected, if token is empty.
+func Authenticate(token, realm string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+realm+`"`)
+			errs.EncodeError(errs.PopulateRequestContext(r.Context(), r), errs.ErrUnauthorized, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package boltdb
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+	bolt "go.etcd.io/bbolt"
+)
+
+type approvalRepository struct {
+	conn *bolt.DB
+}
+
+func findApproval(tx *bolt.Tx, id uuid.UUID) (*payment.Approval, error) {
+	data := tx.Bucket(approvalsBucket).Get([]byte(id.String()))
+	if data == nil {
+		return nil, errs.ErrUnknownApproval
+	}
+	a := &payment.Approval{}
+	if err := decode(data, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// StoreApproval saves a new pending transfer.
+func (r *approvalRepository) StoreApproval(ctx context.Context, approval *payment.Approval) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(approvalsBucket), []byte(approval.ID.String()), approval)
+	})
+}
+
+// FindApproval returns transfer with specified id.
+func (r *approvalRepository) FindApproval(ctx context.Context, id uuid.UUID) (*payment.Approval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var a *payment.Approval
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = findApproval(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// forEachApproval calls fn for every stored transfer.
+func forEachApproval(tx *bolt.Tx, fn func(a *payment.Approval)) error {
+	return tx.Bucket(approvalsBucket).ForEach(func(_, data []byte) error {
+		a := &payment.Approval{}
+		if err := decode(data, a); err != nil {
+			return err
+		}
+		fn(a)
+		return nil
+	})
+}
+
+// FindApprovals returns all transfers ordered by time of creation. Keys of the bucket are random, so transfers are
+// sorted after loading.
+func (r *approvalRepository) FindApprovals(ctx context.Context) ([]*payment.Approval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	aa := make([]*payment.Approval, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return forEachApproval(tx, func(a *payment.Approval) { aa = append(aa, a) })
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(aa, func(i, j int) bool {
+		if !aa[i].CreatedAt.Equal(aa[j].CreatedAt) {
+			return aa[i].CreatedAt.Before(aa[j].CreatedAt)
+		}
+		return aa[i].ID.String() < aa[j].ID.String()
+	})
+	return aa, nil
+}
+
+// Reserved returns total amount of transfers from account, which are pending and expire after now.
+func (r *approvalRepository) Reserved(ctx context.Context, id account.ID, now time.Time) (decimal.Decimal, error) {
+	if err := ctx.Err(); err != nil {
+		return decimal.Zero, err
+	}
+	total := decimal.Zero
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return forEachApproval(tx, func(a *payment.Approval) {
+			if a.FromAccount == id && a.Status == payment.ApprovalPending && a.ExpiresAt.After(now) {
+				total = total.Add(a.Amount)
+			}
+		})
+	})
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return total, nil
+}
+
+// Decide stores decision on pending transfer together with payments, in one transaction.
+func (r *approvalRepository) Decide(ctx context.Context, approval *payment.Approval,
+	payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		stored, err := findApproval(tx, approval.ID)
+		if err != nil {
+			return err
+		}
+		if stored.Status != payment.ApprovalPending {
+			return errs.ErrApprovalDecided
+		}
+		if err := storePayments(tx, payments...); err != nil {
+			return err
+		}
+		return put(tx.Bucket(approvalsBucket), []byte(approval.ID.String()), approval)
+	})
+}
+
+// NewApprovalRepository returns a new instance of a file approval repository.
+func NewApprovalRepository(conn *bolt.DB) payment.ApprovalRepository {
+	return &approvalRepository{
+		conn: conn,
+	}
+}
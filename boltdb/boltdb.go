@@ -0,0 +1,675 @@
+// Package boltdb provide embedded single-file storage for application data repositories, based on bbolt, the
+// maintained fork of BoltDB.
+// All changes of a repository call are made in one read-write transaction, together with domain events.
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/event"
+	"github.com/otetz/payments/payment"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	accountsBucket      = []byte("accounts")
+	paymentsBucket      = []byte("payments")
+	paymentIDsBucket    = []byte("payment_ids")
+	subscriptionsBucket = []byte("webhook_subscriptions")
+	deliveriesBucket    = []byte("webhook_deliveries")
+	outboxBucket        = []byte("outbox")
+	pendingBucket       = []byte("outbox_pending")
+	plansBucket         = []byte("interest_plans")
+	accrualsBucket      = []byte("interest_accruals")
+	approvalsBucket     = []byte("approvals")
+	hitsBucket          = []byte("screening_hits")
+
+	buckets = [][]byte{
+		accountsBucket,
+		paymentsBucket,
+		paymentIDsBucket,
+		subscriptionsBucket,
+		deliveriesBucket,
+		outboxBucket,
+		pendingBucket,
+		plansBucket,
+		accrualsBucket,
+		approvalsBucket,
+		hitsBucket,
+	}
+)
+
+// Open opens database file, creating it and all buckets if necessary. File is locked while it is open, so
+// Open fails after a second of waiting, if another process uses it.
+func Open(path string) (*bolt.DB, error) {
+	conn, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	err = conn.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Check checks that database file is readable and all buckets exist.
+func Check(ctx context.Context, conn *bolt.DB) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return conn.View(func(tx *bolt.Tx) error {
+		var missing []string
+		for _, name := range buckets {
+			if tx.Bucket(name) == nil {
+				missing = append(missing, string(name))
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("database file is not initialized, missing: %s", strings.Join(missing, ", "))
+		}
+		return nil
+	})
+}
+
+// key returns sequence number as a key, which keeps numeric order of sequences.
+func key(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func put(b *bolt.Bucket, k []byte, v interface{}) error {
+	data, err := encode(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(k, data)
+}
+
+// findAccount returns account with specified id, deleted one too, or nil if it does not exist.
+func findAccount(tx *bolt.Tx, id account.ID) (*account.Account, error) {
+	data := tx.Bucket(accountsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+	a := &account.Account{}
+	if err := decode(data, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// live reports whether account exists and is not deleted.
+func live(tx *bolt.Tx, id account.ID) (bool, error) {
+	a, err := findAccount(tx, id)
+	return a != nil && !a.Deleted, err
+}
+
+// unknownParent returns errs.ErrUnknownParentAccount, unless parent is not checked because of err.
+func unknownParent(err error) error {
+	if err != nil {
+		return err
+	}
+	return errs.ErrUnknownParentAccount
+}
+
+// forEachAccount calls fn for every account, deleted ones too, in order of ids.
+func forEachAccount(tx *bolt.Tx, fn func(a *account.Account) error) error {
+	return tx.Bucket(accountsBucket).ForEach(func(_, data []byte) error {
+		a := &account.Account{}
+		if err := decode(data, a); err != nil {
+			return err
+		}
+		return fn(a)
+	})
+}
+
+type accountRepository struct {
+	conn *bolt.DB
+}
+
+// Store account in the repository, together with AccountOpened event.
+func (r *accountRepository) Store(ctx context.Context, account *account.Account) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		a, err := findAccount(tx, account.ID)
+		if err != nil {
+			return err
+		}
+		if a != nil {
+			return errs.ErrAccountExists
+		}
+		if account.Parent != "" {
+			if ok, err := live(tx, account.Parent); err != nil || !ok {
+				return unknownParent(err)
+			}
+		}
+		c := *account
+		c.InitialBalance = c.Balance
+		if err := put(tx.Bucket(accountsBucket), []byte(account.ID), &c); err != nil {
+			return err
+		}
+		return appendEvents(tx, event.NewAccountOpened(account))
+	})
+}
+
+// Find account in the repository with specified id
+func (r *accountRepository) Find(ctx context.Context, id account.ID) (*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var a *account.Account
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = findAccount(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if a == nil || a.Deleted {
+		return nil, errs.ErrUnknownAccount
+	}
+	return a, nil
+}
+
+// FindAll returns all accounts registered in the system, ordered by id.
+func (r *accountRepository) FindAll(ctx context.Context) ([]*account.Account, error) {
+	return r.Search(ctx, account.Filter{})
+}
+
+// Search returns not deleted accounts, which satisfy filter, ordered by id.
+func (r *accountRepository) Search(ctx context.Context, filter account.Filter) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	accounts := make([]*account.Account, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountsBucket).ForEach(func(_, data []byte) error {
+			a := &account.Account{}
+			if err := decode(data, a); err != nil {
+				return err
+			}
+			if !a.Deleted && filter.Match(a) {
+				accounts = append(accounts, a)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// MarkDeleted is mark as deleted specified account in the system, together with AccountDeleted event.
+func (r *accountRepository) MarkDeleted(ctx context.Context, id account.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		a, err := findAccount(tx, id)
+		if err != nil {
+			return err
+		}
+		if a == nil || a.Deleted {
+			return errs.ErrUnknownAccount
+		}
+		err = forEachAccount(tx, func(child *account.Account) error {
+			if child.Parent == id && !child.Deleted {
+				return errs.ErrAccountHasChildren
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		a.Deleted, a.DeletedAt = true, &now
+		if err := put(tx.Bucket(accountsBucket), []byte(id), a); err != nil {
+			return err
+		}
+		return appendEvents(tx, event.NewAccountDeleted(id))
+	})
+}
+
+// FindTree returns account and its not deleted descendants, ordered by id.
+func (r *accountRepository) FindTree(ctx context.Context, id account.ID) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var accounts []*account.Account
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		ok, err := live(tx, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errs.ErrUnknownAccount
+		}
+		children := make(map[account.ID][]*account.Account)
+		var root *account.Account
+		err = forEachAccount(tx, func(a *account.Account) error {
+			switch {
+			case a.ID == id:
+				root = a
+			case a.Parent != "" && !a.Deleted:
+				children[a.Parent] = append(children[a.Parent], a)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		accounts = []*account.Account{root}
+		for i := 0; i < len(accounts); i++ {
+			accounts = append(accounts, children[accounts[i].ID]...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	return accounts, nil
+}
+
+// FindDeleted returns deleted accounts, ordered by id.
+func (r *accountRepository) FindDeleted(ctx context.Context) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	accounts := make([]*account.Account, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountsBucket).ForEach(func(_, data []byte) error {
+			a := &account.Account{}
+			if err := decode(data, a); err != nil {
+				return err
+			}
+			if a.Deleted {
+				accounts = append(accounts, a)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+// Restore clears deletion mark of account, together with AccountRestored event.
+func (r *accountRepository) Restore(ctx context.Context, id account.ID) (*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var a *account.Account
+	err := r.conn.Update(func(tx *bolt.Tx) error {
+		var err error
+		a, err = findAccount(tx, id)
+		if err != nil {
+			return err
+		}
+		if a == nil || !a.Deleted {
+			return errs.ErrUnknownAccount
+		}
+		if a.Parent != "" {
+			if ok, err := live(tx, a.Parent); err != nil || !ok {
+				return unknownParent(err)
+			}
+		}
+		a.Deleted, a.DeletedAt = false, nil
+		if err := put(tx.Bucket(accountsBucket), []byte(id), a); err != nil {
+			return err
+		}
+		return appendEvents(tx, event.NewAccountRestored(id))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Purge erases accounts deleted before the time, together with their payments, in one transaction.
+func (r *accountRepository) Purge(ctx context.Context, before time.Time) ([]account.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]account.ID, 0)
+	err := r.conn.Update(func(tx *bolt.Tx) error {
+		purged := make(map[account.ID]bool)
+		err := tx.Bucket(accountsBucket).ForEach(func(_, data []byte) error {
+			a := &account.Account{}
+			if err := decode(data, a); err != nil {
+				return err
+			}
+			if a.Deleted && a.DeletedAt != nil && a.DeletedAt.Before(before) {
+				ids = append(ids, a.ID)
+				purged[a.ID] = true
+			}
+			return nil
+		})
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		// Bucket must not be changed while it is iterated, so keys are collected first.
+		var keys [][]byte
+		var pids []uuid.UUID
+		err = tx.Bucket(paymentsBucket).ForEach(func(k, data []byte) error {
+			p := &payment.Payment{}
+			if err := decode(data, p); err != nil {
+				return err
+			}
+			if purged[p.Account] {
+				keys = append(keys, append([]byte(nil), k...))
+				pids = append(pids, p.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			if err := tx.Bucket(paymentsBucket).Delete(k); err != nil {
+				return err
+			}
+			if err := tx.Bucket(paymentIDsBucket).Delete(pids[i][:]); err != nil {
+				return err
+			}
+		}
+		for _, id := range ids {
+			if err := tx.Bucket(accountsBucket).Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Update changes mutable fields of account by fn in one transaction, together with AccountUpdated event.
+func (r *accountRepository) Update(ctx context.Context, id account.ID, fn func(a *account.Account) error) (
+	*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var u *account.Account
+	err := r.conn.Update(func(tx *bolt.Tx) error {
+		a, err := findAccount(tx, id)
+		if err != nil {
+			return err
+		}
+		if a == nil || a.Deleted {
+			return errs.ErrUnknownAccount
+		}
+		c := *a
+		if err := fn(&c); err != nil {
+			return err
+		}
+		a.Name, a.Currency, a.Status, a.TransferLimit = c.Name, c.Currency, c.Status, c.TransferLimit
+		a.TreeTransferLimit = c.TreeTransferLimit
+		a.Description, a.Reference, a.Metadata = c.Description, c.Reference, c.Metadata
+		a.Version++
+		if err := put(tx.Bucket(accountsBucket), []byte(id), a); err != nil {
+			return err
+		}
+		u = a
+		return appendEvents(tx, event.NewAccountUpdated(a))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// NewAccountRepository returns a new instance of a file account repository.
+func NewAccountRepository(conn *bolt.DB) account.Repository {
+	return &accountRepository{
+		conn: conn,
+	}
+}
+
+type paymentRepository struct {
+	conn *bolt.DB
+}
+
+// Store payments in the repository, together with TransferCompleted events. Every completed payment changes
+// balance of its account in the same transaction, so transfer is rejected with errs.ErrInsufficientMoney, if source
+// account has not enough money at the moment of commit.
+func (r *paymentRepository) Store(ctx context.Context, payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		return storePayments(tx, payments...)
+	})
+}
+
+// storePayments stores payments with their events and changes balances of accounts of completed ones in
+// transaction.
+func storePayments(tx *bolt.Tx, payments ...*payment.Payment) error {
+	b := tx.Bucket(paymentsBucket)
+	for _, val := range payments {
+		if err := applyPayment(tx, val, false); err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := put(b, key(seq), val); err != nil {
+			return err
+		}
+		if err := tx.Bucket(paymentIDsBucket).Put(val.ID[:], key(seq)); err != nil {
+			return err
+		}
+	}
+	return appendTransferEvents(tx, payments...)
+}
+
+// appendTransferEvents writes events of payments, which moved money, with balances of their accounts after it.
+func appendTransferEvents(tx *bolt.Tx, payments ...*payment.Payment) error {
+	accounts := make(map[account.ID]*account.Account)
+	for _, val := range payments {
+		if s := val.CurrentStatus(); s != payment.StatusCompleted && s != payment.StatusReversed {
+			continue
+		}
+		a, err := findAccount(tx, val.Account)
+		if err != nil {
+			return err
+		}
+		if a != nil {
+			accounts[a.ID] = a
+		}
+	}
+	return appendEvents(tx, event.TransferEvents(accounts, payments...)...)
+}
+
+// applyPayment changes balance of account by completed payment, or returns its money if reverse is set. Balance
+// must not become negative. Failed payment never changes balance, so its account may be deleted.
+func applyPayment(tx *bolt.Tx, p *payment.Payment, reverse bool) error {
+	if p.CurrentStatus() == payment.StatusFailed {
+		return nil
+	}
+	a, err := findAccount(tx, p.Account)
+	if err != nil {
+		return err
+	}
+	if a == nil || a.Deleted {
+		return errs.ErrUnknownAccount
+	}
+	if !p.Completed() && !reverse {
+		return nil
+	}
+	amount := p.Amount
+	if p.Direction == payment.Outgoing {
+		amount = amount.Neg()
+	}
+	if reverse {
+		amount = amount.Neg()
+	}
+	a.Balance = a.Balance.Add(amount)
+	if amount.Sign() < 0 && a.Balance.Sign() < 0 {
+		return errs.ErrInsufficientMoney
+	}
+	return put(tx.Bucket(accountsBucket), []byte(a.ID), a)
+}
+
+// Transition changes status of all payments of transfer in one transaction, together with balances of their
+// accounts and events about completion or reversal.
+func (r *paymentRepository) Transition(ctx context.Context, transfer uuid.UUID, to payment.Status, reason string,
+	at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if transfer == uuid.Nil {
+		return errs.ErrUnknownTransfer
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(paymentsBucket)
+		// Bucket must not be changed while it is iterated, so payments are collected first.
+		var (
+			keys     [][]byte
+			payments []*payment.Payment
+		)
+		err := b.ForEach(func(k, data []byte) error {
+			p := &payment.Payment{}
+			if err := decode(data, p); err != nil {
+				return err
+			}
+			if p.Deleted || p.Transfer != transfer {
+				return nil
+			}
+			if err := p.Transition(to, reason, at); err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			payments = append(payments, p)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(payments) == 0 {
+			return errs.ErrUnknownTransfer
+		}
+		for i, val := range payments {
+			if err := applyPayment(tx, val, to == payment.StatusReversed); err != nil {
+				return err
+			}
+			if err := put(b, keys[i], val); err != nil {
+				return err
+			}
+		}
+		return appendTransferEvents(tx, payments...)
+	})
+}
+
+// find returns not deleted payments, which satisfy filter, in order of storing.
+func (r *paymentRepository) find(ctx context.Context, filter func(p *payment.Payment) bool) ([]*payment.Payment,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pp := make([]*payment.Payment, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(paymentsBucket).ForEach(func(_, data []byte) error {
+			p := &payment.Payment{}
+			if err := decode(data, p); err != nil {
+				return err
+			}
+			if !p.Deleted && filter(p) {
+				pp = append(pp, p)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pp, nil
+}
+
+// Find payments list for an account.
+func (r *paymentRepository) Find(ctx context.Context, id account.ID) ([]*payment.Payment, error) {
+	return r.find(ctx, func(p *payment.Payment) bool { return p.Account == id })
+}
+
+// FindAll returns all payments, registered in the system.
+func (r *paymentRepository) FindAll(ctx context.Context) ([]*payment.Payment, error) {
+	return r.find(ctx, func(p *payment.Payment) bool { return true })
+}
+
+// Search returns not deleted payments, which satisfy filter, in order of storing.
+func (r *paymentRepository) Search(ctx context.Context, filter payment.Filter) ([]*payment.Payment, error) {
+	return r.find(ctx, filter.Match)
+}
+
+// MarkDeleted is mark as deleted specified payment in the system
+func (r *paymentRepository) MarkDeleted(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(paymentsBucket)
+		k := tx.Bucket(paymentIDsBucket).Get(id[:])
+		if k == nil {
+			return errs.ErrUnknownPayment
+		}
+		// Values returned by bolt are valid only until the next change in transaction.
+		k = append([]byte(nil), k...)
+		p := &payment.Payment{}
+		if err := decode(b.Get(k), p); err != nil {
+			return err
+		}
+		if p.Deleted {
+			return errs.ErrUnknownPayment
+		}
+		p.Deleted = true
+		return put(b, k, p)
+	})
+}
+
+// NewPaymentRepository returns a new instance of a file payment repository.
+func NewPaymentRepository(conn *bolt.DB) payment.Repository {
+	return &paymentRepository{
+		conn: conn,
+	}
+}
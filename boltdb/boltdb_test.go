@@ -0,0 +1,160 @@
+package boltdb_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/boltdb"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/event"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+	"github.com/otetz/payments/repotest"
+	"github.com/otetz/payments/screening"
+	"github.com/shopspring/decimal"
+	bolt "go.etcd.io/bbolt"
+)
+
+func OK(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func open(t *testing.T, path string) *bolt.DB {
+	conn, err := boltdb.Open(path)
+	OK(t, err)
+	return conn
+}
+
+func TestConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var conns []*bolt.DB
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	repotest.Run(t, func(t *testing.T) (account.Repository, payment.Repository) {
+		conn := open(t, filepath.Join(dir, fmt.Sprintf("payments%d.db", len(conns))))
+		conns = append(conns, conn)
+		return boltdb.NewAccountRepository(conn), boltdb.NewPaymentRepository(conn)
+	})
+	repotest.RunBulk(t, func(t *testing.T) (account.Repository, payment.Repository, bulk.Repository) {
+		conn := open(t, filepath.Join(dir, fmt.Sprintf("payments%d.db", len(conns))))
+		conns = append(conns, conn)
+		return boltdb.NewAccountRepository(conn), boltdb.NewPaymentRepository(conn), boltdb.NewBulkRepository(conn)
+	})
+	repotest.RunLedger(t, func(t *testing.T) (account.Repository, payment.Repository, bulk.Repository,
+		reconcile.Repository) {
+		conn := open(t, filepath.Join(dir, fmt.Sprintf("payments%d.db", len(conns))))
+		conns = append(conns, conn)
+		return boltdb.NewAccountRepository(conn), boltdb.NewPaymentRepository(conn), boltdb.NewBulkRepository(conn),
+			boltdb.NewLedgerRepository(conn)
+	})
+	repotest.RunInterest(t, func(t *testing.T) (account.Repository, payment.Repository, interest.Repository) {
+		conn := open(t, filepath.Join(dir, fmt.Sprintf("payments%d.db", len(conns))))
+		conns = append(conns, conn)
+		return boltdb.NewAccountRepository(conn), boltdb.NewPaymentRepository(conn), boltdb.NewInterestRepository(conn)
+	})
+	repotest.RunApprovals(t, func(t *testing.T) (account.Repository, payment.Repository, payment.ApprovalRepository) {
+		conn := open(t, filepath.Join(dir, fmt.Sprintf("payments%d.db", len(conns))))
+		conns = append(conns, conn)
+		return boltdb.NewAccountRepository(conn), boltdb.NewPaymentRepository(conn), boltdb.NewApprovalRepository(conn)
+	})
+	repotest.RunScreening(t, func(t *testing.T) screening.Repository {
+		conn := open(t, filepath.Join(dir, fmt.Sprintf("payments%d.db", len(conns))))
+		conns = append(conns, conn)
+		return boltdb.NewScreeningRepository(conn)
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "boltdb")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "payments.db")
+	ctx := context.Background()
+
+	conn := open(t, path)
+	accounts := boltdb.NewAccountRepository(conn)
+	ps := payment.NewService(boltdb.NewPaymentRepository(conn), accounts)
+
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(7.5), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	OK(t, boltdb.Check(ctx, conn))
+	OK(t, conn.Close())
+
+	// Data survives reopening of the file.
+	conn = open(t, path)
+	defer func() { _ = conn.Close() }()
+	accounts = boltdb.NewAccountRepository(conn)
+	payments := boltdb.NewPaymentRepository(conn)
+	outbox := boltdb.NewOutbox(conn)
+
+	for id, balance := range map[account.ID]float64{"alice": 2.5, "bob": 7.5} {
+		a, err := accounts.Find(ctx, id)
+		OK(t, err)
+		if !a.Balance.Equal(decimal.NewFromFloat(balance)) {
+			t.Errorf("%s: wrong balance %s", id, a.Balance)
+		}
+	}
+	if pp, _ := payments.Find(ctx, "bob"); len(pp) != 1 || pp[0].Direction != payment.Incoming {
+		t.Errorf("incoming payment of bob expected, got %v", pp)
+	}
+
+	// Outgoing leg fails in transaction, so incoming one and events are rolled back too.
+	err = payments.Store(ctx,
+		&payment.Payment{Account: "bob", Amount: decimal.NewFromFloat(5), Direction: payment.Incoming},
+		&payment.Payment{Account: "alice", Amount: decimal.NewFromFloat(5), Direction: payment.Outgoing},
+	)
+	if err != errs.ErrInsufficientMoney {
+		t.Errorf("insufficient money expected, got %v", err)
+	}
+	if b, _ := accounts.Find(ctx, "bob"); !b.Balance.Equal(decimal.NewFromFloat(7.5)) {
+		t.Errorf("balance of bob must not change, got %s", b.Balance)
+	}
+	if pp, _ := payments.FindAll(ctx); len(pp) != 2 {
+		t.Errorf("2 payments expected, got %d", len(pp))
+	}
+
+	OK(t, accounts.MarkDeleted(ctx, "bob"))
+	if _, err := accounts.Find(ctx, "bob"); err != errs.ErrUnknownAccount {
+		t.Errorf("deleted account must be unknown, got %v", err)
+	}
+
+	pending, err := outbox.Pending(10)
+	OK(t, err)
+	types := []event.Type{event.AccountOpened, event.AccountOpened, event.TransferCompleted, event.AccountDeleted}
+	if len(pending) != len(types) {
+		t.Fatalf("%d events expected, got %d", len(types), len(pending))
+	}
+	for i, e := range pending {
+		if e.Sequence != int64(i+1) || e.Type != types[i] {
+			t.Errorf("wrong event %d: %d %s", i, e.Sequence, e.Type)
+		}
+	}
+	OK(t, outbox.MarkPublished(1, 2))
+	if pending, _ = outbox.Pending(10); len(pending) != 2 || pending[0].Sequence != 3 {
+		t.Errorf("events 3 and 4 must be pending, got %v", pending)
+	}
+	if since, _ := outbox.Since(2, 1); len(since) != 1 || since[0].Sequence != 3 {
+		t.Errorf("event 3 expected, got %v", since)
+	}
+	if latest, _ := outbox.Latest(); latest != 4 {
+		t.Errorf("latest sequence 4 expected, got %d", latest)
+	}
+}
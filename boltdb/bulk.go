@@ -0,0 +1,171 @@
+package boltdb
+
+import (
+	"context"
+	"errors"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/payment"
+	bolt "go.etcd.io/bbolt"
+)
+
+// errDryRun rolls back transaction of dry-run import.
+var errDryRun = errors.New("dry run")
+
+type bulkRepository struct {
+	conn *bolt.DB
+}
+
+// update runs fn in read-write transaction, which is rolled back in dry-run mode.
+func (r *bulkRepository) update(dryRun bool, fn func(tx *bolt.Tx) error) error {
+	err := r.conn.Update(func(tx *bolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		return nil
+	}
+	return err
+}
+
+// ImportAccounts stores accounts as they are, in one transaction without domain events.
+func (r *bulkRepository) ImportAccounts(ctx context.Context, accounts []*account.Account, dryRun bool) (
+	map[int]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rejects map[int]error
+	err := r.update(dryRun, func(tx *bolt.Tx) error {
+		rejects = make(map[int]error)
+		b := tx.Bucket(accountsBucket)
+		for i, val := range accounts {
+			if b.Get([]byte(val.ID)) != nil {
+				rejects[i] = errs.ErrAccountExists
+				continue
+			}
+			c := *val
+			c.InitialBalance = c.Balance
+			if err := put(b, []byte(val.ID), &c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejects, nil
+}
+
+// ImportPayments stores payments in one transaction, without changing balances of accounts and without domain
+// events. Initial balances are changed instead.
+func (r *bulkRepository) ImportPayments(ctx context.Context, payments []*payment.Payment, dryRun bool) (
+	map[int]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rejects map[int]error
+	err := r.update(dryRun, func(tx *bolt.Tx) error {
+		rejects = make(map[int]error)
+		b, ids := tx.Bucket(paymentsBucket), tx.Bucket(paymentIDsBucket)
+		for i, val := range payments {
+			a, err := findAccount(tx, val.Account)
+			if err != nil {
+				return err
+			}
+			if a == nil || a.Deleted {
+				rejects[i] = errs.ErrUnknownAccount
+				continue
+			}
+			if ids.Get(val.ID[:]) != nil {
+				rejects[i] = errs.ErrPaymentExists
+				continue
+			}
+			// Current balance stays the same, initial one is changed instead by completed payments.
+			if val.Completed() {
+				switch val.Direction {
+				case payment.Outgoing:
+					a.InitialBalance = a.InitialBalance.Add(val.Amount)
+				case payment.Incoming:
+					a.InitialBalance = a.InitialBalance.Sub(val.Amount)
+				}
+				if err := put(tx.Bucket(accountsBucket), []byte(a.ID), a); err != nil {
+					return err
+				}
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := put(b, key(seq), val); err != nil {
+				return err
+			}
+			if err := ids.Put(val.ID[:], key(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejects, nil
+}
+
+// ExportAccounts calls fn for accounts in one read-only transaction, so they are consistent.
+func (r *bulkRepository) ExportAccounts(ctx context.Context, fn func(a *account.Account) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accountsBucket).ForEach(func(_, data []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			a := &account.Account{}
+			if err := decode(data, a); err != nil {
+				return err
+			}
+			if a.Deleted {
+				return nil
+			}
+			return fn(a)
+		})
+	})
+}
+
+// ExportPayments calls fn for payments in one read-only transaction, in order of storing.
+func (r *bulkRepository) ExportPayments(ctx context.Context, fn func(p *payment.Payment) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(paymentsBucket).ForEach(func(_, data []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p := &payment.Payment{}
+			if err := decode(data, p); err != nil {
+				return err
+			}
+			if p.Deleted {
+				return nil
+			}
+			return fn(p)
+		})
+	})
+}
+
+// NewBulkRepository returns a new instance of a file bulk repository.
+func NewBulkRepository(conn *bolt.DB) bulk.Repository {
+	return &bulkRepository{
+		conn: conn,
+	}
+}
@@ -0,0 +1,179 @@
+package boltdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	bolt "go.etcd.io/bbolt"
+)
+
+type interestRepository struct {
+	conn *bolt.DB
+}
+
+func findAccrual(tx *bolt.Tx, id account.ID) (*interest.Accrual, error) {
+	data := tx.Bucket(accrualsBucket).Get([]byte(id))
+	if data == nil {
+		return nil, errs.ErrNoInterestPlan
+	}
+	a := &interest.Accrual{}
+	if err := decode(data, a); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// StorePlan saves a new plan.
+func (r *interestRepository) StorePlan(ctx context.Context, plan *interest.Plan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(plansBucket)
+		if b.Get([]byte(plan.ID)) != nil {
+			return errs.ErrInterestPlanExists
+		}
+		return put(b, []byte(plan.ID), plan)
+	})
+}
+
+// FindPlan returns plan with specified id.
+func (r *interestRepository) FindPlan(ctx context.Context, id string) (*interest.Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var p *interest.Plan
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(plansBucket).Get([]byte(id))
+		if data == nil {
+			return errs.ErrUnknownInterestPlan
+		}
+		p = &interest.Plan{}
+		return decode(data, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// FindPlans returns all plans ordered by id, as keys of the bucket are.
+func (r *interestRepository) FindPlans(ctx context.Context) ([]*interest.Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pp := make([]*interest.Plan, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(plansBucket).ForEach(func(_, data []byte) error {
+			p := &interest.Plan{}
+			if err := decode(data, p); err != nil {
+				return err
+			}
+			pp = append(pp, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pp, nil
+}
+
+// Assign stores accrual of account, if account has none, or changes plan of the stored one.
+func (r *interestRepository) Assign(ctx context.Context, accrual *interest.Accrual) (*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var a *interest.Accrual
+	err := r.conn.Update(func(tx *bolt.Tx) error {
+		var err error
+		a, err = findAccrual(tx, accrual.Account)
+		switch err {
+		case nil:
+			a.Plan = accrual.Plan
+		case errs.ErrNoInterestPlan:
+			a = accrual
+		default:
+			return err
+		}
+		return put(tx.Bucket(accrualsBucket), []byte(a.Account), a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := *a
+	return &c, nil
+}
+
+// FindAccrual returns accrual of account.
+func (r *interestRepository) FindAccrual(ctx context.Context, id account.ID) (*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var a *interest.Accrual
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		var err error
+		a, err = findAccrual(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAccruals returns accruals of all accounts ordered by account, as keys of the bucket are.
+func (r *interestRepository) FindAccruals(ctx context.Context) ([]*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	aa := make([]*interest.Accrual, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(accrualsBucket).ForEach(func(_, data []byte) error {
+			a := &interest.Accrual{}
+			if err := decode(data, a); err != nil {
+				return err
+			}
+			aa = append(aa, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aa, nil
+}
+
+// Save stores accrued and posted interest together with payments of posting, in one transaction.
+func (r *interestRepository) Save(ctx context.Context, accrual *interest.Accrual, accruedThrough time.Time,
+	payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		stored, err := findAccrual(tx, accrual.Account)
+		if err != nil {
+			return err
+		}
+		if !stored.AccruedThrough.Equal(accruedThrough) {
+			return errs.ErrAccrualChanged
+		}
+		if err := storePayments(tx, payments...); err != nil {
+			return err
+		}
+		c := *accrual
+		c.Plan = stored.Plan
+		return put(tx.Bucket(accrualsBucket), []byte(c.Account), &c)
+	})
+}
+
+// NewInterestRepository returns a new instance of a file interest repository.
+func NewInterestRepository(conn *bolt.DB) interest.Repository {
+	return &interestRepository{
+		conn: conn,
+	}
+}
@@ -0,0 +1,137 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/otetz/payments/event"
+	bolt "go.etcd.io/bbolt"
+)
+
+// appendEvents writes events to the outbox in the transaction of data changes, numbering them with sequence of
+// the outbox bucket. Sequences of committed events are dense and start from 1.
+func appendEvents(tx *bolt.Tx, events ...*event.Event) error {
+	b := tx.Bucket(outboxBucket)
+	for _, val := range events {
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		val.Sequence = int64(seq)
+		if err := put(b, key(seq), val); err != nil {
+			return err
+		}
+		if err := tx.Bucket(pendingBucket).Put(key(seq), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type outbox struct {
+	conn *bolt.DB
+}
+
+// Pending returns up to limit not published events, ordered by sequence.
+func (o *outbox) Pending(limit int) ([]*event.Event, error) {
+	ee := make([]*event.Event, 0, limit)
+	err := o.conn.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		c := tx.Bucket(pendingBucket).Cursor()
+		for k, _ := c.First(); k != nil && len(ee) < limit; k, _ = c.Next() {
+			e := &event.Event{}
+			if err := decode(b.Get(k), e); err != nil {
+				return err
+			}
+			ee = append(ee, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ee, nil
+}
+
+// MarkPublished marks events with specified sequence numbers as published.
+func (o *outbox) MarkPublished(sequences ...int64) error {
+	now := time.Now().UTC()
+	return o.conn.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		for _, seq := range sequences {
+			k := key(uint64(seq))
+			data := b.Get(k)
+			if data == nil {
+				continue
+			}
+			e := &event.Event{}
+			if err := decode(data, e); err != nil {
+				return err
+			}
+			e.PublishedAt = now
+			if err := put(b, k, e); err != nil {
+				return err
+			}
+			if err := tx.Bucket(pendingBucket).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Since returns up to limit events with sequence greater than specified, published or not, ordered by sequence.
+func (o *outbox) Since(sequence int64, limit int) ([]*event.Event, error) {
+	if sequence < 0 {
+		sequence = 0
+	}
+	ee := make([]*event.Event, 0, limit)
+	err := o.conn.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(outboxBucket).Cursor()
+		for k, data := c.Seek(key(uint64(sequence) + 1)); k != nil && len(ee) < limit; k, data = c.Next() {
+			e := &event.Event{}
+			if err := decode(data, e); err != nil {
+				return err
+			}
+			ee = append(ee, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ee, nil
+}
+
+// Latest returns sequence of the last event in the outbox, or zero if it is empty.
+func (o *outbox) Latest() (int64, error) {
+	var latest int64
+	err := o.conn.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(outboxBucket).Cursor().Last()
+		if k != nil {
+			latest = int64(binary.BigEndian.Uint64(k))
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// Oldest returns sequence of the first event in the outbox, or 1 if it is empty.
+func (o *outbox) Oldest() (int64, error) {
+	oldest := int64(1)
+	err := o.conn.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(outboxBucket).Cursor().First()
+		if k != nil {
+			oldest = int64(binary.BigEndian.Uint64(k))
+		}
+		return nil
+	})
+	return oldest, err
+}
+
+// NewOutbox returns a new instance of a file outbox.
+func NewOutbox(conn *bolt.DB) event.Outbox {
+	return &outbox{
+		conn: conn,
+	}
+}
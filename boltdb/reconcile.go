@@ -0,0 +1,58 @@
+package boltdb
+
+import (
+	"context"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+	bolt "go.etcd.io/bbolt"
+)
+
+type ledgerRepository struct {
+	conn *bolt.DB
+}
+
+// Ledger returns all accounts and payments in one read-only transaction, so they are consistent.
+func (r *ledgerRepository) Ledger(ctx context.Context) ([]*account.Account, []*payment.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	accounts := make([]*account.Account, 0)
+	payments := make([]*payment.Payment, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(accountsBucket).ForEach(func(_, data []byte) error {
+			a := &account.Account{}
+			if err := decode(data, a); err != nil {
+				return err
+			}
+			accounts = append(accounts, a)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(paymentsBucket).ForEach(func(_, data []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			p := &payment.Payment{}
+			if err := decode(data, p); err != nil {
+				return err
+			}
+			payments = append(payments, p)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return accounts, payments, nil
+}
+
+// NewLedgerRepository returns a new instance of a file ledger repository.
+func NewLedgerRepository(conn *bolt.DB) reconcile.Repository {
+	return &ledgerRepository{
+		conn: conn,
+	}
+}
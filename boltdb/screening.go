@@ -0,0 +1,67 @@
+package boltdb
+
+import (
+	"context"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/screening"
+	bolt "go.etcd.io/bbolt"
+)
+
+type screeningRepository struct {
+	conn *bolt.DB
+}
+
+// StoreHits saves new hits, keyed by sequence of the bucket.
+func (r *screeningRepository) StoreHits(ctx context.Context, hits ...*screening.Hit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(hitsBucket)
+		for _, val := range hits {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			c := *val
+			c.Sequence = int64(seq)
+			if err := put(b, key(seq), &c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindHits returns hits of account, or of all accounts if id is empty, in order of their storing, as keys of the
+// bucket are.
+func (r *screeningRepository) FindHits(ctx context.Context, id account.ID) ([]*screening.Hit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hh := make([]*screening.Hit, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(hitsBucket).ForEach(func(_, data []byte) error {
+			h := &screening.Hit{}
+			if err := decode(data, h); err != nil {
+				return err
+			}
+			if id == "" || h.Account == id {
+				hh = append(hh, h)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hh, nil
+}
+
+// NewScreeningRepository returns a new instance of a file screening repository.
+func NewScreeningRepository(conn *bolt.DB) screening.Repository {
+	return &screeningRepository{
+		conn: conn,
+	}
+}
@@ -0,0 +1,139 @@
+package boltdb
+
+import (
+	"context"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/webhook"
+	bolt "go.etcd.io/bbolt"
+)
+
+type webhookRepository struct {
+	conn *bolt.DB
+}
+
+func findSubscription(tx *bolt.Tx, id uuid.UUID) (*webhook.Subscription, error) {
+	data := tx.Bucket(subscriptionsBucket).Get(id[:])
+	if data == nil {
+		return nil, errs.ErrUnknownSubscription
+	}
+	s := &webhook.Subscription{}
+	if err := decode(data, s); err != nil {
+		return nil, err
+	}
+	if s.Deleted {
+		return nil, errs.ErrUnknownSubscription
+	}
+	return s, nil
+}
+
+// StoreSubscription saves subscription in the repository.
+func (r *webhookRepository) StoreSubscription(ctx context.Context, s *webhook.Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(subscriptionsBucket), s.ID[:], s)
+	})
+}
+
+// FindSubscription returns active subscription with specified id.
+func (r *webhookRepository) FindSubscription(ctx context.Context, id uuid.UUID) (*webhook.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var s *webhook.Subscription
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		var err error
+		s, err = findSubscription(tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// FindAllSubscriptions returns all active subscriptions.
+func (r *webhookRepository) FindAllSubscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss := make([]*webhook.Subscription, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionsBucket).ForEach(func(_, data []byte) error {
+			s := &webhook.Subscription{}
+			if err := decode(data, s); err != nil {
+				return err
+			}
+			if !s.Deleted {
+				ss = append(ss, s)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ss, nil
+}
+
+// MarkSubscriptionDeleted is mark as deleted specified subscription.
+func (r *webhookRepository) MarkSubscriptionDeleted(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		s, err := findSubscription(tx, id)
+		if err != nil {
+			return err
+		}
+		s.Deleted = true
+		return put(tx.Bucket(subscriptionsBucket), id[:], s)
+	})
+}
+
+// StoreDelivery inserts a new delivery or updates existing one.
+func (r *webhookRepository) StoreDelivery(ctx context.Context, d *webhook.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.conn.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(deliveriesBucket), d.ID[:], d)
+	})
+}
+
+// FindDeliveries returns delivery history of the subscription, oldest first.
+func (r *webhookRepository) FindDeliveries(ctx context.Context, subscription uuid.UUID) ([]*webhook.Delivery,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dd := make([]*webhook.Delivery, 0)
+	err := r.conn.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deliveriesBucket).ForEach(func(_, data []byte) error {
+			d := &webhook.Delivery{}
+			if err := decode(data, d); err != nil {
+				return err
+			}
+			if d.Subscription == subscription {
+				dd = append(dd, d)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(dd, func(i, j int) bool { return dd[i].CreatedAt.Before(dd[j].CreatedAt) })
+	return dd, nil
+}
+
+// NewWebhookRepository returns a new instance of a file webhook repository.
+func NewWebhookRepository(conn *bolt.DB) webhook.Repository {
+	return &webhookRepository{
+		conn: conn,
+	}
+}
@@ -0,0 +1,305 @@
+package bulk_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type Case struct {
+	Name        string
+	Method      string
+	Path        string
+	ContentType string
+	Body        string
+	Status      int
+	Result      string
+}
+
+const (
+	paymentID  = "6f1c8a7e-3c1b-4a3e-9c59-2d5d7c0f5b11"
+	paymentID2 = "a2e9c1b0-6f1d-4d0b-9a3f-2c7e5d1f8b44"
+	transferID = "0b7d4c2e-8a1f-4e6b-b3d9-5c2a7f1e9d33"
+)
+
+func newHandler() (http.Handler, account.Repository) {
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	s := bulk.NewService(inmem.NewBulkRepository(accounts, payments))
+	return bulk.MakeHandler(s, log.NewNopLogger()), accounts
+}
+
+func TestBulkApi(t *testing.T) {
+	handler, accounts := newHandler()
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Balance: decimal.NewFromFloat(1), Currency: "USD"}))
+
+	accountsCSV := "id,currency,balance\nalice,USD,10.5\nbob,,0\ncarol,USD,1\nbad-id,USD,1\nbob,USD,1\ndave,EUR,1\n"
+	cases := []Case{
+		{
+			Name:   "import accounts:dry run",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/accounts?dry_run=true",
+			Body:   accountsCSV,
+			Status: http.StatusOK,
+			Result: `{"kind":"accounts","dry_run":true,"total":6,"imported":2,"rejected":4,"rejects":[
+				{"row":3,"id":"carol","error":"account already exists"},
+				{"row":4,"id":"bad-id","error":"id: must be alphanumeric, up to 255 characters"},
+				{"row":5,"id":"bob","error":"duplicate id in file"},
+				{"row":6,"id":"dave","error":"currency: \"EUR\" is not supported"}]}`,
+		},
+		{
+			Name:   "export accounts:nothing is imported by dry run",
+			Method: http.MethodGet,
+			Path:   "/api/bulk/v1/accounts",
+			Status: http.StatusOK,
+			Result: "id,balance,currency,status,name,description,reference,metadata,parent\ncarol,1,USD,active,,,,,\n",
+		},
+		{
+			Name:        "import accounts:csv",
+			Method:      http.MethodPost,
+			Path:        "/api/bulk/v1/accounts",
+			ContentType: "text/csv",
+			Body:        accountsCSV,
+			Status:      http.StatusOK,
+			Result: `{"kind":"accounts","dry_run":false,"total":6,"imported":2,"rejected":4,"rejects":[
+				{"row":3,"id":"carol","error":"account already exists"},
+				{"row":4,"id":"bad-id","error":"id: must be alphanumeric, up to 255 characters"},
+				{"row":5,"id":"bob","error":"duplicate id in file"},
+				{"row":6,"id":"dave","error":"currency: \"EUR\" is not supported"}]}`,
+		},
+		{
+			Name:        "import payments:ndjson",
+			Method:      http.MethodPost,
+			Path:        "/api/bulk/v1/payments",
+			ContentType: "application/x-ndjson",
+			Body: `{"id":"` + paymentID + `","account":"alice","direction":"outgoing","amount":2.5,"to_account":"bob",` +
+				`"transfer":"` + transferID + `","created_at":"2020-01-02T03:04:05Z"}
+
+{"id":"` + paymentID2 + `","account":"bob","direction":"incoming","amount":"2.5","from_account":"alice",` +
+				`"status":"completed","transfer":"` + transferID + `","created_at":"2020-01-02T03:04:05Z"}
+{"id":"` + paymentID2 + `","account":"bob","direction":"incoming","amount":1,"from_account":"alice"}
+{"id":"00000000-0000-0000-0000-000000000001","account":"erin","direction":"incoming","amount":1,"from_account":"bob"}
+{"id":"00000000-0000-0000-0000-000000000002","account":"bob","direction":"incoming","amount":0,"from_account":"bob"}
+{"id":"00000000-0000-0000-0000-000000000003","account":"bob","direction":"sideways","amount":1}
+{"id":"00000000-0000-0000-0000-000000000004","account":"bob","amount":1,"note":"x"}
+{"id":"00000000-0000-0000-0000-000000000005","account":"bob","direction":"incoming","amount":1,"from_account":"alice","status":"pending"}
+{"id":"00000000-0000-0000-0000-000000000006","account":"bob","direction":"incoming","amount":1,"from_account":"alice","transfer":"x"}
+`,
+			Status: http.StatusOK,
+			Result: `{"kind":"payments","dry_run":false,"total":9,"imported":2,"rejected":7,"rejects":[
+				{"row":3,"id":"` + paymentID2 + `","error":"duplicate id in file"},
+				{"row":4,"id":"00000000-0000-0000-0000-000000000001","error":"unknown account"},
+				{"row":5,"id":"00000000-0000-0000-0000-000000000002","error":"amount: must be positive"},
+				{"row":6,"id":"00000000-0000-0000-0000-000000000003","error":"direction: must be incoming or outgoing"},
+				{"row":7,"error":"json: unknown field \"note\""},
+				{"row":8,"id":"00000000-0000-0000-0000-000000000005","error":"status: only completed payments are imported"},
+				{"row":9,"id":"00000000-0000-0000-0000-000000000006","error":"transfer: must be UUID"}]}`,
+		},
+		{
+			Name:   "export accounts:balances are not changed by imported payments",
+			Method: http.MethodGet,
+			Path:   "/api/bulk/v1/accounts?format=ndjson",
+			Status: http.StatusOK,
+			Result: `{"id":"alice","balance":10.5,"currency":"USD","status":"active"}
+{"id":"bob","balance":0,"currency":"USD","status":"active"}
+{"id":"carol","balance":1,"currency":"USD","status":"active"}
+`,
+		},
+		{
+			Name:        "export payments:csv by accept header",
+			Method:      http.MethodGet,
+			Path:        "/api/bulk/v1/payments",
+			ContentType: "text/csv",
+			Status:      http.StatusOK,
+			Result: "id,account,direction,amount,to_account,from_account,status,transfer,created_at,completed_at," +
+				"description,reference,metadata\n" +
+				paymentID + ",alice,outgoing,2.5,bob,,completed," + transferID +
+				",2020-01-02T03:04:05Z,2020-01-02T03:04:05Z,,,\n" +
+				paymentID2 + ",bob,incoming,2.5,,alice,completed," + transferID +
+				",2020-01-02T03:04:05Z,2020-01-02T03:04:05Z,,,\n",
+		},
+		{
+			Name:   "import payments:csv times",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/payments?dry_run=true",
+			Body: "id,account,direction,amount,from_account,created_at\n" +
+				"00000000-0000-0000-0000-000000000007,bob,incoming,1,alice,yesterday\n",
+			Status: http.StatusOK,
+			Result: `{"kind":"payments","dry_run":true,"total":1,"imported":0,"rejected":1,"rejects":[
+				{"row":1,"id":"00000000-0000-0000-0000-000000000007",
+				"error":"created_at: \"yesterday\" is not a time in RFC 3339 format"}]}`,
+		},
+		{
+			Name:   "import accounts:amounts are not rounded",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/accounts?dry_run=true",
+			Body:   "id,balance\nfrank,1.005\ngrace,1000000000000\n",
+			Status: http.StatusOK,
+			Result: `{"kind":"accounts","dry_run":true,"total":2,"imported":0,"rejected":2,"rejects":[
+				{"row":1,"id":"frank","error":"balance: must have up to 2 decimal places and be less than 10^12"},
+				{"row":2,"id":"grace","error":"balance: must have up to 2 decimal places and be less than 10^12"}]}`,
+		},
+		{
+			Name:   "import accounts:client data and status",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/accounts?dry_run=true",
+			Body: "id,status,metadata,parent\nfrank,frozen,\"{\"\"tier\"\":\"\"gold\"\"}\",alice\n" +
+				"grace,closed,,\nheidi,,[1],\nivan,,\"{\"\"bad key\"\":\"\"x\"\"}\",\njudy,,,judy\n",
+			Status: http.StatusOK,
+			Result: `{"kind":"accounts","dry_run":true,"total":5,"imported":1,"rejected":4,"rejects":[
+				{"row":2,"id":"grace","error":"status: must be active or frozen"},
+				{"row":3,"id":"heidi","error":"metadata: must be a JSON object of strings"},
+				{"row":4,"id":"ivan","error":"metadata.bad key: key must be up to 40 latin letters, digits, '_', '-' or '.'"},
+				{"row":5,"id":"judy","error":"parent: must not be the account itself"}]}`,
+		},
+		{
+			Name:   "import:unknown column",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/accounts",
+			Body:   "id,nickname\nfrank,Frank\n",
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "import:unknown kind",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/transfers",
+			Body:   "id\n",
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "import:wrong dry run",
+			Method: http.MethodPost,
+			Path:   "/api/bulk/v1/accounts?dry_run=maybe",
+			Body:   "id\n",
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "export:unknown format",
+			Method: http.MethodGet,
+			Path:   "/api/bulk/v1/accounts?format=xml",
+			Status: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, item := range cases {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(item.Method, item.Path, strings.NewReader(item.Body))
+			if item.ContentType != "" {
+				req.Header.Set("Content-Type", item.ContentType)
+				req.Header.Set("Accept", item.ContentType)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if item.Result == "" {
+				return
+			}
+			if strings.HasPrefix(item.Result, "{\"kind\"") {
+				var got, want interface{}
+				OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+				OK(t, json.Unmarshal([]byte(item.Result), &want))
+				if diff := cmp.Diff(want, got); diff != "" {
+					t.Errorf("wrong report (-want +got):\n%s", diff)
+				}
+				return
+			}
+			if rr.Body.String() != item.Result {
+				t.Errorf("wrong body: got\n%s\nwant\n%s", rr.Body, item.Result)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Currency: "USD", Status: account.StatusFrozen,
+		Name: "Carol", Description: "savings", Reference: "C-1", Metadata: metadata.Metadata{"tier": "gold"},
+		Parent: "alice"}))
+	ps := payment.NewService(payments, accounts)
+	details := payment.Details{Description: "rent", Reference: "INV-1", Metadata: metadata.Metadata{"order": "42"}}
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(1.25), "bob", details); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.New(ctx, "bob", decimal.NewFromFloat(0.5), "alice", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+
+	bulk.BatchSize = 1
+	defer func() { bulk.BatchSize = 1000 }()
+	for _, format := range []bulk.Format{bulk.CSV, bulk.NDJSON} {
+		source := bulk.NewService(inmem.NewBulkRepository(accounts, payments))
+		targetAccounts := inmem.NewAccountRepository()
+		targetPayments := inmem.NewPaymentRepository(targetAccounts)
+		target := bulk.NewService(inmem.NewBulkRepository(targetAccounts, targetPayments))
+
+		for _, kind := range []bulk.Kind{bulk.Accounts, bulk.Payments} {
+			var exported, reexported strings.Builder
+			OK(t, source.Export(ctx, kind, format, &exported))
+			report, err := target.Import(ctx, kind, format, strings.NewReader(exported.String()), false)
+			OK(t, err)
+			if report.Rejected != 0 {
+				t.Errorf("%s %s: no rejects expected, got %+v", format, kind, report.Rejects)
+			}
+			OK(t, target.Export(ctx, kind, format, &reexported))
+			if exported.String() != reexported.String() {
+				t.Errorf("%s %s: exports differ:\n%s\n%s", format, kind, exported.String(), reexported.String())
+			}
+		}
+
+		frozen, err := targetAccounts.Find(ctx, "carol")
+		OK(t, err)
+		if frozen.Status != account.StatusFrozen || frozen.Parent != "alice" || frozen.Metadata["tier"] != "gold" {
+			t.Errorf("%s: account must keep status, parent and metadata, got %+v", format, frozen)
+		}
+
+		// Imported transfers keep their lifecycle.
+		history, err := payments.FindAll(ctx)
+		OK(t, err)
+		reversed, err := payment.NewService(targetPayments, targetAccounts).Reverse(ctx, history[len(history)-1].Transfer)
+		OK(t, err)
+		if len(reversed) != 2 {
+			t.Errorf("%s: both payments of imported transfer must be reversed, got %d", format, len(reversed))
+		}
+	}
+}
+
+func TestCancelledImport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler, _ := newHandler()
+	req := httptest.NewRequest(http.MethodPost, "/api/bulk/v1/accounts", strings.NewReader("id\nalice\n"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req.WithContext(ctx))
+	body, _ := ioutil.ReadAll(rr.Body)
+	if rr.Code == http.StatusOK {
+		t.Errorf("cancelled import must fail, got %s", body)
+	}
+}
@@ -0,0 +1,30 @@
+package bulk
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+type importRequest struct {
+	Kind   Kind
+	Format Format
+	DryRun bool
+	Body   io.Reader
+}
+
+type importResponse struct {
+	*Report
+	Err error `json:"error,omitempty"`
+}
+
+func (r importResponse) ErrError() error { return r.Err }
+
+func makeImportEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(importRequest)
+		report, err := s.Import(ctx, req.Kind, req.Format, req.Body, req.DryRun)
+		return importResponse{Report: report, Err: err}, nil
+	}
+}
@@ -0,0 +1,513 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// Columns of CSV files and fields of NDJSON objects, the first ones are required.
+var (
+	accountColumns = []string{"id", "balance", "currency", "status", "name", "description", "reference", "metadata",
+		"parent"}
+	paymentColumns = []string{"id", "account", "direction", "amount", "to_account", "from_account", "status",
+		"transfer", "created_at", "completed_at", "description", "reference", "metadata"}
+	required = map[Kind]int{Accounts: 1, Payments: 4}
+)
+
+// maxLine limits size of NDJSON line.
+const maxLine = 1 << 20
+
+type accountRecord struct {
+	ID          account.ID        `json:"id"`
+	Balance     decimal.Decimal   `json:"balance"`
+	Currency    account.Currency  `json:"currency"`
+	Status      account.Status    `json:"status,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Reference   string            `json:"reference,omitempty"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty"`
+	Parent      account.ID        `json:"parent,omitempty"`
+}
+
+type paymentRecord struct {
+	ID          string            `json:"id"`
+	Account     account.ID        `json:"account"`
+	Direction   payment.Direction `json:"direction"`
+	Amount      decimal.Decimal   `json:"amount"`
+	ToAccount   account.ID        `json:"to_account,omitempty"`
+	FromAccount account.ID        `json:"from_account,omitempty"`
+	Status      payment.Status    `json:"status,omitempty"`
+	Transfer    string            `json:"transfer,omitempty"`
+	CreatedAt   *time.Time        `json:"created_at,omitempty"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Reference   string            `json:"reference,omitempty"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty"`
+}
+
+// row is a decoded and validated row of imported file, with either record or error.
+type row struct {
+	num     int
+	id      string
+	account *account.Account
+	payment *payment.Payment
+	err     error
+}
+
+func validID(id account.ID) bool {
+	return id != "" && govalidator.IsAlphanumeric(string(id)) && len(id) <= 255
+}
+
+// validMoney reports whether amount has up to scale decimal places and fits into storage, imported amounts are never
+// rounded.
+func validMoney(amount decimal.Decimal, scale int32) bool {
+	_, ok := account.RoundReject.Round(amount, scale)
+	return ok && account.Storable(amount)
+}
+
+// validText checks client data, which limits are the same as of API.
+func validText(name, description, reference string, m metadata.Metadata) error {
+	switch {
+	case utf8.RuneCountInString(name) > 255:
+		return fmt.Errorf("name: must be up to 255 characters")
+	case utf8.RuneCountInString(description) > 1000:
+		return fmt.Errorf("description: must be up to 1000 characters")
+	case utf8.RuneCountInString(reference) > 255:
+		return fmt.Errorf("reference: must be up to 255 characters")
+	}
+	return metadata.Validate(m)
+}
+
+// newAccountRow validates account record. Parent is not checked, it may be imported after its children.
+func newAccountRow(num int, rec *accountRecord) *row {
+	r := &row{num: num, id: string(rec.ID)}
+	if rec.Currency == "" {
+		rec.Currency = account.CurrencyUSD
+	}
+	if rec.Status == "" {
+		rec.Status = account.StatusActive
+	}
+	text := validText(rec.Name, rec.Description, rec.Reference, rec.Metadata)
+	switch {
+	case !validID(rec.ID):
+		r.err = fmt.Errorf("id: must be alphanumeric, up to 255 characters")
+	case rec.Currency != account.CurrencyUSD:
+		r.err = fmt.Errorf("currency: %q is not supported", rec.Currency)
+	case rec.Balance.Sign() < 0:
+		r.err = fmt.Errorf("balance: must not be negative")
+	case !validMoney(rec.Balance, rec.Currency.Scale()):
+		r.err = fmt.Errorf("balance: must have up to %d decimal places and be less than 10^%d",
+			rec.Currency.Scale(), account.MaxPrecision-account.MaxScale)
+	case rec.Status != account.StatusActive && rec.Status != account.StatusFrozen:
+		r.err = fmt.Errorf("status: must be active or frozen")
+	case text != nil:
+		r.err = text
+	case rec.Parent != "" && !validID(rec.Parent):
+		r.err = fmt.Errorf("parent: must be alphanumeric, up to 255 characters")
+	case rec.Parent == rec.ID:
+		r.err = fmt.Errorf("parent: must not be the account itself")
+	default:
+		r.account = &account.Account{
+			ID:          rec.ID,
+			Balance:     rec.Balance,
+			Currency:    rec.Currency,
+			Status:      rec.Status,
+			Name:        rec.Name,
+			Description: rec.Description,
+			Reference:   rec.Reference,
+			Metadata:    rec.Metadata,
+			Parent:      rec.Parent,
+			Version:     1,
+		}
+	}
+	return r
+}
+
+// newPaymentRow validates payment record. Only completed payments are imported, they are history of balances.
+// Payment without transfer is a transfer of its own, identified by its id.
+func newPaymentRow(num int, rec *paymentRecord) *row {
+	r := &row{num: num, id: rec.ID}
+	id, err := uuid.Parse(rec.ID)
+	transfer := id
+	if rec.Transfer != "" {
+		transfer, _ = uuid.Parse(rec.Transfer)
+	}
+	text := validText("", rec.Description, rec.Reference, rec.Metadata)
+	counterparty := rec.ToAccount
+	if rec.Direction == payment.Incoming {
+		counterparty = rec.FromAccount
+	}
+	switch {
+	case err != nil:
+		r.err = fmt.Errorf("id: must be UUID")
+	case !validID(rec.Account):
+		r.err = fmt.Errorf("account: must be alphanumeric, up to 255 characters")
+	case rec.Direction != payment.Incoming && rec.Direction != payment.Outgoing:
+		r.err = fmt.Errorf("direction: must be incoming or outgoing")
+	case rec.Amount.Sign() <= 0:
+		r.err = fmt.Errorf("amount: must be positive")
+	case !validMoney(rec.Amount, account.CurrencyUSD.Scale()):
+		// Only USD accounts are imported.
+		r.err = fmt.Errorf("amount: must have up to %d decimal places and be less than 10^%d",
+			account.CurrencyUSD.Scale(), account.MaxPrecision-account.MaxScale)
+	case rec.Direction == payment.Outgoing && rec.FromAccount != "":
+		r.err = fmt.Errorf("from_account: must be empty for outgoing payment")
+	case rec.Direction == payment.Incoming && rec.ToAccount != "":
+		r.err = fmt.Errorf("to_account: must be empty for incoming payment")
+	case !validID(counterparty):
+		r.err = fmt.Errorf("counterparty account: must be alphanumeric, up to 255 characters")
+	case counterparty == rec.Account:
+		r.err = errs.ErrAccountsAreEqual
+	case rec.Status != "" && rec.Status != payment.StatusCompleted:
+		r.err = fmt.Errorf("status: only completed payments are imported")
+	case transfer == uuid.Nil:
+		r.err = fmt.Errorf("transfer: must be UUID")
+	case text != nil:
+		r.err = text
+	default:
+		r.payment = &payment.Payment{
+			ID:          id,
+			Account:     rec.Account,
+			Amount:      rec.Amount,
+			ToAccount:   rec.ToAccount,
+			FromAccount: rec.FromAccount,
+			Direction:   rec.Direction,
+			Transfer:    transfer,
+			Status:      payment.StatusCompleted,
+			CompletedAt: rec.CompletedAt,
+			Description: rec.Description,
+			Reference:   rec.Reference,
+			Metadata:    rec.Metadata,
+		}
+		if rec.CreatedAt != nil {
+			r.payment.CreatedAt = *rec.CreatedAt
+		}
+	}
+	return r
+}
+
+// parseTime parses optional time of the named column, empty value is no time.
+func parseTime(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %q is not a time in RFC 3339 format", name, value)
+	}
+	return &t, nil
+}
+
+// formatTime formats optional time for CSV.
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseMetadata parses metadata of CSV column, which is a JSON object, empty value is no metadata.
+func parseMetadata(value string) (metadata.Metadata, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var m metadata.Metadata
+	if err := json.Unmarshal([]byte(value), &m); err != nil {
+		return nil, fmt.Errorf("metadata: must be a JSON object of strings")
+	}
+	return m, nil
+}
+
+// formatMetadata formats metadata for CSV.
+func formatMetadata(m metadata.Metadata) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// newAccountRecord returns record of exported account, account without status is active.
+func newAccountRecord(a *account.Account) *accountRecord {
+	rec := &accountRecord{ID: a.ID, Balance: a.Balance, Currency: a.Currency, Status: a.Status, Name: a.Name,
+		Description: a.Description, Reference: a.Reference, Metadata: a.Metadata, Parent: a.Parent}
+	if rec.Status == "" {
+		rec.Status = account.StatusActive
+	}
+	return rec
+}
+
+// newPaymentRecord returns record of exported payment.
+func newPaymentRecord(p *payment.Payment) *paymentRecord {
+	rec := &paymentRecord{ID: p.ID.String(), Account: p.Account, Direction: p.Direction, Amount: p.Amount,
+		ToAccount: p.ToAccount, FromAccount: p.FromAccount, Status: p.CurrentStatus(), CompletedAt: p.CompletedAt,
+		Description: p.Description, Reference: p.Reference, Metadata: p.Metadata}
+	if p.Transfer != uuid.Nil {
+		rec.Transfer = p.Transfer.String()
+	}
+	if !p.CreatedAt.IsZero() {
+		rec.CreatedAt = &p.CreatedAt
+	}
+	return rec
+}
+
+type decoder interface {
+	// next returns the next row, or io.EOF at the end of file. Errors of file as a whole are returned, errors
+	// of rows are kept in them.
+	next() (*row, error)
+}
+
+func newDecoder(kind Kind, format Format, r io.Reader) (decoder, error) {
+	if kind != Accounts && kind != Payments {
+		return nil, errs.ValidationError{Err: fmt.Errorf("kind: %q is not one of accounts, payments", kind)}
+	}
+	switch format {
+	case CSV:
+		return newCSVDecoder(kind, r)
+	case NDJSON:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), maxLine)
+		return &ndjsonDecoder{kind: kind, scanner: s}, nil
+	}
+	return nil, errs.ValidationError{Err: fmt.Errorf("format: %q is not one of csv, ndjson", format)}
+}
+
+type csvDecoder struct {
+	kind    Kind
+	reader  *csv.Reader
+	columns []int
+	num     int
+}
+
+func newCSVDecoder(kind Kind, r io.Reader) (*csvDecoder, error) {
+	known := accountColumns
+	if kind == Payments {
+		known = paymentColumns
+	}
+	d := &csvDecoder{kind: kind, reader: csv.NewReader(r), columns: make([]int, len(known))}
+	d.reader.ReuseRecord = true
+	header, err := d.reader.Read()
+	if err == io.EOF {
+		return nil, errs.ValidationError{Err: fmt.Errorf("header: is missing")}
+	}
+	if err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+
+	for i := range d.columns {
+		d.columns[i] = -1
+	}
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		found := false
+		for j, column := range known {
+			if name == column {
+				d.columns[j], found = i, true
+			}
+		}
+		if !found {
+			return nil, errs.ValidationError{Err: fmt.Errorf("header: unknown column %q", name)}
+		}
+	}
+	for j := 0; j < required[kind]; j++ {
+		if d.columns[j] < 0 {
+			return nil, errs.ValidationError{Err: fmt.Errorf("header: column %q is missing", known[j])}
+		}
+	}
+	return d, nil
+}
+
+// value returns field of column j, or empty string if there is no such column.
+func (d *csvDecoder) value(record []string, j int) string {
+	if d.columns[j] < 0 {
+		return ""
+	}
+	return strings.TrimSpace(record[d.columns[j]])
+}
+
+func (d *csvDecoder) next() (*row, error) {
+	record, err := d.reader.Read()
+	if err == io.EOF {
+		return nil, err
+	}
+	d.num++
+	if e, ok := err.(*csv.ParseError); ok && e.Err == csv.ErrFieldCount {
+		return &row{num: d.num, err: fmt.Errorf("wrong number of fields")}, nil
+	}
+	if err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+
+	if d.kind == Accounts {
+		rec := &accountRecord{
+			ID:          account.ID(d.value(record, 0)),
+			Currency:    account.Currency(d.value(record, 2)),
+			Status:      account.Status(d.value(record, 3)),
+			Name:        d.value(record, 4),
+			Description: d.value(record, 5),
+			Reference:   d.value(record, 6),
+			Parent:      account.ID(d.value(record, 8)),
+		}
+		if balance := d.value(record, 1); balance != "" {
+			if rec.Balance, err = decimal.NewFromString(balance); err != nil {
+				err = fmt.Errorf("balance: %q is not a number", balance)
+				return &row{num: d.num, id: string(rec.ID), err: err}, nil
+			}
+		}
+		if rec.Metadata, err = parseMetadata(d.value(record, 7)); err != nil {
+			return &row{num: d.num, id: string(rec.ID), err: err}, nil
+		}
+		return newAccountRow(d.num, rec), nil
+	}
+	rec := &paymentRecord{
+		ID:          d.value(record, 0),
+		Account:     account.ID(d.value(record, 1)),
+		Direction:   payment.Direction(d.value(record, 2)),
+		ToAccount:   account.ID(d.value(record, 4)),
+		FromAccount: account.ID(d.value(record, 5)),
+		Status:      payment.Status(d.value(record, 6)),
+		Transfer:    d.value(record, 7),
+		Description: d.value(record, 10),
+		Reference:   d.value(record, 11),
+	}
+	if rec.Amount, err = decimal.NewFromString(d.value(record, 3)); err != nil {
+		return &row{num: d.num, id: rec.ID, err: fmt.Errorf("amount: %q is not a number", d.value(record, 3))}, nil
+	}
+	if rec.CreatedAt, err = parseTime("created_at", d.value(record, 8)); err != nil {
+		return &row{num: d.num, id: rec.ID, err: err}, nil
+	}
+	if rec.CompletedAt, err = parseTime("completed_at", d.value(record, 9)); err != nil {
+		return &row{num: d.num, id: rec.ID, err: err}, nil
+	}
+	if rec.Metadata, err = parseMetadata(d.value(record, 12)); err != nil {
+		return &row{num: d.num, id: rec.ID, err: err}, nil
+	}
+	return newPaymentRow(d.num, rec), nil
+}
+
+type ndjsonDecoder struct {
+	kind    Kind
+	scanner *bufio.Scanner
+	num     int
+}
+
+func (d *ndjsonDecoder) next() (*row, error) {
+	var line []byte
+	for len(line) == 0 {
+		if !d.scanner.Scan() {
+			if err := d.scanner.Err(); err != nil {
+				return nil, errs.MalformedRequestError{Err: err}
+			}
+			return nil, io.EOF
+		}
+		line = bytes.TrimSpace(d.scanner.Bytes())
+	}
+	d.num++
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.DisallowUnknownFields()
+	if d.kind == Accounts {
+		rec := &accountRecord{}
+		if err := dec.Decode(rec); err != nil {
+			return &row{num: d.num, err: err}, nil
+		}
+		return newAccountRow(d.num, rec), nil
+	}
+	rec := &paymentRecord{}
+	if err := dec.Decode(rec); err != nil {
+		return &row{num: d.num, err: err}, nil
+	}
+	return newPaymentRow(d.num, rec), nil
+}
+
+type encoder interface {
+	// encode writes account or payment.
+	encode(v interface{}) error
+	// flush writes buffered data.
+	flush() error
+}
+
+func newEncoder(kind Kind, format Format, w io.Writer) (encoder, error) {
+	if kind != Accounts && kind != Payments {
+		return nil, errs.ValidationError{Err: fmt.Errorf("kind: %q is not one of accounts, payments", kind)}
+	}
+	switch format {
+	case CSV:
+		header := accountColumns
+		if kind == Payments {
+			header = paymentColumns
+		}
+		e := &csvEncoder{writer: csv.NewWriter(w)}
+		return e, e.writer.Write(header)
+	case NDJSON:
+		b := bufio.NewWriter(w)
+		return &ndjsonEncoder{buf: b, enc: json.NewEncoder(b)}, nil
+	}
+	return nil, errs.ValidationError{Err: fmt.Errorf("format: %q is not one of csv, ndjson", format)}
+}
+
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) encode(v interface{}) error {
+	switch val := v.(type) {
+	case *account.Account:
+		m, err := formatMetadata(val.Metadata)
+		if err != nil {
+			return err
+		}
+		rec := newAccountRecord(val)
+		return e.writer.Write([]string{string(rec.ID), rec.Balance.String(), string(rec.Currency), string(rec.Status),
+			rec.Name, rec.Description, rec.Reference, m, string(rec.Parent)})
+	case *payment.Payment:
+		m, err := formatMetadata(val.Metadata)
+		if err != nil {
+			return err
+		}
+		rec := newPaymentRecord(val)
+		return e.writer.Write([]string{rec.ID, string(rec.Account), string(rec.Direction), rec.Amount.String(),
+			string(rec.ToAccount), string(rec.FromAccount), string(rec.Status), rec.Transfer,
+			formatTime(rec.CreatedAt), formatTime(rec.CompletedAt), rec.Description, rec.Reference, m})
+	}
+	return fmt.Errorf("unsupported record %T", v)
+}
+
+func (e *csvEncoder) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+type ndjsonEncoder struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) encode(v interface{}) error {
+	switch val := v.(type) {
+	case *account.Account:
+		return e.enc.Encode(newAccountRecord(val))
+	case *payment.Payment:
+		return e.enc.Encode(newPaymentRecord(val))
+	}
+	return fmt.Errorf("unsupported record %T", v)
+}
+
+func (e *ndjsonEncoder) flush() error {
+	return e.buf.Flush()
+}
@@ -0,0 +1,62 @@
+package bulk
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/tracing"
+)
+
+type loggingService struct {
+	logger log.Logger
+	Service
+}
+
+// NewLoggingService returns a new instance of a logging Service.
+func NewLoggingService(logger log.Logger, s Service) Service {
+	return &loggingService{logger, s}
+}
+
+// Import is logging wrapper for import.
+func (s *loggingService) Import(ctx context.Context, kind Kind, format Format, r io.Reader, dryRun bool) (
+	report *Report, err error) {
+	defer func(begin time.Time) {
+		var total, imported, rejected int
+		if report != nil {
+			total, imported, rejected = report.Total, report.Imported, report.Rejected
+		}
+		_ = s.logger.Log(
+			"method", "import",
+			"kind", kind,
+			"format", format,
+			"dry_run", dryRun,
+			"total", total,
+			"imported", imported,
+			"rejected", rejected,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Import(ctx, kind, format, r, dryRun)
+}
+
+// Export is logging wrapper for export.
+func (s *loggingService) Export(ctx context.Context, kind Kind, format Format, w io.Writer) (err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "export",
+			"kind", kind,
+			"format", format,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Export(ctx, kind, format, w)
+}
@@ -0,0 +1,246 @@
+// Package bulk provides import and export of accounts and payments in CSV and NDJSON, for migrations between
+// systems. Imported data is stored as it is: imported payments are history, they do not change balances of
+// accounts, and no domain events or webhooks are produced.
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+)
+
+// Kind of imported or exported records.
+type Kind string
+
+const (
+	Accounts Kind = "accounts"
+	Payments Kind = "payments"
+)
+
+// Format of imported or exported file.
+type Format string
+
+const (
+	CSV    Format = "csv"
+	NDJSON Format = "ndjson"
+)
+
+// BatchSize is a number of valid rows stored at once by import.
+var BatchSize = 1000
+
+// MaxRejects limits number of rejects listed in the report, all of them are counted.
+var MaxRejects = 1000
+
+// Reject is a row of imported file, which is not stored.
+type Reject struct {
+	Row   int    `json:"row"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report is a result of import.
+type Report struct {
+	Kind     Kind     `json:"kind"`
+	DryRun   bool     `json:"dry_run"`
+	Total    int      `json:"total"`
+	Imported int      `json:"imported"`
+	Rejected int      `json:"rejected"`
+	Rejects  []Reject `json:"rejects"`
+}
+
+// Service is the interface that provides import and export methods.
+type Service interface {
+	// Import validates and stores records from r. Invalid rows and rows rejected by storage are reported, the
+	// rest is stored. In dry-run mode nothing is stored, but report is the same.
+	Import(ctx context.Context, kind Kind, format Format, r io.Reader, dryRun bool) (*Report, error)
+
+	// Export writes all not deleted records to w, as they are at one point in time. Only completed payments are
+	// written, as only they are imported.
+	Export(ctx context.Context, kind Kind, format Format, w io.Writer) error
+}
+
+type service struct {
+	repository Repository
+}
+
+// Import validates and stores records from r.
+func (s *service) Import(ctx context.Context, kind Kind, format Format, r io.Reader, dryRun bool) (*Report,
+	error) {
+	dec, err := newDecoder(kind, format, r)
+	if err != nil {
+		return nil, err
+	}
+	im := &importer{
+		ctx:        ctx,
+		kind:       kind,
+		dryRun:     dryRun,
+		repository: s.repository,
+		report:     &Report{Kind: kind, DryRun: dryRun, Rejects: make([]Reject, 0)},
+		seen:       make(map[string]bool),
+		now:        time.Now().UTC(),
+	}
+	for {
+		row, err := dec.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := im.add(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := im.flush(); err != nil {
+		return nil, err
+	}
+	// Rejects of storage are found later, than of validation.
+	rejects := im.report.Rejects
+	sort.SliceStable(rejects, func(i, j int) bool { return rejects[i].Row < rejects[j].Row })
+	return im.report, nil
+}
+
+// Export writes all not deleted records to w, payments which are not completed are skipped.
+func (s *service) Export(ctx context.Context, kind Kind, format Format, w io.Writer) error {
+	enc, err := newEncoder(kind, format, w)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case Accounts:
+		err = s.repository.ExportAccounts(ctx, func(a *account.Account) error { return enc.encode(a) })
+	case Payments:
+		err = s.repository.ExportPayments(ctx, func(p *payment.Payment) error {
+			if !p.Completed() {
+				return nil
+			}
+			return enc.encode(p)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return enc.flush()
+}
+
+// NewService creates a bulk service with necessary dependencies.
+func NewService(repository Repository) Service {
+	return &service{
+		repository: repository,
+	}
+}
+
+// importer collects valid rows into batches and fills the report.
+type importer struct {
+	ctx        context.Context
+	kind       Kind
+	dryRun     bool
+	repository Repository
+	report     *Report
+	// seen ids of the file, so duplicates are rejected in dry-run mode too.
+	seen map[string]bool
+	// now is a time of import, payments without times are created and completed at it.
+	now      time.Time
+	rows     []int
+	ids      []string
+	accounts []*account.Account
+	payments []*payment.Payment
+}
+
+func (im *importer) reject(row int, id string, err error) {
+	im.report.Rejected++
+	if len(im.report.Rejects) < MaxRejects {
+		im.report.Rejects = append(im.report.Rejects, Reject{Row: row, ID: id, Error: err.Error()})
+	}
+}
+
+func (im *importer) add(r *row) error {
+	im.report.Total++
+	if r.err != nil {
+		im.reject(r.num, r.id, r.err)
+		return nil
+	}
+	var id string
+	switch {
+	case r.account != nil:
+		id = string(r.account.ID)
+	case r.payment != nil:
+		id = r.payment.ID.String()
+	}
+	if im.seen[id] {
+		im.reject(r.num, id, fmt.Errorf("duplicate id in file"))
+		return nil
+	}
+	im.seen[id] = true
+
+	im.rows = append(im.rows, r.num)
+	im.ids = append(im.ids, id)
+	if r.account != nil {
+		im.accounts = append(im.accounts, r.account)
+	} else {
+		p := r.payment
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = im.now
+		}
+		if p.CompletedAt == nil {
+			completed := p.CreatedAt
+			p.CompletedAt = &completed
+		}
+		im.payments = append(im.payments, p)
+	}
+	if len(im.rows) >= BatchSize {
+		return im.flush()
+	}
+	return nil
+}
+
+// flush stores the batch and reports its rejects.
+func (im *importer) flush() error {
+	if len(im.rows) == 0 {
+		return nil
+	}
+	var (
+		rejects map[int]error
+		err     error
+	)
+	switch im.kind {
+	case Accounts:
+		rejects, err = im.repository.ImportAccounts(im.ctx, im.accounts, im.dryRun)
+	case Payments:
+		rejects, err = im.repository.ImportPayments(im.ctx, im.payments, im.dryRun)
+	}
+	if err != nil {
+		return err
+	}
+	for i := range im.rows {
+		if e, ok := rejects[i]; ok {
+			im.reject(im.rows[i], im.ids[i], e)
+			continue
+		}
+		im.report.Imported++
+	}
+	im.rows, im.ids, im.accounts, im.payments = im.rows[:0], im.ids[:0], im.accounts[:0], im.payments[:0]
+	return nil
+}
+
+// Repository interface for storing of imported data and reading of exported one.
+type Repository interface {
+	// ImportAccounts stores accounts as they are. Accounts with used ids are rejected with errs.ErrAccountExists,
+	// rejects are returned by index in the batch. In dry-run mode rejects are found, but nothing is stored.
+	ImportAccounts(ctx context.Context, accounts []*account.Account, dryRun bool) (map[int]error, error)
+
+	// ImportPayments stores payments without changing balances of accounts. Payments of unknown or deleted accounts
+	// are rejected with errs.ErrUnknownAccount, payments with used ids with errs.ErrPaymentExists.
+	ImportPayments(ctx context.Context, payments []*payment.Payment, dryRun bool) (map[int]error, error)
+
+	// ExportAccounts calls fn for all not deleted accounts, ordered by id, as they are at one point in time.
+	ExportAccounts(ctx context.Context, fn func(a *account.Account) error) error
+
+	// ExportPayments calls fn for all not deleted payments, as they are at one point in time.
+	ExportPayments(ctx context.Context, fn func(p *payment.Payment) error) error
+}
@@ -0,0 +1,119 @@
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/tracing"
+
+	kitlog "github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// contentTypes of formats.
+var contentTypes = map[Format]string{
+	CSV:    "text/csv",
+	NDJSON: "application/x-ndjson",
+}
+
+// MakeHandler returns a handler for the bulk service. Requests may be long, so handler should not be limited by
+// request timeout.
+func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext),
+	}
+
+	importHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("bulk.import")(makeImportEndpoint(s)),
+		decodeImportRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	router := mux.NewRouter()
+
+	router.Handle("/api/bulk/v1/{kind}", importHandler).Methods("POST")
+	router.Handle("/api/bulk/v1/{kind}", exportHandler(s, logger)).Methods("GET")
+
+	return router
+}
+
+// formatOf returns format of "format" query parameter, or of media type in the header, CSV by default.
+func formatOf(r *http.Request, header string) Format {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return Format(f)
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get(header))
+	for format, contentType := range contentTypes {
+		if mediaType == contentType {
+			return format
+		}
+	}
+	return CSV
+}
+
+func decodeImportRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := importRequest{
+		Kind:   Kind(mux.Vars(r)["kind"]),
+		Format: formatOf(r, "Content-Type"),
+		Body:   r.Body,
+	}
+	if val := r.URL.Query().Get("dry_run"); val != "" {
+		dryRun, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, errs.ValidationError{Err: fmt.Errorf("dry_run: %q is not a boolean", val)}
+		}
+		req.DryRun = dryRun
+	}
+	return req, nil
+}
+
+// countingWriter tells, if anything is written to the response.
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+// exportHandler streams records to the response. Error before the first written byte is reported as usual,
+// after it the response is cut, so client sees incomplete body.
+func exportHandler(s Service, logger kitlog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := errs.PopulateRequestContext(r.Context(), r)
+		kind, format := Kind(mux.Vars(r)["kind"]), formatOf(r, "Accept")
+
+		w.Header().Set("Content-Type", contentTypes[format]+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", string(kind)+"."+string(format)))
+		cw := &countingWriter{ResponseWriter: w}
+		err := s.Export(ctx, kind, format, cw)
+		if err == nil {
+			return
+		}
+		if cw.written == 0 {
+			w.Header().Del("Content-Disposition")
+			errs.EncodeError(ctx, err, w)
+			return
+		}
+		_ = logger.Log(
+			"err", err,
+			"msg", "export is cut",
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+		)
+		// Abort the response, so it is not taken for complete one.
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,447 @@
+// Package config builds configuration of the application from defaults, configuration file,
+// environment variables and command-line flags.
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is a prefix of environment variables, upper-cased flag name follows it (e.g. PAYMENTS_DB_PASSWORD).
+const EnvPrefix = "PAYMENTS_"
+
+// Redacted replaces values of secrets in printed configuration.
+const Redacted = "******"
+
+// Config is an effective configuration of the application.
+type Config struct {
+	HTTP      HTTP
+	Storage   Storage
+	DB        DB
+	Webhook   Webhook
+	Outbox    Outbox
+	Shutdown  Shutdown
+	Tracing   Tracing
+	Bulk      Bulk
+	Reconcile Reconcile
+	Accounts  Accounts
+	Admin     Admin
+	Amounts   Amounts
+	Interest  Interest
+	Approvals Approvals
+	Risk      Risk
+	Screening Screening
+
+	fs      *flag.FlagSet
+	secrets map[string]*string
+}
+
+// HTTP is a configuration of the web server.
+type HTTP struct {
+	Address        string
+	LegacyErrors   bool
+	RequestTimeout time.Duration
+}
+
+// Storage backends of application data.
+const (
+	StoragePostgres = "postgres"
+	StorageInmem    = "inmem"
+	StorageFile     = "file"
+)
+
+// Storage is a configuration of application data storage.
+type Storage struct {
+	Backend          string
+	File             string
+	InmemDir         string
+	SnapshotInterval time.Duration
+}
+
+// DB is a configuration of PostgreSQL connection.
+type DB struct {
+	Address  string
+	User     string
+	Password string
+	Database string
+	AppName  string
+	PoolSize int
+	Log      bool
+}
+
+// Webhook is a configuration of webhook deliveries.
+type Webhook struct {
+	Workers     int
+	MaxAttempts int
+}
+
+// Outbox is a configuration of domain events relay.
+type Outbox struct {
+	Publisher string
+	Interval  time.Duration
+}
+
+// Tracing is a configuration of distributed tracing.
+type Tracing struct {
+	Endpoint string
+	Service  string
+}
+
+// Bulk is a configuration of import and export commands.
+type Bulk struct {
+	Format string
+	DryRun bool
+}
+
+// Reconcile is a configuration of ledger reconciliation job.
+type Reconcile struct {
+	Interval time.Duration
+}
+
+// Accounts is a configuration of deleted accounts retention.
+type Accounts struct {
+	PurgeAfter    time.Duration
+	PurgeInterval time.Duration
+}
+
+// Admin is a configuration of the admin API.
+type Admin struct {
+	Token string
+}
+
+// Amounts is a configuration of validation of amounts of money.
+type Amounts struct {
+	Rounding string
+}
+
+// Approvals is a configuration of approval of large transfers by a second principal.
+type Approvals struct {
+	// Threshold is a decimal amount, transfers above it must be approved. Approvals are not required if it is empty.
+	Threshold string
+	TTL       time.Duration
+	// PrincipalSecret is shared with the proxy, which sets principals of requests. Requests are anonymous without it.
+	PrincipalSecret string
+}
+
+// Risk is a configuration of fraud rules evaluated before transfers.
+type Risk struct {
+	Rules string
+}
+
+// Screening is a configuration of screening of account holders against a blocklist.
+type Screening struct {
+	List      string
+	Threshold float64
+	Interval  time.Duration
+}
+
+// Interest is a configuration of interest accrual job.
+type Interest struct {
+	Account  string
+	Interval time.Duration
+}
+
+// Shutdown is a configuration of health checks and graceful shutdown.
+type Shutdown struct {
+	HealthTimeout time.Duration
+	Delay         time.Duration
+	Timeout       time.Duration
+}
+
+func (c *Config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.HTTP.Address, "http_address", "0.0.0.0:8080", "Http address for web server running")
+	fs.BoolVar(&c.HTTP.LegacyErrors, "legacy_errors", false,
+		"Respond with old {\"error\": \"...\"} errors instead of application/problem+json")
+	fs.DurationVar(&c.HTTP.RequestTimeout, "request_timeout", 30*time.Second,
+		"Deadline of API requests, except of live feed (disabled if 0)")
+
+	fs.StringVar(&c.Storage.Backend, "storage", StoragePostgres,
+		"Storage backend: postgres, inmem (data is lost on restart) or file (embedded single-file database)")
+	fs.StringVar(&c.Storage.File, "storage_file", "payments.db", "Path to database file of file storage")
+	fs.StringVar(&c.Storage.InmemDir, "inmem_dir", "",
+		"Directory for snapshots and write-ahead log of inmem storage (data is lost on restart if empty)")
+	fs.DurationVar(&c.Storage.SnapshotInterval, "inmem_snapshot_interval", time.Minute,
+		"Pause between snapshots of inmem storage (periodic snapshots are disabled if 0)")
+
+	fs.StringVar(&c.DB.Address, "db_address", "localhost:5432", "Address to connect to PostgreSQL server")
+	fs.StringVar(&c.DB.User, "db_user", "postgres", "PostgreSQL connection user")
+	c.secret(fs, &c.DB.Password, "db_password", "PostgreSQL connection password")
+	fs.StringVar(&c.DB.Database, "database", "payments", "PostgreSQL database name")
+	fs.StringVar(&c.DB.AppName, "app_name", "payments", "PostgreSQL application name (for logging)")
+	fs.IntVar(&c.DB.PoolSize, "pool_size", 10, "PostgreSQL connection pool size")
+	fs.BoolVar(&c.DB.Log, "db_log", false, "Switch for statements logging")
+
+	fs.IntVar(&c.Webhook.Workers, "webhook_workers", 4, "Number of concurrent webhook deliveries")
+	fs.IntVar(&c.Webhook.MaxAttempts, "webhook_max_attempts", 8,
+		"Number of webhook delivery attempts before dead letter")
+
+	fs.StringVar(&c.Outbox.Publisher, "outbox_publisher", "",
+		"Where to publish domain events from the outbox: \"-\" for stdout, or file path (disabled if empty)")
+	fs.DurationVar(&c.Outbox.Interval, "outbox_interval", time.Second, "Pause between outbox polls")
+
+	fs.DurationVar(&c.Shutdown.HealthTimeout, "health_timeout", 2*time.Second,
+		"Timeout of health and readiness checks")
+	fs.DurationVar(&c.Shutdown.Delay, "shutdown_delay", 5*time.Second,
+		"Pause between readiness turning off and stop of accepting connections")
+	fs.DurationVar(&c.Shutdown.Timeout, "shutdown_timeout", 30*time.Second,
+		"Time to wait for in-flight requests on shutdown")
+
+	fs.StringVar(&c.Tracing.Endpoint, "tracing_endpoint", "",
+		"OTLP/HTTP endpoint to send spans to, e.g. http://localhost:4318/v1/traces (disabled if empty)")
+	fs.StringVar(&c.Tracing.Service, "tracing_service", "payments", "Service name reported in traces")
+
+	fs.StringVar(&c.Bulk.Format, "bulk_format", "",
+		"Format of import and export commands: csv or ndjson (by file extension if empty)")
+	fs.BoolVar(&c.Bulk.DryRun, "bulk_dry_run", false, "Validate imported file and report rejects without storing")
+
+	fs.DurationVar(&c.Reconcile.Interval, "reconcile_interval", time.Hour,
+		"Pause between reconciliations of the ledger by the server (disabled if 0)")
+
+	fs.DurationVar(&c.Accounts.PurgeAfter, "account_purge_after", 30*24*time.Hour,
+		"Time after deletion, when account may not be restored anymore and is erased with its payments "+
+			"(never if 0)")
+	fs.DurationVar(&c.Accounts.PurgeInterval, "account_purge_interval", time.Hour,
+		"Pause between purges of deleted accounts by the server")
+
+	fs.StringVar(&c.Amounts.Rounding, "amount_rounding", "reject",
+		"Rounding of amounts with more decimal places than currency has: reject, half_up, half_even or down")
+
+	fs.StringVar(&c.Interest.Account, "interest_account", "",
+		"Interest expense account, which pays interest to accounts with interest plans (no interest if empty)")
+	fs.DurationVar(&c.Interest.Interval, "interest_interval", time.Hour,
+		"Pause between runs of interest accrual and posting by the server")
+
+	fs.StringVar(&c.Approvals.Threshold, "approval_threshold", "",
+		"Amount of transfer, above which it must be approved by another principal (no approvals if empty)")
+	fs.DurationVar(&c.Approvals.TTL, "approval_ttl", 24*time.Hour,
+		"Time after which pending transfer expires and its money is released")
+
+	fs.StringVar(&c.Risk.Rules, "risk_rules", "",
+		"Path to YAML or JSON file of risk rules evaluated before transfers (no rules if empty)")
+
+	fs.StringVar(&c.Screening.List, "screening_list", "",
+		"Path to CSV or JSON blocklist, which holders of accounts are screened against (no screening if empty)")
+	fs.Float64Var(&c.Screening.Threshold, "screening_threshold", 0.85,
+		"Minimal similarity of names from 0 to 1, which is a match with blocklist")
+	fs.DurationVar(&c.Screening.Interval, "screening_interval", 10*time.Second,
+		"Pause between checks of the blocklist file for changes")
+
+	c.secret(fs, &c.Approvals.PrincipalSecret, "principal_secret",
+		"Secret, which authenticating proxy sends with principal of request (requests are anonymous if empty)")
+	c.secret(fs, &c.Admin.Token, "admin_token",
+		"Bearer token required by the admin API and to manage interest plans (admin API is disabled if empty)")
+}
+
+// secret registers setting, which may be read from file specified by "<name>_file" setting.
+func (c *Config) secret(fs *flag.FlagSet, p *string, name, usage string) {
+	fs.StringVar(p, name, "", usage)
+	c.secrets[name] = fs.String(name+"_file", "", "File to read "+name+" from (overrides "+name+")")
+}
+
+// Validate checks that configuration is usable.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.HTTP.Address)
+	check(err == nil, "http_address: %q is not a host:port", c.HTTP.Address)
+	check(c.HTTP.RequestTimeout >= 0, "request_timeout: must not be negative")
+	switch c.Storage.Backend {
+	case StoragePostgres:
+	case StorageInmem:
+		check(c.Storage.SnapshotInterval >= 0, "inmem_snapshot_interval: must not be negative")
+	case StorageFile:
+		check(c.Storage.File != "", "storage_file: must not be empty")
+	default:
+		check(false, "storage: %q is not one of postgres, inmem, file", c.Storage.Backend)
+	}
+	_, _, err = net.SplitHostPort(c.DB.Address)
+	check(err == nil, "db_address: %q is not a host:port", c.DB.Address)
+	check(c.DB.User != "", "db_user: must not be empty")
+	check(c.DB.Database != "", "database: must not be empty")
+	check(c.DB.PoolSize > 0, "pool_size: must be positive")
+	check(c.Webhook.Workers > 0, "webhook_workers: must be positive")
+	check(c.Webhook.MaxAttempts > 0, "webhook_max_attempts: must be positive")
+	check(c.Outbox.Interval > 0, "outbox_interval: must be positive")
+	check(c.Shutdown.HealthTimeout > 0, "health_timeout: must be positive")
+	check(c.Shutdown.Delay >= 0, "shutdown_delay: must not be negative")
+	check(c.Shutdown.Timeout > 0, "shutdown_timeout: must be positive")
+	if c.Tracing.Endpoint != "" {
+		u, err := url.Parse(c.Tracing.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"tracing_endpoint: %q is not an HTTP URL", c.Tracing.Endpoint)
+	}
+	check(c.Tracing.Service != "", "tracing_service: must not be empty")
+	switch c.Bulk.Format {
+	case "", "csv", "ndjson":
+	default:
+		check(false, "bulk_format: %q is not one of csv, ndjson", c.Bulk.Format)
+	}
+	check(c.Reconcile.Interval >= 0, "reconcile_interval: must not be negative")
+	check(c.Accounts.PurgeAfter >= 0, "account_purge_after: must not be negative")
+	check(c.Accounts.PurgeInterval > 0, "account_purge_interval: must be positive")
+	switch c.Amounts.Rounding {
+	case "reject", "half_up", "half_even", "down":
+	default:
+		check(false, "amount_rounding: %q is not one of reject, half_up, half_even, down", c.Amounts.Rounding)
+	}
+	check(c.Interest.Interval > 0, "interest_interval: must be positive")
+	if c.Approvals.Threshold != "" {
+		threshold, err := decimal.NewFromString(c.Approvals.Threshold)
+		check(err == nil && threshold.Sign() >= 0, "approval_threshold: %q is not a non-negative decimal",
+			c.Approvals.Threshold)
+		// Anonymous requests can neither request nor decide approvals.
+		check(c.Approvals.PrincipalSecret != "", "approval_threshold: requires principal_secret")
+	}
+	check(c.Approvals.TTL > 0, "approval_ttl: must be positive")
+	check(c.Screening.Threshold > 0 && c.Screening.Threshold <= 1, "screening_threshold: must be above 0 and at most 1")
+	check(c.Screening.Interval > 0, "screening_interval: must be positive")
+	// Matched transfers are held for review as pending ones, so approvals are necessary.
+	check(c.Screening.List == "" || c.Approvals.Threshold != "", "screening_list: requires approval_threshold")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Load builds configuration of the named command from layers, each one overrides the previous: defaults,
+// configuration file (-config flag or PAYMENTS_CONFIG), environment variables and command-line flags.
+// Secrets are read from files at last, if specified. Returned configuration is validated.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := &Config{secrets: make(map[string]*string)}
+	c.fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	path := c.fs.String("config", "", "Path to configuration file, YAML or TOML (by extension)")
+	c.register(c.fs)
+
+	if err := c.fs.Parse(args); err != nil {
+		return nil, err
+	}
+	// Flags are applied last, but file path is needed first, so remember flags and apply them again.
+	explicit := make(map[string]string)
+	c.fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if *path == "" {
+		*path, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	c.fs.VisitAll(func(f *flag.Flag) {
+		val, ok := lookupEnv(EnvPrefix + strings.ToUpper(f.Name))
+		if !ok || f.Name == "config" || err != nil {
+			return
+		}
+		if e := f.Value.Set(val); e != nil {
+			err = fmt.Errorf("%s%s: invalid value %q: %s", EnvPrefix, strings.ToUpper(f.Name), val, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for key, val := range explicit {
+		if err := c.fs.Set(key, val); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, file := range c.secrets {
+		if *file == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return nil, fmt.Errorf("%s_file: %s", key, err)
+		}
+		if err := c.fs.Set(key, strings.TrimRight(string(data), "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Args returns arguments remaining after flags.
+func (c *Config) Args() []string {
+	return c.fs.Args()
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		err = fmt.Errorf("unsupported format %q, must be .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	for key, val := range values {
+		if key == "config" || c.fs.Lookup(key) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+		if err := c.fs.Set(key, fmt.Sprint(val)); err != nil {
+			return fmt.Errorf("%s: %s: %s", path, key, err)
+		}
+	}
+	return nil
+}
+
+// Print writes effective configuration in YAML, which is a valid configuration file. Secrets are redacted.
+func (c *Config) Print(w io.Writer) error {
+	var out yaml.MapSlice
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		val := f.Value.(flag.Getter).Get()
+		if d, ok := val.(time.Duration); ok {
+			val = d.String()
+		}
+		if _, ok := c.secrets[f.Name]; ok && val != "" {
+			val = Redacted
+		}
+		out = append(out, yaml.MapItem{Key: f.Name, Value: val})
+	})
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,176 @@
+package config_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otetz/payments/config"
+)
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		val, ok := values[key]
+		return val, ok
+	}
+}
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	yamlFile := writeFile(t, dir, "payments.yaml", "db_address: db:5432\npool_size: 20\ndb_user: file\n"+
+		"outbox_interval: 3s\n")
+	tomlFile := writeFile(t, dir, "payments.toml", "db_address = \"toml:5432\"\nlegacy_errors = true\n")
+	secret := writeFile(t, dir, "password", "s3cret\n")
+
+	cfg, err := config.Load("serve", []string{"-config", yamlFile, "-pool_size", "30"}, env(map[string]string{
+		"PAYMENTS_DB_USER":          "env",
+		"PAYMENTS_POOL_SIZE":        "25",
+		"PAYMENTS_DB_PASSWORD_FILE": secret,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Address != "db:5432" {
+		t.Errorf("file must override default: got %v", cfg.DB.Address)
+	}
+	if cfg.DB.User != "env" {
+		t.Errorf("env must override file: got %v", cfg.DB.User)
+	}
+	if cfg.DB.PoolSize != 30 {
+		t.Errorf("flag must override env: got %v", cfg.DB.PoolSize)
+	}
+	if cfg.DB.Password != "s3cret" {
+		t.Errorf("secret must be read from file: got %q", cfg.DB.Password)
+	}
+	if cfg.Outbox.Interval != 3*time.Second || cfg.DB.Database != "payments" {
+		t.Errorf("wrong values: %v, %v", cfg.Outbox.Interval, cfg.DB.Database)
+	}
+
+	cfg, err = config.Load("serve", nil, env(map[string]string{"PAYMENTS_CONFIG": tomlFile}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DB.Address != "toml:5432" || !cfg.HTTP.LegacyErrors {
+		t.Errorf("TOML file is not applied: %v, %v", cfg.DB.Address, cfg.HTTP.LegacyErrors)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cases := []struct {
+		Name  string
+		Args  []string
+		Env   map[string]string
+		Error string
+	}{
+		{
+			Name:  "invalid values",
+			Args:  []string{"-pool_size", "0", "-http_address", "8080"},
+			Error: `invalid configuration: http_address: "8080" is not a host:port; pool_size: must be positive`,
+		},
+		{
+			Name:  "unknown storage",
+			Args:  []string{"-storage", "sqlite"},
+			Error: `invalid configuration: storage: "sqlite" is not one of postgres, inmem, file`,
+		},
+		{
+			Name:  "negative snapshot interval",
+			Args:  []string{"-storage", "inmem", "-inmem_snapshot_interval", "-1s"},
+			Error: `invalid configuration: inmem_snapshot_interval: must not be negative`,
+		},
+		{
+			Name:  "negative purge horizon",
+			Args:  []string{"-account_purge_after", "-1h"},
+			Error: `invalid configuration: account_purge_after: must not be negative`,
+		},
+		{
+			Name:  "unknown rounding",
+			Args:  []string{"-amount_rounding", "up"},
+			Error: `invalid configuration: amount_rounding: "up" is not one of reject, half_up, half_even, down`,
+		},
+		{
+			Name: "invalid approval threshold",
+			Args: []string{"-approval_threshold", "-100"},
+			Error: `invalid configuration: approval_threshold: "-100" is not a non-negative decimal; ` +
+				`approval_threshold: requires principal_secret`,
+		},
+		{
+			Name: "screening without approvals",
+			Args: []string{"-screening_list", "blocklist.csv", "-screening_threshold", "1.5"},
+			Error: "invalid configuration: screening_threshold: must be above 0 and at most 1; " +
+				"screening_list: requires approval_threshold",
+		},
+		{
+			Name:  "wrong env",
+			Env:   map[string]string{"PAYMENTS_WEBHOOK_WORKERS": "many"},
+			Error: `PAYMENTS_WEBHOOK_WORKERS: invalid value "many"`,
+		},
+		{
+			Name:  "unknown setting",
+			Args:  []string{"-config", writeFile(t, dir, "unknown.yml", "db_pasword: x\n")},
+			Error: `unknown setting "db_pasword"`,
+		},
+		{
+			Name:  "unsupported format",
+			Args:  []string{"-config", writeFile(t, dir, "payments.json", "{}")},
+			Error: `unsupported format ".json"`,
+		},
+		{
+			Name:  "missing secret file",
+			Env:   map[string]string{"PAYMENTS_DB_PASSWORD_FILE": filepath.Join(dir, "missing")},
+			Error: "db_password_file:",
+		},
+	}
+
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			_, err := config.Load("serve", item.Args, env(item.Env))
+			if err == nil || !strings.Contains(err.Error(), item.Error) {
+				t.Errorf("wrong error: got %v want %v", err, item.Error)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg, err := config.Load("config print", []string{"-db_password", "s3cret"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := cfg.Print(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "s3cret") {
+		t.Errorf("secret is not redacted:\n%s", out)
+	}
+	for _, line := range []string{"db_password: '******'", "pool_size: 10", "shutdown_timeout: 30s"} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("line %q is missing:\n%s", line, out)
+		}
+	}
+}
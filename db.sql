@@ -1,17 +1,85 @@
 CREATE INDEX payments_account_direction_index ON payments (account, direction);
 
+-- Mutable fields of accounts, for databases created before they were added.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS name text NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS status varchar(16) NOT NULL DEFAULT 'active';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS transfer_limit decimal(16,4);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+
+-- Client data of accounts and payments, metadata is filtered by containment (@>) with GIN indexes.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS reference text NOT NULL DEFAULT '';
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS metadata jsonb;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS description text NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS reference text NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS metadata jsonb;
+CREATE INDEX IF NOT EXISTS accounts_metadata_index ON accounts USING GIN (metadata jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS payments_metadata_index ON payments USING GIN (metadata jsonb_path_ops);
+
+-- Time of deletion, deleted accounts are purged after the horizon since it.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+UPDATE accounts SET deleted_at = now() WHERE deleted AND deleted_at IS NULL;
+
+-- Hierarchy of accounts, children are found by parent when tree is loaded or parent is deleted.
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS parent varchar(255);
+ALTER TABLE accounts ADD COLUMN IF NOT EXISTS tree_transfer_limit decimal(16,4);
+CREATE INDEX IF NOT EXISTS accounts_parent_index ON accounts (parent) WHERE parent IS NOT NULL;
+
+-- Target accounts of split transfers, in their outgoing payments.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS splits jsonb;
+
+-- Lifecycle of transfers, payments stored before it are completed. Only completed payments change balances.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS transfer varchar(36);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS status varchar(16) NOT NULL DEFAULT 'completed';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failure_reason text NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS created_at timestamptz;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS completed_at timestamptz;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failed_at timestamptz;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS reversed_at timestamptz;
+CREATE INDEX IF NOT EXISTS payments_transfer_index ON payments (transfer) WHERE transfer IS NOT NULL;
+
+-- Order of storing of payments, lists of payments are sorted by it. Existing payments are numbered in any order.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS seq bigserial;
+CREATE INDEX IF NOT EXISTS payments_seq_index ON payments (seq);
+
 CREATE OR REPLACE VIEW accounts_view AS
 SELECT A.id,
        A.balance
            + (SELECT COALESCE(SUM(P.amount), 0)
               FROM payments AS P
               WHERE P.account = A.id
-              AND P.direction='incoming')
+              AND P.direction='incoming'
+              AND P.status='completed')
            - (SELECT COALESCE(SUM(P.amount), 0)
               FROM payments AS P
               WHERE P.account = A.id
-              AND P.direction='outgoing')
+              AND P.direction='outgoing'
+              AND P.status='completed')
        AS balance,
        A.currency,
-       A.deleted
+       A.deleted,
+       A.name,
+       A.status,
+       A.transfer_limit,
+       A.version,
+       A.description,
+       A.reference,
+       A.metadata,
+       A.deleted_at,
+       A.parent,
+       A.tree_transfer_limit
 FROM accounts AS A;
+
+CREATE INDEX IF NOT EXISTS outbox_pending_index ON outbox (sequence) WHERE published_at IS NULL;
+
+-- Money reserved by pending transfers of an account.
+CREATE INDEX IF NOT EXISTS approvals_pending_index ON approvals (from_account) WHERE status = 'pending';
+
+-- Decisions of risk rules about transfers, for databases created before they were added.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk varchar(16) NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS risk_reasons jsonb;
+ALTER TABLE approvals ADD COLUMN IF NOT EXISTS risk varchar(16) NOT NULL DEFAULT '';
+ALTER TABLE approvals ADD COLUMN IF NOT EXISTS risk_reasons jsonb;
+
+-- Audit trail of screening of an account.
+CREATE INDEX IF NOT EXISTS screening_hits_account_index ON screening_hits (account, sequence);
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+type approvalRepository struct {
+	conn *pg.DB
+}
+
+// StoreApproval saves a new pending transfer.
+func (r *approvalRepository) StoreApproval(ctx context.Context, approval *payment.Approval) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return contextError(ctx, r.conn.WithContext(ctx).Insert(approval))
+}
+
+// FindApproval returns transfer with specified id.
+func (r *approvalRepository) FindApproval(ctx context.Context, id uuid.UUID) (*payment.Approval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a := &payment.Approval{ID: id}
+	err := r.conn.WithContext(ctx).Select(a)
+	if err == pg.ErrNoRows {
+		return nil, errs.ErrUnknownApproval
+	}
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return a, nil
+}
+
+// FindApprovals returns all transfers ordered by time of creation.
+func (r *approvalRepository) FindApprovals(ctx context.Context) ([]*payment.Approval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	aa := make([]*payment.Approval, 0)
+	if err := r.conn.WithContext(ctx).Model(&aa).Order("created_at", "id").Select(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return aa, nil
+}
+
+// Reserved returns total amount of transfers from account, which are pending and expire after now.
+func (r *approvalRepository) Reserved(ctx context.Context, id account.ID, now time.Time) (decimal.Decimal, error) {
+	if err := ctx.Err(); err != nil {
+		return decimal.Zero, err
+	}
+	var total decimal.Decimal
+	err := r.conn.WithContext(ctx).Model((*payment.Approval)(nil)).
+		ColumnExpr("COALESCE(SUM(amount), 0)").
+		Where("from_account = ?", id).
+		Where("status = ?", payment.ApprovalPending).
+		Where("expires_at > ?", now).
+		Select(pg.Scan(&total))
+	if err != nil {
+		return decimal.Zero, contextError(ctx, err)
+	}
+	return total, nil
+}
+
+// Decide stores decision on pending transfer together with payments, in one transaction. Transfer is changed only
+// if it is still pending, so concurrent decisions do not store payments twice.
+func (r *approvalRepository) Decide(ctx context.Context, approval *payment.Approval,
+	payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Model(approval).
+			Column("status", "decided_by", "decided_at").
+			WherePK().
+			Where("status = ?", payment.ApprovalPending).
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			n, err := tx.Model((*payment.Approval)(nil)).Where("id = ?", approval.ID).Count()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errs.ErrUnknownApproval
+			}
+			return errs.ErrApprovalDecided
+		}
+		if len(payments) == 0 {
+			return nil
+		}
+		return storePayments(tx, payments...)
+	})
+	return contextError(ctx, err)
+}
+
+// NewApprovalRepository returns a new instance of a PostgreSQL approval repository.
+func NewApprovalRepository(conn *pg.DB) payment.ApprovalRepository {
+	return &approvalRepository{
+		conn: conn,
+	}
+}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+)
+
+// exportBatch is a number of rows read by one query of export.
+const exportBatch = 1000
+
+// errDryRun rolls back transaction of dry-run import.
+var errDryRun = errors.New("dry run")
+
+type bulkRepository struct {
+	conn *pg.DB
+}
+
+// transaction runs fn in transaction, which is rolled back in dry-run mode.
+func (r *bulkRepository) transaction(ctx context.Context, dryRun bool, fn func(tx *pg.Tx) error) error {
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err == errDryRun {
+		return nil
+	}
+	return contextError(ctx, err)
+}
+
+// copyFrom loads rows by COPY to a new temporary table with columns of the source one, which is dropped on commit.
+func copyFrom(tx *pg.Tx, table, source, columns string, rows [][]string) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("CREATE TEMP TABLE " + table + " ON COMMIT DROP AS SELECT " + columns +
+		" FROM " + source + " WITH NO DATA"); err != nil {
+		return err
+	}
+	_, err := tx.CopyFrom(&buf, "COPY "+table+" ("+columns+") FROM STDIN WITH CSV")
+	return err
+}
+
+// ImportAccounts loads accounts by COPY to a temporary table and inserts new ones from it, without domain events.
+func (r *bulkRepository) ImportAccounts(ctx context.Context, accounts []*account.Account, dryRun bool) (
+	map[int]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rejects map[int]error
+	err := r.transaction(ctx, dryRun, func(tx *pg.Tx) error {
+		rows := make([][]string, len(accounts))
+		for i, val := range accounts {
+			m, err := formatMetadata(val.Metadata)
+			if err != nil {
+				return err
+			}
+			rows[i] = []string{string(val.ID), val.Balance.String(), string(val.Currency), string(val.Status),
+				val.Name, val.Description, val.Reference, m, string(val.Parent)}
+		}
+		columns := "id, balance, currency, status, name, description, reference, metadata, parent"
+		if err := copyFrom(tx, "import_accounts", "accounts", columns, rows); err != nil {
+			return err
+		}
+		// Empty fields of CSV are NULL, parent is NULL for accounts without it.
+		var inserted []string
+		_, err := tx.Query(&inserted, `INSERT INTO accounts (`+columns+`, deleted)
+			SELECT id, balance, currency, status, COALESCE(name, ''), COALESCE(description, ''),
+				COALESCE(reference, ''), metadata, parent, false FROM import_accounts
+			ON CONFLICT (id) DO NOTHING RETURNING id`)
+		if err != nil {
+			return err
+		}
+
+		stored := make(map[string]bool, len(inserted))
+		for _, id := range inserted {
+			stored[id] = true
+		}
+		rejects = make(map[int]error)
+		for i, val := range accounts {
+			if !stored[string(val.ID)] {
+				rejects[i] = errs.ErrAccountExists
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejects, nil
+}
+
+// ImportPayments loads payments by COPY to a temporary table and inserts new ones from it, without domain events.
+// Balance of account is a sum of its initial balance and payments, so initial balances are adjusted, and balances
+// stay the same.
+func (r *bulkRepository) ImportPayments(ctx context.Context, payments []*payment.Payment, dryRun bool) (
+	map[int]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var rejects map[int]error
+	err := r.transaction(ctx, dryRun, func(tx *pg.Tx) error {
+		keys := make([]string, 0, len(payments))
+		for _, val := range payments {
+			keys = append(keys, string(val.Account))
+		}
+		var found []string
+		_, err := tx.Query(&found, "SELECT id FROM accounts WHERE id IN (?) AND NOT deleted ORDER BY id FOR SHARE",
+			pg.In(keys))
+		if err != nil {
+			return err
+		}
+		known := make(map[account.ID]bool, len(found))
+		for _, id := range found {
+			known[account.ID(id)] = true
+		}
+
+		rejects = make(map[int]error)
+		rows := make([][]string, 0, len(payments))
+		for i, val := range payments {
+			if !known[val.Account] {
+				rejects[i] = errs.ErrUnknownAccount
+				continue
+			}
+			m, err := formatMetadata(val.Metadata)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, []string{val.ID.String(), string(val.Account), val.Amount.String(),
+				string(val.ToAccount), string(val.FromAccount), string(val.Direction), string(val.CurrentStatus()),
+				val.Transfer.String(), formatTime(&val.CreatedAt), formatTime(val.CompletedAt), val.Description,
+				val.Reference, m})
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		columns := "id, account, amount, to_account, from_account, direction, status, transfer, created_at, " +
+			"completed_at, description, reference, metadata"
+		if err := copyFrom(tx, "import_payments", "payments", columns, rows); err != nil {
+			return err
+		}
+		var inserted []string
+		_, err = tx.Query(&inserted, `WITH inserted AS (
+				INSERT INTO payments (`+columns+`, deleted)
+				SELECT id, account, amount, to_account, from_account, direction, status, transfer, created_at,
+					completed_at, COALESCE(description, ''), COALESCE(reference, ''), metadata, false
+				FROM import_payments
+				ON CONFLICT (id) DO NOTHING RETURNING id, account, amount, direction, status
+			), adjusted AS (
+				UPDATE accounts AS A SET balance = A.balance - D.delta
+				FROM (SELECT account, SUM(CASE direction WHEN 'incoming' THEN amount ELSE -amount END) AS delta
+					FROM inserted WHERE status = 'completed' GROUP BY account) AS D
+				WHERE A.id = D.account
+			)
+			SELECT id FROM inserted`)
+		if err != nil {
+			return err
+		}
+
+		stored := make(map[string]bool, len(inserted))
+		for _, id := range inserted {
+			stored[id] = true
+		}
+		for i, val := range payments {
+			if _, ok := rejects[i]; !ok && !stored[val.ID.String()] {
+				rejects[i] = errs.ErrPaymentExists
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rejects, nil
+}
+
+// formatTime formats optional time for COPY, empty field is NULL.
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatMetadata formats optional metadata for COPY.
+func formatMetadata(m metadata.Metadata) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+// snapshot runs fn in read-only transaction with repeatable read isolation, so all its queries see data at the
+// same point in time.
+func snapshot(ctx context.Context, conn *pg.DB, fn func(tx *pg.Tx) error) error {
+	err := conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ READ ONLY"); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+	return contextError(ctx, err)
+}
+
+// ExportAccounts calls fn for accounts, read by batches in one snapshot, ordered by id.
+func (r *bulkRepository) ExportAccounts(ctx context.Context, fn func(a *account.Account) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return snapshot(ctx, r.conn, func(tx *pg.Tx) error {
+		var last account.ID
+		for {
+			var batch []*account.Account
+			err := tx.Model(&batch).Where("deleted = ?", false).Where("id > ?", last).Order("id").
+				Limit(exportBatch).Select()
+			if err != nil {
+				return err
+			}
+			for _, val := range batch {
+				if err := fn(val); err != nil {
+					return err
+				}
+			}
+			if len(batch) < exportBatch {
+				return nil
+			}
+			last = batch[len(batch)-1].ID
+		}
+	})
+}
+
+// ExportPayments calls fn for payments, read by batches in one snapshot, ordered by id.
+func (r *bulkRepository) ExportPayments(ctx context.Context, fn func(p *payment.Payment) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return snapshot(ctx, r.conn, func(tx *pg.Tx) error {
+		last := ""
+		for {
+			var batch []*payment.Payment
+			err := tx.Model(&batch).Where("deleted = ?", false).Where("id > ?", last).Order("id").
+				Limit(exportBatch).Select()
+			if err != nil {
+				return err
+			}
+			for _, val := range batch {
+				if err := fn(val); err != nil {
+					return err
+				}
+			}
+			if len(batch) < exportBatch {
+				return nil
+			}
+			last = batch[len(batch)-1].ID.String()
+		}
+	})
+}
+
+// NewBulkRepository returns a new instance of a PostgreSQL bulk repository.
+func NewBulkRepository(conn *pg.DB) bulk.Repository {
+	return &bulkRepository{
+		conn: conn,
+	}
+}
@@ -2,17 +2,35 @@
 package db
 
 import (
+	"context"
+	"sort"
+	"time"
+
 	"github.com/go-pg/pg"
 	"github.com/go-pg/pg/orm"
 	"github.com/google/uuid"
 	"github.com/otetz/payments/account"
 	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/event"
+	"github.com/otetz/payments/interest"
 	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/screening"
+	"github.com/otetz/payments/webhook"
 )
 
 // CreateSchema creating schema if its not exist. Without any migrations mechanic, just schema only.
 func CreateSchema(conn *pg.DB) error {
-	for _, model := range []interface{}{(*account.Account)(nil), (*payment.Payment)(nil)} {
+	for _, model := range []interface{}{
+		(*account.Account)(nil),
+		(*payment.Payment)(nil),
+		(*webhook.Subscription)(nil),
+		(*webhook.Delivery)(nil),
+		(*event.Event)(nil),
+		(*interest.Plan)(nil),
+		(*interest.Accrual)(nil),
+		(*payment.Approval)(nil),
+		(*screening.Hit)(nil),
+	} {
 		err := conn.CreateTable(model, &orm.CreateTableOptions{
 			IfNotExists: true,
 		})
@@ -23,24 +41,67 @@ func CreateSchema(conn *pg.DB) error {
 	return nil
 }
 
+// uniqueViolation is a PostgreSQL error code of duplicate key.
+const uniqueViolation = "23505"
+
+// contextError returns error of cancelled or expired context instead of error of the aborted query.
+func contextError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 type accountRepository struct {
 	conn *pg.DB
 }
 
-// Store account in the repository
-func (r *accountRepository) Store(account *account.Account) error {
-	if err := r.conn.Insert(account); err != nil {
+// live reports whether account exists and is not deleted. Account is locked for share until commit, so it can not
+// be deleted concurrently.
+func live(tx *pg.Tx, id account.ID) (bool, error) {
+	var found []string
+	_, err := tx.Query(&found, "SELECT id FROM accounts WHERE id = ? AND NOT deleted FOR SHARE", id)
+	return len(found) > 0, err
+}
+
+// Store account in the repository, together with AccountOpened event.
+func (r *accountRepository) Store(ctx context.Context, account *account.Account) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	return nil
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if account.Parent != "" {
+			ok, err := live(tx, account.Parent)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errs.ErrUnknownParentAccount
+			}
+		}
+		if err := tx.Insert(account); err != nil {
+			if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == uniqueViolation {
+				return errs.ErrAccountExists
+			}
+			return err
+		}
+		return tx.Insert(event.NewAccountOpened(account))
+	})
+	return contextError(ctx, err)
 }
 
 // Find account in the repository with specified id
-func (r *accountRepository) Find(id account.ID) (*account.Account, error) {
+func (r *accountRepository) Find(ctx context.Context, id account.ID) (*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	a := &account.Account{ID: id}
-	err := r.conn.Select(a)
+	err := r.conn.WithContext(ctx).Select(a)
+	if err == pg.ErrNoRows {
+		return nil, errs.ErrUnknownAccount
+	}
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 	if a.Deleted {
 		return nil, errs.ErrUnknownAccount
@@ -49,31 +110,195 @@ func (r *accountRepository) Find(id account.ID) (*account.Account, error) {
 }
 
 // FindAll returns all accounts registered in the system
-func (r *accountRepository) FindAll() []*account.Account {
+func (r *accountRepository) FindAll(ctx context.Context) ([]*account.Account, error) {
+	return r.Search(ctx, account.Filter{})
+}
+
+// Search returns not deleted accounts, which satisfy filter, ordered by id. Metadata is filtered by JSONB
+// containment, which uses GIN index of the column.
+func (r *accountRepository) Search(ctx context.Context, filter account.Filter) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var accounts []*account.Account
-	err := r.conn.Model(&accounts).Where("deleted = ?", false).Select()
+	q := r.conn.WithContext(ctx).Model(&accounts).Where("deleted = ?", false)
+	if len(filter.Metadata) > 0 {
+		q = q.Where("metadata @> ?", filter.Metadata)
+	}
+	err := q.Order("id").Select()
 	if err != nil {
-		return nil
+		return nil, contextError(ctx, err)
+	}
+	return accounts, nil
+}
+
+// MarkDeleted is mark as deleted specified account in the system, together with AccountDeleted event. Only
+// deletion columns are updated, balance of the table is initial one and must not be overwritten by the view.
+func (r *accountRepository) MarkDeleted(ctx context.Context, id account.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		now := time.Now().UTC()
+		res, err := tx.Model(&account.Account{ID: id, Deleted: true, DeletedAt: &now}).
+			Column("deleted", "deleted_at").
+			WherePK().
+			Where("deleted = ?", false).
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return errs.ErrUnknownAccount
+		}
+		children, err := tx.Model((*account.Account)(nil)).Where("parent = ? AND NOT deleted", id).Count()
+		if err != nil {
+			return err
+		}
+		if children > 0 {
+			return errs.ErrAccountHasChildren
+		}
+		return tx.Insert(event.NewAccountDeleted(id))
+	})
+	return contextError(ctx, err)
+}
+
+// FindTree returns account and its not deleted descendants, ordered by id. Descendants are found by recursive query.
+func (r *accountRepository) FindTree(ctx context.Context, id account.ID) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var accounts []*account.Account
+	err := r.conn.WithContext(ctx).Model(&accounts).
+		Where(`id IN (WITH RECURSIVE tree(id) AS (
+			SELECT id FROM accounts WHERE id = ? AND NOT deleted
+			UNION ALL
+			SELECT A.id FROM accounts AS A JOIN tree AS T ON A.parent = T.id WHERE NOT A.deleted
+		) SELECT id FROM tree)`, id).
+		Order("id").
+		Select()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if len(accounts) == 0 {
+		return nil, errs.ErrUnknownAccount
 	}
-	return accounts
+	return accounts, nil
 }
 
-// MarkDeleted is mark as deleted specified account in the system
-func (r *accountRepository) MarkDeleted(id account.ID) error {
+// FindDeleted returns deleted accounts, ordered by id.
+func (r *accountRepository) FindDeleted(ctx context.Context) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	accounts := make([]*account.Account, 0)
+	err := r.conn.WithContext(ctx).Model(&accounts).Where("deleted = ?", true).Order("id").Select()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return accounts, nil
+}
+
+// Restore clears deletion mark of account, together with AccountRestored event.
+func (r *accountRepository) Restore(ctx context.Context, id account.ID) (*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	a := &account.Account{ID: id}
-	err := r.conn.Select(a)
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Model(a).Column("deleted", "deleted_at").WherePK().Where("deleted = ?", true).Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return errs.ErrUnknownAccount
+		}
+		if err := tx.Select(a); err != nil {
+			return err
+		}
+		if a.Parent != "" {
+			ok, err := live(tx, a.Parent)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errs.ErrUnknownParentAccount
+			}
+		}
+		return tx.Insert(event.NewAccountRestored(id))
+	})
 	if err != nil {
-		return err
+		return nil, contextError(ctx, err)
 	}
-	if a.ID == "" || a.Deleted {
-		return errs.ErrUnknownAccount
+	return a, nil
+}
+
+// Purge erases accounts deleted before the time, together with their payments, in one transaction. Accounts are
+// locked, so they can not be restored in the middle of purge.
+func (r *accountRepository) Purge(ctx context.Context, before time.Time) ([]account.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	a.Deleted = true
-	err = r.conn.Update(a)
+	ids := make([]account.ID, 0)
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		var found []string
+		_, err := tx.Query(&found,
+			"SELECT id FROM accounts WHERE deleted AND deleted_at < ? ORDER BY id FOR UPDATE", before)
+		if err != nil || len(found) == 0 {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM payments WHERE account IN (?)", pg.In(found)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM accounts WHERE id IN (?)", pg.In(found)); err != nil {
+			return err
+		}
+		for _, id := range found {
+			ids = append(ids, account.ID(id))
+		}
+		return nil
+	})
 	if err != nil {
-		return err
+		return nil, contextError(ctx, err)
 	}
-	return nil
+	return ids, nil
+}
+
+// Update changes mutable fields of account by fn in one transaction, together with AccountUpdated event. Account
+// is locked until commit, so concurrent updates do not overwrite each other.
+func (r *accountRepository) Update(ctx context.Context, id account.ID, fn func(a *account.Account) error) (
+	*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a := &account.Account{ID: id}
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		var found []string
+		_, err := tx.Query(&found, "SELECT id FROM accounts WHERE id = ? AND NOT deleted FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return errs.ErrUnknownAccount
+		}
+		if err := tx.Select(a); err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+		a.Version++
+		_, err = tx.Model(a).Column("name", "currency", "status", "transfer_limit", "version",
+			"description", "reference", "metadata", "tree_transfer_limit").WherePK().Update()
+		if err != nil {
+			return err
+		}
+		return tx.Insert(event.NewAccountUpdated(a))
+	})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return a, nil
 }
 
 // NewAccountRepository returns a new instance of a PostgreSQL account repository.
@@ -88,53 +313,242 @@ type paymentRepository struct {
 	accounts account.Repository
 }
 
-// Store payments in the repository.
-func (r *paymentRepository) Store(payments ...*payment.Payment) error {
-	err := r.conn.RunInTransaction(func(tx *pg.Tx) error {
-		for _, val := range payments {
-			if err := r.conn.Insert(val); err != nil {
-				return err
-			}
+// Store payments in the repository, together with TransferCompleted events. Accounts of payments are locked
+// until commit, so they can not be deleted in the middle of transfer, and concurrent transfers can not spend the
+// same money: transfer is rejected with errs.ErrInsufficientMoney, if source account has not enough money at the
+// moment of commit.
+func (r *paymentRepository) Store(ctx context.Context, payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		return storePayments(tx, payments...)
+	})
+	return contextError(ctx, err)
+}
+
+// storePayments inserts payments with their events in transaction, accounts of payments are locked until commit.
+func storePayments(tx *pg.Tx, payments ...*payment.Payment) error {
+	if err := lockAccounts(tx, payments...); err != nil {
+		return err
+	}
+	for _, val := range payments {
+		if err := tx.Insert(val); err != nil {
+			return err
+		}
+	}
+	if err := checkBalances(tx, debited(false, payments...)); err != nil {
+		return err
+	}
+	return insertTransferEvents(tx, payments...)
+}
+
+// insertTransferEvents inserts events of payments, which moved money, with balances of their accounts after it.
+func insertTransferEvents(tx *pg.Tx, payments ...*payment.Payment) error {
+	ids := make(map[account.ID]bool)
+	for _, val := range payments {
+		if s := val.CurrentStatus(); s == payment.StatusCompleted || s == payment.StatusReversed {
+			ids[val.Account] = true
 		}
+	}
+	if len(ids) == 0 {
 		return nil
-	})
+	}
+	keys := make([]string, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, string(id))
+	}
+	var aa []*account.Account
+	if err := tx.Model(&aa).Where("id IN (?)", pg.In(keys)).Select(); err != nil {
+		return err
+	}
+	accounts := make(map[account.ID]*account.Account, len(aa))
+	for _, val := range aa {
+		accounts[val.ID] = val
+	}
+	for _, val := range event.TransferEvents(accounts, payments...) {
+		if err := tx.Insert(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// debited returns accounts, which balances are decreased by completed payments, or by return of their money if
+// reverse is set.
+func debited(reverse bool, payments ...*payment.Payment) []string {
+	ids := make(map[account.ID]bool)
+	for _, val := range payments {
+		switch {
+		case reverse && val.Direction == payment.Incoming:
+			ids[val.Account] = true
+		case !reverse && val.Completed() && val.Direction == payment.Outgoing:
+			ids[val.Account] = true
+		}
+	}
+	keys := make([]string, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, string(id))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkBalances returns errs.ErrInsufficientMoney, if balance of any account became negative in transaction.
+// Accounts are locked by caller, so changes of concurrent transactions are committed before.
+func checkBalances(tx *pg.Tx, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var negative []string
+	_, err := tx.Query(&negative, "SELECT id FROM accounts_view WHERE id IN (?) AND balance < 0", pg.In(ids))
 	if err != nil {
 		return err
 	}
+	if len(negative) > 0 {
+		return errs.ErrInsufficientMoney
+	}
 	return nil
 }
 
+// lockAccounts locks accounts of payments until commit, they must not be deleted. Failed payments never change
+// balances, so their accounts may be deleted.
+func lockAccounts(tx *pg.Tx, payments ...*payment.Payment) error {
+	ids := make(map[account.ID]bool)
+	for _, val := range payments {
+		if val.CurrentStatus() != payment.StatusFailed {
+			ids[val.Account] = true
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	for id := range ids {
+		keys = append(keys, string(id))
+	}
+	sort.Strings(keys)
+	var found []string
+	_, err := tx.Query(&found, "SELECT id FROM accounts WHERE id IN (?) AND NOT deleted ORDER BY id FOR UPDATE",
+		pg.In(keys))
+	if err != nil {
+		return err
+	}
+	if len(found) != len(keys) {
+		return errs.ErrUnknownAccount
+	}
+	return nil
+}
+
+// Transition changes status of all payments of transfer in one transaction, together with events about completion
+// or reversal. Balances follow the status by accounts_view, accounts of payments are locked until commit.
+func (r *paymentRepository) Transition(ctx context.Context, transfer uuid.UUID, to payment.Status, reason string,
+	at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if transfer == uuid.Nil {
+		return errs.ErrUnknownTransfer
+	}
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		var pp []*payment.Payment
+		err := tx.Model(&pp).Where("deleted = ?", false).Where("transfer = ?", transfer).Order("id").
+			For("UPDATE").Select()
+		if err != nil {
+			return err
+		}
+		if len(pp) == 0 {
+			return errs.ErrUnknownTransfer
+		}
+		for _, val := range pp {
+			if err := val.Transition(to, reason, at); err != nil {
+				return err
+			}
+		}
+		if err := lockAccounts(tx, pp...); err != nil {
+			return err
+		}
+		for _, val := range pp {
+			if err := tx.Update(val); err != nil {
+				return err
+			}
+		}
+		if err := checkBalances(tx, debited(to == payment.StatusReversed, pp...)); err != nil {
+			return err
+		}
+		return insertTransferEvents(tx, pp...)
+	})
+	return contextError(ctx, err)
+}
+
 // Find payments list for an account.
-func (r *paymentRepository) Find(id account.ID) []*payment.Payment {
+func (r *paymentRepository) Find(ctx context.Context, id account.ID) ([]*payment.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var pp []*payment.Payment
-	err := r.conn.Model(&pp).Where("deleted = ?", false).Where("account = ?", id).Select()
+	err := r.conn.WithContext(ctx).Model(&pp).Where("deleted = ?", false).Where("account = ?", id).Order("seq").
+		Select()
 	if err != nil {
-		return nil
+		return nil, contextError(ctx, err)
 	}
-	return pp
+	return pp, nil
 }
 
 // FindAll returns all payments, registered in the system.
-func (r *paymentRepository) FindAll() []*payment.Payment {
+func (r *paymentRepository) FindAll(ctx context.Context) ([]*payment.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var pp []*payment.Payment
-	err := r.conn.Model(&pp).Where("deleted = ?", false).Select()
+	err := r.conn.WithContext(ctx).Model(&pp).Where("deleted = ?", false).Order("seq").Select()
 	if err != nil {
-		return nil
+		return nil, contextError(ctx, err)
+	}
+	return pp, nil
+}
+
+// Search returns not deleted payments, which satisfy filter. Metadata is filtered by JSONB containment, which
+// uses GIN index of the column.
+func (r *paymentRepository) Search(ctx context.Context, filter payment.Filter) ([]*payment.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var pp []*payment.Payment
+	q := r.conn.WithContext(ctx).Model(&pp).Where("deleted = ?", false)
+	if filter.Account != "" {
+		q = q.Where("account = ?", filter.Account)
+	}
+	if len(filter.Metadata) > 0 {
+		q = q.Where("metadata @> ?", filter.Metadata)
 	}
-	return pp
+	if filter.Status != "" {
+		q = q.Where("status = ?", filter.Status)
+	}
+	if filter.Transfer != uuid.Nil {
+		q = q.Where("transfer = ?", filter.Transfer)
+	}
+	if err := q.Order("seq").Select(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return pp, nil
 }
 
 // MarkDeleted is mark as deleted specified payment in the system
-func (r *paymentRepository) MarkDeleted(id uuid.UUID) error {
-	p := &payment.Payment{ID: id}
-	err := r.conn.Select(p)
-	if err != nil {
+func (r *paymentRepository) MarkDeleted(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	p.Deleted = true
-	err = r.conn.Update(p)
+	res, err := r.conn.WithContext(ctx).Model((*payment.Payment)(nil)).
+		Set("deleted = ?", true).
+		Where("id = ?", id).
+		Where("deleted = ?", false).
+		Update()
 	if err != nil {
-		return err
+		return contextError(ctx, err)
+	}
+	if res.RowsAffected() == 0 {
+		return errs.ErrUnknownPayment
 	}
 	return nil
 }
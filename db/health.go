@@ -0,0 +1,46 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg"
+)
+
+// relations are tables and views, which must exist for the application to work.
+// Views are created by db.sql, see README.
+var relations = []string{
+	"accounts",
+	"accounts_view",
+	"payments",
+	// Sequence of seq column, which orders payments as they are stored.
+	"payments_seq_seq",
+	"webhook_subscriptions",
+	"webhook_deliveries",
+	"outbox",
+	"interest_plans",
+	"interest_accruals",
+	"approvals",
+	"screening_hits",
+}
+
+// Ping checks connection to PostgreSQL server.
+func Ping(ctx context.Context, conn *pg.DB) error {
+	_, err := conn.WithContext(ctx).Exec("SELECT 1")
+	return err
+}
+
+// CheckSchema checks that all tables and views of the application exist.
+func CheckSchema(ctx context.Context, conn *pg.DB) error {
+	var missing []string
+	_, err := conn.WithContext(ctx).Query(&missing,
+		"SELECT name FROM unnest(?::text[]) AS name WHERE to_regclass(name) IS NULL", pg.Array(relations))
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("schema is not migrated, missing: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
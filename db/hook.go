@@ -0,0 +1,72 @@
+package db
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-pg/pg"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/tracing"
+)
+
+type queryHookKey int
+
+const (
+	queryHookSpan queryHookKey = iota
+	queryHookBegin
+)
+
+type queryHook struct {
+	logger log.Logger
+}
+
+// NewQueryHook returns a hook, which traces queries as client spans of the current request.
+// If logger is not nil, statements are logged too.
+func NewQueryHook(logger log.Logger) pg.QueryHook {
+	return &queryHook{logger: logger}
+}
+
+// BeforeQuery starts span of query.
+func (h *queryHook) BeforeQuery(q *pg.QueryEvent) {
+	q.Data[queryHookBegin] = time.Now()
+	if q.Ctx == nil {
+		return
+	}
+	_, span := tracing.StartSpan(q.Ctx, "db.query", tracing.KindClient)
+	if span != nil {
+		span.SetAttribute("db.system", "postgresql")
+		if query, err := q.UnformattedQuery(); err == nil {
+			span.SetAttribute("db.statement", query)
+		}
+		q.Data[queryHookSpan] = span
+	}
+}
+
+// AfterQuery finishes span of query and logs it.
+func (h *queryHook) AfterQuery(q *pg.QueryEvent) {
+	if span, ok := q.Data[queryHookSpan].(*tracing.Span); ok {
+		if q.Error != pg.ErrNoRows {
+			span.SetError(q.Error)
+		}
+		span.Finish()
+	}
+
+	if h.logger == nil {
+		return
+	}
+	query, err := q.FormattedQuery()
+	if err != nil {
+		query = err.Error()
+	}
+	var requestID, traceID string
+	if q.Ctx != nil {
+		requestID, traceID = requestid.FromContext(q.Ctx), tracing.TraceIDFromContext(q.Ctx)
+	}
+	_ = h.logger.Log(
+		"query", query,
+		"took", time.Since(q.Data[queryHookBegin].(time.Time)),
+		"request_id", requestID,
+		"trace_id", traceID,
+		"err", q.Error,
+	)
+}
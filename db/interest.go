@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+)
+
+type interestRepository struct {
+	conn *pg.DB
+}
+
+// StorePlan saves a new plan.
+func (r *interestRepository) StorePlan(ctx context.Context, plan *interest.Plan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.conn.WithContext(ctx).Insert(plan)
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == uniqueViolation {
+		return errs.ErrInterestPlanExists
+	}
+	return contextError(ctx, err)
+}
+
+// FindPlan returns plan with specified id.
+func (r *interestRepository) FindPlan(ctx context.Context, id string) (*interest.Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	p := &interest.Plan{ID: id}
+	err := r.conn.WithContext(ctx).Select(p)
+	if err == pg.ErrNoRows {
+		return nil, errs.ErrUnknownInterestPlan
+	}
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return p, nil
+}
+
+// FindPlans returns all plans ordered by id.
+func (r *interestRepository) FindPlans(ctx context.Context) ([]*interest.Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pp := make([]*interest.Plan, 0)
+	if err := r.conn.WithContext(ctx).Model(&pp).Order("id").Select(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return pp, nil
+}
+
+// Assign stores accrual of account, if account has none, or changes plan of the stored one.
+func (r *interestRepository) Assign(ctx context.Context, accrual *interest.Accrual) (*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a := *accrual
+	_, err := r.conn.WithContext(ctx).Model(&a).
+		OnConflict("(account) DO UPDATE").
+		Set("plan = EXCLUDED.plan").
+		Returning("*").
+		Insert()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return &a, nil
+}
+
+// FindAccrual returns accrual of account.
+func (r *interestRepository) FindAccrual(ctx context.Context, id account.ID) (*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a := &interest.Accrual{Account: id}
+	err := r.conn.WithContext(ctx).Select(a)
+	if err == pg.ErrNoRows {
+		return nil, errs.ErrNoInterestPlan
+	}
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return a, nil
+}
+
+// FindAccruals returns accruals of all accounts ordered by account.
+func (r *interestRepository) FindAccruals(ctx context.Context) ([]*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	aa := make([]*interest.Accrual, 0)
+	if err := r.conn.WithContext(ctx).Model(&aa).Order("account").Select(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return aa, nil
+}
+
+// Save stores accrued and posted interest together with payments of posting, in one transaction. Accrual is
+// changed only if it is still accrued through accruedThrough, so concurrent runs of several instances do not
+// accrue the same days twice.
+func (r *interestRepository) Save(ctx context.Context, accrual *interest.Accrual, accruedThrough time.Time,
+	payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := r.conn.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Model(accrual).
+			Column("accrued", "accrued_through", "posted_through").
+			WherePK().
+			Where("accrued_through = ?::date", accruedThrough.Format("2006-01-02")).
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			n, err := tx.Model((*interest.Accrual)(nil)).Where("account = ?", accrual.Account).Count()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return errs.ErrNoInterestPlan
+			}
+			return errs.ErrAccrualChanged
+		}
+		if len(payments) == 0 {
+			return nil
+		}
+		return storePayments(tx, payments...)
+	})
+	return contextError(ctx, err)
+}
+
+// NewInterestRepository returns a new instance of a PostgreSQL interest repository.
+func NewInterestRepository(conn *pg.DB) interest.Repository {
+	return &interestRepository{
+		conn: conn,
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/otetz/payments/event"
+)
+
+type outbox struct {
+	conn *pg.DB
+}
+
+// Pending returns up to limit not published events, ordered by sequence.
+func (o *outbox) Pending(limit int) ([]*event.Event, error) {
+	var ee []*event.Event
+	err := o.conn.Model(&ee).Where("published_at IS NULL").Order("sequence").Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+	return ee, nil
+}
+
+// MarkPublished marks events with specified sequence numbers as published.
+func (o *outbox) MarkPublished(sequences ...int64) error {
+	_, err := o.conn.Model((*event.Event)(nil)).
+		Set("published_at = ?", time.Now().UTC()).
+		Where("sequence IN (?)", pg.In(sequences)).
+		Update()
+	return err
+}
+
+// Since returns up to limit events with sequence greater than specified, published or not, ordered by sequence.
+func (o *outbox) Since(sequence int64, limit int) ([]*event.Event, error) {
+	var ee []*event.Event
+	err := o.conn.Model(&ee).Where("sequence > ?", sequence).Order("sequence").Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+	return ee, nil
+}
+
+// Latest returns sequence of the last event in the outbox, or zero if it is empty.
+func (o *outbox) Latest() (int64, error) {
+	var latest int64
+	_, err := o.conn.QueryOne(pg.Scan(&latest), "SELECT COALESCE(MAX(sequence), 0) FROM outbox")
+	if err != nil {
+		return 0, err
+	}
+	return latest, nil
+}
+
+// Oldest returns sequence of the first event in the outbox, or 1 if it is empty.
+func (o *outbox) Oldest() (int64, error) {
+	var oldest int64
+	_, err := o.conn.QueryOne(pg.Scan(&oldest), "SELECT COALESCE(MIN(sequence), 1) FROM outbox")
+	if err != nil {
+		return 0, err
+	}
+	return oldest, nil
+}
+
+// NewOutbox returns a new instance of a PostgreSQL outbox.
+func NewOutbox(conn *pg.DB) event.Outbox {
+	return &outbox{
+		conn: conn,
+	}
+}
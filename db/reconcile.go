@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+
+	"github.com/go-pg/pg"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+	"github.com/shopspring/decimal"
+)
+
+type ledgerRepository struct {
+	conn *pg.DB
+}
+
+// Ledger returns all accounts and payments in one snapshot. Initial balances are read from the accounts table,
+// current ones are calculated by the view.
+func (r *ledgerRepository) Ledger(ctx context.Context) ([]*account.Account, []*payment.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	var (
+		rows []struct {
+			ID             account.ID
+			Balance        decimal.Decimal
+			InitialBalance decimal.Decimal
+			Currency       account.Currency
+			Deleted        bool
+		}
+		payments []*payment.Payment
+	)
+	err := snapshot(ctx, r.conn, func(tx *pg.Tx) error {
+		_, err := tx.Query(&rows, `SELECT V.id, V.balance, A.balance AS initial_balance, V.currency, V.deleted
+			FROM accounts AS A JOIN accounts_view AS V USING (id)`)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&payments).Select()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts := make([]*account.Account, 0, len(rows))
+	for _, row := range rows {
+		accounts = append(accounts, &account.Account{
+			ID:             row.ID,
+			Balance:        row.Balance,
+			InitialBalance: row.InitialBalance,
+			Currency:       row.Currency,
+			Deleted:        row.Deleted,
+		})
+	}
+	return accounts, payments, nil
+}
+
+// NewLedgerRepository returns a new instance of a PostgreSQL ledger repository.
+func NewLedgerRepository(conn *pg.DB) reconcile.Repository {
+	return &ledgerRepository{
+		conn: conn,
+	}
+}
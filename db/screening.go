@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+
+	"github.com/go-pg/pg"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/screening"
+)
+
+type screeningRepository struct {
+	conn *pg.DB
+}
+
+// StoreHits saves new hits.
+func (r *screeningRepository) StoreHits(ctx context.Context, hits ...*screening.Hit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(hits) == 0 {
+		return nil
+	}
+	_, err := r.conn.WithContext(ctx).Model(&hits).Insert()
+	return contextError(ctx, err)
+}
+
+// FindHits returns hits of account, or of all accounts if id is empty, in order of their storing.
+func (r *screeningRepository) FindHits(ctx context.Context, id account.ID) ([]*screening.Hit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	hh := make([]*screening.Hit, 0)
+	q := r.conn.WithContext(ctx).Model(&hh).Order("sequence")
+	if id != "" {
+		q = q.Where("account = ?", id)
+	}
+	if err := q.Select(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return hh, nil
+}
+
+// NewScreeningRepository returns a new instance of a PostgreSQL screening repository.
+func NewScreeningRepository(conn *pg.DB) screening.Repository {
+	return &screeningRepository{
+		conn: conn,
+	}
+}
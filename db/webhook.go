@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+
+	"github.com/go-pg/pg"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/webhook"
+)
+
+type webhookRepository struct {
+	conn *pg.DB
+}
+
+// StoreSubscription saves subscription in the repository.
+func (r *webhookRepository) StoreSubscription(ctx context.Context, s *webhook.Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return contextError(ctx, r.conn.WithContext(ctx).Insert(s))
+}
+
+// FindSubscription returns active subscription with specified id.
+func (r *webhookRepository) FindSubscription(ctx context.Context, id uuid.UUID) (*webhook.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s := &webhook.Subscription{ID: id}
+	err := r.conn.WithContext(ctx).Select(s)
+	if err == pg.ErrNoRows {
+		return nil, errs.ErrUnknownSubscription
+	}
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if s.Deleted {
+		return nil, errs.ErrUnknownSubscription
+	}
+	return s, nil
+}
+
+// FindAllSubscriptions returns all active subscriptions.
+func (r *webhookRepository) FindAllSubscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ss := make([]*webhook.Subscription, 0)
+	err := r.conn.WithContext(ctx).Model(&ss).Where("deleted = ?", false).Select()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return ss, nil
+}
+
+// MarkSubscriptionDeleted is mark as deleted specified subscription.
+func (r *webhookRepository) MarkSubscriptionDeleted(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	res, err := r.conn.WithContext(ctx).Model((*webhook.Subscription)(nil)).
+		Set("deleted = ?", true).
+		Where("id = ?", id).
+		Where("deleted = ?", false).
+		Update()
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if res.RowsAffected() == 0 {
+		return errs.ErrUnknownSubscription
+	}
+	return nil
+}
+
+// StoreDelivery inserts a new delivery or updates existing one.
+func (r *webhookRepository) StoreDelivery(ctx context.Context, d *webhook.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := r.conn.WithContext(ctx).Model(d).
+		OnConflict("(id) DO UPDATE").
+		Set("status = EXCLUDED.status").
+		Set("attempts = EXCLUDED.attempts").
+		Set("last_status_code = EXCLUDED.last_status_code").
+		Set("last_error = EXCLUDED.last_error").
+		Set("next_attempt_at = EXCLUDED.next_attempt_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Insert()
+	return contextError(ctx, err)
+}
+
+// FindDeliveries returns delivery history of the subscription, oldest first.
+func (r *webhookRepository) FindDeliveries(ctx context.Context, subscription uuid.UUID) ([]*webhook.Delivery,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	dd := make([]*webhook.Delivery, 0)
+	err := r.conn.WithContext(ctx).Model(&dd).Where("subscription = ?", subscription).Order("created_at").Select()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return dd, nil
+}
+
+// NewWebhookRepository returns a new instance of a PostgreSQL webhook repository.
+func NewWebhookRepository(conn *pg.DB) webhook.Repository {
+	return &webhookRepository{
+		conn: conn,
+	}
+}
@@ -4,23 +4,138 @@ package errs
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/tracing"
+)
+
+// Code is a stable machine-readable identifier of an error, clients may rely on it.
+type Code string
+
+const (
+	CodeUnknownAccount       Code = "unknown_account"
+	CodeAccountExists        Code = "account_exists"
+	CodeUnknownPayment       Code = "unknown_payment"
+	CodePaymentExists        Code = "payment_exists"
+	CodeInvalidArgument      Code = "invalid_argument"
+	CodeUnknownSourceAccount Code = "unknown_source_account"
+	CodeUnknownTargetAccount Code = "unknown_target_account"
+	CodeAccountsAreEqual     Code = "accounts_are_equal"
+	CodeInsufficientMoney    Code = "insufficient_money"
+	CodeAccountFrozen        Code = "account_frozen"
+	CodeTransferLimit        Code = "transfer_limit_exceeded"
+	CodeVersionMismatch      Code = "version_mismatch"
+	CodePreconditionRequired Code = "precondition_required"
+	CodeStorePayments        Code = "store_payments_failed"
+	CodeStoreSourceAccount   Code = "store_source_account_failed"
+	CodeStoreTargetAccount   Code = "store_target_account_failed"
+	CodeBadRoute             Code = "bad_route"
+	CodeUnknownSubscription  Code = "unknown_subscription"
+	CodeUnknownEventType     Code = "unknown_event_type"
+	CodeValidation           Code = "validation_failed"
+	CodeMalformedRequest     Code = "malformed_request"
+	CodeInternal             Code = "internal_error"
+	CodeRequestTimeout       Code = "request_timeout"
+	CodeRequestCancelled     Code = "request_cancelled"
+	CodeUnauthorized         Code = "unauthorized"
+	CodeUnknownParentAccount Code = "unknown_parent_account"
+	CodeAccountHasChildren   Code = "account_has_children"
+	CodeUnknownInterestPlan  Code = "unknown_interest_plan"
+	CodeInterestPlanExists   Code = "interest_plan_exists"
+	CodeNoInterestPlan       Code = "no_interest_plan"
+	CodeAccrualChanged       Code = "accrual_changed"
+	CodeUnknownApproval      Code = "unknown_approval"
+	CodeApprovalDecided      Code = "approval_decided"
+	CodeApprovalExpired      Code = "approval_expired"
+	CodeSelfApproval         Code = "self_approval"
+	CodeApprovalRequired     Code = "approval_required"
+	CodeTransferDenied       Code = "transfer_denied"
+	CodeUnknownTransfer      Code = "unknown_transfer"
+	CodeStatusTransition     Code = "invalid_status_transition"
 )
 
+// Error is an error of business-logic with stable code and HTTP status.
+type Error struct {
+	Code    Code
+	Status  int
+	Message string
+}
+
+// The error built-in interface type is the conventional interface for
+// representing an error condition, with the nil value representing no error.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// New returns a new typed error. Message must be in English, localized titles are looked up by code.
+func New(code Code, status int, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
 var (
-	ErrUnknownAccount       = errors.New("unknown account")
-	ErrInvalidArgument      = errors.New("invalid argument")
-	ErrUnknownSourceAccount = errors.New("unknown source account")
-	ErrUnknownTargetAccount = errors.New("unknown target account")
-	ErrAccountsAreEqual     = errors.New("target account must not be equal to source account")
-	ErrInsufficientMoney    = errors.New("insufficient money on source account")
-	ErrStorePayments        = errors.New("can not store payments")
-	ErrStoreSourceAccount   = errors.New("can not update source account")
-	ErrStoreTargetAccount   = errors.New("can not update target account")
-	ErrBadRoute             = errors.New("bad route")
+	ErrUnknownAccount       = New(CodeUnknownAccount, http.StatusNotFound, "unknown account")
+	ErrAccountExists        = New(CodeAccountExists, http.StatusConflict, "account already exists")
+	ErrUnknownPayment       = New(CodeUnknownPayment, http.StatusNotFound, "unknown payment")
+	ErrPaymentExists        = New(CodePaymentExists, http.StatusConflict, "payment already exists")
+	ErrInvalidArgument      = New(CodeInvalidArgument, http.StatusBadRequest, "invalid argument")
+	ErrUnknownSourceAccount = New(CodeUnknownSourceAccount, http.StatusNotFound, "unknown source account")
+	ErrUnknownTargetAccount = New(CodeUnknownTargetAccount, http.StatusNotFound, "unknown target account")
+	ErrAccountsAreEqual     = New(CodeAccountsAreEqual, http.StatusUnprocessableEntity,
+		"target account must not be equal to source account")
+	ErrInsufficientMoney = New(CodeInsufficientMoney, http.StatusUnprocessableEntity,
+		"insufficient money on source account")
+	ErrAccountFrozen = New(CodeAccountFrozen, http.StatusUnprocessableEntity, "account is frozen")
+	ErrTransferLimit = New(CodeTransferLimit, http.StatusUnprocessableEntity,
+		"amount exceeds transfer limit of source account")
+	ErrVersionMismatch = New(CodeVersionMismatch, http.StatusPreconditionFailed,
+		"account was changed, version does not match")
+	ErrPreconditionRequired = New(CodePreconditionRequired, http.StatusPreconditionRequired,
+		"If-Match header is required")
+	ErrStorePayments       = New(CodeStorePayments, http.StatusInternalServerError, "can not store payments")
+	ErrStoreSourceAccount  = New(CodeStoreSourceAccount, http.StatusInternalServerError, "can not update source account")
+	ErrStoreTargetAccount  = New(CodeStoreTargetAccount, http.StatusInternalServerError, "can not update target account")
+	ErrBadRoute            = New(CodeBadRoute, http.StatusNotFound, "bad route")
+	ErrUnknownSubscription = New(CodeUnknownSubscription, http.StatusNotFound, "unknown subscription")
+	ErrUnknownEventType    = New(CodeUnknownEventType, http.StatusUnprocessableEntity, "unknown event type")
+	ErrRequestTimeout      = New(CodeRequestTimeout, http.StatusGatewayTimeout, "request timed out")
+	ErrRequestCancelled    = New(CodeRequestCancelled, StatusClientClosedRequest, "request cancelled by client")
+	ErrUnauthorized        = New(CodeUnauthorized, http.StatusUnauthorized, "authentication required")
+
+	// Errors of account hierarchy.
+	ErrUnknownParentAccount = New(CodeUnknownParentAccount, http.StatusNotFound, "unknown parent account")
+	ErrAccountHasChildren   = New(CodeAccountHasChildren, http.StatusConflict, "account has not deleted child accounts")
+
+	// Errors of interest plans.
+	ErrUnknownInterestPlan = New(CodeUnknownInterestPlan, http.StatusNotFound, "unknown interest plan")
+	ErrInterestPlanExists  = New(CodeInterestPlanExists, http.StatusConflict, "interest plan already exists")
+	ErrNoInterestPlan      = New(CodeNoInterestPlan, http.StatusNotFound, "account has no interest plan")
+	ErrAccrualChanged      = New(CodeAccrualChanged, http.StatusConflict, "interest accrual was changed concurrently")
+
+	// Errors of approvals of transfers.
+	ErrUnknownApproval = New(CodeUnknownApproval, http.StatusNotFound, "unknown approval")
+	ErrApprovalDecided = New(CodeApprovalDecided, http.StatusConflict, "transfer is already approved or rejected")
+	ErrApprovalExpired = New(CodeApprovalExpired, http.StatusConflict, "pending transfer has expired")
+	ErrSelfApproval    = New(CodeSelfApproval, http.StatusForbidden,
+		"transfer must be decided by another principal")
+	ErrApprovalRequired = New(CodeApprovalRequired, http.StatusUnprocessableEntity,
+		"split transfer, which requires approval, is not allowed")
+
+	// Errors of risk rules.
+	ErrTransferDenied = New(CodeTransferDenied, http.StatusUnprocessableEntity, "transfer is denied by risk rules")
+
+	// Errors of lifecycle of transfers.
+	ErrUnknownTransfer  = New(CodeUnknownTransfer, http.StatusNotFound, "unknown transfer")
+	ErrStatusTransition = New(CodeStatusTransition, http.StatusConflict,
+		"status of transfer does not allow the transition")
 )
 
+// StatusClientClosedRequest is a non-standard HTTP status (introduced by nginx) of requests, which are cancelled
+// by client before response. Client never sees it, but it is logged and counted.
+const StatusClientClosedRequest = 499
+
 // ValidationError represents validation error, for right choosing of HTTP status in response.
 type ValidationError struct {
 	Err error
@@ -32,13 +147,46 @@ func (e ValidationError) Error() string {
 	return "validation error: " + e.Err.Error()
 }
 
+// MalformedRequestError represents request body, which can not be decoded.
+type MalformedRequestError struct {
+	Err error
+}
+
+// The error built-in interface type is the conventional interface for
+// representing an error condition, with the nil value representing no error.
+func (e MalformedRequestError) Error() string {
+	return e.Err.Error()
+}
+
+// CodeOf returns code of error as it is reported to client, e.g. for labelling of metrics.
+func CodeOf(err error) Code {
+	switch e := err.(type) {
+	case *Error:
+		return e.Code
+	case ValidationError:
+		return CodeValidation
+	case MalformedRequestError:
+		return CodeMalformedRequest
+	}
+	switch err {
+	case context.DeadlineExceeded:
+		return CodeRequestTimeout
+	case context.Canceled:
+		return CodeRequestCancelled
+	}
+	return CodeInternal
+}
+
+// Legacy switches error responses to the old `{"error": "..."}` shape with old HTTP statuses,
+// for clients which are not ready for problem details yet.
+var Legacy = false
+
 type errorer interface {
 	ErrError() error
 }
 
 // EncodeResponse encoding response data by default way (without any struct). It is enough in most cases.
 func EncodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
-	//if e, ok := response.(errorer); ok && e.error() != nil {
 	e, ok := response.(errorer)
 	if ok && e.ErrError() != nil {
 		EncodeError(ctx, e.ErrError(), w)
@@ -49,16 +197,31 @@ func EncodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 	return json.NewEncoder(w).Encode(response)
 }
 
-// EncodeError encode errs from business-logic
-func EncodeError(_ context.Context, err error, w http.ResponseWriter) {
+// EncodeError encode errs from business-logic as RFC 7807 problem details, or in legacy shape if Legacy is set.
+func EncodeError(ctx context.Context, err error, w http.ResponseWriter) {
+	if Legacy {
+		encodeLegacyError(err, w)
+		return
+	}
+
+	p := NewProblem(ctx, err)
+	w.Header().Set("Content-Type", "application/problem+json; charset=utf-8")
+	w.Header().Set("Content-Language", p.lang)
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func encodeLegacyError(err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	switch err {
-	case ErrUnknownAccount, ErrUnknownSourceAccount, ErrUnknownTargetAccount:
+	case ErrUnknownAccount, ErrUnknownSourceAccount, ErrUnknownTargetAccount, ErrUnknownSubscription:
 		w.WriteHeader(http.StatusNotFound)
-	case ErrInvalidArgument, ErrInsufficientMoney:
+	case ErrInvalidArgument, ErrInsufficientMoney, ErrUnknownEventType:
 		w.WriteHeader(http.StatusBadRequest)
 	case ErrAccountsAreEqual:
 		w.WriteHeader(http.StatusNotAcceptable)
+	case ErrUnauthorized:
+		w.WriteHeader(http.StatusUnauthorized)
 	default:
 		switch err.(type) {
 		case ValidationError:
@@ -77,4 +240,23 @@ type ErrorOnlyResponse struct {
 	Err error `json:"error,omitempty"`
 }
 
-func (r ErrorOnlyResponse) error() error { return r.Err }
+// ErrError returns error of the response, if any.
+func (r ErrorOnlyResponse) ErrError() error { return r.Err }
+
+type logErrorHandler struct {
+	logger log.Logger
+}
+
+// NewLogErrorHandler returns a transport error handler, which logs errors together with request and trace IDs.
+func NewLogErrorHandler(logger log.Logger) transport.ErrorHandler {
+	return &logErrorHandler{logger: logger}
+}
+
+// Handle logs error of the request.
+func (h *logErrorHandler) Handle(ctx context.Context, err error) {
+	_ = h.logger.Log(
+		"err", err,
+		"request_id", requestid.FromContext(ctx),
+		"trace_id", tracing.TraceIDFromContext(ctx),
+	)
+}
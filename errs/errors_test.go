@@ -0,0 +1,167 @@
+package errs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/errs"
+)
+
+type Case struct {
+	Name        string
+	Err         error
+	Headers     map[string]string
+	Legacy      bool
+	Status      int
+	ContentType string
+	Result      map[string]interface{}
+}
+
+func TestEncodeError(t *testing.T) {
+	validation := errs.ValidationError{Err: govalidator.Errors{
+		govalidator.Error{Name: "id", Err: errors.New("non zero value required"), Validator: "required"},
+		govalidator.Error{Name: "currency", Err: errors.New("EUR does not validate as in(USD)"), Validator: "in"},
+	}}
+
+	cases := []Case{
+		{
+			Name:        "typed error",
+			Err:         errs.ErrUnknownAccount,
+			Headers:     map[string]string{"X-Request-ID": "req-1"},
+			Status:      http.StatusNotFound,
+			ContentType: "application/problem+json; charset=utf-8",
+			Result: map[string]interface{}{
+				"type":       "urn:payments:problem:unknown_account",
+				"title":      "Unknown account",
+				"status":     404.0,
+				"detail":     "unknown account",
+				"code":       "unknown_account",
+				"request_id": "req-1",
+			},
+		},
+		{
+			Name:        "validation error",
+			Err:         validation,
+			Status:      http.StatusUnprocessableEntity,
+			ContentType: "application/problem+json; charset=utf-8",
+			Result: map[string]interface{}{
+				"type":   "urn:payments:problem:validation_failed",
+				"title":  "Request validation failed",
+				"status": 422.0,
+				"code":   "validation_failed",
+				"invalid_params": []interface{}{
+					map[string]interface{}{"name": "id", "rule": "required", "reason": "value is required"},
+					map[string]interface{}{"name": "currency", "rule": "in", "reason": "is not one of allowed values"},
+				},
+			},
+		},
+		{
+			Name:        "localized",
+			Err:         validation,
+			Headers:     map[string]string{"Accept-Language": "de-DE, ru;q=0.8, en;q=0.5"},
+			Status:      http.StatusUnprocessableEntity,
+			ContentType: "application/problem+json; charset=utf-8",
+			Result: map[string]interface{}{
+				"type":   "urn:payments:problem:validation_failed",
+				"title":  "Запрос не прошёл проверку",
+				"status": 422.0,
+				"code":   "validation_failed",
+				"invalid_params": []interface{}{
+					map[string]interface{}{"name": "id", "rule": "required", "reason": "значение обязательно"},
+					map[string]interface{}{"name": "currency", "rule": "in",
+						"reason": "не входит в список допустимых значений"},
+				},
+			},
+		},
+		{
+			Name:        "internal error is not exposed",
+			Err:         errors.New("pq: connection refused"),
+			Status:      http.StatusInternalServerError,
+			ContentType: "application/problem+json; charset=utf-8",
+			Result: map[string]interface{}{
+				"type":   "urn:payments:problem:internal_error",
+				"title":  "Internal server error",
+				"status": 500.0,
+				"code":   "internal_error",
+			},
+		},
+		{
+			Name:        "deadline exceeded",
+			Err:         context.DeadlineExceeded,
+			Headers:     map[string]string{"X-Request-ID": "req-2"},
+			Status:      http.StatusGatewayTimeout,
+			ContentType: "application/problem+json; charset=utf-8",
+			Result: map[string]interface{}{
+				"type":       "urn:payments:problem:request_timeout",
+				"title":      "Request timed out",
+				"status":     504.0,
+				"detail":     "request timed out",
+				"code":       "request_timeout",
+				"request_id": "req-2",
+			},
+		},
+		{
+			Name:        "legacy:typed error",
+			Err:         errs.ErrAccountsAreEqual,
+			Legacy:      true,
+			Status:      http.StatusNotAcceptable,
+			ContentType: "application/json; charset=utf-8",
+			Result:      map[string]interface{}{"error": "target account must not be equal to source account"},
+		},
+		{
+			Name:        "legacy:validation error",
+			Err:         validation,
+			Legacy:      true,
+			Status:      http.StatusNotAcceptable,
+			ContentType: "application/json; charset=utf-8",
+			Result: map[string]interface{}{
+				"error": "validation error: id: non zero value required;currency: EUR does not validate as in(USD)",
+			},
+		},
+		{
+			Name:        "legacy:malformed request",
+			Err:         errs.MalformedRequestError{Err: errors.New("unexpected EOF")},
+			Legacy:      true,
+			Status:      http.StatusInternalServerError,
+			ContentType: "application/json; charset=utf-8",
+			Result:      map[string]interface{}{"error": "unexpected EOF"},
+		},
+	}
+
+	defer func() { errs.Legacy = false }()
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			errs.Legacy = item.Legacy
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, val := range item.Headers {
+				r.Header.Set(key, val)
+			}
+			ctx := errs.PopulateRequestContext(context.Background(), r)
+
+			rr := httptest.NewRecorder()
+			errs.EncodeError(ctx, item.Err, rr)
+
+			if rr.Code != item.Status {
+				t.Errorf("wrong status code: got %v want %v", rr.Code, item.Status)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != item.ContentType {
+				t.Errorf("wrong content type: got %v want %v", ct, item.ContentType)
+			}
+			var result map[string]interface{}
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, item.Result) {
+				t.Errorf("results not match\nGot: %#v\nExpected: %#v", result, item.Result)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package errs
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is used when client does not accept any of supported languages.
+const DefaultLanguage = "en"
+
+// titles are localized short summaries of problems, by language and code.
+var titles = map[string]map[Code]string{
+	"en": {
+		CodeUnknownAccount:       "Unknown account",
+		CodeAccountExists:        "Account already exists",
+		CodeUnknownPayment:       "Unknown payment",
+		CodePaymentExists:        "Payment already exists",
+		CodeInvalidArgument:      "Invalid argument",
+		CodeUnknownSourceAccount: "Unknown source account",
+		CodeUnknownTargetAccount: "Unknown target account",
+		CodeAccountsAreEqual:     "Target account must not be equal to source account",
+		CodeInsufficientMoney:    "Insufficient money on source account",
+		CodeAccountFrozen:        "Account is frozen",
+		CodeTransferLimit:        "Amount exceeds transfer limit",
+		CodeVersionMismatch:      "Account was changed by another request",
+		CodePreconditionRequired: "Version of account is required",
+		CodeStorePayments:        "Can not store payments",
+		CodeStoreSourceAccount:   "Can not update source account",
+		CodeStoreTargetAccount:   "Can not update target account",
+		CodeBadRoute:             "Bad route",
+		CodeUnknownSubscription:  "Unknown subscription",
+		CodeUnknownEventType:     "Unknown event type",
+		CodeValidation:           "Request validation failed",
+		CodeMalformedRequest:     "Malformed request",
+		CodeInternal:             "Internal server error",
+		CodeRequestTimeout:       "Request timed out",
+		CodeRequestCancelled:     "Request cancelled",
+		CodeUnauthorized:         "Unauthorized",
+		CodeUnknownParentAccount: "Unknown parent account",
+		CodeAccountHasChildren:   "Account has child accounts",
+		CodeUnknownInterestPlan:  "Unknown interest plan",
+		CodeInterestPlanExists:   "Interest plan already exists",
+		CodeNoInterestPlan:       "Account has no interest plan",
+		CodeAccrualChanged:       "Interest accrual was changed concurrently",
+		CodeUnknownApproval:      "Unknown approval",
+		CodeApprovalDecided:      "Transfer is already decided",
+		CodeApprovalExpired:      "Pending transfer has expired",
+		CodeSelfApproval:         "Transfer must be decided by another principal",
+		CodeApprovalRequired:     "Transfer requires approval",
+		CodeTransferDenied:       "Transfer is denied",
+		CodeUnknownTransfer:      "Unknown transfer",
+		CodeStatusTransition:     "Status of transfer does not allow the transition",
+	},
+	"ru": {
+		CodeUnknownAccount:       "Неизвестный счёт",
+		CodeAccountExists:        "Счёт уже существует",
+		CodeUnknownPayment:       "Неизвестный платёж",
+		CodePaymentExists:        "Платёж уже существует",
+		CodeInvalidArgument:      "Неверный аргумент",
+		CodeUnknownSourceAccount: "Неизвестный счёт списания",
+		CodeUnknownTargetAccount: "Неизвестный счёт зачисления",
+		CodeAccountsAreEqual:     "Счёт зачисления не должен совпадать со счётом списания",
+		CodeInsufficientMoney:    "Недостаточно средств на счёте списания",
+		CodeAccountFrozen:        "Счёт заморожен",
+		CodeTransferLimit:        "Сумма превышает лимит перевода",
+		CodeVersionMismatch:      "Счёт был изменён другим запросом",
+		CodePreconditionRequired: "Требуется версия счёта",
+		CodeStorePayments:        "Не удалось сохранить платежи",
+		CodeStoreSourceAccount:   "Не удалось обновить счёт списания",
+		CodeStoreTargetAccount:   "Не удалось обновить счёт зачисления",
+		CodeBadRoute:             "Неверный маршрут",
+		CodeUnknownSubscription:  "Неизвестная подписка",
+		CodeUnknownEventType:     "Неизвестный тип события",
+		CodeValidation:           "Запрос не прошёл проверку",
+		CodeMalformedRequest:     "Некорректный запрос",
+		CodeInternal:             "Внутренняя ошибка сервера",
+		CodeRequestTimeout:       "Истекло время обработки запроса",
+		CodeRequestCancelled:     "Запрос отменён",
+		CodeUnauthorized:         "Требуется аутентификация",
+		CodeUnknownParentAccount: "Неизвестный родительский счёт",
+		CodeAccountHasChildren:   "У счёта есть дочерние счета",
+		CodeUnknownInterestPlan:  "Неизвестный процентный план",
+		CodeInterestPlanExists:   "Процентный план уже существует",
+		CodeNoInterestPlan:       "У счёта нет процентного плана",
+		CodeAccrualChanged:       "Начисление процентов было изменено одновременно",
+		CodeUnknownApproval:      "Неизвестное согласование",
+		CodeApprovalDecided:      "Решение по переводу уже принято",
+		CodeApprovalExpired:      "Срок ожидания перевода истёк",
+		CodeSelfApproval:         "Решение по переводу должен принять другой сотрудник",
+		CodeApprovalRequired:     "Перевод требует согласования",
+		CodeTransferDenied:       "Перевод запрещён",
+		CodeUnknownTransfer:      "Неизвестный перевод",
+		CodeStatusTransition:     "Статус перевода не допускает такой переход",
+	},
+}
+
+// reasons are localized explanations of failed validators, by language and validator name.
+var reasons = map[string]map[string]string{
+	"en": {
+		"required":     "value is required",
+		"alphanum":     "must contain only latin letters and digits",
+		"stringlength": "has wrong length",
+		"in":           "is not one of allowed values",
+		"url":          "must be a valid URL",
+		"decimal":      "must be a decimal number",
+		"int":          "must be a non-negative integer",
+		"positive":     "must be positive",
+		"readonly":     "can not be changed",
+		"maxkeys":      "has too many keys",
+		"metadatakey":  "key must contain only latin letters, digits, '_', '-' and '.'",
+		"once":         "must be given once",
+		"maxdepth":     "tree of accounts is too deep",
+		"currency":     "must have the same currency",
+		"maxitems":     "has too many items",
+		"share":        "must have either amount or percent",
+		"total":        "shares must add up to the amount",
+		"nonnegative":  "must not be negative",
+		"scale":        "has too many decimal places for the currency",
+		"overflow":     "is too large",
+	},
+	"ru": {
+		"required":     "значение обязательно",
+		"alphanum":     "должно содержать только латинские буквы и цифры",
+		"stringlength": "имеет недопустимую длину",
+		"in":           "не входит в список допустимых значений",
+		"url":          "должно быть корректным URL",
+		"decimal":      "должно быть десятичным числом",
+		"int":          "должно быть неотрицательным целым числом",
+		"positive":     "должно быть положительным",
+		"readonly":     "не может быть изменено",
+		"maxkeys":      "содержит слишком много ключей",
+		"metadatakey":  "ключ должен содержать только латинские буквы, цифры, '_', '-' и '.'",
+		"once":         "должно быть указано один раз",
+		"maxdepth":     "дерево счетов слишком глубокое",
+		"currency":     "должен иметь ту же валюту",
+		"maxitems":     "содержит слишком много элементов",
+		"share":        "должна иметь либо сумму, либо процент",
+		"total":        "доли должны в сумме давать сумму перевода",
+		"nonnegative":  "не должно быть отрицательным",
+		"scale":        "содержит слишком много знаков после запятой для валюты",
+		"overflow":     "слишком велико",
+	},
+}
+
+func title(lang string, code Code) string {
+	if val, ok := titles[lang][code]; ok {
+		return val
+	}
+	if val, ok := titles[DefaultLanguage][code]; ok {
+		return val
+	}
+	return string(code)
+}
+
+func reason(lang, validator, fallback string) string {
+	if val, ok := reasons[lang][validator]; ok {
+		return val
+	}
+	if val, ok := reasons[DefaultLanguage][validator]; ok {
+		return val
+	}
+	return fallback
+}
+
+// negotiate picks the supported language with the highest quality from Accept-Language header value.
+func negotiate(header string) string {
+	best, bestQ := DefaultLanguage, 0.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.IndexByte(tag, '-'); i > 0 {
+			tag = tag[:i]
+		}
+		if _, ok := titles[tag]; !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if val, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = val
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,121 @@
+package errs
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/requestid"
+)
+
+// ProblemTypePrefix is a prefix of the problem type URI, problem code follows it.
+const ProblemTypePrefix = "urn:payments:problem:"
+
+// Problem is a machine-readable error response, as described in RFC 7807.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Code          Code           `json:"code"`
+	RequestID     string         `json:"request_id,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+
+	lang string
+}
+
+// InvalidParam describes a field of request, which did not pass validation.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// NewProblem describes error for the client, in language preferred by request (see PopulateRequestContext).
+func NewProblem(ctx context.Context, err error) *Problem {
+	lang := Language(ctx)
+	p := &Problem{
+		RequestID: requestid.FromContext(ctx),
+		lang:      lang,
+	}
+
+	switch err {
+	case context.DeadlineExceeded:
+		err = ErrRequestTimeout
+	case context.Canceled:
+		err = ErrRequestCancelled
+	}
+
+	switch e := err.(type) {
+	case *Error:
+		p.Code, p.Status = e.Code, e.Status
+		p.Detail = e.Message
+	case ValidationError:
+		p.Code, p.Status = CodeValidation, http.StatusUnprocessableEntity
+		p.InvalidParams = invalidParams(e.Err, lang)
+	case MalformedRequestError:
+		p.Code, p.Status = CodeMalformedRequest, http.StatusBadRequest
+		p.Detail = e.Error()
+	default:
+		// Do not expose internals to the client.
+		p.Code, p.Status = CodeInternal, http.StatusInternalServerError
+	}
+	p.Type = ProblemTypePrefix + string(p.Code)
+	p.Title = title(lang, p.Code)
+	return p
+}
+
+func invalidParams(err error, lang string) []InvalidParam {
+	var list []error
+	if ee, ok := err.(govalidator.Errors); ok {
+		list = ee.Errors()
+	} else {
+		list = []error{err}
+	}
+
+	result := make([]InvalidParam, 0, len(list))
+	for _, val := range list {
+		switch e := val.(type) {
+		case govalidator.Error:
+			name := e.Name
+			if len(e.Path) > 0 {
+				name = strings.Join(append(e.Path, e.Name), ".")
+			}
+			result = append(result, InvalidParam{
+				Name:   name,
+				Rule:   e.Validator,
+				Reason: reason(lang, e.Validator, e.Err.Error()),
+			})
+		case govalidator.Errors:
+			result = append(result, invalidParams(e, lang)...)
+		default:
+			result = append(result, InvalidParam{Reason: val.Error()})
+		}
+	}
+	return result
+}
+
+type contextKey int
+
+const (
+	contextKeyLanguage contextKey = iota
+)
+
+// PopulateRequestContext is a ServerBefore function, which stores preferred language in context. Request ID is
+// taken from header, if request did not pass requestid.Middleware (it is not mounted in tests).
+func PopulateRequestContext(ctx context.Context, r *http.Request) context.Context {
+	ctx = context.WithValue(ctx, contextKeyLanguage, negotiate(r.Header.Get("Accept-Language")))
+	if id := r.Header.Get(requestid.Header); requestid.FromContext(ctx) == "" && requestid.Valid(id) {
+		ctx = requestid.NewContext(ctx, id)
+	}
+	return ctx
+}
+
+// Language returns language of error messages stored in context, or default one.
+func Language(ctx context.Context) string {
+	if lang, ok := ctx.Value(contextKeyLanguage).(string); ok {
+		return lang
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,232 @@
+// Package event provides domain events, which are written to the transactional outbox together with the data
+// they describe, and relay which ships them from the outbox to a message bus.
+package event
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// Type of domain event.
+type Type string
+
+const (
+	AccountOpened     Type = "AccountOpened"
+	AccountDeleted    Type = "AccountDeleted"
+	AccountUpdated    Type = "AccountUpdated"
+	AccountRestored   Type = "AccountRestored"
+	TransferCompleted Type = "TransferCompleted"
+	TransferReversed  Type = "TransferReversed"
+)
+
+// Event is a fact happened in the system. Events of an account are published in order of their sequence numbers.
+type Event struct {
+	TableName   struct{}        `json:"-" sql:"outbox"`
+	Sequence    int64           `json:"sequence" sql:"sequence,pk"`
+	ID          uuid.UUID       `json:"id" sql:"id,notnull,unique,type:varchar(36)"`
+	Type        Type            `json:"type" sql:"type,notnull,type:varchar(64)"`
+	Account     account.ID      `json:"account" sql:"account,notnull,type:varchar(255)"`
+	Payload     json.RawMessage `json:"payload" sql:"payload,notnull,type:jsonb"`
+	CreatedAt   time.Time       `json:"created_at" sql:"created_at,notnull"`
+	PublishedAt time.Time       `json:"-" sql:"published_at"`
+}
+
+// AccountOpenedPayload is a payload of AccountOpened event.
+type AccountOpenedPayload struct {
+	ID          account.ID        `json:"id"`
+	Currency    account.Currency  `json:"currency"`
+	Balance     decimal.Decimal   `json:"balance"`
+	Name        string            `json:"name,omitempty"`
+	Status      account.Status    `json:"status"`
+	Description string            `json:"description,omitempty"`
+	Reference   string            `json:"reference,omitempty"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty"`
+	Parent      account.ID        `json:"parent,omitempty"`
+}
+
+// AccountDeletedPayload is a payload of AccountDeleted event.
+type AccountDeletedPayload struct {
+	ID account.ID `json:"id"`
+}
+
+// AccountRestoredPayload is a payload of AccountRestored event.
+type AccountRestoredPayload struct {
+	ID account.ID `json:"id"`
+}
+
+// AccountUpdatedPayload is a payload of AccountUpdated event, it has all mutable fields after update.
+type AccountUpdatedPayload struct {
+	ID                account.ID        `json:"id"`
+	Name              string            `json:"name"`
+	Currency          account.Currency  `json:"currency"`
+	Status            account.Status    `json:"status"`
+	TransferLimit     *decimal.Decimal  `json:"transfer_limit"`
+	TreeTransferLimit *decimal.Decimal  `json:"tree_transfer_limit"`
+	Version           int64             `json:"version"`
+	Description       string            `json:"description"`
+	Reference         string            `json:"reference"`
+	Metadata          metadata.Metadata `json:"metadata"`
+}
+
+// TransferCompletedPayload is a payload of TransferCompleted and TransferReversed events.
+type TransferCompletedPayload struct {
+	Payment     uuid.UUID         `json:"payment"`
+	Transfer    uuid.UUID         `json:"transfer"`
+	From        account.ID        `json:"from"`
+	Amount      decimal.Decimal   `json:"amount"`
+	To          account.ID        `json:"to"`
+	Description string            `json:"description,omitempty"`
+	Reference   string            `json:"reference,omitempty"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty"`
+	// Splits are target accounts of split transfer, which has no To.
+	Splits []payment.Split `json:"splits,omitempty"`
+	// Balances are balances of accounts of the transfer right after it, source account first.
+	Balances []Balance `json:"balances,omitempty"`
+}
+
+// Balance is a balance of an account at the moment of event.
+type Balance struct {
+	ID       account.ID       `json:"id"`
+	Balance  decimal.Decimal  `json:"balance"`
+	Currency account.Currency `json:"currency"`
+}
+
+// NewAccountOpened returns an event about registered account.
+func NewAccountOpened(a *account.Account) *Event {
+	return newEvent(AccountOpened, a.ID, AccountOpenedPayload{
+		ID:          a.ID,
+		Currency:    a.Currency,
+		Balance:     a.Balance,
+		Name:        a.Name,
+		Status:      a.Status,
+		Description: a.Description,
+		Reference:   a.Reference,
+		Metadata:    a.Metadata,
+		Parent:      a.Parent,
+	})
+}
+
+// NewAccountDeleted returns an event about account marked as deleted.
+func NewAccountDeleted(id account.ID) *Event {
+	return newEvent(AccountDeleted, id, AccountDeletedPayload{ID: id})
+}
+
+// NewAccountRestored returns an event about deleted account returned back to the system.
+func NewAccountRestored(id account.ID) *Event {
+	return newEvent(AccountRestored, id, AccountRestoredPayload{ID: id})
+}
+
+// NewAccountUpdated returns an event about changed mutable fields of account.
+func NewAccountUpdated(a *account.Account) *Event {
+	return newEvent(AccountUpdated, a.ID, AccountUpdatedPayload{
+		ID:                a.ID,
+		Name:              a.Name,
+		Currency:          a.Currency,
+		Status:            a.Status,
+		TransferLimit:     a.TransferLimit,
+		TreeTransferLimit: a.TreeTransferLimit,
+		Version:           a.Version,
+		Description:       a.Description,
+		Reference:         a.Reference,
+		Metadata:          a.Metadata,
+	})
+}
+
+// NewTransferCompleted returns an event about money transfer, described by its outgoing payment, with balances of
+// accounts after it. Event belongs to the source account.
+func NewTransferCompleted(p *payment.Payment, accounts map[account.ID]*account.Account) *Event {
+	return newEvent(TransferCompleted, p.Account, transferPayload(p, accounts))
+}
+
+// NewTransferReversed returns an event about returned money of completed transfer, described by its outgoing
+// payment, with balances of accounts after it. Event belongs to the source account.
+func NewTransferReversed(p *payment.Payment, accounts map[account.ID]*account.Account) *Event {
+	return newEvent(TransferReversed, p.Account, transferPayload(p, accounts))
+}
+
+func transferPayload(p *payment.Payment, accounts map[account.ID]*account.Account) TransferCompletedPayload {
+	ids := []account.ID{p.Account}
+	if p.ToAccount != "" {
+		ids = append(ids, p.ToAccount)
+	}
+	for _, val := range p.Splits {
+		ids = append(ids, val.Account)
+	}
+	var balances []Balance
+	for _, id := range ids {
+		if a, ok := accounts[id]; ok {
+			balances = append(balances, Balance{ID: a.ID, Balance: a.Balance, Currency: a.Currency})
+		}
+	}
+	return TransferCompletedPayload{
+		Payment:     p.ID,
+		Transfer:    p.Transfer,
+		From:        p.Account,
+		Amount:      p.Amount,
+		To:          p.ToAccount,
+		Description: p.Description,
+		Reference:   p.Reference,
+		Metadata:    p.Metadata,
+		Splits:      p.Splits,
+		Balances:    balances,
+	}
+}
+
+// TransferEvents returns events for outgoing payments from the list, which are completed or reversed. Incoming
+// payments are the other legs of the same transfers, so they produce no events. Pending and failed payments do not
+// move money, so they produce no events too. Accounts are accounts of payments after the change, for balances.
+func TransferEvents(accounts map[account.ID]*account.Account, payments ...*payment.Payment) []*Event {
+	var result []*Event
+	for _, val := range payments {
+		if val.Direction != payment.Outgoing {
+			continue
+		}
+		switch val.CurrentStatus() {
+		case payment.StatusCompleted:
+			result = append(result, NewTransferCompleted(val, accounts))
+		case payment.StatusReversed:
+			result = append(result, NewTransferReversed(val, accounts))
+		}
+	}
+	return result
+}
+
+func newEvent(t Type, id account.ID, payload interface{}) *Event {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		// Payloads are plain structs, so it is a programming error.
+		panic(err)
+	}
+	return &Event{
+		ID:        uuid.New(),
+		Type:      t,
+		Account:   id,
+		Payload:   data,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// Outbox is a storage of events, written together with the data they describe.
+type Outbox interface {
+	// Pending returns up to limit not published events, ordered by sequence.
+	Pending(limit int) ([]*Event, error)
+
+	// MarkPublished marks events with specified sequence numbers as published.
+	MarkPublished(sequences ...int64) error
+
+	// Since returns up to limit events with sequence greater than specified, published or not, ordered by sequence.
+	Since(sequence int64, limit int) ([]*Event, error)
+
+	// Latest returns sequence of the last event in the outbox, events appended later follow it.
+	Latest() (int64, error)
+
+	// Oldest returns sequence of the first event kept in the outbox, or sequence of the next event if it is empty.
+	// Events before it are pruned or never were in the outbox.
+	Oldest() (int64, error)
+}
@@ -0,0 +1,70 @@
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+type writerPublisher struct {
+	mtx sync.Mutex
+	enc *json.Encoder
+}
+
+// Publish writes event as a single JSON line.
+func (p *writerPublisher) Publish(e *Event) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.enc.Encode(e)
+}
+
+// NewWriterPublisher returns a publisher, which writes events to w as newline-delimited JSON.
+func NewWriterPublisher(w io.Writer) Publisher {
+	return &writerPublisher{
+		enc: json.NewEncoder(w),
+	}
+}
+
+// NewFilePublisher returns a publisher, which appends events to the file as newline-delimited JSON.
+// Caller is responsible for closing the returned file.
+func NewFilePublisher(path string) (Publisher, *os.File, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewWriterPublisher(f), f, nil
+}
+
+// MemoryPublisher keeps published events in memory. Useful in tests.
+type MemoryPublisher struct {
+	mtx    sync.Mutex
+	events []*Event
+	// Fail, if set, is called before every publishing, and its error is returned instead of publishing.
+	Fail func(e *Event) error
+}
+
+// Publish appends event to the list.
+func (p *MemoryPublisher) Publish(e *Event) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.Fail != nil {
+		if err := p.Fail(e); err != nil {
+			return err
+		}
+	}
+	p.events = append(p.events, e)
+	return nil
+}
+
+// Events returns all published events, in order of publishing.
+func (p *MemoryPublisher) Events() []*Event {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	result := make([]*Event, len(p.events))
+	copy(result, p.events)
+	return result
+}
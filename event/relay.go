@@ -0,0 +1,117 @@
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/account"
+)
+
+// Publisher ships events to a message bus.
+type Publisher interface {
+	// Publish sends event. Event is considered delivered only if no error returned.
+	Publish(e *Event) error
+}
+
+// Relay periodically moves events from the outbox to the publisher.
+//
+// Delivery is at-least-once: event is marked published only after successful Publish, so it may be sent again if
+// marking fails. Events of an account are published in order: if one of them failed, following events of the same
+// account wait for the next round.
+type Relay struct {
+	outbox    Outbox
+	publisher Publisher
+	logger    log.Logger
+	interval  time.Duration
+	batch     int
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// RelayOption sets an optional parameter for relay.
+type RelayOption func(*Relay)
+
+// RelayInterval sets pause between outbox polls.
+func RelayInterval(d time.Duration) RelayOption {
+	return func(r *Relay) { r.interval = d }
+}
+
+// RelayBatch sets maximum number of events fetched from the outbox at once.
+func RelayBatch(n int) RelayOption {
+	return func(r *Relay) { r.batch = n }
+}
+
+// NewRelay returns a new relay. Call Start to begin publishing.
+func NewRelay(outbox Outbox, publisher Publisher, logger log.Logger, options ...RelayOption) *Relay {
+	r := &Relay{
+		outbox:    outbox,
+		publisher: publisher,
+		logger:    logger,
+		interval:  time.Second,
+		batch:     100,
+		quit:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// Start runs outbox polling in background.
+func (r *Relay) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			// Drain the outbox, then wait for new events.
+			for r.Flush() == r.batch {
+			}
+			select {
+			case <-r.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop terminates polling and waits for the current round to finish.
+func (r *Relay) Stop() {
+	r.once.Do(func() { close(r.quit) })
+	r.wg.Wait()
+}
+
+// Flush publishes one batch of pending events and returns number of events fetched from the outbox.
+func (r *Relay) Flush() int {
+	events, err := r.outbox.Pending(r.batch)
+	if err != nil {
+		_ = r.logger.Log("method", "pending", "err", err)
+		return 0
+	}
+
+	blocked := make(map[account.ID]bool)
+	for _, e := range events {
+		if blocked[e.Account] {
+			continue
+		}
+		if err := r.publisher.Publish(e); err != nil {
+			_ = r.logger.Log("method", "publish", "sequence", e.Sequence, "account", e.Account, "err", err)
+			blocked[e.Account] = true
+			continue
+		}
+		if err := r.outbox.MarkPublished(e.Sequence); err != nil {
+			_ = r.logger.Log("method", "markPublished", "sequence", e.Sequence, "err", err)
+			blocked[e.Account] = true
+		}
+	}
+	if len(blocked) > 0 {
+		// Do not spin on failing publisher, retry in the next round.
+		return 0
+	}
+	return len(events)
+}
@@ -0,0 +1,141 @@
+package event_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/event"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type published struct {
+	Type    event.Type
+	Account account.ID
+}
+
+func setup(t *testing.T) *inmem.Outbox {
+	outbox := inmem.NewOutbox()
+	accounts := inmem.NewAccountRepository(inmem.WithOutbox(outbox))
+	payments := inmem.NewPaymentRepository(accounts, inmem.WithOutbox(outbox))
+	as := account.NewService(accounts)
+	ps := payment.NewService(payments, accounts)
+	ctx := context.Background()
+
+	OK(t, as.New(ctx, "alice", account.CurrencyUSD, decimal.NewFromFloat(100), account.Details{}))
+	OK(t, as.New(ctx, "bob", account.CurrencyUSD, decimal.NewFromFloat(50), account.Details{}))
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(10), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.New(ctx, "bob", decimal.NewFromFloat(5), "alice", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	OK(t, as.Delete(ctx, "bob"))
+	return outbox
+}
+
+func summary(events []*event.Event) []published {
+	result := make([]published, 0, len(events))
+	for _, val := range events {
+		result = append(result, published{val.Type, val.Account})
+	}
+	return result
+}
+
+func equal(a, b []published) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRelay(t *testing.T) {
+	logger := log.NewLogfmtLogger(os.Stderr)
+
+	expected := []published{
+		{event.AccountOpened, "alice"},
+		{event.AccountOpened, "bob"},
+		{event.TransferCompleted, "alice"},
+		{event.TransferCompleted, "bob"},
+		{event.AccountDeleted, "bob"},
+	}
+
+	t.Run("publish in order", func(t *testing.T) {
+		outbox := setup(t)
+		publisher := &event.MemoryPublisher{}
+		relay := event.NewRelay(outbox, publisher, logger, event.RelayBatch(2))
+
+		for relay.Flush() > 0 {
+		}
+		if got := summary(publisher.Events()); !equal(got, expected) {
+			t.Errorf("wrong events published\nGot: %v\nExpected: %v", got, expected)
+		}
+
+		pending, err := outbox.Pending(100)
+		OK(t, err)
+		if len(pending) != 0 {
+			t.Errorf("all events must be marked published, %d pending", len(pending))
+		}
+
+		var payload event.TransferCompletedPayload
+		e := publisher.Events()[2]
+		OK(t, json.Unmarshal(e.Payload, &payload))
+		if payload.From != "alice" || payload.To != "bob" || !payload.Amount.Equal(decimal.NewFromFloat(10)) {
+			t.Errorf("wrong transfer payload: %+v", payload)
+		}
+		if len(payload.Balances) != 2 || !payload.Balances[0].Balance.Equal(decimal.NewFromFloat(90)) ||
+			!payload.Balances[1].Balance.Equal(decimal.NewFromFloat(60)) {
+			t.Errorf("balances after transfer expected: %+v", payload.Balances)
+		}
+	})
+
+	t.Run("failed account waits, others go on", func(t *testing.T) {
+		outbox := setup(t)
+		failures := 2
+		publisher := &event.MemoryPublisher{Fail: func(e *event.Event) error {
+			if e.Account == "bob" && failures > 0 {
+				failures--
+				return errors.New("bus is unavailable")
+			}
+			return nil
+		}}
+		relay := event.NewRelay(outbox, publisher, logger)
+
+		relay.Flush()
+		first := []published{
+			{event.AccountOpened, "alice"},
+			{event.TransferCompleted, "alice"},
+		}
+		if got := summary(publisher.Events()); !equal(got, first) {
+			t.Errorf("wrong events published\nGot: %v\nExpected: %v", got, first)
+		}
+
+		relay.Flush()
+		relay.Flush()
+		rest := append(first,
+			published{event.AccountOpened, "bob"},
+			published{event.TransferCompleted, "bob"},
+			published{event.AccountDeleted, "bob"},
+		)
+		if got := summary(publisher.Events()); !equal(got, rest) {
+			t.Errorf("wrong events published\nGot: %v\nExpected: %v", got, rest)
+		}
+	})
+}
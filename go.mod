@@ -3,6 +3,7 @@ module github.com/otetz/payments
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.0
 	github.com/VividCortex/gohistogram v1.0.0 // indirect
 	github.com/asaskevich/govalidator v0.0.0-20190424111038-f61b66f89f4a
 	github.com/go-kit/kit v0.9.0
@@ -16,5 +17,7 @@ require (
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/prometheus/client_golang v1.0.0
 	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24
+	go.etcd.io/bbolt v1.3.5
+	gopkg.in/yaml.v2 v2.4.0
 	mellium.im/sasl v0.2.1 // indirect
 )
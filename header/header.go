@@ -0,0 +1,16 @@
+// Package header validates values of request headers, which are accepted from clients and proxies.
+package header
+
+// Valid reports whether header value is safe to be logged and stored: not empty, not longer than maxLength, and
+// consists of printable ASCII characters only.
+func Valid(value string, maxLength int) bool {
+	if value == "" || len(value) > maxLength {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x21 || value[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+// Package health provides liveness and readiness probes of the service.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status of the service or of a single check.
+type Status string
+
+const (
+	StatusOK           Status = "ok"
+	StatusFail         Status = "fail"
+	StatusShuttingDown Status = "shutting_down"
+)
+
+// CheckFunc checks a dependency of the service. It must respect context deadline.
+type CheckFunc func(ctx context.Context) error
+
+// CheckResult is a result of a single check.
+type CheckResult struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is a body of probe responses.
+type Report struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Health runs registered checks for probes and tracks readiness of the service to accept traffic.
+type Health struct {
+	timeout time.Duration
+	ready   int32
+
+	mtx    sync.RWMutex
+	checks map[string]CheckFunc
+}
+
+// New returns a new instance of Health, which is not ready until SetReady is called.
+func New(timeout time.Duration) *Health {
+	return &Health{
+		timeout: timeout,
+		checks:  make(map[string]CheckFunc),
+	}
+}
+
+// AddCheck registers a named check.
+func (h *Health) AddCheck(name string, check CheckFunc) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.checks[name] = check
+}
+
+// SetReady switches readiness of the service. It must be set to false at the start of shutdown,
+// so load balancers stop sending traffic.
+func (h *Health) SetReady(ready bool) {
+	var val int32
+	if ready {
+		val = 1
+	}
+	atomic.StoreInt32(&h.ready, val)
+}
+
+// Ready reports whether service accepts traffic.
+func (h *Health) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+// Check runs all checks concurrently and returns report.
+func (h *Health) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	h.mtx.RLock()
+	names := make([]string, 0, len(h.checks))
+	for name := range h.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for idx, name := range names {
+		wg.Add(1)
+		go func(idx int, check CheckFunc) {
+			defer wg.Done()
+			if err := check(ctx); err != nil {
+				results[idx] = CheckResult{Status: StatusFail, Error: err.Error()}
+				return
+			}
+			results[idx] = CheckResult{Status: StatusOK}
+		}(idx, h.checks[name])
+	}
+	h.mtx.RUnlock()
+	wg.Wait()
+
+	r := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(names))}
+	for idx, name := range names {
+		r.Checks[name] = results[idx]
+		if results[idx].Status != StatusOK {
+			r.Status = StatusFail
+		}
+	}
+	return r
+}
+
+// LivenessHandler responds with 200 if all checks passed, and with 503 otherwise.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, h.Check(r.Context()))
+	})
+}
+
+// ReadinessHandler responds with 200 if service is ready and all checks passed, and with 503 otherwise.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			writeReport(w, Report{Status: StatusShuttingDown})
+			return
+		}
+		writeReport(w, h.Check(r.Context()))
+	})
+}
+
+func writeReport(w http.ResponseWriter, r Report) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Status != StatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,101 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/otetz/payments/health"
+)
+
+type Case struct {
+	Name    string
+	Handler http.Handler
+	Ready   bool
+	Status  int
+	Result  health.Report
+}
+
+func TestProbes(t *testing.T) {
+	h := health.New(50 * time.Millisecond)
+	h.AddCheck("postgres", func(ctx context.Context) error { return nil })
+
+	failed := health.New(50 * time.Millisecond)
+	failed.AddCheck("postgres", func(ctx context.Context) error { return nil })
+	failed.AddCheck("migrations", func(ctx context.Context) error { return errors.New("missing: outbox") })
+
+	slow := health.New(50 * time.Millisecond)
+	slow.AddCheck("postgres", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ok := health.Report{Status: health.StatusOK, Checks: map[string]health.CheckResult{
+		"postgres": {Status: health.StatusOK},
+	}}
+
+	cases := []Case{
+		{
+			Name:    "liveness",
+			Handler: h.LivenessHandler(),
+			Status:  http.StatusOK,
+			Result:  ok,
+		},
+		{
+			Name:    "liveness:failed check",
+			Handler: failed.LivenessHandler(),
+			Status:  http.StatusServiceUnavailable,
+			Result: health.Report{Status: health.StatusFail, Checks: map[string]health.CheckResult{
+				"postgres":   {Status: health.StatusOK},
+				"migrations": {Status: health.StatusFail, Error: "missing: outbox"},
+			}},
+		},
+		{
+			Name:    "liveness:timeout",
+			Handler: slow.LivenessHandler(),
+			Status:  http.StatusServiceUnavailable,
+			Result: health.Report{Status: health.StatusFail, Checks: map[string]health.CheckResult{
+				"postgres": {Status: health.StatusFail, Error: "context deadline exceeded"},
+			}},
+		},
+		{
+			Name:    "readiness",
+			Handler: h.ReadinessHandler(),
+			Ready:   true,
+			Status:  http.StatusOK,
+			Result:  ok,
+		},
+		{
+			Name:    "readiness:shutting down",
+			Handler: h.ReadinessHandler(),
+			Status:  http.StatusServiceUnavailable,
+			Result:  health.Report{Status: health.StatusShuttingDown},
+		},
+	}
+
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			h.SetReady(item.Ready)
+
+			rr := httptest.NewRecorder()
+			item.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rr.Code != item.Status {
+				t.Errorf("wrong status code: got %v want %v", rr.Code, item.Status)
+			}
+			var result health.Report
+			if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, item.Result) {
+				t.Errorf("results not match\nGot: %#v\nExpected: %#v", result, item.Result)
+			}
+		})
+	}
+}
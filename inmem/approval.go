@@ -0,0 +1,155 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+type approvalRepository struct {
+	mtx       sync.RWMutex
+	approvals map[uuid.UUID]*payment.Approval
+	order     []uuid.UUID
+	payments  *paymentRepository
+	journal   *Journal
+}
+
+// copyApproval returns a deep copy of approval, so callers can not change stored one.
+func copyApproval(a *payment.Approval) *payment.Approval {
+	c := *a
+	c.Metadata = a.Metadata.Clone()
+	if a.DecidedAt != nil {
+		t := *a.DecidedAt
+		c.DecidedAt = &t
+	}
+	if a.RiskReasons != nil {
+		c.RiskReasons = append([]string(nil), a.RiskReasons...)
+	}
+	return &c
+}
+
+// StoreApproval saves a new pending transfer.
+func (r *approvalRepository) StoreApproval(ctx context.Context, approval *payment.Approval) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	c := copyApproval(approval)
+	if err := r.journal.append(&record{Op: opStoreApproval, Approval: c}); err != nil {
+		return err
+	}
+	r.store(c)
+	return nil
+}
+
+// store saves transfer without journaling, caller holds the lock.
+func (r *approvalRepository) store(approval *payment.Approval) {
+	if _, ok := r.approvals[approval.ID]; !ok {
+		r.order = append(r.order, approval.ID)
+	}
+	r.approvals[approval.ID] = approval
+}
+
+// FindApproval returns transfer with specified id.
+func (r *approvalRepository) FindApproval(ctx context.Context, id uuid.UUID) (*payment.Approval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if val, ok := r.approvals[id]; ok {
+		return copyApproval(val), nil
+	}
+	return nil, errs.ErrUnknownApproval
+}
+
+// FindApprovals returns copies of all transfers ordered by time of creation, transfers created at the same time are
+// in order of storing.
+func (r *approvalRepository) FindApprovals(ctx context.Context) ([]*payment.Approval, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*payment.Approval, 0, len(r.order))
+	for _, id := range r.order {
+		result = append(result, copyApproval(r.approvals[id]))
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result, nil
+}
+
+// Reserved returns total amount of transfers from account, which are pending and expire after now.
+func (r *approvalRepository) Reserved(ctx context.Context, id account.ID, now time.Time) (decimal.Decimal, error) {
+	if err := ctx.Err(); err != nil {
+		return decimal.Zero, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	total := decimal.Zero
+	for _, val := range r.approvals {
+		if val.FromAccount == id && val.Status == payment.ApprovalPending && val.ExpiresAt.After(now) {
+			total = total.Add(val.Amount)
+		}
+	}
+	return total, nil
+}
+
+// Decide stores decision on pending transfer together with payments. Payments are stored under the lock of the
+// repository, so transfer is decided only if they are stored, and both are journaled as one change.
+func (r *approvalRepository) Decide(ctx context.Context, approval *payment.Approval,
+	payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	val, ok := r.approvals[approval.ID]
+	if !ok {
+		return errs.ErrUnknownApproval
+	}
+	if val.Status != payment.ApprovalPending {
+		return errs.ErrApprovalDecided
+	}
+	c := copyApproval(approval)
+	rec := &record{Op: opDecideApproval, Approval: c, Payments: payments}
+	if len(payments) > 0 {
+		r.payments.mtx.Lock()
+		defer r.payments.mtx.Unlock()
+		if err := r.payments.save(rec, payments...); err != nil {
+			return err
+		}
+	} else if err := r.journal.append(rec); err != nil {
+		return err
+	}
+	r.approvals[approval.ID] = c
+	return nil
+}
+
+// NewApprovalRepository returns a new instance of an in-memory approval repository. Payments must be an in-memory
+// repository too. With journal, it must be created before Restore, which loads its transfers.
+func NewApprovalRepository(payments payment.Repository, opts ...Option) payment.ApprovalRepository {
+	o := newOptions(opts)
+	r := &approvalRepository{
+		approvals: make(map[uuid.UUID]*payment.Approval),
+		payments:  payments.(*paymentRepository),
+		journal:   o.journal,
+	}
+	if r.journal != nil {
+		r.journal.approvals = r
+	}
+	return r
+}
@@ -0,0 +1,125 @@
+package inmem
+
+import (
+	"context"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/payment"
+)
+
+type bulkRepository struct {
+	accounts *accountRepository
+	payments *paymentRepository
+}
+
+// ImportAccounts stores accounts as they are, without domain events.
+func (r *bulkRepository) ImportAccounts(ctx context.Context, accounts []*account.Account, dryRun bool) (
+	map[int]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.accounts.mtx.Lock()
+	defer r.accounts.mtx.Unlock()
+
+	rejects := make(map[int]error)
+	accepted := make([]*account.Account, 0, len(accounts))
+	for i, val := range accounts {
+		if _, ok := r.accounts.accounts[val.ID]; ok {
+			rejects[i] = errs.ErrAccountExists
+			continue
+		}
+		c := *val
+		c.InitialBalance = c.Balance
+		accepted = append(accepted, &c)
+	}
+	if dryRun || len(accepted) == 0 {
+		return rejects, nil
+	}
+	if err := r.accounts.journal.append(&record{Op: opImportAccounts, Accounts: accepted}); err != nil {
+		return nil, err
+	}
+	r.accounts.store(accepted...)
+	return rejects, nil
+}
+
+// ImportPayments stores payments without changing balances of accounts and without domain events. Initial
+// balances are changed instead.
+func (r *bulkRepository) ImportPayments(ctx context.Context, payments []*payment.Payment, dryRun bool) (
+	map[int]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.payments.mtx.Lock()
+	defer r.payments.mtx.Unlock()
+	// Accounts are locked too, so they are not deleted before payments are journaled.
+	r.accounts.mtx.Lock()
+	defer r.accounts.mtx.Unlock()
+
+	rejects := make(map[int]error)
+	accepted := make([]*payment.Payment, 0, len(payments))
+	for i, val := range payments {
+		if a, ok := r.accounts.accounts[val.Account]; !ok || a.Deleted {
+			rejects[i] = errs.ErrUnknownAccount
+			continue
+		}
+		if _, ok := r.payments.payments[val.ID]; ok {
+			rejects[i] = errs.ErrPaymentExists
+			continue
+		}
+		accepted = append(accepted, val)
+	}
+	if dryRun || len(accepted) == 0 {
+		return rejects, nil
+	}
+	if err := r.payments.journal.append(&record{Op: opImportPayments, Payments: accepted}); err != nil {
+		return nil, err
+	}
+	r.payments.store(accepted...)
+	r.accounts.history(accepted...)
+	return rejects, nil
+}
+
+// ExportAccounts calls fn for copies of accounts, taken under the lock.
+func (r *bulkRepository) ExportAccounts(ctx context.Context, fn func(a *account.Account) error) error {
+	accounts, err := r.accounts.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, val := range accounts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportPayments calls fn for copies of payments, taken under the lock, in order of storing.
+func (r *bulkRepository) ExportPayments(ctx context.Context, fn func(p *payment.Payment) error) error {
+	payments, err := r.payments.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	for _, val := range payments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewBulkRepository returns a new instance of an in-memory bulk repository, which imports to and exports from
+// in-memory repositories.
+func NewBulkRepository(accounts account.Repository, payments payment.Repository) bulk.Repository {
+	return &bulkRepository{
+		accounts: accounts.(*accountRepository),
+		payments: payments.(*paymentRepository),
+	}
+}
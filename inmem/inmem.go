@@ -2,169 +2,556 @@
 package inmem
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/otetz/payments/account"
 	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/event"
 	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
 )
 
 type accountRepository struct {
 	mtx      sync.RWMutex
 	accounts map[account.ID]*account.Account
+	payments *paymentRepository
+	outbox   *Outbox
+	journal  *Journal
 }
 
 // Store account in the repository
-func (r *accountRepository) Store(account *account.Account) error {
+func (r *accountRepository) Store(ctx context.Context, account *account.Account) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	r.accounts[account.ID] = account
+	if _, ok := r.accounts[account.ID]; ok {
+		return errs.ErrAccountExists
+	}
+	if account.Parent != "" && !r.live(account.Parent) {
+		return errs.ErrUnknownParentAccount
+	}
+	c := *account
+	c.InitialBalance = c.Balance
+	if err := r.journal.append(&record{Op: opStoreAccount, Account: &c}); err != nil {
+		return err
+	}
+	r.store(&c)
+	r.outbox.append(event.NewAccountOpened(account))
 	return nil
 }
 
+// live reports whether account exists and is not deleted, caller holds the lock.
+func (r *accountRepository) live(id account.ID) bool {
+	a, ok := r.accounts[id]
+	return ok && !a.Deleted
+}
+
+// store puts copies of accounts to the map, caller holds the lock.
+func (r *accountRepository) store(accounts ...*account.Account) {
+	for _, val := range accounts {
+		r.accounts[val.ID] = copyAccount(val)
+	}
+}
+
+// copyAccount returns a copy of account, which shares nothing with it.
+func copyAccount(a *account.Account) *account.Account {
+	c := *a
+	c.Metadata = a.Metadata.Clone()
+	return &c
+}
+
 // Find account in the repository with specified id
-func (r *accountRepository) Find(id account.ID) (*account.Account, error) {
+func (r *accountRepository) Find(ctx context.Context, id account.ID) (*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
 
 	if val, ok := r.accounts[id]; ok {
 		if !val.Deleted {
-			return val, nil
+			return copyAccount(val), nil
 		}
 	}
 	return nil, errs.ErrUnknownAccount
 }
 
 // FindAll returns all accounts registered in the system
-func (r *accountRepository) FindAll() []*account.Account {
+func (r *accountRepository) FindAll(ctx context.Context) ([]*account.Account, error) {
+	return r.Search(ctx, account.Filter{})
+}
+
+// Search returns copies of not deleted accounts, which satisfy filter, ordered by id.
+func (r *accountRepository) Search(ctx context.Context, filter account.Filter) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
 
-	c := make([]*account.Account, 0, len(r.accounts))
+	result := make([]*account.Account, 0, len(r.accounts))
 	for _, val := range r.accounts {
-		if !val.Deleted {
-			c = append(c, val)
+		if !val.Deleted && filter.Match(val) {
+			result = append(result, copyAccount(val))
 		}
 	}
-	return c
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
 }
 
 // MarkDeleted is mark as deleted specified account in the system
-func (r *accountRepository) MarkDeleted(id account.ID) error {
+func (r *accountRepository) MarkDeleted(ctx context.Context, id account.ID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	if _, ok := r.accounts[id]; ok {
-		r.accounts[id].Deleted = true
+	if val, ok := r.accounts[id]; ok && !val.Deleted {
+		for _, child := range r.accounts {
+			if child.Parent == id && !child.Deleted {
+				return errs.ErrAccountHasChildren
+			}
+		}
+		now := time.Now().UTC()
+		if err := r.journal.append(&record{Op: opDeleteAccount, AccountID: id, Time: now}); err != nil {
+			return err
+		}
+		val.Deleted, val.DeletedAt = true, &now
+		r.outbox.append(event.NewAccountDeleted(id))
 		return nil
 	}
 	return errs.ErrUnknownAccount
 }
 
+// FindTree returns copies of account and its not deleted descendants, ordered by id.
+func (r *accountRepository) FindTree(ctx context.Context, id account.ID) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if !r.live(id) {
+		return nil, errs.ErrUnknownAccount
+	}
+	children := make(map[account.ID][]*account.Account)
+	for _, val := range r.accounts {
+		if val.Parent != "" && !val.Deleted {
+			children[val.Parent] = append(children[val.Parent], val)
+		}
+	}
+	result := []*account.Account{copyAccount(r.accounts[id])}
+	for i := 0; i < len(result); i++ {
+		for _, val := range children[result[i].ID] {
+			result = append(result, copyAccount(val))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// FindDeleted returns copies of deleted accounts, ordered by id.
+func (r *accountRepository) FindDeleted(ctx context.Context) ([]*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*account.Account, 0)
+	for _, val := range r.accounts {
+		if val.Deleted {
+			result = append(result, copyAccount(val))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// Restore clears deletion mark of account and returns its copy.
+func (r *accountRepository) Restore(ctx context.Context, id account.ID) (*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	val, ok := r.accounts[id]
+	if !ok || !val.Deleted {
+		return nil, errs.ErrUnknownAccount
+	}
+	if val.Parent != "" && !r.live(val.Parent) {
+		return nil, errs.ErrUnknownParentAccount
+	}
+	if err := r.journal.append(&record{Op: opRestoreAccount, AccountID: id}); err != nil {
+		return nil, err
+	}
+	val.Deleted, val.DeletedAt = false, nil
+	r.outbox.append(event.NewAccountRestored(id))
+	return copyAccount(val), nil
+}
+
+// Purge erases accounts deleted before the time, together with their payments. Lock order is the same as in
+// payment Store: payments, then accounts.
+func (r *accountRepository) Purge(ctx context.Context, before time.Time) ([]account.ID, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.payments != nil {
+		r.payments.mtx.Lock()
+		defer r.payments.mtx.Unlock()
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	ids := make([]account.ID, 0)
+	for _, val := range r.accounts {
+		if val.Deleted && val.DeletedAt != nil && val.DeletedAt.Before(before) {
+			ids = append(ids, val.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if err := r.journal.append(&record{Op: opPurgeAccounts, AccountIDs: ids}); err != nil {
+		return nil, err
+	}
+	r.purge(ids...)
+	return ids, nil
+}
+
+// purge removes accounts and their payments, caller holds locks of both repositories.
+func (r *accountRepository) purge(ids ...account.ID) {
+	for _, id := range ids {
+		delete(r.accounts, id)
+	}
+	if r.payments != nil {
+		r.payments.purge(ids...)
+	}
+}
+
+// Update changes mutable fields of account by fn under the lock.
+func (r *accountRepository) Update(ctx context.Context, id account.ID, fn func(a *account.Account) error) (
+	*account.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	val, ok := r.accounts[id]
+	if !ok || val.Deleted {
+		return nil, errs.ErrUnknownAccount
+	}
+	c := copyAccount(val)
+	if err := fn(c); err != nil {
+		return nil, err
+	}
+	u := copyAccount(val)
+	u.Name, u.Currency, u.Status, u.TransferLimit = c.Name, c.Currency, c.Status, c.TransferLimit
+	u.TreeTransferLimit = c.TreeTransferLimit
+	u.Description, u.Reference, u.Metadata = c.Description, c.Reference, c.Metadata
+	u.Version++
+	if err := r.journal.append(&record{Op: opUpdateAccount, Account: u}); err != nil {
+		return nil, err
+	}
+	r.store(u)
+	r.outbox.append(event.NewAccountUpdated(u))
+	return u, nil
+}
+
+// apply changes balances of accounts by completed payments, or returns their money if reverse is set, all or
+// nothing, and journals the change as rec. Each payment changes balance of its own account, so transfer is applied
+// once by its outgoing and incoming payments. Balance of debited account must not become negative, it is checked
+// under the lock, so concurrent transfers can not spend the same money. Failed payments never change balances, so
+// their accounts may be deleted. Changes are journaled under the lock of accounts, so their order in the journal
+// agrees with deletion of accounts. It returns copies of changed accounts.
+func (r *accountRepository) apply(rec *record, reverse bool, payments ...*payment.Payment) (
+	map[account.ID]*account.Account, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	balances := make(map[account.ID]decimal.Decimal, len(payments))
+	debited := make(map[account.ID]bool)
+	for _, val := range payments {
+		if val.CurrentStatus() == payment.StatusFailed {
+			continue
+		}
+		a, ok := r.accounts[val.Account]
+		if !ok || a.Deleted {
+			return nil, errs.ErrUnknownAccount
+		}
+		if !val.Completed() && !reverse {
+			continue
+		}
+		balance, ok := balances[a.ID]
+		if !ok {
+			balance = a.Balance
+		}
+		amount := val.Amount
+		if val.Direction == payment.Outgoing {
+			amount = amount.Neg()
+		}
+		if reverse {
+			amount = amount.Neg()
+		}
+		if amount.Sign() < 0 {
+			debited[a.ID] = true
+		}
+		balances[a.ID] = balance.Add(amount)
+	}
+	for id := range debited {
+		if balances[id].Sign() < 0 {
+			return nil, errs.ErrInsufficientMoney
+		}
+	}
+	if err := r.journal.append(rec); err != nil {
+		return nil, err
+	}
+	changed := make(map[account.ID]*account.Account, len(balances))
+	for id, balance := range balances {
+		r.accounts[id].Balance = balance
+		c := *r.accounts[id]
+		changed[id] = &c
+	}
+	return changed, nil
+}
+
+// history changes initial balances of accounts by imported completed payments, so their current balances stay the
+// same. Caller holds the lock.
+func (r *accountRepository) history(payments ...*payment.Payment) {
+	for _, val := range payments {
+		if !val.Completed() {
+			continue
+		}
+		a := r.accounts[val.Account]
+		switch val.Direction {
+		case payment.Outgoing:
+			a.InitialBalance = a.InitialBalance.Add(val.Amount)
+		case payment.Incoming:
+			a.InitialBalance = a.InitialBalance.Sub(val.Amount)
+		}
+	}
+}
+
 // NewAccountRepository returns a new instance of an in-memory account repository.
-func NewAccountRepository() account.Repository {
-	return &accountRepository{
+func NewAccountRepository(opts ...Option) account.Repository {
+	o := newOptions(opts)
+	r := &accountRepository{
 		accounts: make(map[account.ID]*account.Account),
+		outbox:   o.outbox,
+		journal:  o.journal,
 	}
+	if r.journal != nil {
+		r.journal.accounts = r
+	}
+	return r
 }
 
 type paymentRepository struct {
 	mtx      sync.RWMutex
 	payments map[uuid.UUID]*payment.Payment
-	accounts account.Repository
+	order    []uuid.UUID
+	accounts *accountRepository
+	outbox   *Outbox
+	journal  *Journal
 }
 
-// Store payments in the repository.
-func (r *paymentRepository) Store(payments ...*payment.Payment) error {
+// Store payments in the repository, completed ones change balances of their accounts.
+func (r *paymentRepository) Store(ctx context.Context, payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	for _, val := range payments {
-		r.payments[val.ID] = val
+	return r.save(&record{Op: opStorePayments, Payments: payments}, payments...)
+}
 
-		a, err := r.accounts.Find(val.Account)
-		if err != nil {
-			return err
-		}
+// save stores payments, changing balances of their accounts, and journals the change as rec. Caller holds the lock.
+func (r *paymentRepository) save(rec *record, payments ...*payment.Payment) error {
+	changed, err := r.accounts.apply(rec, false, payments...)
+	if err != nil {
+		return err
+	}
+	r.store(payments...)
+	r.outbox.append(event.TransferEvents(changed, payments...)...)
+	return nil
+}
 
-		if val.ToAccount != "" {
-			to, err := r.accounts.Find(val.ToAccount)
-			if err != nil {
-				return err
-			}
-			a.Balance.Sub(val.Amount)
-			if err = r.accounts.Store(a); err != nil {
-				return err
-			}
-			to.Balance.Add(val.Amount)
-			if err = r.accounts.Store(to); err != nil {
-				return err
-			}
-		} else if val.FromAccount != "" {
-			from, err := r.accounts.Find(val.FromAccount)
-			if err != nil {
-				return err
-			}
-			a.Balance.Add(val.Amount)
-			if err = r.accounts.Store(a); err != nil {
-				return err
-			}
-			from.Balance.Sub(val.Amount)
-			if err = r.accounts.Store(from); err != nil {
-				return err
-			}
-		}
+// Transition changes status of all payments of transfer at once, changing balances of their accounts by
+// completion or reversal.
+func (r *paymentRepository) Transition(ctx context.Context, transfer uuid.UUID, to payment.Status, reason string,
+	at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
 
+	payments, changed, err := r.transition(transfer, to, reason, at)
+	if err != nil {
+		return err
+	}
+	r.outbox.append(event.TransferEvents(changed, payments...)...)
 	return nil
 }
 
-// Find payments list for an account.
-func (r *paymentRepository) Find(id account.ID) []*payment.Payment {
-	r.mtx.RLock()
-	defer r.mtx.RUnlock()
-
-	result := make([]*payment.Payment, 0, len(r.payments))
-	for _, val := range r.payments {
-		if val.Account == id && !val.Deleted {
-			result = append(result, val)
+// transition changes status of payments of transfer and returns their copies together with copies of changed
+// accounts, caller holds the lock. Journal replays the change by it too.
+func (r *paymentRepository) transition(transfer uuid.UUID, to payment.Status, reason string, at time.Time) (
+	[]*payment.Payment, map[account.ID]*account.Account, error) {
+	if transfer == uuid.Nil {
+		return nil, nil, errs.ErrUnknownTransfer
+	}
+	var payments []*payment.Payment
+	for _, pid := range r.order {
+		val := r.payments[pid]
+		if val.Deleted || val.Transfer != transfer {
+			continue
 		}
+		c := copyPayment(val)
+		if err := c.Transition(to, reason, at); err != nil {
+			return nil, nil, err
+		}
+		payments = append(payments, c)
+	}
+	if len(payments) == 0 {
+		return nil, nil, errs.ErrUnknownTransfer
 	}
-	return result
+	rec := &record{Op: opTransition, Transfer: transfer, Status: to, Reason: reason, Time: at}
+	changed, err := r.accounts.apply(rec, to == payment.StatusReversed, payments...)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.store(payments...)
+	return payments, changed, nil
 }
 
-// FindAll returns all payments, registered in the system.
-func (r *paymentRepository) FindAll() []*payment.Payment {
+// find returns copies of not deleted payments, which satisfy filter, in order of storing.
+func (r *paymentRepository) find(ctx context.Context, filter func(p *payment.Payment) bool) ([]*payment.Payment,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	r.mtx.RLock()
 	defer r.mtx.RUnlock()
 
 	result := make([]*payment.Payment, 0, len(r.payments))
-	for _, val := range r.payments {
-		if !val.Deleted {
-			result = append(result, val)
+	for _, pid := range r.order {
+		val := r.payments[pid]
+		if !val.Deleted && filter(val) {
+			result = append(result, copyPayment(val))
 		}
 	}
-	return result
+	return result, nil
+}
+
+// Find payments list for an account.
+func (r *paymentRepository) Find(ctx context.Context, id account.ID) ([]*payment.Payment, error) {
+	return r.find(ctx, func(p *payment.Payment) bool { return p.Account == id })
+}
+
+// FindAll returns all payments, registered in the system.
+func (r *paymentRepository) FindAll(ctx context.Context) ([]*payment.Payment, error) {
+	return r.find(ctx, func(p *payment.Payment) bool { return true })
+}
+
+// Search returns not deleted payments, which satisfy filter, in order of storing.
+func (r *paymentRepository) Search(ctx context.Context, filter payment.Filter) ([]*payment.Payment, error) {
+	return r.find(ctx, filter.Match)
 }
 
 // MarkDeleted is mark as deleted specified payment in the system
-func (r *paymentRepository) MarkDeleted(id uuid.UUID) error {
+func (r *paymentRepository) MarkDeleted(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	r.mtx.Lock()
 	defer r.mtx.Unlock()
 
-	if _, ok := r.payments[id]; ok {
-		r.payments[id].Deleted = true
+	if val, ok := r.payments[id]; ok && !val.Deleted {
+		if err := r.journal.append(&record{Op: opDeletePayment, PaymentID: id}); err != nil {
+			return err
+		}
+		val.Deleted = true
+		return nil
+	}
+	return errs.ErrUnknownPayment
+}
+
+// store puts copies of payments to the map, caller holds the lock.
+func (r *paymentRepository) store(payments ...*payment.Payment) {
+	for _, val := range payments {
+		if _, ok := r.payments[val.ID]; !ok {
+			r.order = append(r.order, val.ID)
+		}
+		r.payments[val.ID] = copyPayment(val)
+	}
+}
+
+// purge removes payments of accounts, caller holds the lock.
+func (r *paymentRepository) purge(ids ...account.ID) {
+	purged := make(map[account.ID]bool, len(ids))
+	for _, id := range ids {
+		purged[id] = true
+	}
+	order := r.order[:0]
+	for _, pid := range r.order {
+		if purged[r.payments[pid].Account] {
+			delete(r.payments, pid)
+			continue
+		}
+		order = append(order, pid)
+	}
+	r.order = order
+}
+
+// copyPayment returns a copy of payment, which shares nothing with it.
+func copyPayment(p *payment.Payment) *payment.Payment {
+	c := *p
+	c.Metadata = p.Metadata.Clone()
+	if p.Splits != nil {
+		c.Splits = append([]payment.Split(nil), p.Splits...)
+	}
+	if p.RiskReasons != nil {
+		c.RiskReasons = append([]string(nil), p.RiskReasons...)
+	}
+	c.CompletedAt, c.FailedAt, c.ReversedAt = copyTime(p.CompletedAt), copyTime(p.FailedAt), copyTime(p.ReversedAt)
+	return &c
+}
+
+// copyTime returns a copy of optional time.
+func copyTime(t *time.Time) *time.Time {
+	if t == nil {
 		return nil
 	}
-	return errs.ErrUnknownAccount
+	c := *t
+	return &c
 }
 
-// NewPaymentRepository returns a new instance of an in-memory payment repository.
-func NewPaymentRepository(accounts account.Repository) payment.Repository {
-	return &paymentRepository{
+// NewPaymentRepository returns a new instance of an in-memory payment repository. Accounts must be an in-memory
+// repository too, payments change balances of its accounts.
+func NewPaymentRepository(accounts account.Repository, opts ...Option) payment.Repository {
+	o := newOptions(opts)
+	r := &paymentRepository{
 		payments: make(map[uuid.UUID]*payment.Payment),
-		accounts: accounts,
+		accounts: accounts.(*accountRepository),
+		outbox:   o.outbox,
+		journal:  o.journal,
+	}
+	r.accounts.payments = r
+	if r.journal != nil {
+		r.journal.payments = r
 	}
+	return r
 }
@@ -0,0 +1,121 @@
+package inmem_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+	"github.com/otetz/payments/repotest"
+	"github.com/otetz/payments/screening"
+	"github.com/shopspring/decimal"
+)
+
+func TestConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) (account.Repository, payment.Repository) {
+		accounts := inmem.NewAccountRepository()
+		return accounts, inmem.NewPaymentRepository(accounts)
+	})
+}
+
+func TestBulkConformance(t *testing.T) {
+	repotest.RunBulk(t, func(t *testing.T) (account.Repository, payment.Repository, bulk.Repository) {
+		accounts := inmem.NewAccountRepository()
+		payments := inmem.NewPaymentRepository(accounts)
+		return accounts, payments, inmem.NewBulkRepository(accounts, payments)
+	})
+}
+
+func TestLedgerConformance(t *testing.T) {
+	repotest.RunLedger(t, func(t *testing.T) (account.Repository, payment.Repository, bulk.Repository,
+		reconcile.Repository) {
+		accounts := inmem.NewAccountRepository()
+		payments := inmem.NewPaymentRepository(accounts)
+		return accounts, payments, inmem.NewBulkRepository(accounts, payments),
+			inmem.NewLedgerRepository(accounts, payments)
+	})
+}
+
+func TestInterestConformance(t *testing.T) {
+	repotest.RunInterest(t, func(t *testing.T) (account.Repository, payment.Repository, interest.Repository) {
+		accounts := inmem.NewAccountRepository()
+		payments := inmem.NewPaymentRepository(accounts)
+		return accounts, payments, inmem.NewInterestRepository(payments)
+	})
+}
+
+func TestApprovalConformance(t *testing.T) {
+	repotest.RunApprovals(t, func(t *testing.T) (account.Repository, payment.Repository, payment.ApprovalRepository) {
+		accounts := inmem.NewAccountRepository()
+		payments := inmem.NewPaymentRepository(accounts)
+		return accounts, payments, inmem.NewApprovalRepository(payments)
+	})
+}
+
+func TestScreeningConformance(t *testing.T) {
+	repotest.RunScreening(t, func(t *testing.T) screening.Repository {
+		return inmem.NewScreeningRepository()
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	outbox := inmem.NewOutbox(inmem.OutboxRetention(2))
+	accounts := inmem.NewAccountRepository(inmem.WithOutbox(outbox))
+	for _, id := range []account.ID{"alice", "bob", "carol", "dave"} {
+		if err := accounts.Store(ctx, &account.Account{ID: id, Balance: decimal.Zero, Currency: "USD"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, _ := outbox.Since(0, 10)
+	if len(events) != 4 || events[3].Sequence != events[0].Sequence+3 {
+		t.Fatalf("4 events with dense sequences expected, got %d", len(events))
+	}
+	first := events[0].Sequence
+
+	// Published events beyond retention are pruned, but not before not published one.
+	if err := outbox.MarkPublished(first, first+2, first+3); err != nil {
+		t.Fatal(err)
+	}
+	if oldest, _ := outbox.Oldest(); oldest != first {
+		t.Errorf("events must be kept, oldest is %d, want %d", oldest-first, 0)
+	}
+	if err := outbox.MarkPublished(first + 1); err != nil {
+		t.Fatal(err)
+	}
+	if oldest, _ := outbox.Oldest(); oldest != first+2 {
+		t.Errorf("published events beyond retention must be pruned, oldest is %d, want %d", oldest-first, 2)
+	}
+	if events, _ := outbox.Since(first-1, 10); len(events) != 2 || events[0].Sequence != first+2 {
+		t.Errorf("kept events expected, got %d", len(events))
+	}
+	if pending, _ := outbox.Pending(10); len(pending) != 0 {
+		t.Errorf("all events are published, got %d pending", len(pending))
+	}
+
+	// Without relay events are not pending, they are kept up to retention.
+	unrelayed := inmem.NewOutbox(inmem.OutboxRetention(2), inmem.OutboxWithoutRelay())
+	accounts = inmem.NewAccountRepository(inmem.WithOutbox(unrelayed))
+	for _, id := range []account.ID{"alice", "bob", "carol"} {
+		if err := accounts.Store(ctx, &account.Account{ID: id, Balance: decimal.Zero, Currency: "USD"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events, _ := unrelayed.Since(0, 10); len(events) != 2 || events[0].Account != "bob" {
+		t.Errorf("2 last events expected, got %d", len(events))
+	}
+	if pending, _ := unrelayed.Pending(10); len(pending) != 0 {
+		t.Errorf("no pending events expected without relay, got %d", len(pending))
+	}
+
+	// Events are not journaled, outbox after restart does not reuse sequences.
+	latest, _ := outbox.Latest()
+	restarted := inmem.NewOutbox()
+	if oldest, _ := restarted.Oldest(); oldest <= latest {
+		t.Errorf("sequence %d is reused after restart, latest was %d", oldest, latest)
+	}
+}
@@ -0,0 +1,169 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+)
+
+type interestRepository struct {
+	mtx      sync.RWMutex
+	plans    map[string]*interest.Plan
+	accruals map[account.ID]*interest.Accrual
+	payments *paymentRepository
+	journal  *Journal
+}
+
+// StorePlan saves a new plan.
+func (r *interestRepository) StorePlan(ctx context.Context, plan *interest.Plan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.plans[plan.ID]; ok {
+		return errs.ErrInterestPlanExists
+	}
+	c := *plan
+	if err := r.journal.append(&record{Op: opStorePlan, Plan: &c}); err != nil {
+		return err
+	}
+	r.plans[plan.ID] = &c
+	return nil
+}
+
+// FindPlan returns plan with specified id.
+func (r *interestRepository) FindPlan(ctx context.Context, id string) (*interest.Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if val, ok := r.plans[id]; ok {
+		c := *val
+		return &c, nil
+	}
+	return nil, errs.ErrUnknownInterestPlan
+}
+
+// FindPlans returns copies of all plans ordered by id.
+func (r *interestRepository) FindPlans(ctx context.Context) ([]*interest.Plan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*interest.Plan, 0, len(r.plans))
+	for _, val := range r.plans {
+		c := *val
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// Assign stores accrual of account, if account has none, or changes plan of the stored one.
+func (r *interestRepository) Assign(ctx context.Context, accrual *interest.Accrual) (*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	c := *accrual
+	if val, ok := r.accruals[accrual.Account]; ok {
+		c = *val
+		c.Plan = accrual.Plan
+	}
+	if err := r.journal.append(&record{Op: opAssignAccrual, Accrual: &c}); err != nil {
+		return nil, err
+	}
+	r.accruals[accrual.Account] = &c
+	result := c
+	return &result, nil
+}
+
+// FindAccrual returns accrual of account.
+func (r *interestRepository) FindAccrual(ctx context.Context, id account.ID) (*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if val, ok := r.accruals[id]; ok {
+		c := *val
+		return &c, nil
+	}
+	return nil, errs.ErrNoInterestPlan
+}
+
+// FindAccruals returns copies of accruals of all accounts ordered by account.
+func (r *interestRepository) FindAccruals(ctx context.Context) ([]*interest.Accrual, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*interest.Accrual, 0, len(r.accruals))
+	for _, val := range r.accruals {
+		c := *val
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Account < result[j].Account })
+	return result, nil
+}
+
+// Save stores accrued and posted interest together with payments of posting. Payments are stored under the lock of
+// the repository, so accrual is changed only if they are stored, and both are journaled as one change.
+func (r *interestRepository) Save(ctx context.Context, accrual *interest.Accrual, accruedThrough time.Time,
+	payments ...*payment.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	val, ok := r.accruals[accrual.Account]
+	if !ok {
+		return errs.ErrNoInterestPlan
+	}
+	if !val.AccruedThrough.Equal(accruedThrough) {
+		return errs.ErrAccrualChanged
+	}
+	c := *accrual
+	c.Plan = val.Plan
+	r.payments.mtx.Lock()
+	defer r.payments.mtx.Unlock()
+	if err := r.payments.save(&record{Op: opSaveAccrual, Accrual: &c, Payments: payments}, payments...); err != nil {
+		return err
+	}
+	r.accruals[accrual.Account] = &c
+	return nil
+}
+
+// NewInterestRepository returns a new instance of an in-memory interest repository. Payments must be an in-memory
+// repository too. With journal, it must be created before Restore, which loads its plans and accruals.
+func NewInterestRepository(payments payment.Repository, opts ...Option) interest.Repository {
+	o := newOptions(opts)
+	r := &interestRepository{
+		plans:    make(map[string]*interest.Plan),
+		accruals: make(map[account.ID]*interest.Accrual),
+		payments: payments.(*paymentRepository),
+		journal:  o.journal,
+	}
+	if r.journal != nil {
+		r.journal.interest = r
+	}
+	return r
+}
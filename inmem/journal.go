@@ -0,0 +1,535 @@
+package inmem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/screening"
+)
+
+const (
+	snapshotFile = "snapshot"
+	walFile      = "wal"
+)
+
+var errJournalClosed = errors.New("journal is closed")
+
+// op is a kind of change recorded in the write-ahead log.
+type op int
+
+const (
+	opStoreAccount op = iota + 1
+	opDeleteAccount
+	opStorePayments
+	opDeletePayment
+	opImportAccounts
+	opImportPayments
+	opUpdateAccount
+	opRestoreAccount
+	opPurgeAccounts
+	opTransition
+	opStorePlan
+	opAssignAccrual
+	opSaveAccrual
+	opStoreApproval
+	opDecideApproval
+	opStoreHits
+)
+
+// record is an entry of the write-ahead log.
+type record struct {
+	Seq        uint64
+	Op         op
+	Account    *account.Account
+	Accounts   []*account.Account
+	AccountID  account.ID
+	AccountIDs []account.ID
+	Payments   []*payment.Payment
+	PaymentID  uuid.UUID
+	Time       time.Time
+	// Transfer, Status and Reason describe transition of transfer payments at Time.
+	Transfer uuid.UUID
+	Status   payment.Status
+	Reason   string
+	// Plan and Accrual are interest state, accrual is saved together with Payments of its posting.
+	Plan    *interest.Plan
+	Accrual *interest.Accrual
+	// Approval is a pending transfer, decision on it is saved together with Payments of approved one.
+	Approval *payment.Approval
+	Hits     []*screening.Hit
+}
+
+// snapshot is a state of repositories after the change with Seq number.
+type snapshot struct {
+	Seq       uint64
+	TakenAt   time.Time
+	Accounts  []*account.Account
+	Payments  []*payment.Payment
+	Plans     []*interest.Plan
+	Accruals  []*interest.Accrual
+	Approvals []*payment.Approval
+	Hits      []*screening.Hit
+}
+
+// SnapshotInfo describes a taken snapshot.
+type SnapshotInfo struct {
+	Sequence uint64    `json:"sequence"`
+	TakenAt  time.Time `json:"taken_at"`
+	Accounts int       `json:"accounts"`
+	Payments int       `json:"payments"`
+}
+
+// Journal keeps accounts, payments, interest accruals, pending transfers and screening hits of in-memory repositories
+// in a directory: a snapshot and an append-only write-ahead log of changes made after it. Every change is written to the log before it is applied in memory.
+// Snapshot replaces the log, it is taken periodically, on Stop and on demand.
+//
+// Log is not synced to disk, so journal survives restarts and crashes of the process, but not of the host.
+// Domain events of the outbox and webhooks are not journaled.
+type Journal struct {
+	dir      string
+	logger   log.Logger
+	interval time.Duration
+
+	mtx    sync.Mutex
+	wal    *os.File
+	seq    uint64
+	closed bool
+
+	accounts  *accountRepository
+	payments  *paymentRepository
+	interest  *interestRepository
+	approvals *approvalRepository
+	screening *screeningRepository
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// JournalOption sets an optional parameter for journal.
+type JournalOption func(*Journal)
+
+// JournalInterval sets pause between periodic snapshots, zero disables them.
+func JournalInterval(d time.Duration) JournalOption {
+	return func(j *Journal) { j.interval = d }
+}
+
+// OpenJournal opens journal in the directory, creating it if necessary. Create repositories with WithJournal
+// option, then call Restore to load saved data into them.
+func OpenJournal(dir string, logger log.Logger, options ...JournalOption) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		dir:      dir,
+		logger:   logger,
+		interval: time.Minute,
+		quit:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(j)
+	}
+	return j, nil
+}
+
+// Restore loads the snapshot and replays the log after it, then opens the log for new changes. Incomplete record
+// at the end of the log, left by a crash in the middle of write, is discarded. Repositories must not be used
+// until Restore returns.
+func (j *Journal) Restore() error {
+	if j.accounts == nil || j.payments == nil {
+		return fmt.Errorf("journal: account and payment repositories must be created with WithJournal")
+	}
+
+	s := &snapshot{}
+	data, err := ioutil.ReadFile(filepath.Join(j.dir, snapshotFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(s); err != nil {
+			return fmt.Errorf("journal: snapshot: %s", err)
+		}
+	}
+	j.load(s)
+	j.seq = s.Seq
+
+	path := filepath.Join(j.dir, walFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	valid, err := j.replay(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("journal: %s: %s", path, err)
+	}
+	if err := f.Truncate(valid); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	j.mtx.Lock()
+	j.wal = f
+	j.mtx.Unlock()
+	return nil
+}
+
+// load replaces content of repositories with the snapshot.
+func (j *Journal) load(s *snapshot) {
+	if j.screening != nil {
+		j.screening.mtx.Lock()
+		defer j.screening.mtx.Unlock()
+		j.screening.hits = append(j.screening.hits, s.Hits...)
+	}
+	if j.interest != nil {
+		j.interest.mtx.Lock()
+		defer j.interest.mtx.Unlock()
+		for _, val := range s.Plans {
+			j.interest.plans[val.ID] = val
+		}
+		for _, val := range s.Accruals {
+			j.interest.accruals[val.Account] = val
+		}
+	}
+	if j.approvals != nil {
+		j.approvals.mtx.Lock()
+		defer j.approvals.mtx.Unlock()
+		for _, val := range s.Approvals {
+			j.approvals.store(val)
+		}
+	}
+	j.payments.mtx.Lock()
+	defer j.payments.mtx.Unlock()
+	j.accounts.mtx.Lock()
+	defer j.accounts.mtx.Unlock()
+
+	j.accounts.store(s.Accounts...)
+	j.payments.store(s.Payments...)
+}
+
+// replay applies records of the log, which are newer than the snapshot, and returns size of its valid part.
+func (j *Journal) replay(r io.Reader) (int64, error) {
+	var valid int64
+	br := bufio.NewReader(r)
+	for {
+		var size uint32
+		if err := binary.Read(br, binary.BigEndian, &size); err != nil {
+			return valid, nil
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return valid, nil
+		}
+		rec := &record{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(rec); err != nil {
+			return valid, nil
+		}
+		valid += 4 + int64(size)
+		if rec.Seq <= j.seq {
+			// Snapshot was taken, but the log was not truncated.
+			continue
+		}
+		if err := j.apply(rec); err != nil {
+			return 0, fmt.Errorf("record %d: %s", rec.Seq, err)
+		}
+		j.seq = rec.Seq
+	}
+}
+
+// apply makes the change of record in repositories without journaling.
+func (j *Journal) apply(rec *record) error {
+	switch rec.Op {
+	case opStoreAccount, opUpdateAccount:
+		j.accounts.mtx.Lock()
+		defer j.accounts.mtx.Unlock()
+		j.accounts.store(rec.Account)
+	case opDeleteAccount:
+		j.accounts.mtx.Lock()
+		defer j.accounts.mtx.Unlock()
+		a, ok := j.accounts.accounts[rec.AccountID]
+		if !ok {
+			return errs.ErrUnknownAccount
+		}
+		a.Deleted = true
+		if !rec.Time.IsZero() {
+			t := rec.Time
+			a.DeletedAt = &t
+		}
+	case opRestoreAccount:
+		j.accounts.mtx.Lock()
+		defer j.accounts.mtx.Unlock()
+		a, ok := j.accounts.accounts[rec.AccountID]
+		if !ok {
+			return errs.ErrUnknownAccount
+		}
+		a.Deleted, a.DeletedAt = false, nil
+	case opPurgeAccounts:
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		j.accounts.mtx.Lock()
+		defer j.accounts.mtx.Unlock()
+		j.accounts.purge(rec.AccountIDs...)
+	case opStorePayments:
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		if _, err := j.accounts.apply(rec, false, rec.Payments...); err != nil {
+			return err
+		}
+		j.payments.store(rec.Payments...)
+	case opTransition:
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		if _, _, err := j.payments.transition(rec.Transfer, rec.Status, rec.Reason, rec.Time); err != nil {
+			return err
+		}
+	case opImportAccounts:
+		j.accounts.mtx.Lock()
+		defer j.accounts.mtx.Unlock()
+		j.accounts.store(rec.Accounts...)
+	case opImportPayments:
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		j.accounts.mtx.Lock()
+		defer j.accounts.mtx.Unlock()
+		j.payments.store(rec.Payments...)
+		j.accounts.history(rec.Payments...)
+	case opStorePlan, opAssignAccrual, opSaveAccrual:
+		return j.applyInterest(rec)
+	case opStoreApproval, opDecideApproval:
+		return j.applyApproval(rec)
+	case opStoreHits:
+		if j.screening == nil {
+			return fmt.Errorf("screening repository must be created with WithJournal")
+		}
+		j.screening.mtx.Lock()
+		defer j.screening.mtx.Unlock()
+		j.screening.hits = append(j.screening.hits, rec.Hits...)
+	case opDeletePayment:
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		p, ok := j.payments.payments[rec.PaymentID]
+		if !ok {
+			return errs.ErrUnknownPayment
+		}
+		p.Deleted = true
+	default:
+		return fmt.Errorf("unknown operation %d", rec.Op)
+	}
+	return nil
+}
+
+// applyInterest makes the change of interest record without journaling.
+func (j *Journal) applyInterest(rec *record) error {
+	if j.interest == nil {
+		return fmt.Errorf("interest repository must be created with WithJournal")
+	}
+	j.interest.mtx.Lock()
+	defer j.interest.mtx.Unlock()
+
+	switch rec.Op {
+	case opStorePlan:
+		j.interest.plans[rec.Plan.ID] = rec.Plan
+	case opAssignAccrual:
+		j.interest.accruals[rec.Accrual.Account] = rec.Accrual
+	case opSaveAccrual:
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		if _, err := j.accounts.apply(rec, false, rec.Payments...); err != nil {
+			return err
+		}
+		j.payments.store(rec.Payments...)
+		j.interest.accruals[rec.Accrual.Account] = rec.Accrual
+	}
+	return nil
+}
+
+// applyApproval makes the change of pending transfer record without journaling.
+func (j *Journal) applyApproval(rec *record) error {
+	if j.approvals == nil {
+		return fmt.Errorf("approval repository must be created with WithJournal")
+	}
+	j.approvals.mtx.Lock()
+	defer j.approvals.mtx.Unlock()
+
+	if rec.Op == opDecideApproval && len(rec.Payments) > 0 {
+		j.payments.mtx.Lock()
+		defer j.payments.mtx.Unlock()
+		if _, err := j.accounts.apply(rec, false, rec.Payments...); err != nil {
+			return err
+		}
+		j.payments.store(rec.Payments...)
+	}
+	j.approvals.store(rec.Approval)
+	return nil
+}
+
+// append writes record to the log, numbering it. Caller holds the lock of changed repository, so records are
+// written in the order of changes. It does nothing, if journal is not used or is not restored yet.
+func (j *Journal) append(rec *record) error {
+	if j == nil {
+		return nil
+	}
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.closed {
+		return errJournalClosed
+	}
+	if j.wal == nil {
+		// Replay of the log is in progress.
+		return nil
+	}
+	rec.Seq = j.seq + 1
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if err := gob.NewEncoder(&buf).Encode(rec); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	if _, err := j.wal.Write(data); err != nil {
+		return err
+	}
+	j.seq = rec.Seq
+	return nil
+}
+
+// Snapshot writes state of repositories to the snapshot file and truncates the log. Repositories are read-locked
+// while snapshot is written, so it is consistent: changes wait, readers do not.
+func (j *Journal) Snapshot() (*SnapshotInfo, error) {
+	// Lock order is the same as in interest Save, approval Decide and payment Store: interest or approvals,
+	// payments, then accounts.
+	if j.screening != nil {
+		j.screening.mtx.RLock()
+		defer j.screening.mtx.RUnlock()
+	}
+	if j.interest != nil {
+		j.interest.mtx.RLock()
+		defer j.interest.mtx.RUnlock()
+	}
+	if j.approvals != nil {
+		j.approvals.mtx.RLock()
+		defer j.approvals.mtx.RUnlock()
+	}
+	j.payments.mtx.RLock()
+	defer j.payments.mtx.RUnlock()
+	j.accounts.mtx.RLock()
+	defer j.accounts.mtx.RUnlock()
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+
+	if j.wal == nil {
+		return nil, fmt.Errorf("journal: is not restored")
+	}
+	s := &snapshot{
+		Seq:      j.seq,
+		TakenAt:  time.Now().UTC(),
+		Accounts: make([]*account.Account, 0, len(j.accounts.accounts)),
+		Payments: make([]*payment.Payment, 0, len(j.payments.order)),
+	}
+	for _, a := range j.accounts.accounts {
+		s.Accounts = append(s.Accounts, a)
+	}
+	for _, id := range j.payments.order {
+		s.Payments = append(s.Payments, j.payments.payments[id])
+	}
+	if j.interest != nil {
+		for _, val := range j.interest.plans {
+			s.Plans = append(s.Plans, val)
+		}
+		for _, val := range j.interest.accruals {
+			s.Accruals = append(s.Accruals, val)
+		}
+	}
+	if j.approvals != nil {
+		for _, id := range j.approvals.order {
+			s.Approvals = append(s.Approvals, j.approvals.approvals[id])
+		}
+	}
+	if j.screening != nil {
+		s.Hits = j.screening.hits
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return nil, err
+	}
+	// Snapshot is replaced atomically, crash leaves either old or new one.
+	tmp := filepath.Join(j.dir, snapshotFile+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(j.dir, snapshotFile)); err != nil {
+		return nil, err
+	}
+	if err := j.wal.Truncate(0); err != nil {
+		return nil, err
+	}
+	if _, err := j.wal.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &SnapshotInfo{Sequence: s.Seq, TakenAt: s.TakenAt, Accounts: len(s.Accounts), Payments: len(s.Payments)},
+		nil
+}
+
+// Start runs periodic snapshots in background.
+func (j *Journal) Start() {
+	if j.interval <= 0 {
+		return
+	}
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-j.quit:
+				return
+			case <-ticker.C:
+				if _, err := j.Snapshot(); err != nil {
+					_ = j.logger.Log("method", "snapshot", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop terminates periodic snapshots, takes the final one and closes the log.
+func (j *Journal) Stop() error {
+	j.once.Do(func() { close(j.quit) })
+	j.wg.Wait()
+
+	_, err := j.Snapshot()
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	if j.wal != nil {
+		if e := j.wal.Close(); err == nil {
+			err = e
+		}
+		j.wal = nil
+	}
+	j.closed = true
+	return err
+}
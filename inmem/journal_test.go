@@ -0,0 +1,304 @@
+package inmem_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/repotest"
+	"github.com/otetz/payments/screening"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// open returns restored repositories of the journal in dir.
+func open(t *testing.T, dir string) (*inmem.Journal, account.Repository, payment.Repository) {
+	t.Helper()
+	journal, err := inmem.OpenJournal(dir, log.NewNopLogger(), inmem.JournalInterval(0))
+	OK(t, err)
+	accounts := inmem.NewAccountRepository(inmem.WithJournal(journal))
+	payments := inmem.NewPaymentRepository(accounts, inmem.WithJournal(journal))
+	OK(t, journal.Restore())
+	return journal, accounts, payments
+}
+
+func checkBalances(t *testing.T, accounts account.Repository, balances map[account.ID]float64) {
+	t.Helper()
+	for id, balance := range balances {
+		a, err := accounts.Find(context.Background(), id)
+		OK(t, err)
+		if !a.Balance.Equal(decimal.NewFromFloat(balance)) {
+			t.Errorf("%s: wrong balance %s", id, a.Balance)
+		}
+	}
+}
+
+func TestJournalConformance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmem")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var journals []*inmem.Journal
+	defer func() {
+		for _, j := range journals {
+			_ = j.Stop()
+		}
+	}()
+	repotest.Run(t, func(t *testing.T) (account.Repository, payment.Repository) {
+		journal, accounts, payments := open(t, filepath.Join(dir, fmt.Sprint(len(journals))))
+		journals = append(journals, journal)
+		return accounts, payments
+	})
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmem")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	ctx := context.Background()
+
+	journal, accounts, payments := open(t, dir)
+	ps := payment.NewService(payments, accounts)
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Currency: "USD"}))
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(4), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := journal.Snapshot()
+	OK(t, err)
+	// Transfer is journaled, when it is stored as pending and when it is completed.
+	if info.Sequence != 5 || info.Accounts != 3 || info.Payments != 2 {
+		t.Errorf("wrong snapshot: %+v", info)
+	}
+	if _, err := ps.New(ctx, "bob", decimal.NewFromFloat(1.5), "carol", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	OK(t, accounts.MarkDeleted(ctx, "carol"))
+	_, err = inmem.NewBulkRepository(accounts, payments).ImportAccounts(ctx,
+		[]*account.Account{{ID: "erin", Balance: decimal.NewFromFloat(3), Currency: "USD"}}, false)
+	OK(t, err)
+	_, err = accounts.Update(ctx, "alice", func(a *account.Account) error {
+		a.Name = "Alice"
+		return nil
+	})
+	OK(t, err)
+
+	// Process crashes: no final snapshot, changes after the first one are in the log only.
+	_, accounts, payments = open(t, dir)
+	checkBalances(t, accounts, map[account.ID]float64{"alice": 6, "bob": 2.5, "erin": 3})
+	if _, err := accounts.Find(ctx, "carol"); err != errs.ErrUnknownAccount {
+		t.Errorf("deleted account must be unknown, got %v", err)
+	}
+	if pp, _ := payments.FindAll(ctx); len(pp) != 4 {
+		t.Errorf("4 payments expected, got %d", len(pp))
+	}
+	if a, _ := accounts.Find(ctx, "alice"); a == nil || a.Name != "Alice" || a.Version != 1 {
+		t.Errorf("update must be replayed, got %+v", a)
+	}
+
+	// Incomplete record at the end of the log is discarded.
+	wal, err := os.OpenFile(filepath.Join(dir, "wal"), os.O_APPEND|os.O_WRONLY, 0600)
+	OK(t, err)
+	_, err = wal.Write([]byte{0, 0, 1, 0, 42})
+	OK(t, err)
+	OK(t, wal.Close())
+
+	journal, accounts, payments = open(t, dir)
+	checkBalances(t, accounts, map[account.ID]float64{"alice": 6, "bob": 2.5})
+	ps = payment.NewService(payments, accounts)
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(1), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	OK(t, journal.Stop())
+	if err := accounts.Store(ctx, &account.Account{ID: "dave", Currency: "USD"}); err == nil {
+		t.Error("changes must fail after stop")
+	}
+
+	// Stop takes the final snapshot and empties the log.
+	if fi, err := os.Stat(filepath.Join(dir, "wal")); err != nil || fi.Size() != 0 {
+		t.Errorf("empty log expected, got %v %v", fi, err)
+	}
+	journal, accounts, _ = open(t, dir)
+	defer func() { _ = journal.Stop() }()
+	checkBalances(t, accounts, map[account.ID]float64{"alice": 5, "bob": 3.5})
+	if _, err := accounts.Find(ctx, "dave"); err != errs.ErrUnknownAccount {
+		t.Errorf("account stored after stop must be unknown, got %v", err)
+	}
+}
+
+func TestJournalRestoreAndPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmem")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	ctx := context.Background()
+
+	_, accounts, payments := open(t, dir)
+	ps := payment.NewService(payments, accounts)
+	for _, id := range []account.ID{"alice", "bob", "carol", "dave"} {
+		OK(t, accounts.Store(ctx, &account.Account{ID: id, Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	}
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(4), "carol", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []account.ID{"bob", "carol"} {
+		OK(t, accounts.MarkDeleted(ctx, id))
+	}
+	_, err = accounts.Restore(ctx, "bob")
+	OK(t, err)
+	purged, err := accounts.Purge(ctx, time.Now().Add(time.Minute))
+	OK(t, err)
+	if len(purged) != 1 || purged[0] != "carol" {
+		t.Errorf("carol expected to be purged, got %v", purged)
+	}
+	OK(t, accounts.MarkDeleted(ctx, "dave"))
+
+	// Process crashes, changes are replayed from the log.
+	_, accounts, payments = open(t, dir)
+	checkBalances(t, accounts, map[account.ID]float64{"alice": 6, "bob": 10})
+	deleted, err := accounts.FindDeleted(ctx)
+	OK(t, err)
+	if len(deleted) != 1 || deleted[0].ID != "dave" || deleted[0].DeletedAt == nil {
+		t.Errorf("deleted dave with time of deletion expected, got %+v", deleted)
+	}
+	if pp, _ := payments.FindAll(ctx); len(pp) != 1 || pp[0].Account != "alice" {
+		t.Errorf("outgoing payment of alice expected, got %+v", pp)
+	}
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Currency: "USD"}))
+}
+
+func TestJournalInterest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmem")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	ctx := context.Background()
+
+	open := func() (*inmem.Journal, account.Repository, interest.Repository) {
+		journal, err := inmem.OpenJournal(dir, log.NewNopLogger(), inmem.JournalInterval(0))
+		OK(t, err)
+		accounts := inmem.NewAccountRepository(inmem.WithJournal(journal))
+		payments := inmem.NewPaymentRepository(accounts, inmem.WithJournal(journal))
+		interests := inmem.NewInterestRepository(payments, inmem.WithJournal(journal))
+		OK(t, journal.Restore())
+		return journal, accounts, interests
+	}
+	check := func(accounts account.Repository, interests interest.Repository) {
+		t.Helper()
+		checkBalances(t, accounts, map[account.ID]float64{"bank": 7, "alice": 3})
+		if _, err := interests.FindPlan(ctx, "savings"); err != nil {
+			t.Errorf("plan must be restored, got %v", err)
+		}
+		a, err := interests.FindAccrual(ctx, "alice")
+		OK(t, err)
+		if !a.AccruedThrough.Equal(time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC)) ||
+			!a.Accrued.Equal(decimal.NewFromFloat(0.5)) {
+			t.Errorf("saved accrual must be restored, got %+v", a)
+		}
+	}
+
+	_, accounts, interests := open()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bank", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Currency: "USD"}))
+	OK(t, interests.StorePlan(ctx, &interest.Plan{ID: "savings", Rate: decimal.NewFromFloat(1)}))
+	prev := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	_, err = interests.Assign(ctx, &interest.Accrual{Account: "alice", Plan: "savings", AccruedThrough: prev})
+	OK(t, err)
+	pp := []*payment.Payment{
+		{ID: uuid.New(), Account: "bank", Amount: decimal.NewFromFloat(3), ToAccount: "alice",
+			Direction: payment.Outgoing},
+		{ID: uuid.New(), Account: "alice", Amount: decimal.NewFromFloat(3), FromAccount: "bank",
+			Direction: payment.Incoming},
+	}
+	day := time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC)
+	OK(t, interests.Save(ctx, &interest.Accrual{Account: "alice", Accrued: decimal.NewFromFloat(0.5),
+		AccruedThrough: day, PostedThrough: day}, prev, pp...))
+
+	// Process crashes, posting and accrual are replayed from the log together.
+	journal, accounts, interests := open()
+	check(accounts, interests)
+	OK(t, journal.Stop())
+
+	journal, accounts, interests = open()
+	defer func() { _ = journal.Stop() }()
+	check(accounts, interests)
+}
+
+func TestJournalApprovalsAndScreening(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inmem")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	ctx := context.Background()
+
+	open := func() (*inmem.Journal, account.Repository, payment.ApprovalRepository, screening.Repository) {
+		journal, err := inmem.OpenJournal(dir, log.NewNopLogger(), inmem.JournalInterval(0))
+		OK(t, err)
+		accounts := inmem.NewAccountRepository(inmem.WithJournal(journal))
+		payments := inmem.NewPaymentRepository(accounts, inmem.WithJournal(journal))
+		approvals := inmem.NewApprovalRepository(payments, inmem.WithJournal(journal))
+		hits := inmem.NewScreeningRepository(inmem.WithJournal(journal))
+		OK(t, journal.Restore())
+		return journal, accounts, approvals, hits
+	}
+	newApproval := func(amount float64) *payment.Approval {
+		return &payment.Approval{ID: uuid.New(), FromAccount: "alice", Amount: decimal.NewFromFloat(amount),
+			ToAccount: "bob", Status: payment.ApprovalPending, RequestedBy: "maker"}
+	}
+	approved, pending := newApproval(3), newApproval(5)
+	check := func(accounts account.Repository, approvals payment.ApprovalRepository, hits screening.Repository) {
+		t.Helper()
+		checkBalances(t, accounts, map[account.ID]float64{"alice": 7, "bob": 3})
+		found, err := approvals.FindApprovals(ctx)
+		OK(t, err)
+		if len(found) != 2 || found[0].ID != approved.ID || found[0].Status != payment.ApprovalApproved ||
+			found[1].ID != pending.ID || found[1].Status != payment.ApprovalPending {
+			t.Errorf("transfers must be restored in order, got %+v", found)
+		}
+		found2, err := hits.FindHits(ctx, "")
+		OK(t, err)
+		if len(found2) != 2 || found2[0].Sequence != 1 || found2[1].Sequence != 2 || found2[1].Name != "Jon Doe" {
+			t.Errorf("hits must be restored in order, got %+v", found2)
+		}
+	}
+
+	_, accounts, approvals, hits := open()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+	OK(t, approvals.StoreApproval(ctx, approved))
+	OK(t, approvals.StoreApproval(ctx, pending))
+	approved.Status, approved.DecidedBy = payment.ApprovalApproved, "checker"
+	OK(t, approvals.Decide(ctx, approved, []*payment.Payment{
+		{ID: uuid.New(), Account: "alice", Amount: decimal.NewFromFloat(3), ToAccount: "bob",
+			Direction: payment.Outgoing},
+		{ID: uuid.New(), Account: "bob", Amount: decimal.NewFromFloat(3), FromAccount: "alice",
+			Direction: payment.Incoming},
+	}...))
+	OK(t, hits.StoreHits(ctx, &screening.Hit{ID: uuid.New(), Account: "bob", Name: "John Doe"}))
+	OK(t, hits.StoreHits(ctx, &screening.Hit{ID: uuid.New(), Account: "alice", Name: "Jon Doe"}))
+
+	// Process crashes, decision and payments of approved transfer are replayed from the log together.
+	journal, accounts, approvals, hits := open()
+	check(accounts, approvals, hits)
+	OK(t, journal.Stop())
+
+	journal, accounts, approvals, hits = open()
+	defer func() { _ = journal.Stop() }()
+	check(accounts, approvals, hits)
+}
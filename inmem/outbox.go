@@ -0,0 +1,182 @@
+package inmem
+
+import (
+	"sync"
+	"time"
+
+	"github.com/otetz/payments/event"
+)
+
+// Outbox is an in-memory storage of domain events, filled by repositories created with WithOutbox option.
+//
+// Events are not journaled, so sequence of a new outbox starts after the current time in microseconds: sequences
+// are not reused after restart, and replay from sequence of previous run is detected by Oldest. Published events
+// are kept for replay up to retention, older ones are pruned. Outbox without relay publishes nothing, so its events
+// are pruned beyond retention when they are appended.
+type Outbox struct {
+	mtx       sync.Mutex
+	sequence  int64
+	retention int
+	relayed   bool
+	events    []*event.Event
+	// first is sequence of events[0], sequences of kept events are dense.
+	first int64
+	// pending is index of the first not published event, events before it are published.
+	pending int
+}
+
+// OutboxOption sets an optional parameter for outbox.
+type OutboxOption func(*Outbox)
+
+// OutboxRetention sets number of published events, which are kept for replay.
+func OutboxRetention(n int) OutboxOption {
+	return func(o *Outbox) { o.retention = n }
+}
+
+// OutboxWithoutRelay makes events not pending, as no relay publishes them, they are kept for replay only.
+func OutboxWithoutRelay() OutboxOption {
+	return func(o *Outbox) { o.relayed = false }
+}
+
+// NewOutbox returns a new instance of an in-memory outbox.
+func NewOutbox(options ...OutboxOption) *Outbox {
+	o := &Outbox{
+		sequence:  time.Now().UnixNano() / int64(time.Microsecond),
+		retention: 10000,
+		relayed:   true,
+	}
+	for _, option := range options {
+		option(o)
+	}
+	o.first = o.sequence + 1
+	return o
+}
+
+func (o *Outbox) append(events ...*event.Event) {
+	if o == nil {
+		return
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	for _, val := range events {
+		o.sequence++
+		val.Sequence = o.sequence
+		o.events = append(o.events, val)
+	}
+	if !o.relayed {
+		o.pending = len(o.events)
+		o.prune()
+	}
+}
+
+// Pending returns up to limit not published events, ordered by sequence.
+func (o *Outbox) Pending(limit int) ([]*event.Event, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	result := make([]*event.Event, 0, limit)
+	for _, val := range o.events[o.pending:] {
+		if len(result) == limit {
+			break
+		}
+		if val.PublishedAt.IsZero() {
+			c := *val
+			result = append(result, &c)
+		}
+	}
+	return result, nil
+}
+
+// MarkPublished marks events with specified sequence numbers as published. Published events beyond retention are
+// pruned.
+func (o *Outbox) MarkPublished(sequences ...int64) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	now := time.Now().UTC()
+	for _, seq := range sequences {
+		if idx := seq - o.first; idx >= 0 && idx < int64(len(o.events)) {
+			o.events[idx].PublishedAt = now
+		}
+	}
+	for o.pending < len(o.events) && !o.events[o.pending].PublishedAt.IsZero() {
+		o.pending++
+	}
+	o.prune()
+	return nil
+}
+
+// prune removes events, which are not pending, beyond retention.
+func (o *Outbox) prune() {
+	if n := o.pending - o.retention; n > 0 {
+		// Pruned events are released to garbage collector, slice is copied when it grows.
+		for i := 0; i < n; i++ {
+			o.events[i] = nil
+		}
+		o.events = o.events[n:]
+		o.first += int64(n)
+		o.pending -= n
+	}
+}
+
+// Since returns up to limit events with sequence greater than specified, published or not, ordered by sequence.
+// Pruned events are skipped.
+func (o *Outbox) Since(sequence int64, limit int) ([]*event.Event, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	idx := sequence + 1 - o.first
+	if idx < 0 {
+		idx = 0
+	}
+	result := make([]*event.Event, 0, limit)
+	for ; idx < int64(len(o.events)) && len(result) < limit; idx++ {
+		c := *o.events[idx]
+		result = append(result, &c)
+	}
+	return result, nil
+}
+
+// Latest returns sequence of the last event in the outbox, events appended later follow it.
+func (o *Outbox) Latest() (int64, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	return o.sequence, nil
+}
+
+// Oldest returns sequence of the first event kept in the outbox, or sequence of the next event if it is empty.
+func (o *Outbox) Oldest() (int64, error) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	return o.first, nil
+}
+
+// Option sets an optional parameter for in-memory repositories.
+type Option func(*options)
+
+type options struct {
+	outbox  *Outbox
+	journal *Journal
+}
+
+// WithOutbox makes repository record domain events to the outbox, under the same lock as the data changes.
+func WithOutbox(o *Outbox) Option {
+	return func(opts *options) { opts.outbox = o }
+}
+
+// WithJournal makes repository write changes to the journal, which restores them after restart. Both account and
+// payment repositories must be created with the same journal, interest repository may be too.
+func WithJournal(j *Journal) Option {
+	return func(opts *options) { opts.journal = j }
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, option := range opts {
+		option(o)
+	}
+	return o
+}
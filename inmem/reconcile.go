@@ -0,0 +1,46 @@
+package inmem
+
+import (
+	"context"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+)
+
+type ledgerRepository struct {
+	accounts *accountRepository
+	payments *paymentRepository
+}
+
+// Ledger returns copies of all accounts and payments, taken under locks of both repositories.
+func (r *ledgerRepository) Ledger(ctx context.Context) ([]*account.Account, []*payment.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	r.payments.mtx.RLock()
+	defer r.payments.mtx.RUnlock()
+	r.accounts.mtx.RLock()
+	defer r.accounts.mtx.RUnlock()
+
+	accounts := make([]*account.Account, 0, len(r.accounts.accounts))
+	for _, val := range r.accounts.accounts {
+		c := *val
+		accounts = append(accounts, &c)
+	}
+	payments := make([]*payment.Payment, 0, len(r.payments.order))
+	for _, pid := range r.payments.order {
+		c := *r.payments.payments[pid]
+		payments = append(payments, &c)
+	}
+	return accounts, payments, nil
+}
+
+// NewLedgerRepository returns a new instance of an in-memory ledger repository, which reads in-memory
+// repositories.
+func NewLedgerRepository(accounts account.Repository, payments payment.Repository) reconcile.Repository {
+	return &ledgerRepository{
+		accounts: accounts.(*accountRepository),
+		payments: payments.(*paymentRepository),
+	}
+}
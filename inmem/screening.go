@@ -0,0 +1,68 @@
+package inmem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/screening"
+)
+
+type screeningRepository struct {
+	mtx     sync.RWMutex
+	hits    []*screening.Hit
+	journal *Journal
+}
+
+// StoreHits saves new hits.
+func (r *screeningRepository) StoreHits(ctx context.Context, hits ...*screening.Hit) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(hits) == 0 {
+		return nil
+	}
+	stored := make([]*screening.Hit, 0, len(hits))
+	for i, val := range hits {
+		c := *val
+		c.Sequence = int64(len(r.hits) + i + 1)
+		stored = append(stored, &c)
+	}
+	if err := r.journal.append(&record{Op: opStoreHits, Hits: stored}); err != nil {
+		return err
+	}
+	r.hits = append(r.hits, stored...)
+	return nil
+}
+
+// FindHits returns copies of hits of account, or of all accounts if id is empty, in order of their storing.
+func (r *screeningRepository) FindHits(ctx context.Context, id account.ID) ([]*screening.Hit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*screening.Hit, 0)
+	for _, val := range r.hits {
+		if id == "" || val.Account == id {
+			c := *val
+			result = append(result, &c)
+		}
+	}
+	return result, nil
+}
+
+// NewScreeningRepository returns a new instance of an in-memory screening repository. With journal, it must be
+// created before Restore, which loads its hits.
+func NewScreeningRepository(opts ...Option) screening.Repository {
+	o := newOptions(opts)
+	r := &screeningRepository{journal: o.journal}
+	if r.journal != nil {
+		r.journal.screening = r
+	}
+	return r
+}
@@ -0,0 +1,119 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/webhook"
+)
+
+type webhookRepository struct {
+	mtx           sync.RWMutex
+	subscriptions map[uuid.UUID]*webhook.Subscription
+	deliveries    map[uuid.UUID]*webhook.Delivery
+}
+
+// StoreSubscription saves subscription in the repository.
+func (r *webhookRepository) StoreSubscription(ctx context.Context, s *webhook.Subscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.subscriptions[s.ID] = s
+	return nil
+}
+
+// FindSubscription returns active subscription with specified id.
+func (r *webhookRepository) FindSubscription(ctx context.Context, id uuid.UUID) (*webhook.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if val, ok := r.subscriptions[id]; ok {
+		if !val.Deleted {
+			return val, nil
+		}
+	}
+	return nil, errs.ErrUnknownSubscription
+}
+
+// FindAllSubscriptions returns all active subscriptions.
+func (r *webhookRepository) FindAllSubscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*webhook.Subscription, 0, len(r.subscriptions))
+	for _, val := range r.subscriptions {
+		if !val.Deleted {
+			result = append(result, val)
+		}
+	}
+	return result, nil
+}
+
+// MarkSubscriptionDeleted is mark as deleted specified subscription.
+func (r *webhookRepository) MarkSubscriptionDeleted(ctx context.Context, id uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if val, ok := r.subscriptions[id]; ok && !val.Deleted {
+		val.Deleted = true
+		return nil
+	}
+	return errs.ErrUnknownSubscription
+}
+
+// StoreDelivery inserts a new delivery or updates existing one.
+// Delivery is copied, because dispatcher keeps changing it while retrying.
+func (r *webhookRepository) StoreDelivery(ctx context.Context, d *webhook.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	c := *d
+	r.deliveries[d.ID] = &c
+	return nil
+}
+
+// FindDeliveries returns delivery history of the subscription, oldest first.
+func (r *webhookRepository) FindDeliveries(ctx context.Context, subscription uuid.UUID) ([]*webhook.Delivery,
+	error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	result := make([]*webhook.Delivery, 0)
+	for _, val := range r.deliveries {
+		if val.Subscription == subscription {
+			c := *val
+			result = append(result, &c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result, nil
+}
+
+// NewWebhookRepository returns a new instance of an in-memory webhook repository.
+func NewWebhookRepository() webhook.Repository {
+	return &webhookRepository{
+		subscriptions: make(map[uuid.UUID]*webhook.Subscription),
+		deliveries:    make(map[uuid.UUID]*webhook.Delivery),
+	}
+}
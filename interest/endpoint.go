@@ -0,0 +1,77 @@
+package interest
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/otetz/payments/account"
+	"github.com/shopspring/decimal"
+)
+
+type newPlanRequest struct {
+	ID          string          `json:"id" valid:"alphanum,required,stringlength(1|255)"`
+	Rate        decimal.Decimal `json:"rate" valid:"decimal,required"`
+	DayCount    DayCount        `json:"day_count,omitempty" valid:"in(actual/365|actual/360|30/360),optional"`
+	Compounding Compounding     `json:"compounding,omitempty" valid:"in(monthly|daily),optional"`
+}
+
+type planResponse struct {
+	*Plan
+	Err error `json:"error,omitempty"`
+}
+
+func (r planResponse) ErrError() error { return r.Err }
+
+func makeNewPlanEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(newPlanRequest)
+		p, err := s.NewPlan(ctx, Plan{ID: req.ID, Rate: req.Rate, DayCount: req.DayCount, Compounding: req.Compounding})
+		return planResponse{Plan: p, Err: err}, nil
+	}
+}
+
+type plansResponse struct {
+	Plans []*Plan `json:"plans"`
+	Err   error   `json:"error,omitempty"`
+}
+
+func (r plansResponse) ErrError() error { return r.Err }
+
+func makePlansEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		r, err := s.Plans(ctx)
+		return plansResponse{Plans: r, Err: err}, nil
+	}
+}
+
+type assignRequest struct {
+	Account account.ID `json:"-"`
+	Plan    string     `json:"plan" valid:"alphanum,required,stringlength(1|255)"`
+}
+
+type accrualResponse struct {
+	*Accrual
+	Err error `json:"error,omitempty"`
+}
+
+func (r accrualResponse) ErrError() error { return r.Err }
+
+func makeAssignEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(assignRequest)
+		a, err := s.Assign(ctx, req.Account, req.Plan)
+		return accrualResponse{Accrual: a, Err: err}, nil
+	}
+}
+
+type accountField struct {
+	Account account.ID
+}
+
+func makeAccrualEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountField)
+		a, err := s.Accrual(ctx, req.Account)
+		return accrualResponse{Accrual: a, Err: err}, nil
+	}
+}
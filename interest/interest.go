@@ -0,0 +1,229 @@
+// Package interest provides interest plans of accounts, daily accrual of interest and its monthly posting.
+package interest
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// DayCount is a convention of counting days of interest period, it sets a fraction of annual rate for every day.
+type DayCount string
+
+const (
+	// Actual365 gives every day 1/365 of annual rate.
+	Actual365 DayCount = "actual/365"
+	// Actual360 gives every day 1/360 of annual rate.
+	Actual360 DayCount = "actual/360"
+	// Thirty360 counts every month as 30 days of 360: the 31st day earns nothing, the last day of February earns
+	// the missing days of the month.
+	Thirty360 DayCount = "30/360"
+)
+
+// Compounding is a frequency of adding of interest to the base of the next interest.
+type Compounding string
+
+const (
+	// CompoundMonthly adds interest to the base, when it is posted to account.
+	CompoundMonthly Compounding = "monthly"
+	// CompoundDaily adds interest to the base every day, accrued and not posted interest earns too.
+	CompoundDaily Compounding = "daily"
+)
+
+// AccrualScale is a number of decimal places of accrued interest, amounts are rounded to scale of currency when
+// they are posted.
+const AccrualScale = 10
+
+// Plan is a set of interest terms, which accounts earn by.
+type Plan struct {
+	TableName struct{} `json:"-" sql:"interest_plans"`
+	ID        string   `json:"id" sql:"id,pk,type:varchar(255)"`
+	// Rate is an annual interest rate in percents.
+	Rate        decimal.Decimal `json:"rate" sql:"rate,notnull,type:'decimal(8,4)'"`
+	DayCount    DayCount        `json:"day_count" sql:"day_count,notnull,type:varchar(16)"`
+	Compounding Compounding     `json:"compounding" sql:"compounding,notnull,type:varchar(16)"`
+	CreatedAt   time.Time       `json:"created_at" sql:"created_at,notnull"`
+}
+
+// days returns number of days, which day counts for by convention.
+func (c DayCount) days(day time.Time) int64 {
+	if c != Thirty360 {
+		return 1
+	}
+	switch {
+	case day.Day() == 31:
+		return 0
+	case lastDay(day) && day.Day() < 30:
+		return int64(30 - day.Day() + 1)
+	}
+	return 1
+}
+
+// basis returns number of days in year by convention.
+func (c DayCount) basis() int64 {
+	if c == Actual365 {
+		return 365
+	}
+	return 360
+}
+
+// Daily returns interest of one day on base, with AccrualScale decimal places. Zero and negative bases earn nothing.
+func (p *Plan) Daily(base decimal.Decimal, day time.Time) decimal.Decimal {
+	if base.Sign() <= 0 {
+		return decimal.Zero
+	}
+	return base.Mul(p.Rate).Mul(decimal.New(p.DayCount.days(day), 0)).
+		Div(decimal.New(100*p.DayCount.basis(), 0)).Truncate(AccrualScale)
+}
+
+// Accrual is a state of interest of an account. Interest is accrued for every day after AccruedThrough and before
+// today, and is posted after the last day of every month.
+type Accrual struct {
+	TableName struct{}   `json:"-" sql:"interest_accruals"`
+	Account   account.ID `json:"account" sql:"account,pk,type:varchar(255)"`
+	Plan      string     `json:"plan" sql:"plan,notnull,type:varchar(255)"`
+	// Accrued is interest accrued and not posted yet, including parts of cents left by previous postings.
+	Accrued decimal.Decimal `json:"accrued" sql:"accrued,notnull,type:'decimal(24,10)'"`
+	// AccruedThrough is the last day, which interest is accrued for.
+	AccruedThrough time.Time `json:"accrued_through" sql:"accrued_through,notnull,type:date"`
+	// PostedThrough is the last day of the last posted period.
+	PostedThrough time.Time `json:"posted_through" sql:"posted_through,notnull,type:date"`
+}
+
+// Day returns midnight UTC of the day of t.
+func Day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// lastDay reports whether day is the last day of its month.
+func lastDay(day time.Time) bool {
+	return day.AddDate(0, 0, 1).Day() == 1
+}
+
+// Service is the interface that provides interest plans management.
+type Service interface {
+	// NewPlan registers a new interest plan.
+	NewPlan(ctx context.Context, plan Plan) (*Plan, error)
+
+	// Plans returns all interest plans.
+	Plans(ctx context.Context) ([]*Plan, error)
+
+	// Assign sets interest plan of account. Interest of a new assignment is accrued from today, plan of existing
+	// one is changed from the next accrued day, interest accrued before is kept.
+	Assign(ctx context.Context, id account.ID, plan string) (*Accrual, error)
+
+	// Accrual returns state of interest of account.
+	Accrual(ctx context.Context, id account.ID) (*Accrual, error)
+}
+
+type service struct {
+	interest Repository
+	accounts account.Repository
+}
+
+// NewPlan registers a new interest plan.
+func (s *service) NewPlan(ctx context.Context, plan Plan) (*Plan, error) {
+	if err := validRate(plan.Rate); err != nil {
+		return nil, err
+	}
+	if plan.DayCount == "" {
+		plan.DayCount = Actual365
+	}
+	if plan.Compounding == "" {
+		plan.Compounding = CompoundMonthly
+	}
+	plan.CreatedAt = time.Now().UTC()
+	if err := s.interest.StorePlan(ctx, &plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// validRate returns validation error, if rate is negative, has more than 4 decimal places or is not less than 1000.
+func validRate(rate decimal.Decimal) error {
+	var problem *govalidator.Error
+	invalid := func(validator, message string) {
+		problem = &govalidator.Error{Name: "rate", Validator: validator, Err: errors.New(message)}
+	}
+	switch {
+	case rate.Sign() < 0:
+		invalid("nonnegative", "must not be negative")
+	case !rate.Equal(rate.Truncate(4)):
+		invalid("scale", "must have up to 4 decimal places")
+	case !rate.LessThan(decimal.New(1000, 0)):
+		invalid("overflow", "must be less than 1000")
+	}
+	if problem != nil {
+		return errs.ValidationError{Err: govalidator.Errors{*problem}}
+	}
+	return nil
+}
+
+// Plans returns all interest plans.
+func (s *service) Plans(ctx context.Context) ([]*Plan, error) {
+	return s.interest.FindPlans(ctx)
+}
+
+// Assign sets interest plan of account.
+func (s *service) Assign(ctx context.Context, id account.ID, plan string) (*Accrual, error) {
+	if _, err := s.accounts.Find(ctx, id); err != nil {
+		return nil, err
+	}
+	if _, err := s.interest.FindPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+	yesterday := Day(time.Now()).AddDate(0, 0, -1)
+	return s.interest.Assign(ctx, &Accrual{
+		Account:        id,
+		Plan:           plan,
+		AccruedThrough: yesterday,
+		PostedThrough:  yesterday,
+	})
+}
+
+// Accrual returns state of interest of account.
+func (s *service) Accrual(ctx context.Context, id account.ID) (*Accrual, error) {
+	return s.interest.FindAccrual(ctx, id)
+}
+
+// NewService creates an interest service with necessary dependencies.
+func NewService(interest Repository, accounts account.Repository) Service {
+	return &service{
+		interest: interest,
+		accounts: accounts,
+	}
+}
+
+// Repository interface for interest plans and accruals storing.
+type Repository interface {
+	// StorePlan saves a new plan, it fails with errs.ErrInterestPlanExists, if plan with the same id exists.
+	StorePlan(ctx context.Context, plan *Plan) error
+
+	// FindPlan returns plan with specified id.
+	FindPlan(ctx context.Context, id string) (*Plan, error)
+
+	// FindPlans returns all plans ordered by id.
+	FindPlans(ctx context.Context) ([]*Plan, error)
+
+	// Assign stores accrual of account, if account has none, or changes plan of the stored one. It returns stored
+	// accrual.
+	Assign(ctx context.Context, accrual *Accrual) (*Accrual, error)
+
+	// FindAccrual returns accrual of account, errs.ErrNoInterestPlan if account has none.
+	FindAccrual(ctx context.Context, id account.ID) (*Accrual, error)
+
+	// FindAccruals returns accruals of all accounts ordered by account.
+	FindAccruals(ctx context.Context) ([]*Accrual, error)
+
+	// Save stores accrued and posted interest of accrual together with payments of posting, in one transaction.
+	// Plan of stored accrual is kept. It fails with errs.ErrAccrualChanged, if stored accrual is not accrued
+	// through accruedThrough, so every day is accrued and every period is posted once.
+	Save(ctx context.Context, accrual *Accrual, accruedThrough time.Time, payments ...*payment.Payment) error
+}
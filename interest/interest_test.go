@@ -0,0 +1,356 @@
+package interest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestDaily(t *testing.T) {
+	for _, item := range []struct {
+		Name     string
+		Rate     string
+		DayCount interest.DayCount
+		Base     string
+		Day      time.Time
+		Want     string
+	}{
+		{Name: "actual/365", Rate: "3.65", DayCount: interest.Actual365, Base: "1000", Day: date(2026, 1, 31),
+			Want: "0.1"},
+		{Name: "actual/360", Rate: "3.6", DayCount: interest.Actual360, Base: "1000", Day: date(2026, 1, 31),
+			Want: "0.1"},
+		{Name: "30/360:usual day", Rate: "3.6", DayCount: interest.Thirty360, Base: "1000", Day: date(2026, 1, 30),
+			Want: "0.1"},
+		{Name: "30/360:31st day", Rate: "3.6", DayCount: interest.Thirty360, Base: "1000", Day: date(2026, 1, 31),
+			Want: "0"},
+		{Name: "30/360:end of february", Rate: "3.6", DayCount: interest.Thirty360, Base: "1000",
+			Day: date(2026, 2, 28), Want: "0.3"},
+		{Name: "30/360:end of leap february", Rate: "3.6", DayCount: interest.Thirty360, Base: "1000",
+			Day: date(2028, 2, 29), Want: "0.2"},
+		{Name: "accrual scale", Rate: "1", DayCount: interest.Actual365, Base: "1", Day: date(2026, 1, 1),
+			Want: "0.0000273972"},
+		{Name: "zero base", Rate: "3.65", DayCount: interest.Actual365, Base: "0", Day: date(2026, 1, 1),
+			Want: "0"},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			p := &interest.Plan{Rate: decimal.RequireFromString(item.Rate), DayCount: item.DayCount}
+			got := p.Daily(decimal.RequireFromString(item.Base), item.Day)
+			if !got.Equal(decimal.RequireFromString(item.Want)) {
+				t.Errorf("wrong interest: got %s want %s", got, item.Want)
+			}
+		})
+	}
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func checkBalance(t *testing.T, accounts account.Repository, id account.ID, expected string) {
+	t.Helper()
+	a, err := accounts.Find(context.Background(), id)
+	OK(t, err)
+	if !a.Balance.Equal(decimal.RequireFromString(expected)) {
+		t.Errorf("%s: wrong balance: got %s want %s", id, a.Balance, expected)
+	}
+}
+
+func TestJob(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	repository := inmem.NewInterestRepository(payments)
+	for id, balance := range map[account.ID]int64{"bank": 1, "alice": 1000, "bob": 10000} {
+		OK(t, accounts.Store(ctx, &account.Account{ID: id, Balance: decimal.New(balance, 0),
+			Currency: account.CurrencyUSD}))
+	}
+	OK(t, repository.StorePlan(ctx, &interest.Plan{ID: "savings", Rate: decimal.RequireFromString("3.65"),
+		DayCount: interest.Actual365, Compounding: interest.CompoundMonthly}))
+	OK(t, repository.StorePlan(ctx, &interest.Plan{ID: "deposit", Rate: decimal.RequireFromString("3.65"),
+		DayCount: interest.Actual365, Compounding: interest.CompoundDaily}))
+	for id, plan := range map[account.ID]string{"alice": "savings", "bob": "deposit"} {
+		_, err := repository.Assign(ctx, &interest.Accrual{Account: id, Plan: plan, AccruedThrough: date(2026, 1, 31),
+			PostedThrough: date(2026, 1, 31)})
+		OK(t, err)
+	}
+
+	c := &clock{now: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)}
+	job := interest.NewJob(repository, accounts, payments, "bank", log.NewNopLogger(), interest.JobClock(c.Now))
+
+	// Interest expense account has not enough money, nothing is posted and accrued.
+	postings, err := job.Run()
+	if err != errs.ErrInsufficientMoney {
+		t.Fatalf("wrong error: got %v want %v", err, errs.ErrInsufficientMoney)
+	}
+	if len(postings) != 0 {
+		t.Errorf("unexpected postings: %+v", postings)
+	}
+	a, err := repository.FindAccrual(ctx, "bob")
+	OK(t, err)
+	if !a.AccruedThrough.Equal(date(2026, 1, 31)) {
+		t.Errorf("accrual must not be saved, it is accrued through %s", a.AccruedThrough)
+	}
+
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bank2", Balance: decimal.New(1000, 0),
+		Currency: account.CurrencyUSD}))
+	job = interest.NewJob(repository, accounts, payments, "bank2", log.NewNopLogger(), interest.JobClock(c.Now))
+	postings, err = job.Run()
+	OK(t, err)
+	// 28 days of February, 0.1 a day on 1000, and compounded daily on 10000.
+	want := []interest.Posting{
+		{Account: "alice", Period: "2026-02", Amount: decimal.RequireFromString("2.8")},
+		{Account: "bob", Period: "2026-02", Amount: decimal.RequireFromString("28.03")},
+	}
+	if diff := cmp.Diff(want, postings, cmp.Comparer(decimal.Decimal.Equal)); diff != "" {
+		t.Errorf("wrong postings (-want +got):\n%s", diff)
+	}
+	checkBalance(t, accounts, "alice", "1002.8")
+	checkBalance(t, accounts, "bob", "10028.03")
+	checkBalance(t, accounts, "bank2", "969.17")
+
+	// The same day again does nothing.
+	postings, err = job.Run()
+	OK(t, err)
+	if len(postings) != 0 {
+		t.Errorf("period is posted twice: %+v", postings)
+	}
+	checkBalance(t, accounts, "alice", "1002.8")
+
+	// Days of the next period are accrued on posted balance, until the period ends.
+	c.now = time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC)
+	postings, err = job.Run()
+	OK(t, err)
+	if len(postings) != 0 {
+		t.Errorf("unexpected postings: %+v", postings)
+	}
+	a, err = repository.FindAccrual(ctx, "alice")
+	OK(t, err)
+	if !a.AccruedThrough.Equal(date(2026, 3, 2)) || !a.PostedThrough.Equal(date(2026, 2, 28)) ||
+		!a.Accrued.Equal(decimal.RequireFromString("0.20056")) {
+		t.Errorf("wrong accrual: %+v", a)
+	}
+	a, err = repository.FindAccrual(ctx, "bob")
+	OK(t, err)
+	if a.Plan != "deposit" || a.Accrued.LessThan(decimal.RequireFromString("2")) {
+		t.Errorf("wrong accrual, cents left by posting must be kept: %+v", a)
+	}
+
+	pp, err := payments.Find(ctx, "alice")
+	OK(t, err)
+	if len(pp) != 1 || pp[0].FromAccount != "bank2" || pp[0].Metadata["interest_period"] != "2026-02" {
+		t.Errorf("wrong payments: %+v", pp)
+	}
+}
+
+func TestJobDailyBalance(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	repository := inmem.NewInterestRepository(payments)
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bank", Balance: decimal.New(1000, 0),
+		Currency: account.CurrencyUSD}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Currency: account.CurrencyUSD}))
+	OK(t, repository.StorePlan(ctx, &interest.Plan{ID: "savings", Rate: decimal.RequireFromString("3.65"),
+		DayCount: interest.Actual365, Compounding: interest.CompoundMonthly}))
+	_, err := repository.Assign(ctx, &interest.Accrual{Account: "carol", Plan: "savings",
+		AccruedThrough: date(2026, 1, 31), PostedThrough: date(2026, 1, 31)})
+	OK(t, err)
+
+	c := &clock{now: time.Date(2026, 2, 15, 10, 0, 0, 0, time.UTC)}
+	ps := payment.NewService(payments, accounts, payment.ServiceClock(c.Now))
+	_, err = ps.New(ctx, "bank", decimal.New(1000, 0), "carol", payment.Details{})
+	OK(t, err)
+	c.now = time.Date(2026, 2, 25, 10, 0, 0, 0, time.UTC)
+	_, err = ps.New(ctx, "carol", decimal.New(500, 0), "bank", payment.Details{})
+	OK(t, err)
+
+	// Nothing on 14 days, 0.1 on 10 days with 1000, 0.05 on 4 days with 500.
+	c.now = time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	postings, err := interest.NewJob(repository, accounts, payments, "bank", log.NewNopLogger(),
+		interest.JobClock(c.Now)).Run()
+	OK(t, err)
+	want := []interest.Posting{{Account: "carol", Period: "2026-02", Amount: decimal.RequireFromString("1.2")}}
+	if diff := cmp.Diff(want, postings, cmp.Comparer(decimal.Decimal.Equal)); diff != "" {
+		t.Errorf("wrong postings (-want +got):\n%s", diff)
+	}
+	checkBalance(t, accounts, "carol", "501.2")
+}
+
+func TestInterestApi(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	repository := inmem.NewInterestRepository(inmem.NewPaymentRepository(accounts))
+	handler := interest.MakeHandler(interest.NewService(repository, accounts), "secret", log.NewNopLogger())
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Currency: account.CurrencyUSD}))
+	yesterday := interest.Day(time.Now()).AddDate(0, 0, -1).Format(time.RFC3339)
+
+	for _, item := range []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Token  string
+		Status int
+		Result string
+	}{
+		{
+			Name:   "new plan:no token",
+			Method: http.MethodPost,
+			Path:   "/api/interest/v1/plans",
+			Body:   `{"id":"savings","rate":3.5}`,
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "assign:wrong token",
+			Method: http.MethodPut,
+			Path:   "/api/interest/v1/accounts/alice",
+			Body:   `{"plan":"savings"}`,
+			Token:  "guess",
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "new plan:normal flow",
+			Method: http.MethodPost,
+			Path:   "/api/interest/v1/plans",
+			Body:   `{"id":"savings","rate":3.5}`,
+			Token:  "secret",
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "new plan:already exists",
+			Method: http.MethodPost,
+			Path:   "/api/interest/v1/plans",
+			Body:   `{"id":"savings","rate":1}`,
+			Token:  "secret",
+			Status: http.StatusConflict,
+		},
+		{
+			Name:   "new plan:validation",
+			Method: http.MethodPost,
+			Path:   "/api/interest/v1/plans",
+			Body:   `{"id":"sav-ings","rate":1,"day_count":"actual/actual","compounding":"yearly"}`,
+			Token:  "secret",
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"id","rule":"alphanum","reason":"must contain only latin letters and digits"},
+				{"name":"day_count","rule":"in","reason":"is not one of allowed values"},
+				{"name":"compounding","rule":"in","reason":"is not one of allowed values"}]}`,
+		},
+		{
+			Name:   "new plan:negative rate",
+			Method: http.MethodPost,
+			Path:   "/api/interest/v1/plans",
+			Body:   `{"id":"loan","rate":-1,"day_count":"30/360"}`,
+			Token:  "secret",
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"rate","rule":"nonnegative","reason":"must not be negative"}]}`,
+		},
+		{
+			Name:   "plans:defaults",
+			Method: http.MethodGet,
+			Path:   "/api/interest/v1/plans",
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "accrual:no plan",
+			Method: http.MethodGet,
+			Path:   "/api/interest/v1/accounts/alice",
+			Status: http.StatusNotFound,
+			Result: `{"type":"urn:payments:problem:no_interest_plan","title":"Account has no interest plan",
+				"status":404,"detail":"account has no interest plan","code":"no_interest_plan"}`,
+		},
+		{
+			Name:   "assign:unknown account",
+			Method: http.MethodPut,
+			Path:   "/api/interest/v1/accounts/bob",
+			Body:   `{"plan":"savings"}`,
+			Token:  "secret",
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "assign:unknown plan",
+			Method: http.MethodPut,
+			Path:   "/api/interest/v1/accounts/alice",
+			Body:   `{"plan":"deposit"}`,
+			Token:  "secret",
+			Status: http.StatusNotFound,
+			Result: `{"type":"urn:payments:problem:unknown_interest_plan","title":"Unknown interest plan",
+				"status":404,"detail":"unknown interest plan","code":"unknown_interest_plan"}`,
+		},
+		{
+			Name:   "assign:normal flow",
+			Method: http.MethodPut,
+			Path:   "/api/interest/v1/accounts/alice",
+			Body:   `{"plan":"savings"}`,
+			Token:  "secret",
+			Status: http.StatusOK,
+			Result: `{"account":"alice","plan":"savings","accrued":0,"accrued_through":"` + yesterday +
+				`","posted_through":"` + yesterday + `"}`,
+		},
+		{
+			Name:   "accrual:normal flow",
+			Method: http.MethodGet,
+			Path:   "/api/interest/v1/accounts/alice",
+			Status: http.StatusOK,
+			Result: `{"account":"alice","plan":"savings","accrued":0,"accrued_through":"` + yesterday +
+				`","posted_through":"` + yesterday + `"}`,
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(item.Method, item.Path, strings.NewReader(item.Body))
+			if item.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+item.Token)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if item.Result == "" {
+				return
+			}
+			var got, want interface{}
+			OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			OK(t, json.Unmarshal([]byte(item.Result), &want))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("wrong body (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	plans, err := repository.FindPlans(ctx)
+	OK(t, err)
+	if len(plans) != 1 || plans[0].DayCount != interest.Actual365 || plans[0].Compounding != interest.CompoundMonthly {
+		t.Errorf("wrong plans, defaults must be applied: %+v", plans)
+	}
+}
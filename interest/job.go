@@ -0,0 +1,273 @@
+package interest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// Posting is interest of a period, paid to account.
+type Posting struct {
+	Account account.ID      `json:"account"`
+	Period  string          `json:"period"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+// Job periodically accrues interest of accounts for every past day and posts it after the end of every month, as
+// transfer from the interest expense account. Runs are idempotent: days and periods, which are done already, are
+// skipped.
+type Job struct {
+	repository Repository
+	accounts   account.Repository
+	payments   payment.Repository
+	expense    account.ID
+	logger     log.Logger
+	interval   time.Duration
+	timeout    time.Duration
+	now        func() time.Time
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// JobOption sets an optional parameter for job.
+type JobOption func(*Job)
+
+// JobInterval sets pause between runs.
+func JobInterval(d time.Duration) JobOption {
+	return func(j *Job) { j.interval = d }
+}
+
+// JobTimeout sets deadline of a run.
+func JobTimeout(d time.Duration) JobOption {
+	return func(j *Job) { j.timeout = d }
+}
+
+// JobClock sets source of current time, interest is accrued for days before its current day.
+func JobClock(now func() time.Time) JobOption {
+	return func(j *Job) { j.now = now }
+}
+
+// NewJob returns a new interest job, which pays interest from expense account. Payments are used to find balances
+// of past days. Call Start to begin runs.
+func NewJob(repository Repository, accounts account.Repository, payments payment.Repository, expense account.ID,
+	logger log.Logger, options ...JobOption) *Job {
+	j := &Job{
+		repository: repository,
+		accounts:   accounts,
+		payments:   payments,
+		expense:    expense,
+		logger:     logger,
+		interval:   time.Hour,
+		timeout:    time.Minute,
+		now:        time.Now,
+		quit:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(j)
+	}
+	return j
+}
+
+// Start runs accrual in background, the first run is immediate.
+func (j *Job) Start() {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = j.Run()
+			select {
+			case <-j.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop terminates runs and waits for the current one to finish.
+func (j *Job) Stop() {
+	j.once.Do(func() { close(j.quit) })
+	j.wg.Wait()
+}
+
+// Run accrues interest of all accounts through yesterday once and returns postings made. Failure of one account
+// does not stop the others, the first error is returned after all of them.
+func (j *Job) Run() ([]Posting, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+
+	begin := time.Now()
+	today := Day(j.now())
+	plans, err := j.repository.FindPlans(ctx)
+	if err != nil {
+		_ = j.logger.Log("method", "accrue", "err", err)
+		return nil, err
+	}
+	byID := make(map[string]*Plan, len(plans))
+	for _, val := range plans {
+		byID[val.ID] = val
+	}
+	accruals, err := j.repository.FindAccruals(ctx)
+	if err != nil {
+		_ = j.logger.Log("method", "accrue", "err", err)
+		return nil, err
+	}
+
+	var (
+		postings []Posting
+		first    error
+	)
+	for _, val := range accruals {
+		p, err := j.accrue(ctx, val, byID[val.Plan], today)
+		postings = append(postings, p...)
+		if err != nil {
+			_ = j.logger.Log("method", "accrue", "account", val.Account, "err", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	_ = j.logger.Log("method", "accrue", "accounts", len(accruals), "postings", len(postings),
+		"took", time.Since(begin))
+	return postings, first
+}
+
+// accrue accrues interest of account for days after the last accrued one and before today, each day on the balance
+// at its end. Every finished period is saved together with its posting, the rest of days is saved at last.
+func (j *Job) accrue(ctx context.Context, a *Accrual, plan *Plan, today time.Time) ([]Posting, error) {
+	if !a.AccruedThrough.Before(today.AddDate(0, 0, -1)) {
+		return nil, nil
+	}
+	if plan == nil {
+		return nil, errs.ErrUnknownInterestPlan
+	}
+	acc, err := j.accounts.Find(ctx, a.Account)
+	if err == errs.ErrUnknownAccount {
+		// Accrual of deleted account waits for its restore.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	history, err := j.payments.Find(ctx, a.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		postings []Posting
+		posted   decimal.Decimal
+	)
+	prev := a.AccruedThrough
+	for day := prev.AddDate(0, 0, 1); day.Before(today); day = day.AddDate(0, 0, 1) {
+		// Postings of this run are made now, but are paid at the end of their periods.
+		base := balance(acc, history, day.AddDate(0, 0, 1)).Add(posted)
+		if plan.Compounding == CompoundDaily {
+			base = base.Add(a.Accrued)
+		}
+		a.Accrued = a.Accrued.Add(plan.Daily(base, day))
+		a.AccruedThrough = day
+		if !lastDay(day) {
+			continue
+		}
+
+		period := day.Format("2006-01")
+		amount := a.Accrued.Truncate(acc.Currency.Scale())
+		var payments []*payment.Payment
+		if amount.Sign() > 0 {
+			if payments, err = j.transfer(ctx, acc, amount, plan, period); err != nil {
+				return postings, err
+			}
+		}
+		a.Accrued = a.Accrued.Sub(amount)
+		a.PostedThrough = day
+		if err := j.repository.Save(ctx, a, prev, payments...); err != nil {
+			return postings, err
+		}
+		prev = day
+		if amount.Sign() > 0 {
+			posted = posted.Add(amount)
+			postings = append(postings, Posting{Account: a.Account, Period: period, Amount: amount})
+		}
+	}
+	if a.AccruedThrough.After(prev) {
+		return postings, j.repository.Save(ctx, a, prev)
+	}
+	return postings, nil
+}
+
+// transfer returns completed payments of interest from expense account to account.
+func (j *Job) transfer(ctx context.Context, to *account.Account, amount decimal.Decimal, plan *Plan,
+	period string) ([]*payment.Payment, error) {
+	if to.ID == j.expense {
+		return nil, errs.ErrAccountsAreEqual
+	}
+	from, err := j.accounts.Find(ctx, j.expense)
+	if err != nil {
+		return nil, errs.ErrUnknownSourceAccount
+	}
+	if from.Currency != to.Currency {
+		return nil, errs.ErrInvalidArgument
+	}
+	// Balance of expense account is checked by repository, when payments are saved.
+	details := metadata.Metadata{"interest_plan": plan.ID, "interest_period": period}
+	id, now := uuid.New(), j.now().UTC()
+	return []*payment.Payment{
+		{
+			ID:          uuid.New(),
+			Account:     from.ID,
+			Amount:      amount,
+			ToAccount:   to.ID,
+			Direction:   payment.Outgoing,
+			Description: "interest for " + period,
+			Metadata:    details,
+			Transfer:    id,
+			Status:      payment.StatusCompleted,
+			CreatedAt:   now,
+			CompletedAt: &now,
+		},
+		{
+			ID:          uuid.New(),
+			Account:     to.ID,
+			Amount:      amount,
+			FromAccount: from.ID,
+			Direction:   payment.Incoming,
+			Description: "interest for " + period,
+			Metadata:    details.Clone(),
+			Transfer:    id,
+			Status:      payment.StatusCompleted,
+			CreatedAt:   now,
+			CompletedAt: &now,
+		},
+	}, nil
+}
+
+// balance returns balance of account at time, its current balance without changes made by payments since then.
+func balance(acc *account.Account, payments []*payment.Payment, at time.Time) decimal.Decimal {
+	result := acc.Balance
+	for _, val := range payments {
+		amount := val.Amount
+		if val.Direction == payment.Outgoing {
+			amount = amount.Neg()
+		}
+		if val.CompletedAt != nil && !val.CompletedAt.Before(at) {
+			result = result.Sub(amount)
+		}
+		if val.ReversedAt != nil && !val.ReversedAt.Before(at) {
+			result = result.Add(amount)
+		}
+	}
+	return result
+}
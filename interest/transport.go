@@ -0,0 +1,104 @@
+package interest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bearer"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/tracing"
+
+	kitlog "github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// MakeHandler returns a handler for the interest service. Plans are created and assigned only by requests with the
+// admin token in "Authorization: Bearer <token>" header, and never if token is empty. Plans and accruals are public.
+func MakeHandler(s Service, token string, logger kitlog.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext),
+	}
+
+	newPlanHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("interest.newPlan")(makeNewPlanEndpoint(s)),
+		decodeNewPlanRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	plansHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("interest.plans")(makePlansEndpoint(s)),
+		decodePlansRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	assignHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("interest.assign")(makeAssignEndpoint(s)),
+		decodeAssignRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	accrualHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("interest.accrual")(makeAccrualEndpoint(s)),
+		decodeAccountRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	router := mux.NewRouter()
+
+	router.Handle("/api/interest/v1/plans", bearer.Authenticate(token, "admin", newPlanHandler)).Methods("POST")
+	router.Handle("/api/interest/v1/plans", plansHandler).Methods("GET")
+	router.Handle("/api/interest/v1/accounts/{id}", bearer.Authenticate(token, "admin", assignHandler)).
+		Methods("PUT")
+	router.Handle("/api/interest/v1/accounts/{id}", accrualHandler).Methods("GET")
+
+	return router
+}
+
+func decodeNewPlanRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body newPlanRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+	if _, err := govalidator.ValidateStruct(body); err != nil {
+		return nil, errs.ValidationError{Err: err}
+	}
+	return body, nil
+}
+
+func decodePlansRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeAssignRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	var body assignRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+	if _, err := govalidator.ValidateStruct(body); err != nil {
+		return nil, errs.ValidationError{Err: err}
+	}
+	body.Account = account.ID(id)
+	return body, nil
+}
+
+func decodeAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	return accountField{Account: account.ID(id)}, nil
+}
@@ -1,159 +1,630 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/go-pg/pg"
+	"github.com/otetz/payments/boltdb"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/config"
 	"github.com/otetz/payments/db"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/event"
+	"github.com/otetz/payments/health"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/interest"
 
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/admin"
 	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/principal"
+	"github.com/otetz/payments/reconcile"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/risk"
+	"github.com/otetz/payments/screening"
+	"github.com/otetz/payments/stream"
+	"github.com/otetz/payments/tracing"
+	"github.com/otetz/payments/webhook"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/shopspring/decimal"
 )
 
-type dbLogger struct{}
+// commands of the application, first argument chooses one, "serve" is the default.
+var commands = map[string]func(cfg *config.Config, logger log.Logger) error{
+	"serve":        serve,
+	"config print": printConfig,
+	"import":       importData,
+	"export":       exportData,
+	"reconcile":    reconcileLedger,
+}
+
+func main() {
+	name, args := "serve", os.Args[1:]
+	for key := range commands {
+		words := strings.Fields(key)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == key {
+			name, args = key, args[len(words):]
+		}
+	}
+
+	cfg, err := config.Load(os.Args[0]+" "+name, args, os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-func (d dbLogger) BeforeQuery(q *pg.QueryEvent) {
+	logger := log.NewLogfmtLogger(os.Stderr)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	if err := commands[name](cfg, logger); err != nil {
+		_ = logger.Log("command", name, "err", err)
+		os.Exit(1)
+	}
 }
 
-func (d dbLogger) AfterQuery(q *pg.QueryEvent) {
-	fmt.Println(q.FormattedQuery())
+func printConfig(cfg *config.Config, _ log.Logger) error {
+	return cfg.Print(os.Stdout)
 }
 
-var (
-	flagHttpAddr = flag.String("http_address", "0.0.0.0:8080", "Http address for web server running")
+// bulkArgs returns kind of records and file of import and export commands: "accounts|payments [file]".
+// Standard input or output is used, if file is "-" or omitted.
+func bulkArgs(cfg *config.Config) (bulk.Kind, string, bulk.Format, error) {
+	args := cfg.Args()
+	if len(args) < 1 || len(args) > 2 {
+		return "", "", "", fmt.Errorf("usage: [flags] accounts|payments [file]")
+	}
+	kind, path := bulk.Kind(args[0]), "-"
+	if len(args) == 2 {
+		path = args[1]
+	}
+	format := bulk.Format(cfg.Bulk.Format)
+	if format == "" {
+		format = bulk.CSV
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ndjson", ".jsonl":
+			format = bulk.NDJSON
+		}
+	}
+	return kind, path, format, nil
+}
 
-	flagDBAddr     = flag.String("db_address", "localhost:5432", "Address to connect to PostgreSQL server")
-	flagDBUser     = flag.String("db_user", "postgres", "PostgreSQL connection user")
-	flagDBPassword = flag.String("db_password", "", "PostgreSQL connection password")
-	flagDBDatabase = flag.String("database", "payments", "PostgreSQL database name")
-	flagDBAppName  = flag.String("app_name", "payments", "PostgreSQL application name (for logging)")
-	flagDBPoolSize = flag.Int("pool_size", 10, "PostgreSQL connection pool size")
-	flagDBLog = flag.Bool("db_log", false, "Switch for statements logging")
-)
+func setupBulkService(repository bulk.Repository, logger log.Logger) bulk.Service {
+	return bulk.NewLoggingService(log.With(logger, "component", "bulk"), bulk.NewService(repository))
+}
 
-func main() {
-	flag.Parse()
+// importData imports records from file and prints report of import.
+func importData(cfg *config.Config, logger log.Logger) error {
+	kind, path, format, err := bulkArgs(cfg)
+	if err != nil {
+		return err
+	}
+	in := os.Stdin
+	if path != "-" {
+		if in, err = os.Open(path); err != nil {
+			return err
+		}
+		defer func() { _ = in.Close() }()
+	}
 
-	logger := log.NewLogfmtLogger(os.Stderr)
-	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+	store, err := setupStorage(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.close(); err != nil {
+			_ = logger.Log("error", err)
+		}
+	}()
+
+	report, err := setupBulkService(store.bulk, logger).Import(context.Background(), kind, format, in,
+		cfg.Bulk.DryRun)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// exportData exports records to file.
+func exportData(cfg *config.Config, logger log.Logger) error {
+	kind, path, format, err := bulkArgs(cfg)
+	if err != nil {
+		return err
+	}
+	out := os.Stdout
+	if path != "-" {
+		if out, err = os.Create(path); err != nil {
+			return err
+		}
+	}
+
+	store, err := setupStorage(cfg, logger)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	defer func() {
+		if err := store.close(); err != nil {
+			_ = logger.Log("error", err)
+		}
+	}()
+
+	err = setupBulkService(store.bulk, logger).Export(context.Background(), kind, format, out)
+	if e := out.Close(); err == nil && path != "-" {
+		err = e
+	}
+	return err
+}
+
+// reconcileLedger verifies invariants of the ledger and prints report of reconciliation. It fails, if
+// discrepancies are found.
+func reconcileLedger(cfg *config.Config, logger log.Logger) error {
+	store, err := setupStorage(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := store.close(); err != nil {
+			_ = logger.Log("error", err)
+		}
+	}()
+
+	report, err := reconcile.Reconcile(context.Background(), store.ledger)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if n := len(report.Discrepancies); n > 0 {
+		return fmt.Errorf("%d discrepancies found", n)
+	}
+	return nil
+}
+
+func serve(cfg *config.Config, logger log.Logger) error {
+	errs.Legacy = cfg.HTTP.LegacyErrors
 
-	conn := setupDB(logger)
+	store, err := setupStorage(cfg, logger)
+	if err != nil {
+		return err
+	}
 	defer func() {
-		if err := conn.Close(); err != nil {
+		if err := store.close(); err != nil {
 			_ = logger.Log("error", err)
 		}
 	}()
+	accounts, payments, webhooks := store.accounts, store.payments, store.webhooks
+
+	dispatcher := webhook.NewDispatcher(webhooks, log.With(logger, "component", "webhook"),
+		webhook.DispatcherWorkers(cfg.Webhook.Workers),
+		webhook.DispatcherMaxAttempts(cfg.Webhook.MaxAttempts),
+	)
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	if cfg.Outbox.Publisher != "" {
+		relay, closer, err := setupRelay(cfg.Outbox, store.outbox, logger)
+		if err != nil {
+			return err
+		}
+		relay.Start()
+		defer func() {
+			relay.Stop()
+			if err := closer(); err != nil {
+				_ = logger.Log("error", err)
+			}
+		}()
+	}
+
+	if cfg.Reconcile.Interval > 0 {
+		job := setupReconcileJob(cfg.Reconcile, store.ledger, logger)
+		job.Start()
+		defer job.Stop()
+	}
 
+	if cfg.Interest.Account != "" {
+		job := interest.NewJob(store.interest, accounts, payments, account.ID(cfg.Interest.Account),
+			log.With(logger, "component", "interest"), interest.JobInterval(cfg.Interest.Interval))
+		job.Start()
+		defer job.Stop()
+	}
+
+	if cfg.Accounts.PurgeAfter > 0 {
+		purger := account.NewPurger(accounts, cfg.Accounts.PurgeAfter, log.With(logger, "component", "purge"),
+			account.PurgerInterval(cfg.Accounts.PurgeInterval))
+		purger.Start()
+		defer purger.Stop()
+	}
+
+	broker := stream.NewBroker(store.outbox, accounts, log.With(logger, "component", "stream"))
+	if err := broker.Start(); err != nil {
+		return err
+	}
+	defer broker.Stop()
+
+	rounding := account.Rounding(cfg.Amounts.Rounding)
 	var (
-		accounts = db.NewAccountRepository(conn)
-		payments = db.NewPaymentRepository(conn, accounts)
+		accountOptions = []account.ServiceOption{account.ServiceRounding(rounding)}
+		paymentOptions = []payment.ServiceOption{payment.ServiceRounding(rounding)}
+		rules          []payment.Rule
 	)
+	if cfg.Approvals.Threshold != "" {
+		paymentOptions = append(paymentOptions, payment.ServiceApprovals(store.approvals,
+			decimal.RequireFromString(cfg.Approvals.Threshold), cfg.Approvals.TTL))
+	}
+	if cfg.Risk.Rules != "" {
+		rules, err = risk.Load(cfg.Risk.Rules, payments)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Screening.List != "" {
+		screener, err := screening.NewScreener(cfg.Screening.List, log.With(logger, "component", "screening"),
+			screening.ScreenerThreshold(cfg.Screening.Threshold), screening.ScreenerInterval(cfg.Screening.Interval))
+		if err != nil {
+			return err
+		}
+		screener.Start()
+		defer screener.Stop()
+		// Holders of new accounts and parties of transfers are screened by the same list.
+		checker := screening.NewChecker(screener, store.screening)
+		accountOptions = append(accountOptions, account.ServiceScreening(checker))
+		rules = append(rules, checker)
+	}
+	if len(rules) > 0 {
+		paymentOptions = append(paymentOptions, payment.ServiceRules(rules...))
+	}
+	as := setupAccountService(accounts, dispatcher, logger, accountOptions...)
+	ps := setupPaymentService(payments, accounts, dispatcher, logger, paymentOptions...)
+	ws := webhook.NewService(webhooks)
 
-	as := setupAccountService(accounts, logger)
-	ps := setupPaymentService(payments, accounts, logger)
+	checks := health.New(cfg.Shutdown.HealthTimeout)
+	for name, check := range store.checks {
+		checks.AddCheck(name, check)
+	}
 
 	httpLogger := log.With(logger, "component", "http")
 
 	mux := http.NewServeMux()
 
-	mux.Handle("/api/accounts/v1/", account.MakeHandler(as, httpLogger))
-	mux.Handle("/api/payments/v1/", payment.MakeHandler(ps, httpLogger))
+	mux.Handle("/api/accounts/v1/", withTimeout(account.MakeHandler(as, httpLogger), cfg.HTTP.RequestTimeout))
+	mux.Handle("/api/payments/v1/", withTimeout(payment.MakeHandler(ps, httpLogger), cfg.HTTP.RequestTimeout))
+	mux.Handle("/api/webhooks/v1/", withTimeout(webhook.MakeHandler(ws, httpLogger), cfg.HTTP.RequestTimeout))
+	// Plans are managed with the admin token, they are only read without it.
+	mux.Handle("/api/interest/v1/", withTimeout(interest.MakeHandler(interest.NewService(store.interest, accounts),
+		cfg.Admin.Token, httpLogger), cfg.HTTP.RequestTimeout))
+	mux.Handle("/api/screening/v1/", withTimeout(screening.MakeHandler(screening.NewService(store.screening),
+		httpLogger), cfg.HTTP.RequestTimeout))
+	mux.Handle("/api/stream/v1/", stream.MakeHandler(broker, httpLogger))
+	// Import and export of millions of records take longer than usual requests.
+	mux.Handle("/api/bulk/v1/", bulk.MakeHandler(setupBulkService(store.bulk, logger), httpLogger))
+	// Admin API is not mounted without a token, it must never be open.
+	if cfg.Admin.Token != "" {
+		mux.Handle("/api/admin/v1/", withTimeout(admin.MakeHandler(admin.NewService(store.snapshotter, as),
+			cfg.Admin.Token, httpLogger), cfg.HTTP.RequestTimeout))
+	} else {
+		_ = logger.Log("api", "admin", "msg", "disabled, admin_token is not set")
+	}
+
+	api := http.Handler(mux)
+	if cfg.Tracing.Endpoint != "" {
+		exporter := tracing.NewOTLPExporter(cfg.Tracing.Endpoint, log.With(logger, "component", "tracing"))
+		exporter.Start()
+		defer exporter.Stop()
+		api = tracing.NewTracer(cfg.Tracing.Service, exporter).Handler(api)
+	}
+	api = principal.Trusted(cfg.Approvals.PrincipalSecret)(api)
+	api = requestid.Middleware(api)
 
-	http.Handle("/", accessControl(mux))
-	http.Handle("/metrics", promhttp.Handler())
+	root := http.NewServeMux()
+	root.Handle("/", accessControl(api))
+	root.Handle("/metrics", promhttp.Handler())
+	root.Handle("/healthz", checks.LivenessHandler())
+	root.Handle("/readyz", checks.ReadinessHandler())
 
-	errs := make(chan error, 2)
-	go func() {
-		_ = logger.Log("transport", "http", "address", *flagHttpAddr, "msg", "listening")
-		errs <- http.ListenAndServe(*flagHttpAddr, nil)
-	}()
+	server := &http.Server{Addr: cfg.HTTP.Address, Handler: root}
+	// Live feed connections never finish by themselves, close them to let Shutdown complete.
+	server.RegisterOnShutdown(broker.Stop)
+
+	failures := make(chan error, 1)
 	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, syscall.SIGINT)
-		errs <- fmt.Errorf("%s", <-c)
+		_ = logger.Log("transport", "http", "address", cfg.HTTP.Address, "msg", "listening")
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			failures <- err
+		}
 	}()
+	checks.SetReady(true)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-failures:
+		return err
+	case sig := <-signals:
+		_ = logger.Log("msg", "shutting down", "signal", sig)
+	}
+
+	// Load balancers must notice, that instance is not ready, before it stops accepting connections.
+	checks.SetReady(false)
+	time.Sleep(cfg.Shutdown.Delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		_ = logger.Log("transport", "http", "msg", "shutdown", "err", err)
+	}
+	_ = logger.Log("terminated", "shutdown")
+	return nil
+}
 
-	_ = logger.Log("terminated", <-errs)
+// storage holds repositories of the chosen backend.
+type storage struct {
+	accounts account.Repository
+	payments payment.Repository
+	webhooks webhook.Repository
+	outbox   event.Outbox
+	bulk     bulk.Repository
+	ledger   reconcile.Repository
+	interest interest.Repository
+	checks   map[string]health.CheckFunc
+	close    func() error
+	// snapshotter is set, if backend saves snapshots on demand.
+	snapshotter admin.Snapshotter
+	// approvals keeps transfers pending until approval, they are required if approval threshold is set.
+	approvals payment.ApprovalRepository
+	screening screening.Repository
 }
 
-func setupDB(logger log.Logger) *pg.DB {
+func setupStorage(cfg *config.Config, logger log.Logger) (*storage, error) {
+	switch cfg.Storage.Backend {
+	case config.StorageInmem:
+		var opts []inmem.OutboxOption
+		if cfg.Outbox.Publisher == "" {
+			// Events are replayed by streams only, relay does not publish them.
+			opts = append(opts, inmem.OutboxWithoutRelay())
+		}
+		outbox := inmem.NewOutbox(opts...)
+		if cfg.Storage.InmemDir == "" {
+			accounts := inmem.NewAccountRepository(inmem.WithOutbox(outbox))
+			payments := inmem.NewPaymentRepository(accounts, inmem.WithOutbox(outbox))
+			return &storage{
+				accounts:  accounts,
+				payments:  payments,
+				webhooks:  inmem.NewWebhookRepository(),
+				outbox:    outbox,
+				bulk:      inmem.NewBulkRepository(accounts, payments),
+				ledger:    inmem.NewLedgerRepository(accounts, payments),
+				interest:  inmem.NewInterestRepository(payments),
+				approvals: inmem.NewApprovalRepository(payments),
+				screening: inmem.NewScreeningRepository(),
+				close:     func() error { return nil },
+			}, nil
+		}
+		journal, err := inmem.OpenJournal(cfg.Storage.InmemDir, log.With(logger, "component", "journal"),
+			inmem.JournalInterval(cfg.Storage.SnapshotInterval))
+		if err != nil {
+			return nil, err
+		}
+		accounts := inmem.NewAccountRepository(inmem.WithOutbox(outbox), inmem.WithJournal(journal))
+		payments := inmem.NewPaymentRepository(accounts, inmem.WithOutbox(outbox), inmem.WithJournal(journal))
+		interests := inmem.NewInterestRepository(payments, inmem.WithJournal(journal))
+		approvals := inmem.NewApprovalRepository(payments, inmem.WithJournal(journal))
+		screenings := inmem.NewScreeningRepository(inmem.WithJournal(journal))
+		if err := journal.Restore(); err != nil {
+			return nil, err
+		}
+		journal.Start()
+		return &storage{
+			accounts:    accounts,
+			payments:    payments,
+			webhooks:    inmem.NewWebhookRepository(),
+			outbox:      outbox,
+			bulk:        inmem.NewBulkRepository(accounts, payments),
+			ledger:      inmem.NewLedgerRepository(accounts, payments),
+			interest:    interests,
+			approvals:   approvals,
+			screening:   screenings,
+			close:       journal.Stop,
+			snapshotter: journal,
+		}, nil
+	case config.StorageFile:
+		conn, err := boltdb.Open(cfg.Storage.File)
+		if err != nil {
+			return nil, err
+		}
+		return &storage{
+			accounts:  boltdb.NewAccountRepository(conn),
+			payments:  boltdb.NewPaymentRepository(conn),
+			webhooks:  boltdb.NewWebhookRepository(conn),
+			outbox:    boltdb.NewOutbox(conn),
+			bulk:      boltdb.NewBulkRepository(conn),
+			ledger:    boltdb.NewLedgerRepository(conn),
+			interest:  boltdb.NewInterestRepository(conn),
+			approvals: boltdb.NewApprovalRepository(conn),
+			screening: boltdb.NewScreeningRepository(conn),
+			checks: map[string]health.CheckFunc{
+				"file": func(ctx context.Context) error { return boltdb.Check(ctx, conn) },
+			},
+			close: conn.Close,
+		}, nil
+	default:
+		conn := setupDB(cfg.DB, logger)
+		accounts := db.NewAccountRepository(conn)
+		return &storage{
+			accounts:  accounts,
+			payments:  db.NewPaymentRepository(conn, accounts),
+			webhooks:  db.NewWebhookRepository(conn),
+			outbox:    db.NewOutbox(conn),
+			bulk:      db.NewBulkRepository(conn),
+			ledger:    db.NewLedgerRepository(conn),
+			interest:  db.NewInterestRepository(conn),
+			approvals: db.NewApprovalRepository(conn),
+			screening: db.NewScreeningRepository(conn),
+			checks: map[string]health.CheckFunc{
+				"postgres":   func(ctx context.Context) error { return db.Ping(ctx, conn) },
+				"migrations": func(ctx context.Context) error { return db.CheckSchema(ctx, conn) },
+			},
+			close: conn.Close,
+		}, nil
+	}
+}
+
+func setupDB(cfg config.DB, logger log.Logger) *pg.DB {
 	conn := pg.Connect(&pg.Options{
-		Addr:            *flagDBAddr,
-		User:            *flagDBUser,
-		Password:        *flagDBPassword,
-		Database:        *flagDBDatabase,
-		ApplicationName: *flagDBAppName,
-		PoolSize:        *flagDBPoolSize,
+		Addr:            cfg.Address,
+		User:            cfg.User,
+		Password:        cfg.Password,
+		Database:        cfg.Database,
+		ApplicationName: cfg.AppName,
+		PoolSize:        cfg.PoolSize,
 	})
-	if *flagDBLog {
-		conn.AddQueryHook(dbLogger{})
+	var queryLogger log.Logger
+	if cfg.Log {
+		queryLogger = log.With(logger, "component", "db")
 	}
+	conn.AddQueryHook(db.NewQueryHook(queryLogger))
 	if err := db.CreateSchema(conn); err != nil {
-		_ = logger.Log("transport", "DB", "address", *flagDBAddr, "msg", err)
+		_ = logger.Log("transport", "DB", "address", cfg.Address, "msg", err)
 	}
 	return conn
 }
 
-func setupPaymentService(payments payment.Repository, accounts account.Repository, logger log.Logger) payment.Service {
+func setupRelay(cfg config.Outbox, outbox event.Outbox, logger log.Logger) (*event.Relay, func() error, error) {
+	var (
+		publisher = event.NewWriterPublisher(os.Stdout)
+		closer    = func() error { return nil }
+	)
+	if cfg.Publisher != "-" {
+		p, f, err := event.NewFilePublisher(cfg.Publisher)
+		if err != nil {
+			return nil, nil, err
+		}
+		publisher, closer = p, f.Close
+	}
+	relay := event.NewRelay(outbox, publisher, log.With(logger, "component", "outbox"),
+		event.RelayInterval(cfg.Interval),
+	)
+	return relay, closer, nil
+}
+
+func setupReconcileJob(cfg config.Reconcile, ledger reconcile.Repository, logger log.Logger) *reconcile.Job {
+	return reconcile.NewJob(ledger, reconcile.Metrics{
+		Discrepancies: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "payments",
+			Name:      "reconciliation_discrepancies",
+			Help:      "Number of ledger discrepancies found by the last reconciliation, by check.",
+		}, []string{"check"}),
+		LastRun: kitprometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: "payments",
+			Name:      "reconciliation_last_run_timestamp_seconds",
+			Help:      "Unix time of the last successful reconciliation.",
+		}, []string{}),
+	}, log.With(logger, "component", "reconcile"), reconcile.JobInterval(cfg.Interval))
+}
+
+func setupPaymentService(payments payment.Repository, accounts account.Repository, notifier webhook.Notifier,
+	logger log.Logger, options ...payment.ServiceOption) payment.Service {
 	fieldKeys := []string{"method"}
 
-	ps := payment.NewService(payments, accounts)
+	options = append(options, payment.ServiceObservers(webhook.NewPaymentObserver(notifier)))
+	ps := payment.NewService(payments, accounts, options...)
+	ps = webhook.NewPaymentService(notifier, ps)
 	ps = payment.NewLoggingService(log.With(logger, "component", "payment"), ps)
-	ps = payment.NewMetricsService(
-		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	ps = payment.NewMetricsService(payment.Metrics{
+		RequestCount: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 			Namespace: "api",
 			Subsystem: "payment_service",
 			Name:      "request_count",
 			Help:      "Number of requests received.",
 		}, fieldKeys),
-		kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		RequestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
 			Namespace: "api",
 			Subsystem: "payment_service",
-			Name:      "request_latency_microseconds",
-			Help:      "Total duration of requests in microseconds.",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests in seconds.",
+			Buckets:   stdprometheus.DefBuckets,
 		}, fieldKeys),
-		ps,
-	)
+		Transfers: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "payments",
+			Name:      "transfers_total",
+			Help:      "Number of completed transfers.",
+		}, []string{"currency"}),
+		TransferValue: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "payments",
+			Name:      "transfer_value_total",
+			Help:      "Total amount of completed transfers.",
+		}, []string{"currency"}),
+		Failures: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "payments",
+			Name:      "transfer_failures_total",
+			Help:      "Number of rejected transfers by reason.",
+		}, []string{"reason"}),
+	}, accounts, ps)
 	return ps
 }
 
-func setupAccountService(accounts account.Repository, logger log.Logger) account.Service {
+func setupAccountService(accounts account.Repository, notifier webhook.Notifier, logger log.Logger,
+	options ...account.ServiceOption) account.Service {
 	fieldKeys := []string{"method"}
 
-	as := account.NewService(accounts)
+	as := account.NewService(accounts, options...)
+	as = webhook.NewAccountService(notifier, as)
 	as = account.NewLoggingService(log.With(logger, "component", "account"), as)
-	as = account.NewMetricsService(
-		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	as = account.NewMetricsService(account.Metrics{
+		RequestCount: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 			Namespace: "api",
 			Subsystem: "account_service",
 			Name:      "request_count",
 			Help:      "Number of requests received.",
 		}, fieldKeys),
-		kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		RequestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
 			Namespace: "api",
 			Subsystem: "account_service",
-			Name:      "request_latency_microseconds",
-			Help:      "Total duration of requests in microseconds.",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests in seconds.",
+			Buckets:   stdprometheus.DefBuckets,
 		}, fieldKeys),
-		as,
-	)
+		Created: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "payments",
+			Name:      "accounts_created_total",
+			Help:      "Number of created accounts.",
+		}, []string{"currency"}),
+		Deleted: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "payments",
+			Name:      "accounts_deleted_total",
+			Help:      "Number of deleted accounts.",
+		}, []string{}),
+	}, as)
+	stdprometheus.MustRegister(account.NewBalanceCollector("payments", accounts, account.BalanceBuckets))
 	return as
 }
 
 func accessControl(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, traceparent, X-Request-ID, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, ETag")
 
 		if r.Method == "OPTIONS" {
 			return
@@ -162,3 +633,15 @@ func accessControl(h http.Handler) http.Handler {
 		h.ServeHTTP(w, r)
 	})
 }
+
+// withTimeout sets deadline of request context, so it reaches database queries. Zero duration disables it.
+func withTimeout(h http.Handler, d time.Duration) http.Handler {
+	if d <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
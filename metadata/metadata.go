@@ -0,0 +1,125 @@
+// Package metadata provides bounded key/value metadata, which clients attach to accounts and payments, e.g.
+// invoice numbers and customer IDs of their own systems.
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// Limits of metadata.
+const (
+	MaxKeys        = 20
+	MaxKeyLength   = 40
+	MaxValueLength = 500
+)
+
+// QueryPrefix is a prefix of query parameters, which filter lists by metadata: ?metadata.invoice=42.
+const QueryPrefix = "metadata."
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Metadata is a set of string values by keys. It is stored as JSON object, JSONB in PostgreSQL.
+type Metadata map[string]string
+
+// Clone returns a copy of metadata, so changes of it do not affect the original.
+func (m Metadata) Clone() Metadata {
+	if m == nil {
+		return nil
+	}
+	c := make(Metadata, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Contains reports whether metadata has all keys of filter with the same values. Every metadata contains empty
+// filter.
+func (m Metadata) Contains(filter Metadata) bool {
+	for k, v := range filter {
+		if val, ok := m[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidKey reports whether key has allowed length and consists of latin letters, digits, '_', '-' and '.'.
+func ValidKey(key string) bool {
+	return len(key) <= MaxKeyLength && keyPattern.MatchString(key)
+}
+
+// Validate checks number of keys, format of keys and length of values. Problems are returned as
+// govalidator.Errors, named by keys, in order of keys.
+func Validate(m Metadata) error {
+	var problems govalidator.Errors
+	if len(m) > MaxKeys {
+		problems = append(problems, govalidator.Error{Name: "metadata", Validator: "maxkeys",
+			Err: fmt.Errorf("must have up to %d keys", MaxKeys)})
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch {
+		case !ValidKey(k):
+			problems = append(problems, govalidator.Error{Name: k, Path: []string{"metadata"},
+				Validator: "metadatakey", Err: fmt.Errorf("key must be up to %d latin letters, digits, '_', '-' "+
+					"or '.'", MaxKeyLength)})
+		case utf8.RuneCountInString(m[k]) > MaxValueLength:
+			problems = append(problems, govalidator.Error{Name: k, Path: []string{"metadata"},
+				Validator: "stringlength", Err: fmt.Errorf("must be up to %d characters", MaxValueLength)})
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// FromQuery returns filter by query parameters with QueryPrefix, other parameters are ignored. Keys are
+// validated, every key must be given once.
+func FromQuery(query url.Values) (Metadata, error) {
+	var (
+		filter   Metadata
+		problems govalidator.Errors
+	)
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !strings.HasPrefix(name, QueryPrefix) {
+			continue
+		}
+		key, values := strings.TrimPrefix(name, QueryPrefix), query[name]
+		switch {
+		case !ValidKey(key):
+			problems = append(problems, govalidator.Error{Name: name, Validator: "metadatakey",
+				Err: errors.New("key must be latin letters, digits, '_', '-' or '.'")})
+		case len(values) > 1:
+			problems = append(problems, govalidator.Error{Name: name, Validator: "once",
+				Err: errors.New("must be given once")})
+		default:
+			if filter == nil {
+				filter = make(Metadata)
+			}
+			filter[key] = values[0]
+		}
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return filter, nil
+}
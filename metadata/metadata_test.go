@@ -0,0 +1,80 @@
+package metadata_test
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/google/go-cmp/cmp"
+	"github.com/otetz/payments/metadata"
+)
+
+func TestValidate(t *testing.T) {
+	full := make(metadata.Metadata, metadata.MaxKeys)
+	for i := 0; i < metadata.MaxKeys; i++ {
+		full[fmt.Sprintf("key_%d", i)] = strings.Repeat("ы", metadata.MaxValueLength)
+	}
+	longKey := strings.Repeat("k", metadata.MaxKeyLength+1)
+	for name, item := range map[string]struct {
+		Metadata metadata.Metadata
+		Problems []string
+	}{
+		"nil":    {nil, nil},
+		"limits": {full, nil},
+		"too many keys": {
+			metadata.Metadata{"a": "", "b": "", "c": "", "d": "", "e": "", "f": "", "g": "", "h": "", "i": "", "j": "",
+				"k": "", "l": "", "m": "", "n": "", "o": "", "p": "", "q": "", "r": "", "s": "", "t": "", "u": ""},
+			[]string{"metadata:maxkeys"},
+		},
+		"keys and values": {
+			metadata.Metadata{
+				"order.id":      "1",
+				"a/b":           "1",
+				longKey:         "1",
+				"note":          strings.Repeat("x", metadata.MaxValueLength+1),
+				"customer-ID_2": "",
+				strings.Repeat("k", metadata.MaxKeyLength): "ok",
+			},
+			[]string{"a/b:metadatakey", longKey + ":metadatakey", "note:stringlength"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var problems []string
+			if err := metadata.Validate(item.Metadata); err != nil {
+				for _, e := range err.(govalidator.Errors) {
+					problems = append(problems, e.(govalidator.Error).Name+":"+e.(govalidator.Error).Validator)
+				}
+			}
+			if diff := cmp.Diff(item.Problems, problems); diff != "" {
+				t.Errorf("wrong problems (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFromQuery(t *testing.T) {
+	query, err := url.ParseQuery("metadata.invoice=7&metadata.customer=&page=2&metadata=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := metadata.FromQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(metadata.Metadata{"invoice": "7", "customer": ""}, filter); diff != "" {
+		t.Errorf("wrong filter (-want +got):\n%s", diff)
+	}
+
+	filter, err = metadata.FromQuery(url.Values{"page": {"2"}})
+	if filter != nil || err != nil {
+		t.Errorf("no filter expected, got %v, %v", filter, err)
+	}
+
+	m := metadata.Metadata{"invoice": "7", "customer": "42"}
+	if !m.Contains(metadata.Metadata{"invoice": "7"}) || m.Contains(metadata.Metadata{"invoice": "8"}) ||
+		!m.Contains(nil) || metadata.Metadata(nil).Contains(metadata.Metadata{"invoice": "7"}) {
+		t.Errorf("wrong containment of %v", m)
+	}
+}
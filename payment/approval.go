@@ -0,0 +1,78 @@
+package payment
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/metadata"
+	"github.com/shopspring/decimal"
+)
+
+// ApprovalStatus is a state of pending transfer.
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+	// ApprovalExpired is reported for pending transfer after its expiry, it is stored only if somebody tried to
+	// decide such transfer.
+	ApprovalExpired ApprovalStatus = "expired"
+)
+
+// Approval is a transfer above approval threshold or under review of risk rules, which waits for decision of a
+// principal other than requester.
+// Its amount is reserved on source account while it is pending, payments are stored only when it is approved.
+type Approval struct {
+	TableName   struct{}          `json:"-" sql:"approvals"`
+	ID          uuid.UUID         `json:"id" sql:"id,pk,type:varchar(36)"`
+	FromAccount account.ID        `json:"from" sql:"from_account,notnull,type:varchar(255)"`
+	Amount      decimal.Decimal   `json:"amount" sql:"amount,notnull,type:'decimal(16,4)'"`
+	ToAccount   account.ID        `json:"to" sql:"to_account,notnull,type:varchar(255)"`
+	Description string            `json:"description,omitempty" sql:"description,notnull,default:''"`
+	Reference   string            `json:"reference,omitempty" sql:"reference,notnull,default:''"`
+	Metadata    metadata.Metadata `json:"metadata,omitempty" sql:"metadata,type:jsonb"`
+	Status      ApprovalStatus    `json:"status" sql:"status,notnull,type:varchar(16)"`
+	RequestedBy string            `json:"requested_by" sql:"requested_by,notnull,type:varchar(255)"`
+	DecidedBy   string            `json:"decided_by,omitempty" sql:"decided_by,notnull,default:''"`
+	CreatedAt   time.Time         `json:"created_at" sql:"created_at,notnull"`
+	ExpiresAt   time.Time         `json:"expires_at" sql:"expires_at,notnull"`
+	DecidedAt   *time.Time        `json:"decided_at,omitempty" sql:"decided_at"`
+	// Risk is a decision of risk rules, transfer is pending if they required review, even below threshold.
+	Risk        Decision `json:"risk,omitempty" sql:"risk,notnull,type:varchar(16),default:''"`
+	RiskReasons []string `json:"risk_reasons,omitempty" sql:"risk_reasons,type:jsonb"`
+}
+
+// Details returns client data of the transfer.
+func (a *Approval) Details() Details {
+	return Details{Description: a.Description, Reference: a.Reference, Metadata: a.Metadata}
+}
+
+// Assessment returns decision of risk rules about the transfer, or nil if there were no rules.
+func (a *Approval) Assessment() *Assessment {
+	if a.Risk == "" {
+		return nil
+	}
+	return &Assessment{Decision: a.Risk, Reasons: a.RiskReasons}
+}
+
+// ApprovalRepository interface for storing of pending transfers and decisions on them.
+type ApprovalRepository interface {
+	// StoreApproval saves a new pending transfer.
+	StoreApproval(ctx context.Context, approval *Approval) error
+
+	// FindApproval returns transfer with specified id, or errs.ErrUnknownApproval.
+	FindApproval(ctx context.Context, id uuid.UUID) (*Approval, error)
+
+	// FindApprovals returns all transfers ordered by time of creation.
+	FindApprovals(ctx context.Context) ([]*Approval, error)
+
+	// Reserved returns total amount of transfers from account, which are pending and expire after now.
+	Reserved(ctx context.Context, id account.ID, now time.Time) (decimal.Decimal, error)
+
+	// Decide stores status of pending transfer and its decision, together with payments of approved transfer. It
+	// returns errs.ErrApprovalDecided, if transfer is not pending anymore, so it is decided only once.
+	Decide(ctx context.Context, approval *Approval, payments ...*Payment) error
+}
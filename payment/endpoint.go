@@ -4,8 +4,10 @@ import (
 	"context"
 
 	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/metadata"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
 
@@ -19,31 +21,175 @@ type newPaymentRequest struct {
 	FromAccountID account.ID      `json:"from" valid:"alphanum,required,stringlength(1|255)"`
 	Amount        decimal.Decimal `json:"amount" valid:"decimal,required"`
 	ToAccountID   account.ID      `json:"to" valid:"alphanum,required,stringlength(1|255)"`
+	Description   string          `json:"description,omitempty" valid:"stringlength(0|1000),optional"`
+	Reference     string          `json:"reference,omitempty" valid:"stringlength(0|255),optional"`
+	// Metadata is validated by metadata.Validate.
+	Metadata metadata.Metadata `json:"metadata,omitempty" valid:"-"`
 }
 
+// approvalResponse holds pending or decided transfer, new payment has it only if it must be approved.
+type approvalResponse struct {
+	Approval *Approval `json:"approval,omitempty"`
+	Err      error     `json:"error,omitempty"`
+}
+
+func (r approvalResponse) ErrError() error { return r.Err }
+
 func makeNewPaymentEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(newPaymentRequest)
-		err := s.New(req.FromAccountID, req.Amount, req.ToAccountID)
-		return errorOnlyResponse{Err: err}, nil
+		details := Details{Description: req.Description, Reference: req.Reference, Metadata: req.Metadata}
+		a, err := s.New(ctx, req.FromAccountID, req.Amount, req.ToAccountID, details)
+		return approvalResponse{Approval: a, Err: err}, nil
+	}
+}
+
+type assessmentResponse struct {
+	Assessment *Assessment `json:"assessment,omitempty"`
+	Err        error       `json:"error,omitempty"`
+}
+
+func (r assessmentResponse) ErrError() error { return r.Err }
+
+func makeAssessEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(newPaymentRequest)
+		details := Details{Description: req.Description, Reference: req.Reference, Metadata: req.Metadata}
+		a, err := s.Assess(ctx, req.FromAccountID, req.Amount, req.ToAccountID, details)
+		return assessmentResponse{Assessment: a, Err: err}, nil
+	}
+}
+
+type shareRequest struct {
+	Account account.ID       `json:"account" valid:"alphanum,required,stringlength(1|255)"`
+	Amount  *decimal.Decimal `json:"amount,omitempty" valid:"-"`
+	Percent *decimal.Decimal `json:"percent,omitempty" valid:"-"`
+}
+
+type newSplitRequest struct {
+	FromAccountID account.ID      `json:"from" valid:"alphanum,required,stringlength(1|255)"`
+	Amount        decimal.Decimal `json:"amount" valid:"decimal,required"`
+	// To is validated by Allocate, accounts of shares are validated one by one.
+	To          []shareRequest `json:"to" valid:"-"`
+	Description string         `json:"description,omitempty" valid:"stringlength(0|1000),optional"`
+	Reference   string         `json:"reference,omitempty" valid:"stringlength(0|255),optional"`
+	// Metadata is validated by metadata.Validate.
+	Metadata metadata.Metadata `json:"metadata,omitempty" valid:"-"`
+}
+
+type newSplitResponse struct {
+	Splits []Split `json:"splits,omitempty"`
+	Err    error   `json:"error,omitempty"`
+}
+
+func (r newSplitResponse) ErrError() error { return r.Err }
+
+func makeNewSplitEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(newSplitRequest)
+		shares := make([]Share, 0, len(req.To))
+		for _, val := range req.To {
+			shares = append(shares, Share{Account: val.Account, Amount: val.Amount, Percent: val.Percent})
+		}
+		details := Details{Description: req.Description, Reference: req.Reference, Metadata: req.Metadata}
+		splits, err := s.Split(ctx, req.FromAccountID, req.Amount, shares, details)
+		return newSplitResponse{Splits: splits, Err: err}, nil
 	}
 }
 
 type loadPaymentsRequest struct {
-	AccountID account.ID `json:"account"`
+	AccountID account.ID        `json:"account"`
+	Metadata  metadata.Metadata `json:"metadata"`
+	Status    Status            `json:"status"`
 }
 
 func makeLoadPaymentsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(loadPaymentsRequest)
-		r := s.Load(req.AccountID)
+		var (
+			r   []*Payment
+			err error
+		)
+		if len(req.Metadata) > 0 || req.Status != "" {
+			r, err = s.Search(ctx, Filter{Account: req.AccountID, Metadata: req.Metadata, Status: req.Status})
+		} else {
+			r, err = s.Load(ctx, req.AccountID)
+		}
+		if err != nil {
+			return errorOnlyResponse{Err: err}, nil
+		}
 		return r, nil
 	}
 }
 
 func makeLoadAllPaymentsEndpoint(s Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
-		r := s.LoadAll()
+		req := request.(loadPaymentsRequest)
+		var (
+			r   []*Payment
+			err error
+		)
+		if len(req.Metadata) > 0 || req.Status != "" {
+			r, err = s.Search(ctx, Filter{Metadata: req.Metadata, Status: req.Status})
+		} else {
+			r, err = s.LoadAll(ctx)
+		}
+		if err != nil {
+			return errorOnlyResponse{Err: err}, nil
+		}
 		return r, nil
 	}
 }
+
+type approvalsResponse struct {
+	Approvals []*Approval `json:"approvals"`
+	Err       error       `json:"error,omitempty"`
+}
+
+func (r approvalsResponse) ErrError() error { return r.Err }
+
+func makeApprovalsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		aa, err := s.Approvals(ctx)
+		return approvalsResponse{Approvals: aa, Err: err}, nil
+	}
+}
+
+type approvalIDField struct {
+	ID uuid.UUID
+}
+
+func makeApproveEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(approvalIDField)
+		a, err := s.Approve(ctx, req.ID)
+		return approvalResponse{Approval: a, Err: err}, nil
+	}
+}
+
+func makeRejectEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(approvalIDField)
+		a, err := s.Reject(ctx, req.ID)
+		return approvalResponse{Approval: a, Err: err}, nil
+	}
+}
+
+type transferIDField struct {
+	ID uuid.UUID
+}
+
+type paymentsResponse struct {
+	Payments []*Payment `json:"payments"`
+	Err      error      `json:"error,omitempty"`
+}
+
+func (r paymentsResponse) ErrError() error { return r.Err }
+
+func makeReverseEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(transferIDField)
+		pp, err := s.Reverse(ctx, req.ID)
+		return paymentsResponse{Payments: pp, Err: err}, nil
+	}
+}
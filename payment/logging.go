@@ -1,11 +1,16 @@
 package payment
 
 import (
+	"context"
 	"time"
 
 	"github.com/otetz/payments/account"
 
 	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/principal"
+	"github.com/otetz/payments/requestid"
+	"github.com/otetz/payments/tracing"
 	"github.com/shopspring/decimal"
 )
 
@@ -20,41 +25,175 @@ func NewLoggingService(logger log.Logger, s Service) Service {
 }
 
 // New is logging wrapper for new payment creation.
-func (s *loggingService) New(fromAccountID account.ID, amount decimal.Decimal, toAccountID account.ID) (err error) {
+func (s *loggingService) New(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details) (approval *Approval, err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "new",
 			"from", fromAccountID,
 			"amount", amount,
 			"to", toAccountID,
+			"reference", details.Reference,
+			"pending", approval != nil,
+			"principal", principal.FromContext(ctx),
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
 			"err", err,
 		)
 	}(time.Now())
-	return s.Service.New(fromAccountID, amount, toAccountID)
+	return s.Service.New(ctx, fromAccountID, amount, toAccountID, details)
+}
+
+// Split is logging wrapper for new split transfer creation.
+func (s *loggingService) Split(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal, shares []Share,
+	details Details) (splits []Split, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "split",
+			"from", fromAccountID,
+			"amount", amount,
+			"len(shares)", len(shares),
+			"reference", details.Reference,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Split(ctx, fromAccountID, amount, shares, details)
 }
 
 // Load is logging wrapper for load payments by account.
-func (s *loggingService) Load(accountID account.ID) (result []*Payment) {
+func (s *loggingService) Load(ctx context.Context, accountID account.ID) (result []*Payment, err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "loadForAccount",
 			"account_id", accountID,
 			"len(result)", len(result),
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
 		)
 	}(time.Now())
-	return s.Service.Load(accountID)
+	return s.Service.Load(ctx, accountID)
 }
 
 // LoadAll is logging wrapper for load all payments.
-func (s *loggingService) LoadAll() (result []*Payment) {
+func (s *loggingService) LoadAll(ctx context.Context) (result []*Payment, err error) {
 	defer func(begin time.Time) {
 		_ = s.logger.Log(
 			"method", "loadForAccount",
 			"len(result)", len(result),
 			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.LoadAll(ctx)
+}
+
+// Search is logging wrapper for search payments.
+func (s *loggingService) Search(ctx context.Context, filter Filter) (result []*Payment, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "search",
+			"account_id", filter.Account,
+			"metadata", len(filter.Metadata),
+			"len(result)", len(result),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Search(ctx, filter)
+}
+
+// Approvals is logging wrapper for load transfers, which required approval.
+func (s *loggingService) Approvals(ctx context.Context) (result []*Approval, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "approvals",
+			"len(result)", len(result),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Approvals(ctx)
+}
+
+// Approve is logging wrapper for approval of pending transfer.
+func (s *loggingService) Approve(ctx context.Context, id uuid.UUID) (approval *Approval, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "approve",
+			"approval_id", id,
+			"principal", principal.FromContext(ctx),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Approve(ctx, id)
+}
+
+// Reject is logging wrapper for rejection of pending transfer.
+func (s *loggingService) Reject(ctx context.Context, id uuid.UUID) (approval *Approval, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "reject",
+			"approval_id", id,
+			"principal", principal.FromContext(ctx),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Reject(ctx, id)
+}
+
+// Assess is logging wrapper for dry run of risk rules.
+func (s *loggingService) Assess(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details) (assessment *Assessment, err error) {
+	defer func(begin time.Time) {
+		var decision Decision
+		if assessment != nil {
+			decision = assessment.Decision
+		}
+		_ = s.logger.Log(
+			"method", "assess",
+			"from", fromAccountID,
+			"amount", amount,
+			"to", toAccountID,
+			"decision", decision,
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
+		)
+	}(time.Now())
+	return s.Service.Assess(ctx, fromAccountID, amount, toAccountID, details)
+}
+
+// Reverse is logging wrapper for reversal of completed transfer.
+func (s *loggingService) Reverse(ctx context.Context, transfer uuid.UUID) (payments []*Payment, err error) {
+	defer func(begin time.Time) {
+		_ = s.logger.Log(
+			"method", "reverse",
+			"transfer_id", transfer,
+			"principal", principal.FromContext(ctx),
+			"took", time.Since(begin),
+			"request_id", requestid.FromContext(ctx),
+			"trace_id", tracing.TraceIDFromContext(ctx),
+			"err", err,
 		)
 	}(time.Now())
-	return s.Service.LoadAll()
+	return s.Service.Reverse(ctx, transfer)
 }
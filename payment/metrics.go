@@ -1,55 +1,146 @@
 package payment
 
 import (
+	"context"
 	"time"
 
 	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
 
 	"github.com/go-kit/kit/metrics"
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
 
+// Metrics are instruments of payment service.
+type Metrics struct {
+	// RequestCount counts calls of service methods, labelled by method.
+	RequestCount metrics.Counter
+	// RequestLatency observes duration of calls in seconds, labelled by method.
+	RequestLatency metrics.Histogram
+	// Transfers counts completed transfers, labelled by currency.
+	Transfers metrics.Counter
+	// TransferValue sums amounts of completed transfers, labelled by currency.
+	TransferValue metrics.Counter
+	// Failures counts rejected transfers, labelled by reason (code of error, see errs.CodeOf).
+	Failures metrics.Counter
+}
+
 type metricsService struct {
-	requestCount   metrics.Counter
-	requestLatency metrics.Histogram
+	Metrics
+	accounts account.Repository
 	Service
 }
 
-// NewMetricsService returns an instance of a metrics Service.
-func NewMetricsService(counter metrics.Counter, latency metrics.Histogram, s Service) Service {
+// NewMetricsService returns an instance of a metrics Service. Accounts are used to find out currency of transfers.
+func NewMetricsService(m Metrics, accounts account.Repository, s Service) Service {
 	return &metricsService{
-		requestCount:   counter,
-		requestLatency: latency,
-		Service:        s,
+		Metrics:  m,
+		accounts: accounts,
+		Service:  s,
+	}
+}
+
+func (s *metricsService) observe(method string, begin time.Time) {
+	s.RequestCount.With("method", method).Add(1)
+	s.RequestLatency.With("method", method).Observe(time.Since(begin).Seconds())
+}
+
+// New is metrics wrapper for new payment creation, pending transfer is counted when it is approved.
+func (s *metricsService) New(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details) (*Approval, error) {
+	defer s.observe("new", time.Now())
+
+	a, err := s.Service.New(ctx, fromAccountID, amount, toAccountID, details)
+	if a == nil {
+		s.count(ctx, fromAccountID, amount, err)
+	}
+	return a, err
+}
+
+// Split is metrics wrapper for new split transfer creation, it is counted as one transfer.
+func (s *metricsService) Split(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	shares []Share, details Details) ([]Split, error) {
+	defer s.observe("split", time.Now())
+
+	splits, err := s.Service.Split(ctx, fromAccountID, amount, shares, details)
+	s.count(ctx, fromAccountID, amount, err)
+	return splits, err
+}
+
+// count counts completed or rejected transfer.
+func (s *metricsService) count(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal, err error) {
+	if err != nil {
+		s.Failures.With("reason", string(errs.CodeOf(err))).Add(1)
+		return
+	}
+
+	currency := account.CurrencyUSD
+	if a, err := s.accounts.Find(ctx, fromAccountID); err == nil {
+		currency = a.Currency
+	}
+	value, _ := amount.Float64()
+	s.Transfers.With("currency", string(currency)).Add(1)
+	s.TransferValue.With("currency", string(currency)).Add(value)
+}
+
+// Load is metrics wrapper for load payments by account.
+func (s *metricsService) Load(ctx context.Context, accountID account.ID) ([]*Payment, error) {
+	defer s.observe("load", time.Now())
+
+	return s.Service.Load(ctx, accountID)
+}
+
+// LoadAll is metrics wrapper for load all payments.
+func (s *metricsService) LoadAll(ctx context.Context) ([]*Payment, error) {
+	defer s.observe("loadAll", time.Now())
+
+	return s.Service.LoadAll(ctx)
+}
+
+// Search is metrics wrapper for search payments.
+func (s *metricsService) Search(ctx context.Context, filter Filter) ([]*Payment, error) {
+	defer s.observe("search", time.Now())
+
+	return s.Service.Search(ctx, filter)
+}
+
+// Approvals is metrics wrapper for load transfers, which required approval.
+func (s *metricsService) Approvals(ctx context.Context) ([]*Approval, error) {
+	defer s.observe("approvals", time.Now())
+
+	return s.Service.Approvals(ctx)
+}
+
+// Approve is metrics wrapper for approval of pending transfer, approved transfer is counted as completed.
+func (s *metricsService) Approve(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	defer s.observe("approve", time.Now())
+
+	a, err := s.Service.Approve(ctx, id)
+	if err == nil {
+		s.count(ctx, a.FromAccount, a.Amount, nil)
 	}
+	return a, err
 }
 
-// New is logging wrapper for new payment creation.
-func (s *metricsService) New(fromAccountID account.ID, amount decimal.Decimal, toAccountID account.ID) error {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "new").Add(1)
-		s.requestLatency.With("method", "new").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// Reject is metrics wrapper for rejection of pending transfer.
+func (s *metricsService) Reject(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	defer s.observe("reject", time.Now())
 
-	return s.Service.New(fromAccountID, amount, toAccountID)
+	return s.Service.Reject(ctx, id)
 }
 
-// Load is logging wrapper for load payments by account.
-func (s *metricsService) Load(accountID account.ID) []*Payment {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "load").Add(1)
-		s.requestLatency.With("method", "load").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// Assess is metrics wrapper for dry run of risk rules.
+func (s *metricsService) Assess(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details) (*Assessment, error) {
+	defer s.observe("assess", time.Now())
 
-	return s.Service.Load(accountID)
+	return s.Service.Assess(ctx, fromAccountID, amount, toAccountID, details)
 }
 
-// LoadAll is logging wrapper for load all payments.
-func (s *metricsService) LoadAll() []*Payment {
-	defer func(begin time.Time) {
-		s.requestCount.With("method", "loadAll").Add(1)
-		s.requestLatency.With("method", "loadAll").Observe(time.Since(begin).Seconds() * 100000)
-	}(time.Now())
+// Reverse is metrics wrapper for reversal of completed transfer.
+func (s *metricsService) Reverse(ctx context.Context, transfer uuid.UUID) ([]*Payment, error) {
+	defer s.observe("reverse", time.Now())
 
-	return s.Service.LoadAll()
+	return s.Service.Reverse(ctx, transfer)
 }
@@ -0,0 +1,92 @@
+package payment
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/shopspring/decimal"
+)
+
+// Decision of risk rules about a transfer.
+type Decision string
+
+const (
+	DecisionAllow Decision = "allow"
+	// DecisionReview transfer is held for approval, if approvals are required, otherwise it is registered and
+	// flagged for later investigation.
+	DecisionReview Decision = "review"
+	DecisionDeny   Decision = "deny"
+)
+
+// severity orders decisions, the most severe decision of rules is a decision about transfer.
+var severity = map[Decision]int{DecisionAllow: 0, DecisionReview: 1, DecisionDeny: 2}
+
+// Transfer is a transfer evaluated by risk rules before it is registered.
+type Transfer struct {
+	From   *account.Account
+	Amount decimal.Decimal
+	// Targets are target accounts of the transfer, split transfer has several ones.
+	Targets []*account.Account
+	Details Details
+	// At is a time of the transfer.
+	At time.Time
+	// DryRun transfer is only assessed and never registered, rules must not record anything about it.
+	DryRun bool
+	// Payments are stored payments of registered transfer, they are set for observers.
+	Payments []*Payment
+	// Approval is ID of pending transfer, if transfer is registered by its approval.
+	Approval uuid.UUID
+}
+
+// Rule evaluates transfers before they are registered.
+type Rule interface {
+	// Name identifies rule in reasons of decisions.
+	Name() string
+
+	// Evaluate returns decision about transfer, and its reason if transfer is not allowed.
+	Evaluate(ctx context.Context, t *Transfer) (Decision, string, error)
+}
+
+// Observer is notified about registered transfers, e.g. rule, which keeps its own history of them to count them.
+type Observer interface {
+	// Observe is called when transfer is registered.
+	Observe(t *Transfer)
+}
+
+// Assessment is a decision of risk rules about transfer, with reasons of all rules, which did not allow it.
+type Assessment struct {
+	Decision Decision `json:"decision"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+// Assess evaluates transfer by all rules, reason of every rule is prefixed by its name.
+func Assess(ctx context.Context, rules []Rule, t *Transfer) (*Assessment, error) {
+	result := &Assessment{Decision: DecisionAllow}
+	for _, rule := range rules {
+		decision, reason, err := rule.Evaluate(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+		if decision == DecisionAllow {
+			continue
+		}
+		result.Reasons = append(result.Reasons, rule.Name()+": "+reason)
+		if severity[decision] > severity[result.Decision] {
+			result.Decision = decision
+		}
+	}
+	return result, nil
+}
+
+// apply stores assessment on payments of the transfer, nil assessment means that there are no rules.
+func (a *Assessment) apply(payments []*Payment) {
+	if a == nil {
+		return
+	}
+	for _, p := range payments {
+		p.Risk = a.Decision
+		p.RiskReasons = append([]string(nil), a.Reasons...)
+	}
+}
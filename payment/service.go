@@ -2,9 +2,14 @@
 package payment
 
 import (
+	"context"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/otetz/payments/account"
 	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/principal"
 	"github.com/shopspring/decimal"
 )
 
@@ -25,92 +30,683 @@ type Payment struct {
 	FromAccount account.ID      `json:"from_account,omitempty" sql:"from_account,type:varchar(255)" pg:"fk:from_account_id"`
 	Direction   Direction       `json:"direction" sql:"direction,notnull,type:varchar(16)"`
 	Deleted     bool            `json:"-" sql:"deleted,notnull"`
+	Description string          `json:"description,omitempty" sql:"description,notnull,default:''"`
+	// Reference is an identifier of transfer in client's system, e.g. invoice number.
+	Reference string            `json:"reference,omitempty" sql:"reference,notnull,default:''"`
+	Metadata  metadata.Metadata `json:"metadata,omitempty" sql:"metadata,type:jsonb"`
+	// Splits are target accounts of outgoing payment of a split transfer, such payment has no ToAccount.
+	Splits []Split `json:"splits,omitempty" sql:"splits,type:jsonb"`
+	// Risk is a decision of risk rules about the transfer, RiskReasons explain it. It is empty without rules.
+	Risk        Decision `json:"risk,omitempty" sql:"risk,notnull,type:varchar(16),default:''"`
+	RiskReasons []string `json:"risk_reasons,omitempty" sql:"risk_reasons,type:jsonb"`
+	// Transfer identifies transfer of the payment, all payments of transfer have the same one.
+	Transfer uuid.UUID `json:"transfer" sql:"transfer,type:varchar(36)"`
+	Status   Status    `json:"status" sql:"status,notnull,type:varchar(16),default:'completed'"`
+	// FailureReason explains, why failed payment could not be completed.
+	FailureReason string    `json:"failure_reason,omitempty" sql:"failure_reason,notnull,default:''"`
+	CreatedAt     time.Time `json:"created_at" sql:"created_at"`
+	// CompletedAt, FailedAt and ReversedAt are times of transitions to the statuses.
+	CompletedAt *time.Time `json:"completed_at,omitempty" sql:"completed_at"`
+	FailedAt    *time.Time `json:"failed_at,omitempty" sql:"failed_at"`
+	ReversedAt  *time.Time `json:"reversed_at,omitempty" sql:"reversed_at"`
+}
+
+// Details are optional client data of a transfer, both its payments have them.
+type Details struct {
+	Description string
+	Reference   string
+	Metadata    metadata.Metadata
+}
+
+// Filter selects payments, zero fields match any payment.
+type Filter struct {
+	// Account selects payments of the account.
+	Account account.ID
+	// Metadata selects payments, which metadata contains all its keys with the same values.
+	Metadata metadata.Metadata
+	// Status selects payments in the status.
+	Status Status
+	// Transfer selects payments of the transfer.
+	Transfer uuid.UUID
+}
+
+// Match reports whether payment satisfies filter.
+func (f Filter) Match(p *Payment) bool {
+	return (f.Account == "" || p.Account == f.Account) && p.Metadata.Contains(f.Metadata) &&
+		(f.Status == "" || p.CurrentStatus() == f.Status) && (f.Transfer == uuid.Nil || p.Transfer == f.Transfer)
 }
 
 // Service is the interface that provides payment methods.
 type Service interface {
-	// New registers a new payment in the system.
-	New(fromAccountID account.ID, amount decimal.Decimal, toAccountID account.ID) error
+	// New registers a new payment in the system. If transfer must be approved, it returns pending transfer and
+	// payments are not stored yet, otherwise it returns nil.
+	New(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+		toAccountID account.ID, details Details) (*Approval, error)
+
+	// Split registers a new transfer from one account to several ones, amount is allocated by shares. It returns
+	// resolved splits.
+	Split(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal, shares []Share,
+		details Details) ([]Split, error)
 
 	// Load returns payments list for an account.
-	Load(accountID account.ID) []*Payment
+	Load(ctx context.Context, accountID account.ID) ([]*Payment, error)
 
 	// LoadAll returns all payments, registered in the system.
-	LoadAll() []*Payment
+	LoadAll(ctx context.Context) ([]*Payment, error)
+
+	// Search returns payments, registered in the system, which satisfy filter.
+	Search(ctx context.Context, filter Filter) ([]*Payment, error)
+
+	// Approvals returns all transfers, which required approval, ordered by time of creation.
+	Approvals(ctx context.Context) ([]*Approval, error)
+
+	// Approve registers payments of pending transfer. Principal of context must differ from requester of transfer.
+	Approve(ctx context.Context, id uuid.UUID) (*Approval, error)
+
+	// Reject cancels pending transfer and releases its reserved money. Principal of context must differ from
+	// requester of transfer.
+	Reject(ctx context.Context, id uuid.UUID) (*Approval, error)
+
+	// Reverse returns money of completed transfer to its source account and returns reversed payments.
+	Reverse(ctx context.Context, transfer uuid.UUID) ([]*Payment, error)
+
+	// Assess checks a hypothetical transfer like a new payment and returns decision of risk rules about it, nothing
+	// is registered.
+	Assess(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal, toAccountID account.ID,
+		details Details) (*Assessment, error)
 }
 
 type service struct {
 	accounts account.Repository
 	payments Repository
+	// approvals is set, if transfers above threshold must be approved.
+	approvals ApprovalRepository
+	threshold decimal.Decimal
+	ttl       time.Duration
+	rules     []Rule
+	observers []Observer
+	rounding  account.Rounding
+	now       func() time.Time
+}
+
+// ServiceOption sets optional parameter of the service.
+type ServiceOption func(*service)
+
+// ServiceApprovals requires transfers with amount above threshold to be approved by another principal. Such
+// transfers are pending until approval, rejection or expiry after ttl.
+func ServiceApprovals(approvals ApprovalRepository, threshold decimal.Decimal, ttl time.Duration) ServiceOption {
+	return func(s *service) {
+		s.approvals = approvals
+		s.threshold = threshold
+		s.ttl = ttl
+	}
+}
+
+// ServiceRules evaluates transfers by risk rules before they are registered. Denied transfers are rejected, ones
+// under review are held for approval, if approvals are required.
+func ServiceRules(rules ...Rule) ServiceOption {
+	return func(s *service) {
+		s.rules = rules
+	}
+}
+
+// ServiceObservers notifies observers about registered transfers. Rules, which are observers, are notified without
+// it.
+func ServiceObservers(observers ...Observer) ServiceOption {
+	return func(s *service) {
+		s.observers = append(s.observers, observers...)
+	}
+}
+
+// ServiceRounding sets rounding of amounts with more decimal places than currency has, they are rejected by default.
+func ServiceRounding(rounding account.Rounding) ServiceOption {
+	return func(s *service) {
+		s.rounding = rounding
+	}
+}
+
+// ServiceClock sets source of current time, e.g. for tests of expiry.
+func ServiceClock(now func() time.Time) ServiceOption {
+	return func(s *service) {
+		s.now = now
+	}
+}
+
+// exceeds reports whether amount is greater than limit, nil limit means no limit.
+func exceeds(amount decimal.Decimal, limit *decimal.Decimal) bool {
+	return limit != nil && amount.GreaterThan(*limit)
+}
+
+// New registers a new payment in the system. Transfer above approval threshold or under review of risk rules is
+// stored as pending, if approvals are required.
+func (s *service) New(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details) (*Approval, error) {
+	t, err := s.check(ctx, fromAccountID, amount, toAccountID, details, decimal.Zero)
+	if err != nil {
+		return nil, err
+	}
+	assessment, err := s.assess(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	review := assessment != nil && assessment.Decision == DecisionReview
+	if s.approvals != nil && (review || t.Amount.GreaterThan(s.threshold)) {
+		return s.request(ctx, t, assessment)
+	}
+	payments := transfer(fromAccountID, t.Amount, toAccountID, details, t.At)
+	assessment.apply(payments)
+	if err := s.register(ctx, payments); err != nil {
+		return nil, err
+	}
+	t.Payments = payments
+	s.observe(t)
+	return nil, nil
 }
 
-// New registers a new payment in the system.
-func (s *service) New(fromAccountID account.ID, amount decimal.Decimal, toAccountID account.ID) error {
+// register stores payments of transfer as pending, then completes them. Transfer, which can not be completed, stays
+// stored as failed with the reason.
+func (s *service) register(ctx context.Context, payments []*Payment) error {
+	if err := s.payments.Store(ctx, payments...); err != nil {
+		return contextError(ctx, errs.ErrStorePayments)
+	}
+	id := payments[0].Transfer
+	err := s.payments.Transition(ctx, id, StatusCompleted, "", s.now().UTC())
+	if err == nil {
+		return nil
+	}
+	// Failure is recorded even if context is cancelled, it is the reason of failure.
+	_ = s.payments.Transition(context.Background(), id, StatusFailed, err.Error(), s.now().UTC())
+	if err == errs.ErrInsufficientMoney {
+		// Repository checks balance again under its lock, concurrent transfer could spend the money.
+		return err
+	}
+	return contextError(ctx, errs.ErrStorePayments)
+}
+
+// Assess checks a hypothetical transfer like a new payment and returns decision of risk rules about it. Transfer is
+// allowed, if there are no rules.
+func (s *service) Assess(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details) (*Assessment, error) {
+	t, err := s.check(ctx, fromAccountID, amount, toAccountID, details, decimal.Zero)
+	if err != nil {
+		return nil, err
+	}
+	t.DryRun = true
+	assessment, err := Assess(ctx, s.rules, t)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return assessment, nil
+}
+
+// assess evaluates transfer by risk rules and returns errs.ErrTransferDenied, if they deny it. Assessment is nil, if
+// there are no rules.
+func (s *service) assess(ctx context.Context, t *Transfer) (*Assessment, error) {
+	if len(s.rules) == 0 {
+		return nil, nil
+	}
+	assessment, err := Assess(ctx, s.rules, t)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if assessment.Decision == DecisionDeny {
+		return assessment, errs.ErrTransferDenied
+	}
+	return assessment, nil
+}
+
+// observe passes registered transfer to rules, which keep history of transfers, and to observers.
+func (s *service) observe(t *Transfer) {
+	for _, rule := range s.rules {
+		if o, ok := rule.(Observer); ok {
+			o.Observe(t)
+		}
+	}
+	for _, o := range s.observers {
+		o.Observe(t)
+	}
+}
+
+// check checks transfer between accounts and returns it with amount rounded by currency of source account. Money
+// reserved for pending transfers is not available, except released amount.
+func (s *service) check(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal,
+	toAccountID account.ID, details Details, released decimal.Decimal) (*Transfer, error) {
 	if fromAccountID == toAccountID {
-		return errs.ErrAccountsAreEqual
+		return nil, errs.ErrAccountsAreEqual
+	}
+	from, err := s.accounts.Find(ctx, fromAccountID)
+	if err != nil {
+		return nil, contextError(ctx, errs.ErrUnknownSourceAccount)
+	}
+	amount, err = s.rounding.CheckAmount("amount", amount, from.Currency)
+	if err != nil {
+		return nil, err
 	}
-	from, err := s.accounts.Find(fromAccountID)
+	if from.Status == account.StatusFrozen {
+		return nil, errs.ErrAccountFrozen
+	}
+	if from.TreeTransferLimit == nil && exceeds(amount, from.TransferLimit) {
+		return nil, errs.ErrTransferLimit
+	}
+	available, err := s.available(ctx, from)
 	if err != nil {
-		return errs.ErrUnknownSourceAccount
+		return nil, err
 	}
-	if from.Balance.LessThan(amount) {
-		return errs.ErrInsufficientMoney
+	if available.Add(released).LessThan(amount) {
+		return nil, errs.ErrInsufficientMoney
 	}
-	_, err = s.accounts.Find(toAccountID)
+	to, err := s.accounts.Find(ctx, toAccountID)
 	if err != nil {
-		return errs.ErrUnknownTargetAccount
+		return nil, contextError(ctx, errs.ErrUnknownTargetAccount)
+	}
+	if to.Status == account.StatusFrozen {
+		return nil, errs.ErrAccountFrozen
 	}
+	if from.TreeTransferLimit != nil {
+		if err := s.checkTreeLimit(ctx, from, amount, to); err != nil {
+			return nil, err
+		}
+	}
+	return &Transfer{
+		From:    from,
+		Amount:  amount,
+		Targets: []*account.Account{to},
+		Details: details,
+		At:      s.now().UTC(),
+	}, nil
+}
 
-	outgoingPayment := Payment{
-		ID:        uuid.New(),
-		Account:   fromAccountID,
-		Amount:    amount,
-		ToAccount: toAccountID,
-		Direction: Outgoing,
+// available returns balance of account without money reserved for its pending transfers.
+func (s *service) available(ctx context.Context, a *account.Account) (decimal.Decimal, error) {
+	if s.approvals == nil {
+		return a.Balance, nil
 	}
-	incomingPayment := Payment{
+	reserved, err := s.approvals.Reserved(ctx, a.ID, s.now())
+	if err != nil {
+		return decimal.Zero, contextError(ctx, err)
+	}
+	return a.Balance.Sub(reserved), nil
+}
+
+// transfer returns pending outgoing and incoming payments of transfer between two accounts, created at the time.
+func transfer(fromAccountID account.ID, amount decimal.Decimal, toAccountID account.ID,
+	details Details, at time.Time) []*Payment {
+	id := uuid.New()
+	return []*Payment{{
+		ID:          uuid.New(),
+		Account:     fromAccountID,
+		Amount:      amount,
+		ToAccount:   toAccountID,
+		Direction:   Outgoing,
+		Description: details.Description,
+		Reference:   details.Reference,
+		Metadata:    details.Metadata,
+		Transfer:    id,
+		Status:      StatusPending,
+		CreatedAt:   at,
+	}, {
 		ID:          uuid.New(),
 		Account:     toAccountID,
 		Amount:      amount,
 		FromAccount: fromAccountID,
 		Direction:   Incoming,
+		Description: details.Description,
+		Reference:   details.Reference,
+		Metadata:    details.Metadata.Clone(),
+		Transfer:    id,
+		Status:      StatusPending,
+		CreatedAt:   at,
+	}}
+}
+
+// checkTreeLimit checks amount against tree transfer limit, if all targets are in the tree of source account, or
+// against usual transfer limit otherwise.
+func (s *service) checkTreeLimit(ctx context.Context, from *account.Account, amount decimal.Decimal,
+	targets ...*account.Account) error {
+	limit := from.TreeTransferLimit
+	for _, to := range targets {
+		same, err := account.SameTree(ctx, s.accounts, from, to)
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if !same {
+			limit = from.TransferLimit
+			break
+		}
 	}
-	err = s.payments.Store(&outgoingPayment, &incomingPayment)
-	if err != nil {
-		return errs.ErrStorePayments
+	if exceeds(amount, limit) {
+		return errs.ErrTransferLimit
 	}
 	return nil
 }
 
+// Split registers a new transfer from one account to several ones, as one outgoing payment of the whole amount and
+// incoming payment for every target. Transfer limits and risk rules apply to the whole amount, split transfers can
+// not be approved, so the whole amount must not exceed approval threshold nor be under review, if approvals are
+// required.
+func (s *service) Split(ctx context.Context, fromAccountID account.ID, amount decimal.Decimal, shares []Share,
+	details Details) ([]Split, error) {
+	from, err := s.accounts.Find(ctx, fromAccountID)
+	if err != nil {
+		return nil, contextError(ctx, errs.ErrUnknownSourceAccount)
+	}
+	splits, err := Allocate(amount, shares, from.Currency, s.rounding)
+	if err != nil {
+		return nil, err
+	}
+	// Amount is rounded by currency as well as splits.
+	amount = decimal.Zero
+	for _, val := range splits {
+		if val.Account == fromAccountID {
+			return nil, errs.ErrAccountsAreEqual
+		}
+		amount = amount.Add(val.Amount)
+	}
+	if from.Status == account.StatusFrozen {
+		return nil, errs.ErrAccountFrozen
+	}
+	if from.TreeTransferLimit == nil && exceeds(amount, from.TransferLimit) {
+		return nil, errs.ErrTransferLimit
+	}
+	if s.approvals != nil && amount.GreaterThan(s.threshold) {
+		return nil, errs.ErrApprovalRequired
+	}
+	available, err := s.available(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	if available.LessThan(amount) {
+		return nil, errs.ErrInsufficientMoney
+	}
+	targets := make([]*account.Account, 0, len(splits))
+	for _, val := range splits {
+		to, err := s.accounts.Find(ctx, val.Account)
+		if err != nil {
+			return nil, contextError(ctx, errs.ErrUnknownTargetAccount)
+		}
+		if to.Status == account.StatusFrozen {
+			return nil, errs.ErrAccountFrozen
+		}
+		targets = append(targets, to)
+	}
+	if from.TreeTransferLimit != nil {
+		if err := s.checkTreeLimit(ctx, from, amount, targets...); err != nil {
+			return nil, err
+		}
+	}
+	t := &Transfer{From: from, Amount: amount, Targets: targets, Details: details, At: s.now().UTC()}
+	assessment, err := s.assess(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if s.approvals != nil && assessment != nil && assessment.Decision == DecisionReview {
+		return nil, errs.ErrApprovalRequired
+	}
+
+	id := uuid.New()
+	payments := []*Payment{{
+		ID:          uuid.New(),
+		Account:     fromAccountID,
+		Amount:      amount,
+		Direction:   Outgoing,
+		Description: details.Description,
+		Reference:   details.Reference,
+		Metadata:    details.Metadata,
+		Splits:      splits,
+		Transfer:    id,
+		Status:      StatusPending,
+		CreatedAt:   t.At,
+	}}
+	for _, val := range splits {
+		payments = append(payments, &Payment{
+			ID:          uuid.New(),
+			Account:     val.Account,
+			Amount:      val.Amount,
+			FromAccount: fromAccountID,
+			Direction:   Incoming,
+			Description: details.Description,
+			Reference:   details.Reference,
+			Metadata:    details.Metadata.Clone(),
+			Transfer:    id,
+			Status:      StatusPending,
+			CreatedAt:   t.At,
+		})
+	}
+	assessment.apply(payments)
+	if err := s.register(ctx, payments); err != nil {
+		return nil, err
+	}
+	t.Payments = payments
+	s.observe(t)
+	return splits, nil
+}
+
+// contextError returns error of cancelled or expired context, which is the real cause of failure, or err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
 // Load returns payments list for an account.
-func (s *service) Load(accountID account.ID) []*Payment {
-	return s.payments.Find(accountID)
+func (s *service) Load(ctx context.Context, accountID account.ID) ([]*Payment, error) {
+	return s.payments.Find(ctx, accountID)
 }
 
 // LoadAll returns all payments, registered in the system.
-func (s *service) LoadAll() []*Payment {
-	return s.payments.FindAll()
+func (s *service) LoadAll(ctx context.Context) ([]*Payment, error) {
+	return s.payments.FindAll(ctx)
+}
+
+// Search returns payments, registered in the system, which satisfy filter.
+func (s *service) Search(ctx context.Context, filter Filter) ([]*Payment, error) {
+	return s.payments.Search(ctx, filter)
+}
+
+// request stores pending transfer, which waits for approval, with decision of risk rules about it.
+func (s *service) request(ctx context.Context, t *Transfer, assessment *Assessment) (*Approval, error) {
+	requester := principal.FromContext(ctx)
+	if requester == "" {
+		return nil, errs.ErrUnauthorized
+	}
+	a := &Approval{
+		ID:          uuid.New(),
+		FromAccount: t.From.ID,
+		Amount:      t.Amount,
+		ToAccount:   t.Targets[0].ID,
+		Description: t.Details.Description,
+		Reference:   t.Details.Reference,
+		Metadata:    t.Details.Metadata,
+		Status:      ApprovalPending,
+		RequestedBy: requester,
+		CreatedAt:   t.At,
+		ExpiresAt:   t.At.Add(s.ttl),
+	}
+	if assessment != nil {
+		a.Risk, a.RiskReasons = assessment.Decision, assessment.Reasons
+	}
+	if err := s.approvals.StoreApproval(ctx, a); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return a, nil
+}
+
+// Approvals returns all transfers, which required approval, ordered by time of creation. Pending transfers after
+// expiry are reported as expired.
+func (s *service) Approvals(ctx context.Context) ([]*Approval, error) {
+	if s.approvals == nil {
+		return []*Approval{}, nil
+	}
+	aa, err := s.approvals.FindApprovals(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	for _, a := range aa {
+		if a.Status == ApprovalPending && !now.Before(a.ExpiresAt) {
+			a.Status = ApprovalExpired
+		}
+	}
+	return aa, nil
+}
+
+// decision returns pending transfer to be decided by principal of context. Expiry of transfer is stored, when
+// somebody tries to decide it late.
+func (s *service) decision(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	if s.approvals == nil {
+		return nil, errs.ErrUnknownApproval
+	}
+	decider := principal.FromContext(ctx)
+	if decider == "" {
+		return nil, errs.ErrUnauthorized
+	}
+	a, err := s.approvals.FindApproval(ctx, id)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if a.Status != ApprovalPending {
+		return nil, errs.ErrApprovalDecided
+	}
+	now := s.now().UTC()
+	if !now.Before(a.ExpiresAt) {
+		a.Status = ApprovalExpired
+		a.DecidedAt = &a.ExpiresAt
+		if err := s.approvals.Decide(ctx, a); err != nil && err != errs.ErrApprovalDecided {
+			return nil, contextError(ctx, err)
+		}
+		return nil, errs.ErrApprovalExpired
+	}
+	if a.RequestedBy == decider {
+		return nil, errs.ErrSelfApproval
+	}
+	a.DecidedBy = decider
+	a.DecidedAt = &now
+	return a, nil
+}
+
+// Approve registers completed payments of pending transfer. Transfer is checked again, as accounts could change
+// while it was pending, but its own reserved money is available. Risk rules are not evaluated again, the principal
+// decides instead of them, payments keep the decision of rules made at request.
+func (s *service) Approve(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	a, err := s.decision(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	t, err := s.check(ctx, a.FromAccount, a.Amount, a.ToAccount, a.Details(), a.Amount)
+	if err != nil {
+		return nil, err
+	}
+	a.Status = ApprovalApproved
+	payments := transfer(a.FromAccount, a.Amount, a.ToAccount, a.Details(), a.CreatedAt)
+	for _, val := range payments {
+		_ = val.Transition(StatusCompleted, "", *a.DecidedAt)
+	}
+	a.Assessment().apply(payments)
+	err = s.approvals.Decide(ctx, a, payments...)
+	if err == errs.ErrInsufficientMoney || err == errs.ErrApprovalDecided {
+		return nil, err
+	}
+	if err != nil {
+		return nil, contextError(ctx, errs.ErrStorePayments)
+	}
+	t.Payments, t.Approval = payments, a.ID
+	s.observe(t)
+	return a, nil
+}
+
+// Reject cancels pending transfer and releases its reserved money.
+func (s *service) Reject(ctx context.Context, id uuid.UUID) (*Approval, error) {
+	a, err := s.decision(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	a.Status = ApprovalRejected
+	if err := s.approvals.Decide(ctx, a); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return a, nil
+}
+
+// Reverse returns money of completed transfer to its source account. Targets of transfer must have its money
+// available, money reserved for their pending transfers is not.
+func (s *service) Reverse(ctx context.Context, transfer uuid.UUID) ([]*Payment, error) {
+	payments, err := s.payments.Search(ctx, Filter{Transfer: transfer})
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if len(payments) == 0 {
+		return nil, errs.ErrUnknownTransfer
+	}
+	for _, val := range payments {
+		if !CanTransition(val.CurrentStatus(), StatusReversed) {
+			return nil, errs.ErrStatusTransition
+		}
+		if val.Direction != Incoming {
+			continue
+		}
+		to, err := s.accounts.Find(ctx, val.Account)
+		if err != nil {
+			return nil, contextError(ctx, errs.ErrUnknownTargetAccount)
+		}
+		available, err := s.available(ctx, to)
+		if err != nil {
+			return nil, err
+		}
+		if available.LessThan(val.Amount) {
+			return nil, errs.ErrInsufficientMoney
+		}
+	}
+	now := s.now().UTC()
+	err = s.payments.Transition(ctx, transfer, StatusReversed, "", now)
+	if err == errs.ErrInsufficientMoney || err == errs.ErrStatusTransition {
+		// Transfer could be reversed or its money spent concurrently.
+		return nil, err
+	}
+	if err != nil {
+		return nil, contextError(ctx, errs.ErrStorePayments)
+	}
+	for _, val := range payments {
+		_ = val.Transition(StatusReversed, "", now)
+	}
+	return payments, nil
 }
 
 // NewService creates a payment service with necessary dependencies.
-func NewService(payments Repository, accounts account.Repository) Service {
-	return &service{
+func NewService(payments Repository, accounts account.Repository, options ...ServiceOption) Service {
+	s := &service{
 		payments: payments,
 		accounts: accounts,
+		rounding: account.RoundReject,
+		now:      time.Now,
+	}
+	for _, option := range options {
+		option(s)
 	}
+	return s
 }
 
 // Repository interface for payment storing and operations.
 type Repository interface {
-	// Store payments in the repository.
-	Store(payment ...*Payment) error
+	// Store payments in the repository. Completed payments change balances of their accounts, payments in other
+	// statuses do not.
+	Store(ctx context.Context, payment ...*Payment) error
+
+	// Transition changes status of all payments of transfer at once, reason is kept for failed ones. Completion
+	// changes balances of accounts by payments, reversal returns the money. Unknown transfer is reported with
+	// errs.ErrUnknownTransfer, not allowed change with errs.ErrStatusTransition.
+	Transition(ctx context.Context, transfer uuid.UUID, to Status, reason string, at time.Time) error
+
+	// Find payments list for an account, in order of storing.
+	Find(ctx context.Context, id account.ID) ([]*Payment, error)
 
-	// Find payments list for an account.
-	Find(id account.ID) []*Payment
+	// FindAll returns all payments, registered in the system, in order of storing.
+	FindAll(ctx context.Context) ([]*Payment, error)
 
-	// FindAll returns all payments, registered in the system.
-	FindAll() []*Payment
+	// Search returns not deleted payments, which satisfy filter, in order of storing.
+	Search(ctx context.Context, filter Filter) ([]*Payment, error)
 
-	// MarkDeleted is mark as deleted specified payment in the system
-	MarkDeleted(id uuid.UUID) error
+	// MarkDeleted is mark as deleted specified payment in the system. Unknown or already deleted payment is
+	// reported with errs.ErrUnknownPayment.
+	MarkDeleted(ctx context.Context, id uuid.UUID) error
 }
@@ -1,7 +1,9 @@
 package payment_test
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -10,15 +12,24 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/principal"
 
+	"github.com/asaskevich/govalidator"
 	"github.com/go-kit/kit/log"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
 	"github.com/otetz/payments/inmem"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/shopspring/decimal"
 )
 
@@ -51,6 +62,26 @@ const (
 	EndpointURL = "/api/payments/v1/payments"
 )
 
+func problem(status int, code errs.Code, title, detail string, invalidParams ...CaseResponse) CaseResponse {
+	p := CaseResponse{
+		"type":   errs.ProblemTypePrefix + string(code),
+		"title":  title,
+		"status": status,
+		"code":   code,
+	}
+	if detail != "" {
+		p["detail"] = detail
+	}
+	if len(invalidParams) > 0 {
+		p["invalid_params"] = invalidParams
+	}
+	return p
+}
+
+func invalidParam(name, rule, reason string) CaseResponse {
+	return CaseResponse{"name": name, "rule": rule, "reason": reason}
+}
+
 func TestPaymentApi(t *testing.T) {
 	logger := log.NewLogfmtLogger(os.Stderr)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
@@ -62,17 +93,27 @@ func TestPaymentApi(t *testing.T) {
 
 	handler := payment.MakeHandler(ps, httpLogger)
 
-	_ = accounts.Store(&account.Account{ID: "test1", Balance: decimal.NewFromFloat(1000.0), Currency: "USD"})
-	_ = accounts.Store(&account.Account{ID: "test2", Currency: "USD"})
+	ctx := context.Background()
+	_ = accounts.Store(ctx, &account.Account{ID: "test1", Balance: decimal.NewFromFloat(1000.0), Currency: "USD"})
+	_ = accounts.Store(ctx, &account.Account{ID: "test2", Currency: "USD"})
+	_ = accounts.Store(ctx, &account.Account{ID: "frozen", Balance: decimal.NewFromFloat(100), Currency: "USD",
+		Status: account.StatusFrozen})
+	limit := decimal.NewFromFloat(10)
+	_ = accounts.Store(ctx, &account.Account{ID: "limited", Balance: decimal.NewFromFloat(100), Currency: "USD",
+		TransferLimit: &limit})
+	treeLimit := decimal.NewFromFloat(50)
+	_ = accounts.Store(ctx, &account.Account{ID: "parent", Balance: decimal.NewFromFloat(100), Currency: "USD",
+		TransferLimit: &limit, TreeTransferLimit: &treeLimit})
+	_ = accounts.Store(ctx, &account.Account{ID: "child", Currency: "USD", Parent: "parent"})
 
-	_ = payments.Store(&payment.Payment{
+	_ = payments.Store(ctx, &payment.Payment{
 		ID:        uuid.New(),
 		Account:   "test1",
 		Amount:    decimal.NewFromFloat(55.55),
 		ToAccount: "test2",
 		Direction: payment.Outgoing,
 	})
-	_ = payments.Store(&payment.Payment{
+	_ = payments.Store(ctx, &payment.Payment{
 		ID:          uuid.New(),
 		Account:     "test2",
 		Amount:      decimal.NewFromFloat(55.55),
@@ -92,6 +133,7 @@ func TestPaymentApi(t *testing.T) {
 					"amount":     55.55,
 					"to_account": "test2",
 					"direction":  "outgoing",
+					"status":     "completed",
 				},
 			},
 		},
@@ -106,12 +148,14 @@ func TestPaymentApi(t *testing.T) {
 					"amount":     55.55,
 					"to_account": "test2",
 					"direction":  "outgoing",
+					"status":     "completed",
 				},
 				{
 					"account":      "test2",
 					"amount":       55.55,
 					"from_account": "test1",
 					"direction":    "incoming",
+					"status":       "completed",
 				},
 			},
 		},
@@ -136,8 +180,9 @@ func TestPaymentApi(t *testing.T) {
 				"amount": "33,33",
 				"to":     account.ID("test2"),
 			},
-			Status: http.StatusInternalServerError,
-			Result: CaseResponse{"error": "Error decoding string '33,33': can't convert 33,33 to decimal"},
+			Status: http.StatusBadRequest,
+			Result: problem(http.StatusBadRequest, errs.CodeMalformedRequest, "Malformed request",
+				"Error decoding string '33,33': can't convert 33,33 to decimal"),
 		},
 		{
 			Name:   "new payment:incorrect source account",
@@ -148,9 +193,9 @@ func TestPaymentApi(t *testing.T) {
 				"amount": decimal.NewFromFloat(33.33),
 				"to":     account.ID("test2"),
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "validation error: from: " + strings.Repeat("abcd1234", 33) +
-				" does not validate as stringlength(1|255)"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("from", "stringlength", "has wrong length")),
 		},
 		{
 			Name:   "new payment:incorrect target account",
@@ -161,9 +206,9 @@ func TestPaymentApi(t *testing.T) {
 				"amount": decimal.NewFromFloat(33.33),
 				"to":     account.ID(strings.Repeat("abcd1234", 33)),
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "validation error: to: " + strings.Repeat("abcd1234", 33) +
-				" does not validate as stringlength(1|255)"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("to", "stringlength", "has wrong length")),
 		},
 		{
 			Name:   "new payment:validate account",
@@ -174,8 +219,48 @@ func TestPaymentApi(t *testing.T) {
 				"amount": decimal.NewFromFloat(33.33),
 				"to":     account.ID("test2"),
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "validation error: from: test1фыва does not validate as alphanum"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("from", "alphanum", "must contain only latin letters and digits")),
+		},
+		{
+			Name:   "new payment:negative amount",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("test1"),
+				"amount": decimal.NewFromFloat(-1),
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("amount", "positive", "must be positive")),
+		},
+		{
+			Name:   "new payment:too many decimal places",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("test1"),
+				"amount": "0.001",
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("amount", "scale", "has too many decimal places for the currency")),
+		},
+		{
+			Name:   "new payment:amount too large",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("test1"),
+				"amount": "1000000000000",
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeValidation, "Request validation failed", "",
+				invalidParam("amount", "overflow", "is too large")),
 		},
 		{
 			Name:   "new payment:wrong source account",
@@ -187,7 +272,8 @@ func TestPaymentApi(t *testing.T) {
 				"to":     account.ID("test2"),
 			},
 			Status: http.StatusNotFound,
-			Result: CaseResponse{"error": "unknown source account"},
+			Result: problem(http.StatusNotFound, errs.CodeUnknownSourceAccount, "Unknown source account",
+				"unknown source account"),
 		},
 		{
 			Name:   "new payment:wrong target account",
@@ -199,7 +285,8 @@ func TestPaymentApi(t *testing.T) {
 				"to":     account.ID("test3"),
 			},
 			Status: http.StatusNotFound,
-			Result: CaseResponse{"error": "unknown target account"},
+			Result: problem(http.StatusNotFound, errs.CodeUnknownTargetAccount, "Unknown target account",
+				"unknown target account"),
 		},
 		{
 			Name:   "new payment:accounts the same",
@@ -210,8 +297,9 @@ func TestPaymentApi(t *testing.T) {
 				"amount": decimal.NewFromFloat(33.33),
 				"to":     account.ID("test1"),
 			},
-			Status: http.StatusNotAcceptable,
-			Result: CaseResponse{"error": "target account must not be equal to source account"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeAccountsAreEqual,
+				"Target account must not be equal to source account", "target account must not be equal to source account"),
 		},
 		{
 			Name:   "new payment:insufficient money",
@@ -222,8 +310,98 @@ func TestPaymentApi(t *testing.T) {
 				"amount": decimal.NewFromFloat(9999),
 				"to":     account.ID("test2"),
 			},
-			Status: http.StatusBadRequest,
-			Result: CaseResponse{"error": "insufficient money on source account"},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeInsufficientMoney,
+				"Insufficient money on source account", "insufficient money on source account"),
+		},
+		{
+			Name:   "new payment:frozen source account",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("frozen"),
+				"amount": decimal.NewFromFloat(1),
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeAccountFrozen, "Account is frozen",
+				"account is frozen"),
+		},
+		{
+			Name:   "new payment:frozen target account",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("test1"),
+				"amount": decimal.NewFromFloat(1),
+				"to":     account.ID("frozen"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeAccountFrozen, "Account is frozen",
+				"account is frozen"),
+		},
+		{
+			Name:   "new payment:transfer limit",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("limited"),
+				"amount": decimal.NewFromFloat(10.01),
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeTransferLimit, "Amount exceeds transfer limit",
+				"amount exceeds transfer limit of source account"),
+		},
+		{
+			Name:   "new payment:up to transfer limit",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("limited"),
+				"amount": decimal.NewFromFloat(10),
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusOK,
+			Result: CaseResponse{},
+		},
+		{
+			Name:   "new payment:tree transfer limit exceeded",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("parent"),
+				"amount": decimal.NewFromFloat(50.01),
+				"to":     account.ID("child"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeTransferLimit, "Amount exceeds transfer limit",
+				"amount exceeds transfer limit of source account"),
+		},
+		{
+			Name:   "new payment:tree transfer limit inside tree",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("parent"),
+				"amount": decimal.NewFromFloat(50),
+				"to":     account.ID("child"),
+			},
+			Status: http.StatusOK,
+			Result: CaseResponse{},
+		},
+		{
+			Name:   "new payment:transfer limit outside tree",
+			Path:   EndpointURL,
+			Method: http.MethodPost,
+			Payload: CaseRequestPayload{
+				"from":   account.ID("parent"),
+				"amount": decimal.NewFromFloat(20),
+				"to":     account.ID("test2"),
+			},
+			Status: http.StatusUnprocessableEntity,
+			Result: problem(http.StatusUnprocessableEntity, errs.CodeTransferLimit, "Amount exceeds transfer limit",
+				"amount exceeds transfer limit of source account"),
 		},
 	}
 
@@ -238,17 +416,95 @@ func TestPaymentApi(t *testing.T) {
 		rr := httptest.NewRecorder()
 		handler.ServeHTTP(rr, req)
 
-		if status := rr.Code; status != http.StatusInternalServerError {
+		if status := rr.Code; status != http.StatusBadRequest {
 			t.Errorf("handler returned wrong status code: got %v want %v", status,
-				http.StatusInternalServerError)
+				http.StatusBadRequest)
 		}
-		expectedBody := `{"error":"unexpected EOF"}`
+		expectedBody := `{"type":"urn:payments:problem:malformed_request","title":"Malformed request",` +
+			`"status":400,"detail":"unexpected EOF","code":"malformed_request"}`
 		if strings.TrimSpace(rr.Body.String()) != expectedBody {
 			t.Errorf("handler returned wrong body: got %v want %v", rr.Body.String(), expectedBody)
 		}
 	})
 }
 
+func TestCancelledRequest(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	ps := payment.NewService(payments, accounts)
+	handler := payment.MakeHandler(ps, log.NewNopLogger())
+
+	OK(t, accounts.Store(context.Background(), &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10)}))
+	OK(t, accounts.Store(context.Background(), &account.Account{ID: "bob"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(1), "bob", payment.Details{}); err != context.Canceled {
+		t.Errorf("cancelled request must not be stored: got %v", err)
+	}
+	if pp, _ := ps.LoadAll(context.Background()); len(pp) != 0 {
+		t.Errorf("no payments expected, got %d", len(pp))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -1)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodPost, EndpointURL,
+		strings.NewReader(`{"from": "alice", "amount": 1, "to": "bob"}`)).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("wrong status code: got %v want %v", rr.Code, http.StatusGatewayTimeout)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+
+	var (
+		counter   = stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "c"}, []string{"method"})
+		latency   = stdprometheus.NewHistogramVec(stdprometheus.HistogramOpts{Name: "l"}, []string{"method"})
+		transfers = stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "t"}, []string{"currency"})
+		value     = stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "v"}, []string{"currency"})
+		failures  = stdprometheus.NewCounterVec(stdprometheus.CounterOpts{Name: "f"}, []string{"reason"})
+	)
+	ps := payment.NewMetricsService(payment.Metrics{
+		RequestCount:   kitprometheus.NewCounter(counter),
+		RequestLatency: kitprometheus.NewHistogram(latency),
+		Transfers:      kitprometheus.NewCounter(transfers),
+		TransferValue:  kitprometheus.NewCounter(value),
+		Failures:       kitprometheus.NewCounter(failures),
+	}, accounts, payment.NewService(payments, accounts))
+
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(2.5), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(1.5), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = ps.New(ctx, "alice", decimal.NewFromFloat(1), "alice", payment.Details{})
+	_, _ = ps.New(ctx, "carol", decimal.NewFromFloat(1), "bob", payment.Details{})
+
+	for name, item := range map[string]struct {
+		Collector stdprometheus.Collector
+		Value     float64
+	}{
+		"requests":              {counter.WithLabelValues("new"), 4},
+		"transfers":             {transfers.WithLabelValues("USD"), 2},
+		"value":                 {value.WithLabelValues("USD"), 4},
+		"failures:equal":        {failures.WithLabelValues("accounts_are_equal"), 1},
+		"failures:unknown":      {failures.WithLabelValues("unknown_source_account"), 1},
+		"failures:insufficient": {failures.WithLabelValues("insufficient_money"), 0},
+	} {
+		if val := testutil.ToFloat64(item.Collector); val != item.Value {
+			t.Errorf("%s: got %v want %v", name, val, item.Value)
+		}
+	}
+}
+
 func runTests(t *testing.T, handler http.Handler, cases []Case, repository account.Repository) {
 	for idx, item := range cases {
 		idx := idx
@@ -321,7 +577,7 @@ func runTests(t *testing.T, handler http.Handler, cases []Case, repository accou
 
 				if item.CheckRepo {
 					payloadStruct := item.Payload.(CaseRequestPayload)
-					a, _ := repository.Find(payloadStruct["id"].(account.ID))
+					a, _ := repository.Find(context.Background(), payloadStruct["id"].(account.ID))
 					expectedAcc := &account.Account{
 						ID:       payloadStruct["id"].(account.ID),
 						Balance:  decimal.NewFromFloat(0.0),
@@ -342,3 +598,719 @@ func runTests(t *testing.T, handler http.Handler, cases []Case, repository accou
 		})
 	}
 }
+
+func TestPaymentMetadata(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	c := &clock{now: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)}
+	handler := payment.MakeHandler(payment.NewService(inmem.NewPaymentRepository(accounts), accounts,
+		payment.ServiceClock(c.Now)), log.NewNopLogger())
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+	// Transfers are identified by random ids.
+	ignoreTransfer := cmpopts.IgnoreMapEntries(func(k string, _ interface{}) bool { return k == "transfer" })
+
+	for _, item := range []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Result string
+	}{
+		{
+			Name:   "metadata:new payment",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body: `{"from":"alice","amount":2,"to":"bob","description":"March invoice","reference":"INV-7",
+				"metadata":{"invoice":"7"}}`,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "metadata:new payment without metadata",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body:   `{"from":"alice","amount":1,"to":"bob"}`,
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "metadata:validation",
+			Method: http.MethodPost,
+			Path:   EndpointURL,
+			Body: `{"from":"alice","amount":1,"to":"bob","reference":"` + strings.Repeat("x", 256) + `",
+				"metadata":{"":"1"}}`,
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"reference","rule":"stringlength","reason":"has wrong length"},
+				{"name":"metadata.","rule":"metadatakey",
+					"reason":"key must contain only latin letters, digits, '_', '-' and '.'"}]}`,
+		},
+		{
+			Name:   "metadata:filter all payments",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "?metadata.invoice=7",
+			Status: http.StatusOK,
+			Result: `[{"account":"alice","amount":2,"to_account":"bob","direction":"outgoing",
+				"description":"March invoice","reference":"INV-7","metadata":{"invoice":"7"},"status":"completed",
+				"created_at":"2026-03-01T10:00:00Z","completed_at":"2026-03-01T10:00:00Z"},
+				{"account":"bob","amount":2,"from_account":"alice","direction":"incoming",
+				"description":"March invoice","reference":"INV-7","metadata":{"invoice":"7"},"status":"completed",
+				"created_at":"2026-03-01T10:00:00Z","completed_at":"2026-03-01T10:00:00Z"}]`,
+		},
+		{
+			Name:   "metadata:filter payments of account",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "/bob?metadata.invoice=7",
+			Status: http.StatusOK,
+			Result: `[{"account":"bob","amount":2,"from_account":"alice","direction":"incoming",
+				"description":"March invoice","reference":"INV-7","metadata":{"invoice":"7"},"status":"completed",
+				"created_at":"2026-03-01T10:00:00Z","completed_at":"2026-03-01T10:00:00Z"}]`,
+		},
+		{
+			Name:   "metadata:filter by absent value",
+			Method: http.MethodGet,
+			Path:   EndpointURL + "/bob?metadata.invoice=8",
+			Status: http.StatusOK,
+			Result: `[]`,
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(item.Method, item.Path, strings.NewReader(item.Body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if item.Result == "" {
+				return
+			}
+			var got, want interface{}
+			OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			OK(t, json.Unmarshal([]byte(item.Result), &want))
+			if diff := cmp.Diff(want, got, ignoreTransfer); diff != "" {
+				t.Errorf("wrong body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	d := func(s string) *decimal.Decimal {
+		v := decimal.RequireFromString(s)
+		return &v
+	}
+	for _, item := range []struct {
+		Name     string
+		Amount   string
+		Shares   []payment.Share
+		Expected []string
+		Invalid  []string
+	}{
+		{
+			Name:     "amounts",
+			Amount:   "10",
+			Shares:   []payment.Share{{Account: "a", Amount: d("7.5")}, {Account: "b", Amount: d("2.5")}},
+			Expected: []string{"7.5", "2.5"},
+		},
+		{
+			Name:   "percents with remainder",
+			Amount: "100",
+			Shares: []payment.Share{{Account: "a", Percent: d("33.33")}, {Account: "b", Percent: d("33.34")},
+				{Account: "c", Percent: d("33.33")}},
+			Expected: []string{"33.33", "33.34", "33.33"},
+		},
+		{
+			Name:   "remainder goes to the largest rounded off parts, earlier first",
+			Amount: "0.1",
+			Shares: []payment.Share{{Account: "a", Percent: d("30")}, {Account: "b", Percent: d("35")},
+				{Account: "c", Percent: d("35")}},
+			Expected: []string{"0.03", "0.04", "0.03"},
+		},
+		{
+			Name:   "fixed amounts first, percents of the rest",
+			Amount: "101",
+			Shares: []payment.Share{{Account: "seller", Percent: d("90")}, {Account: "platform", Percent: d("10")},
+				{Account: "tax", Amount: d("1")}},
+			Expected: []string{"90", "10", "1"},
+		},
+		{
+			Name:   "thirds",
+			Amount: "10",
+			Shares: []payment.Share{{Account: "a", Percent: d("33.33")}, {Account: "b", Percent: d("33.33")},
+				{Account: "c", Percent: d("33.34")}},
+			Expected: []string{"3.33", "3.33", "3.34"},
+		},
+		{
+			Name:    "no shares",
+			Amount:  "10",
+			Invalid: []string{"to:required"},
+		},
+		{
+			Name:   "wrong shares",
+			Amount: "10",
+			Shares: []payment.Share{{Account: "a"}, {Account: "a", Amount: d("1"), Percent: d("1")},
+				{Account: "b", Amount: d("-1")}, {Account: "c", Percent: d("0")}},
+			Invalid: []string{"to[0]:share", "to[1].account:once", "to[1]:share", "to[2].amount:positive",
+				"to[3].percent:positive"},
+		},
+		{
+			Name:    "amounts do not add up",
+			Amount:  "10",
+			Shares:  []payment.Share{{Account: "a", Amount: d("7.5")}},
+			Invalid: []string{"to:total"},
+		},
+		{
+			Name:    "percents do not add up",
+			Amount:  "10",
+			Shares:  []payment.Share{{Account: "a", Percent: d("99")}},
+			Invalid: []string{"to:total"},
+		},
+		{
+			Name:    "too many decimal places",
+			Amount:  "10.001",
+			Shares:  []payment.Share{{Account: "a", Amount: d("5.005")}, {Account: "b", Percent: d("100")}},
+			Invalid: []string{"amount:scale", "to[0].amount:scale"},
+		},
+		{
+			Name:   "shares less than a unit",
+			Amount: "0.01",
+			Shares: []payment.Share{{Account: "a", Percent: d("33.33")}, {Account: "b", Percent: d("33.33")},
+				{Account: "c", Percent: d("33.34")}},
+			Invalid: []string{"to[0].percent:positive", "to[1].percent:positive"},
+		},
+		{
+			Name:    "nothing left for percents",
+			Amount:  "10",
+			Shares:  []payment.Share{{Account: "a", Amount: d("10")}, {Account: "b", Percent: d("100")}},
+			Invalid: []string{"to:total"},
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			splits, err := payment.Allocate(decimal.RequireFromString(item.Amount), item.Shares, account.CurrencyUSD,
+				account.RoundReject)
+			if item.Invalid != nil {
+				verr, ok := err.(errs.ValidationError)
+				if !ok {
+					t.Fatalf("validation error expected, got %v", err)
+				}
+				var got []string
+				for _, e := range verr.Err.(govalidator.Errors) {
+					ge := e.(govalidator.Error)
+					got = append(got, ge.Name+":"+ge.Validator)
+				}
+				if diff := cmp.Diff(item.Invalid, got); diff != "" {
+					t.Errorf("wrong problems (-want +got):\n%s", diff)
+				}
+				return
+			}
+			OK(t, err)
+			total := decimal.Zero
+			for i, val := range splits {
+				expected := decimal.RequireFromString(item.Expected[i])
+				if val.Account != item.Shares[i].Account || !val.Amount.Equal(expected) {
+					t.Errorf("%d: wrong split %s %s, want %s", i, val.Account, val.Amount, item.Expected[i])
+				}
+				total = total.Add(val.Amount)
+			}
+			if !total.Equal(decimal.RequireFromString(item.Amount)) {
+				t.Errorf("splits add up to %s", total)
+			}
+		})
+	}
+}
+
+func TestSplitPayment(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	handler := payment.MakeHandler(payment.NewService(payments, accounts), log.NewNopLogger())
+	ctx := context.Background()
+	limit := decimal.NewFromFloat(50)
+	OK(t, accounts.Store(ctx, &account.Account{ID: "buyer", Balance: decimal.NewFromFloat(100), Currency: "USD",
+		TransferLimit: &limit}))
+	for _, id := range []account.ID{"seller", "platform", "tax"} {
+		OK(t, accounts.Store(ctx, &account.Account{ID: id, Currency: "USD"}))
+	}
+	OK(t, accounts.Store(ctx, &account.Account{ID: "frozen", Currency: "USD", Status: account.StatusFrozen}))
+
+	for _, item := range []struct {
+		Name   string
+		Body   string
+		Status int
+		Result string
+	}{
+		{
+			Name: "split:normal flow",
+			Body: `{"from":"buyer","amount":21,"to":[{"account":"seller","percent":90},
+				{"account":"platform","percent":10},{"account":"tax","amount":1}],"reference":"ORDER-1"}`,
+			Status: http.StatusOK,
+			Result: `{"splits":[{"account":"seller","amount":18},{"account":"platform","amount":2},
+				{"account":"tax","amount":1}]}`,
+		},
+		{
+			Name:   "split:validation",
+			Body:   `{"from":"buyer","amount":10,"to":[{"account":"sel-ler","amount":10}]}`,
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"to[0].account","rule":"alphanum","reason":"must contain only latin letters and digits"}]}`,
+		},
+		{
+			Name:   "split:shares do not add up",
+			Body:   `{"from":"buyer","amount":10,"to":[{"account":"seller","amount":9}]}`,
+			Status: http.StatusUnprocessableEntity,
+			Result: `{"type":"urn:payments:problem:validation_failed","title":"Request validation failed","status":422,
+				"code":"validation_failed","invalid_params":[
+				{"name":"to","rule":"total","reason":"shares must add up to the amount"}]}`,
+		},
+		{
+			Name: "split:transfer limit of the total",
+			Body: `{"from":"buyer","amount":60,"to":[{"account":"seller","amount":30},
+				{"account":"tax","amount":30}]}`,
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name:   "split:source among targets",
+			Body:   `{"from":"buyer","amount":10,"to":[{"account":"buyer","amount":10}]}`,
+			Status: http.StatusUnprocessableEntity,
+		},
+		{
+			Name: "split:unknown target",
+			Body: `{"from":"buyer","amount":10,"to":[{"account":"seller","amount":5},
+				{"account":"nobody","amount":5}]}`,
+			Status: http.StatusNotFound,
+		},
+		{
+			Name:   "split:frozen target",
+			Body:   `{"from":"buyer","amount":10,"to":[{"account":"frozen","amount":10}]}`,
+			Status: http.StatusUnprocessableEntity,
+		},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/payments/v1/splits", strings.NewReader(item.Body))
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != item.Status {
+				t.Fatalf("wrong status code: got %v want %v: %s", rr.Code, item.Status, rr.Body)
+			}
+			if item.Result == "" {
+				return
+			}
+			var got, want interface{}
+			OK(t, json.Unmarshal(rr.Body.Bytes(), &got))
+			OK(t, json.Unmarshal([]byte(item.Result), &want))
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("wrong body (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The whole transfer is stored atomically as N+1 legs, balances are changed by all of them.
+	pp, err := payments.FindAll(ctx)
+	OK(t, err)
+	if len(pp) != 4 || len(pp[0].Splits) != 3 || pp[0].ToAccount != "" || pp[1].FromAccount != "buyer" {
+		t.Errorf("outgoing and 3 incoming legs expected, got %+v", pp)
+	}
+	for id, expected := range map[account.ID]float64{"buyer": 79, "seller": 18, "platform": 2, "tax": 1} {
+		a, err := accounts.Find(ctx, id)
+		OK(t, err)
+		if !a.Balance.Equal(decimal.NewFromFloat(expected)) {
+			t.Errorf("%s: wrong balance %s, want %v", id, a.Balance, expected)
+		}
+	}
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func TestApprovals(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	c := &clock{now: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)}
+	ps := payment.NewService(payments, accounts,
+		payment.ServiceApprovals(inmem.NewApprovalRepository(payments), decimal.NewFromFloat(100), time.Hour),
+		payment.ServiceClock(c.Now))
+	handler := payment.MakeHandler(ps, log.NewNopLogger())
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(500), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+
+	do := func(path, name, body string, status int, code errs.Code) map[string]interface{} {
+		t.Helper()
+		method := http.MethodPost
+		if body == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if name != "" {
+			req.Header.Set(principal.Header, name)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != status {
+			t.Fatalf("%s %s: wrong status code: got %v want %v: %s", method, path, rr.Code, status, rr.Body)
+		}
+		var result map[string]interface{}
+		OK(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		if code != "" && result["code"] != string(code) {
+			t.Fatalf("%s %s: wrong error: got %v want %v", method, path, result["code"], code)
+		}
+		return result
+	}
+	request := func(amount, status int, code errs.Code) string {
+		t.Helper()
+		result := do(EndpointURL, "maker", fmt.Sprintf(`{"from":"alice","amount":%d,"to":"bob"}`, amount),
+			status, code)
+		if status != http.StatusAccepted {
+			return ""
+		}
+		a := result["approval"].(map[string]interface{})
+		if a["status"] != "pending" || a["requested_by"] != "maker" || a["expires_at"] != "2026-03-01T11:00:00Z" {
+			t.Errorf("wrong pending transfer: %v", a)
+		}
+		return a["id"].(string)
+	}
+	decide := func(id, decision, name string, status int, code errs.Code) map[string]interface{} {
+		t.Helper()
+		return do("/api/payments/v1/approvals/"+id+"/"+decision, name, "{}", status, code)
+	}
+	checkBalances := func(expected map[account.ID]float64) {
+		t.Helper()
+		for id, balance := range expected {
+			a, err := accounts.Find(ctx, id)
+			OK(t, err)
+			if !a.Balance.Equal(decimal.NewFromFloat(balance)) {
+				t.Errorf("%s: wrong balance %s, want %v", id, a.Balance, balance)
+			}
+		}
+	}
+
+	// Transfer up to threshold is completed at once.
+	request(100, http.StatusOK, "")
+	do(EndpointURL, "", `{"from":"alice","amount":300,"to":"bob"}`, http.StatusUnauthorized, errs.CodeUnauthorized)
+	first := request(300, http.StatusAccepted, "")
+	checkBalances(map[account.ID]float64{"alice": 400, "bob": 100})
+
+	// Money of pending transfer is reserved.
+	request(150, http.StatusUnprocessableEntity, errs.CodeInsufficientMoney)
+	do("/api/payments/v1/splits", "maker", `{"from":"alice","amount":101,"to":[{"account":"bob","amount":101}]}`,
+		http.StatusUnprocessableEntity, errs.CodeApprovalRequired)
+
+	decide(first, "approve", "maker", http.StatusForbidden, errs.CodeSelfApproval)
+	decide(first, "reject", "maker", http.StatusForbidden, errs.CodeSelfApproval)
+	decide(first, "approve", "", http.StatusUnauthorized, errs.CodeUnauthorized)
+	decide(uuid.New().String(), "approve", "checker", http.StatusNotFound, errs.CodeUnknownApproval)
+	decide("unknown", "approve", "checker", http.StatusNotFound, errs.CodeUnknownApproval)
+	// Approval checks the transfer again, its own reserved money is available.
+	approved := decide(first, "approve", "checker", http.StatusOK, "")["approval"].(map[string]interface{})
+	if approved["status"] != "approved" || approved["decided_by"] != "checker" ||
+		approved["decided_at"] != "2026-03-01T10:00:00Z" {
+		t.Errorf("wrong approved transfer: %v", approved)
+	}
+	checkBalances(map[account.ID]float64{"alice": 100, "bob": 400})
+	decide(first, "approve", "checker", http.StatusConflict, errs.CodeApprovalDecided)
+	decide(first, "reject", "checker", http.StatusConflict, errs.CodeApprovalDecided)
+
+	// Transfer is checked before it becomes pending.
+	request(101, http.StatusUnprocessableEntity, errs.CodeInsufficientMoney)
+	OK(t, accounts.Store(ctx, &account.Account{ID: "carol", Balance: decimal.NewFromFloat(1000), Currency: "USD"}))
+	second := do(EndpointURL, "maker", `{"from":"carol","amount":500,"to":"bob"}`, http.StatusAccepted,
+		"")["approval"].(map[string]interface{})["id"].(string)
+	rejected := decide(second, "reject", "checker", http.StatusOK, "")["approval"].(map[string]interface{})
+	if rejected["status"] != "rejected" {
+		t.Errorf("wrong rejected transfer: %v", rejected)
+	}
+	checkBalances(map[account.ID]float64{"carol": 1000, "bob": 400})
+
+	// Expired transfer releases its money and can not be decided.
+	third := do(EndpointURL, "maker", `{"from":"carol","amount":1000,"to":"bob"}`, http.StatusAccepted,
+		"")["approval"].(map[string]interface{})["id"].(string)
+	do(EndpointURL, "maker", `{"from":"carol","amount":1,"to":"bob"}`, http.StatusUnprocessableEntity,
+		errs.CodeInsufficientMoney)
+	c.now = c.now.Add(time.Hour)
+	do(EndpointURL, "maker", `{"from":"carol","amount":1,"to":"bob"}`, http.StatusOK, "")
+	decide(third, "approve", "checker", http.StatusConflict, errs.CodeApprovalExpired)
+	decide(third, "approve", "checker", http.StatusConflict, errs.CodeApprovalDecided)
+
+	var statuses []string
+	for _, val := range do("/api/payments/v1/approvals", "", "", http.StatusOK, "")["approvals"].([]interface{}) {
+		statuses = append(statuses, val.(map[string]interface{})["status"].(string))
+	}
+	if fmt.Sprint(statuses) != "[approved rejected expired]" {
+		t.Errorf("wrong statuses of approvals: got %v", statuses)
+	}
+	checkBalances(map[account.ID]float64{"alice": 100, "bob": 401, "carol": 999})
+}
+
+// limitRule reviews transfers above review amount, denies ones above deny amount and counts completed transfers.
+type limitRule struct {
+	review, deny decimal.Decimal
+	observed     int
+}
+
+func (r *limitRule) Name() string { return "limit" }
+
+func (r *limitRule) Evaluate(_ context.Context, t *payment.Transfer) (payment.Decision, string, error) {
+	switch {
+	case t.Amount.GreaterThan(r.deny):
+		return payment.DecisionDeny, "too much", nil
+	case t.Amount.GreaterThan(r.review):
+		return payment.DecisionReview, "large", nil
+	}
+	return payment.DecisionAllow, "", nil
+}
+
+func (r *limitRule) Observe(*payment.Transfer) { r.observed++ }
+
+func TestRiskRules(t *testing.T) {
+	for _, approvals := range []bool{false, true} {
+		t.Run(fmt.Sprintf("approvals:%v", approvals), func(t *testing.T) {
+			accounts := inmem.NewAccountRepository()
+			payments := inmem.NewPaymentRepository(accounts)
+			rule := &limitRule{review: decimal.NewFromFloat(50), deny: decimal.NewFromFloat(100)}
+			options := []payment.ServiceOption{payment.ServiceRules(rule)}
+			if approvals {
+				options = append(options, payment.ServiceApprovals(inmem.NewApprovalRepository(payments),
+					decimal.NewFromFloat(1000), time.Hour))
+			}
+			handler := payment.MakeHandler(payment.NewService(payments, accounts, options...), log.NewNopLogger())
+			ctx := context.Background()
+			OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(500),
+				Currency: "USD"}))
+			OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+
+			do := func(path, name, body string, status int) map[string]interface{} {
+				t.Helper()
+				req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+				if name != "" {
+					req.Header.Set(principal.Header, name)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				if rr.Code != status {
+					t.Fatalf("%s: wrong status code: got %v want %v: %s", path, rr.Code, status, rr.Body)
+				}
+				var result map[string]interface{}
+				OK(t, json.Unmarshal(rr.Body.Bytes(), &result))
+				return result
+			}
+			last := func() *payment.Payment {
+				t.Helper()
+				pp, err := payments.Find(ctx, "bob")
+				OK(t, err)
+				return pp[len(pp)-1]
+			}
+
+			do(EndpointURL, "maker", `{"from":"alice","amount":10,"to":"bob"}`, http.StatusOK)
+			if p := last(); p.Risk != payment.DecisionAllow || p.RiskReasons != nil {
+				t.Errorf("wrong allowed payment: %+v", p)
+			}
+			result := do(EndpointURL, "maker", `{"from":"alice","amount":200,"to":"bob"}`,
+				http.StatusUnprocessableEntity)
+			if result["code"] != string(errs.CodeTransferDenied) {
+				t.Errorf("wrong error of denied transfer: %v", result)
+			}
+
+			// Dry run scores transfer without registering it.
+			result = do("/api/payments/v1/assessments", "", `{"from":"alice","amount":200,"to":"bob"}`,
+				http.StatusOK)
+			if diff := cmp.Diff(map[string]interface{}{"decision": "deny", "reasons": []interface{}{
+				"limit: too much"}}, result["assessment"]); diff != "" {
+				t.Errorf("wrong assessment (-want +got):\n%s", diff)
+			}
+			do("/api/payments/v1/assessments", "", `{"from":"alice","amount":10,"to":"carol"}`, http.StatusNotFound)
+
+			if !approvals {
+				do(EndpointURL, "maker", `{"from":"alice","amount":60,"to":"bob"}`, http.StatusOK)
+				if p := last(); p.Risk != payment.DecisionReview || fmt.Sprint(p.RiskReasons) != "[limit: large]" {
+					t.Errorf("wrong reviewed payment: %+v", p)
+				}
+				do("/api/payments/v1/splits", "maker", `{"from":"alice","amount":60,"to":[{"account":"bob",
+					"percent":100}]}`, http.StatusOK)
+				if p := last(); p.Risk != payment.DecisionReview {
+					t.Errorf("wrong reviewed payment of split transfer: %+v", p)
+				}
+				if rule.observed != 3 {
+					t.Errorf("wrong number of observed transfers: got %d want 3", rule.observed)
+				}
+				return
+			}
+
+			// Transfer under review is held for approval below threshold.
+			a := do(EndpointURL, "maker", `{"from":"alice","amount":60,"to":"bob"}`,
+				http.StatusAccepted)["approval"].(map[string]interface{})
+			if a["risk"] != "review" || fmt.Sprint(a["risk_reasons"]) != "[limit: large]" {
+				t.Errorf("wrong pending transfer: %v", a)
+			}
+			result = do("/api/payments/v1/splits", "maker", `{"from":"alice","amount":60,"to":[{"account":"bob",
+				"percent":100}]}`, http.StatusUnprocessableEntity)
+			if result["code"] != string(errs.CodeApprovalRequired) {
+				t.Errorf("wrong error of split transfer under review: %v", result)
+			}
+			do("/api/payments/v1/approvals/"+a["id"].(string)+"/approve", "checker", "{}", http.StatusOK)
+			if p := last(); p.Risk != payment.DecisionReview || fmt.Sprint(p.RiskReasons) != "[limit: large]" {
+				t.Errorf("wrong approved payment: %+v", p)
+			}
+			if rule.observed != 2 {
+				t.Errorf("wrong number of observed transfers: got %d want 2", rule.observed)
+			}
+		})
+	}
+}
+
+// unavailableRepository stores payments, but can not complete them.
+type unavailableRepository struct {
+	payment.Repository
+}
+
+func (r unavailableRepository) Transition(ctx context.Context, transfer uuid.UUID, to payment.Status, reason string,
+	at time.Time) error {
+	if to == payment.StatusCompleted {
+		return errors.New("storage is unavailable")
+	}
+	return r.Repository.Transition(ctx, transfer, to, reason, at)
+}
+
+func TestTransferLifecycle(t *testing.T) {
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	c := &clock{now: time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)}
+	ps := payment.NewService(payments, accounts, payment.ServiceClock(c.Now))
+	handler := payment.MakeHandler(ps, log.NewNopLogger())
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+	balances := func(alice, bob float64) {
+		t.Helper()
+		for id, expected := range map[account.ID]float64{"alice": alice, "bob": bob} {
+			a, err := accounts.Find(ctx, id)
+			OK(t, err)
+			if !a.Balance.Equal(decimal.NewFromFloat(expected)) {
+				t.Errorf("%s: wrong balance %s, want %v", id, a.Balance, expected)
+			}
+		}
+	}
+	do := func(method, path string, status int) []byte {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		if rr.Code != status {
+			t.Fatalf("%s %s: wrong status code: got %v want %v: %s", method, path, rr.Code, status, rr.Body)
+		}
+		return rr.Body.Bytes()
+	}
+
+	// Registered transfer is completed at once.
+	_, err := ps.New(ctx, "alice", decimal.NewFromFloat(4), "bob", payment.Details{})
+	OK(t, err)
+	balances(6, 4)
+	pp, err := ps.Search(ctx, payment.Filter{Status: payment.StatusCompleted})
+	OK(t, err)
+	if len(pp) != 2 || pp[0].Transfer == uuid.Nil || pp[0].Transfer != pp[1].Transfer ||
+		!pp[0].CreatedAt.Equal(c.now) || pp[0].CompletedAt == nil {
+		t.Fatalf("completed payments of transfer expected, got %+v", pp)
+	}
+	completed := pp[0].Transfer
+
+	// Transfer, which can not be completed, is stored as failed attempt with the reason.
+	failing := payment.NewService(unavailableRepository{payments}, accounts, payment.ServiceClock(c.Now))
+	if _, err := failing.New(ctx, "alice", decimal.NewFromFloat(1), "bob", payment.Details{}); err !=
+		errs.ErrStorePayments {
+		t.Errorf("wrong error of failed transfer: %v", err)
+	}
+	balances(6, 4)
+	var failed []*payment.Payment
+	OK(t, json.Unmarshal(do(http.MethodGet, EndpointURL+"/alice?status=failed", http.StatusOK), &failed))
+	if len(failed) != 1 || failed[0].Status != payment.StatusFailed ||
+		failed[0].FailureReason != "storage is unavailable" || failed[0].FailedAt == nil {
+		t.Errorf("failed payment expected, got %+v", failed)
+	}
+	if pp, _ := ps.LoadAll(ctx); len(pp) != 4 {
+		t.Errorf("payments of completed and failed transfers expected, got %d", len(pp))
+	}
+	do(http.MethodGet, EndpointURL+"?status=lost", http.StatusUnprocessableEntity)
+	do(http.MethodPost, "/api/payments/v1/transfers/"+failed[0].Transfer.String()+"/reversal", http.StatusConflict)
+
+	// Reversal returns money, only if target still has it.
+	_, err = ps.New(ctx, "bob", decimal.NewFromFloat(3), "alice", payment.Details{})
+	OK(t, err)
+	balances(9, 1)
+	do(http.MethodPost, "/api/payments/v1/transfers/"+completed.String()+"/reversal", http.StatusUnprocessableEntity)
+	_, err = ps.New(ctx, "alice", decimal.NewFromFloat(3), "bob", payment.Details{})
+	OK(t, err)
+	var reversal struct {
+		Payments []*payment.Payment `json:"payments"`
+	}
+	OK(t, json.Unmarshal(do(http.MethodPost, "/api/payments/v1/transfers/"+completed.String()+"/reversal",
+		http.StatusOK), &reversal))
+	balances(10, 0)
+	if len(reversal.Payments) != 2 || reversal.Payments[0].Status != payment.StatusReversed ||
+		reversal.Payments[0].ReversedAt == nil {
+		t.Errorf("reversed payments expected, got %+v", reversal.Payments)
+	}
+	do(http.MethodPost, "/api/payments/v1/transfers/"+completed.String()+"/reversal", http.StatusConflict)
+	do(http.MethodPost, "/api/payments/v1/transfers/"+uuid.New().String()+"/reversal", http.StatusNotFound)
+	do(http.MethodPost, "/api/payments/v1/transfers/42/reversal", http.StatusNotFound)
+	if pp, _ := ps.Search(ctx, payment.Filter{Status: payment.StatusReversed}); len(pp) != 2 {
+		t.Errorf("reversed payments expected, got %d", len(pp))
+	}
+}
+
+func TestConcurrentTransfers(t *testing.T) {
+	const transfers = 25
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	ps := payment.NewService(payments, accounts)
+	ctx := context.Background()
+	OK(t, accounts.Store(ctx, &account.Account{ID: "alice", Balance: decimal.NewFromFloat(10), Currency: "USD"}))
+	OK(t, accounts.Store(ctx, &account.Account{ID: "bob", Currency: "USD"}))
+
+	// Transfers pass the check of service together, but only ten of them are completed by repository.
+	var wg sync.WaitGroup
+	results := make(chan error, transfers)
+	for i := 0; i < transfers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := ps.New(ctx, "alice", decimal.NewFromFloat(1), "bob", payment.Details{})
+			results <- err
+		}()
+	}
+	wg.Wait()
+	close(results)
+	for err := range results {
+		if err != nil && err != errs.ErrInsufficientMoney {
+			t.Errorf("wrong error of rejected transfer: %v", err)
+		}
+	}
+	for id, expected := range map[account.ID]float64{"alice": 0, "bob": 10} {
+		a, err := accounts.Find(ctx, id)
+		OK(t, err)
+		if !a.Balance.Equal(decimal.NewFromFloat(expected)) {
+			t.Errorf("%s: wrong balance %s, want %v", id, a.Balance, expected)
+		}
+	}
+	pp, err := ps.LoadAll(ctx)
+	OK(t, err)
+	completed := 0
+	for _, val := range pp {
+		switch val.CurrentStatus() {
+		case payment.StatusCompleted:
+			completed++
+		case payment.StatusFailed:
+			if val.FailureReason != errs.ErrInsufficientMoney.Error() {
+				t.Errorf("wrong failure reason: %q", val.FailureReason)
+			}
+		default:
+			t.Errorf("transfer must be completed or failed, got %+v", val)
+		}
+	}
+	if completed != 20 {
+		t.Errorf("payments of ten transfers must be completed, got %d", completed)
+	}
+}
@@ -0,0 +1,137 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/shopspring/decimal"
+)
+
+// MaxShares is a maximum number of target accounts of a split transfer.
+const MaxShares = 20
+
+// Share is a requested part of a split transfer, which goes to one target account. Exactly one of Amount and
+// Percent is set, percent is of the amount left after fixed shares.
+type Share struct {
+	Account account.ID
+	Amount  *decimal.Decimal
+	Percent *decimal.Decimal
+}
+
+// Split is a resolved part of a split transfer, outgoing payment of the transfer keeps all of them.
+type Split struct {
+	Account account.ID      `json:"account"`
+	Amount  decimal.Decimal `json:"amount"`
+}
+
+// Allocate resolves shares of amount in currency. Amounts are checked and rounded by rounding. Fixed
+// amounts are taken first, the rest is divided by percentages, which must add up to 100. Percent shares are rounded
+// down to scale of currency, the remainder is given by the smallest units to shares with the largest rounded off
+// parts, earlier shares first on ties. So the same request is always split the same way, and splits add up to amount
+// exactly. Shares left without a single unit of currency are rejected, as payments must have positive amounts.
+func Allocate(amount decimal.Decimal, shares []Share, currency account.Currency, rounding account.Rounding) ([]Split,
+	error) {
+	var problems govalidator.Errors
+	invalid := func(name, validator, message string) {
+		problems = append(problems, govalidator.Error{Name: name, Validator: validator, Err: errors.New(message)})
+	}
+	// check returns amount rounded by rounding, its problems are added to the others.
+	check := func(name string, amount decimal.Decimal) decimal.Decimal {
+		rounded, err := rounding.CheckAmount(name, amount, currency)
+		if err != nil {
+			problems = append(problems, err.(errs.ValidationError).Err.(govalidator.Errors)...)
+		}
+		return rounded
+	}
+	switch {
+	case len(shares) == 0:
+		invalid("to", "required", "at least one target account is required")
+	case len(shares) > MaxShares:
+		invalid("to", "maxitems", fmt.Sprintf("up to %d target accounts are allowed", MaxShares))
+	}
+	amount = check("amount", amount)
+
+	fixed, percents := decimal.Zero, decimal.Zero
+	hasPercents := false
+	amounts := make([]decimal.Decimal, len(shares))
+	seen := make(map[account.ID]bool, len(shares))
+	for i, s := range shares {
+		name := fmt.Sprintf("to[%d]", i)
+		if seen[s.Account] {
+			invalid(name+".account", "once", "target account must be given once")
+		}
+		seen[s.Account] = true
+		switch {
+		case (s.Amount == nil) == (s.Percent == nil):
+			invalid(name, "share", "either amount or percent must be given")
+		case s.Amount != nil:
+			amounts[i] = check(name+".amount", *s.Amount)
+			fixed = fixed.Add(amounts[i])
+		case s.Percent.Sign() <= 0:
+			invalid(name+".percent", "positive", "must be positive")
+		default:
+			percents, hasPercents = percents.Add(*s.Percent), true
+		}
+	}
+	if len(problems) == 0 {
+		switch {
+		case hasPercents && !percents.Equal(decimal.New(100, 0)):
+			invalid("to", "total", "percents must add up to 100")
+		case hasPercents && !fixed.LessThan(amount):
+			invalid("to", "total", "fixed amounts must be less than amount")
+		case !hasPercents && !fixed.Equal(amount):
+			invalid("to", "total", "amounts must add up to amount")
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errs.ValidationError{Err: problems}
+	}
+
+	scale := currency.Scale()
+	splits := make([]Split, len(shares))
+	rest := amount.Sub(fixed)
+	left := rest
+	var rounded []int
+	for i, s := range shares {
+		splits[i].Account = s.Account
+		if s.Amount != nil {
+			splits[i].Amount = amounts[i]
+			continue
+		}
+		splits[i].Amount = rest.Mul(*s.Percent).Div(decimal.New(100, 0)).Truncate(scale)
+		left = left.Sub(splits[i].Amount)
+		rounded = append(rounded, i)
+	}
+
+	// Rounded off parts are compared exactly, without division.
+	off := func(i int) decimal.Decimal {
+		return rest.Mul(*shares[i].Percent).Sub(splits[i].Amount.Mul(decimal.New(100, 0)))
+	}
+	sort.SliceStable(rounded, func(a, b int) bool { return off(rounded[a]).GreaterThan(off(rounded[b])) })
+	unit := decimal.New(1, -scale)
+	for _, i := range rounded {
+		if left.LessThan(unit) {
+			break
+		}
+		splits[i].Amount = splits[i].Amount.Add(unit)
+		left = left.Sub(unit)
+	}
+	// Amount with more places than currency has leaves less than a unit, share with the largest rounded off part
+	// takes it.
+	if left.Sign() > 0 {
+		splits[rounded[0]].Amount = splits[rounded[0]].Amount.Add(left)
+	}
+	for i := range splits {
+		if splits[i].Amount.Sign() == 0 {
+			invalid(fmt.Sprintf("to[%d].percent", i), "positive", "share of amount must be positive")
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errs.ValidationError{Err: problems}
+	}
+	return splits, nil
+}
@@ -0,0 +1,93 @@
+package payment
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+)
+
+// Status of payment in lifecycle of its transfer, all payments of transfer have the same status.
+type Status string
+
+const (
+	// StatusPending payment is registered, but does not change balance of its account yet.
+	StatusPending Status = "pending"
+	// StatusCompleted payment changes balance of its account.
+	StatusCompleted Status = "completed"
+	// StatusFailed payment could not be completed, FailureReason explains why. It never changes balance.
+	StatusFailed Status = "failed"
+	// StatusReversed payment was completed, then its money was returned, so it does not change balance anymore.
+	StatusReversed Status = "reversed"
+)
+
+// Statuses are all statuses of payments.
+var Statuses = []Status{StatusPending, StatusCompleted, StatusFailed, StatusReversed}
+
+// transitions are allowed changes of status, failed and reversed statuses are final.
+var transitions = map[Status][]Status{
+	StatusPending:   {StatusCompleted, StatusFailed},
+	StatusCompleted: {StatusReversed},
+}
+
+// CanTransition reports whether payment in status from may change it to status to.
+func CanTransition(from, to Status) bool {
+	for _, val := range transitions[from] {
+		if val == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentStatus returns status of payment. Payments stored before statuses were introduced have none, they are
+// completed.
+func (p *Payment) CurrentStatus() Status {
+	if p.Status == "" {
+		return StatusCompleted
+	}
+	return p.Status
+}
+
+// Completed reports whether payment changes balance of its account.
+func (p *Payment) Completed() bool {
+	return p.CurrentStatus() == StatusCompleted
+}
+
+// Transition changes status of payment and records time of the change, reason is kept for failed payment. It fails
+// with errs.ErrStatusTransition, if the current status does not allow the change.
+func (p *Payment) Transition(to Status, reason string, at time.Time) error {
+	if !CanTransition(p.CurrentStatus(), to) {
+		return errs.ErrStatusTransition
+	}
+	p.Status = to
+	switch to {
+	case StatusCompleted:
+		p.CompletedAt = &at
+	case StatusFailed:
+		p.FailedAt, p.FailureReason = &at, reason
+	case StatusReversed:
+		p.ReversedAt = &at
+	}
+	return nil
+}
+
+// MarshalJSON encodes payment with its current status. Payments stored before statuses were introduced have no
+// transfer and time of creation, so they are omitted.
+func (p Payment) MarshalJSON() ([]byte, error) {
+	type plain Payment
+	c := struct {
+		plain
+		Status    Status     `json:"status"`
+		Transfer  *uuid.UUID `json:"transfer,omitempty"`
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+	}{plain: plain(p), Status: p.CurrentStatus()}
+	if p.Transfer != uuid.Nil {
+		c.Transfer = &p.Transfer
+	}
+	if !p.CreatedAt.IsZero() {
+		c.CreatedAt = &p.CreatedAt
+	}
+	return json.Marshal(c)
+}
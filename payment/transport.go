@@ -3,51 +3,104 @@ package payment
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/otetz/payments/account"
 	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/principal"
+	"github.com/otetz/payments/tracing"
 
 	kitlog "github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/transport"
 	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
 // MakeHandler returns a handler for the payment service.
 func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
 	opts := []kithttp.ServerOption{
-		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
 		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext, principal.PopulateRequestContext),
 	}
 
 	newPaymentHandler := kithttp.NewServer(
-		makeNewPaymentEndpoint(s),
+		tracing.EndpointMiddleware("payment.new")(makeNewPaymentEndpoint(s)),
 		decodeNewPaymentRequest,
+		encodeNewPaymentResponse,
+		opts...,
+	)
+
+	newSplitHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("payment.split")(makeNewSplitEndpoint(s)),
+		decodeNewSplitRequest,
 		errs.EncodeResponse,
 		opts...,
 	)
 
 	loadPaymentsHandler := kithttp.NewServer(
-		makeLoadPaymentsEndpoint(s),
+		tracing.EndpointMiddleware("payment.load")(makeLoadPaymentsEndpoint(s)),
 		decodeLoadPaymentsRequest,
 		errs.EncodeResponse,
 		opts...,
 	)
 
 	loadAllPaymentsHandler := kithttp.NewServer(
-		makeLoadAllPaymentsEndpoint(s),
+		tracing.EndpointMiddleware("payment.loadAll")(makeLoadAllPaymentsEndpoint(s)),
 		decodeLoadAllPaymentsRequest,
 		errs.EncodeResponse,
 		opts...,
 	)
 
+	assessHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("payment.assess")(makeAssessEndpoint(s)),
+		decodeNewPaymentRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	approvalsHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("payment.approvals")(makeApprovalsEndpoint(s)),
+		decodeApprovalsRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	approveHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("payment.approve")(makeApproveEndpoint(s)),
+		decodeApprovalIDRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	rejectHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("payment.reject")(makeRejectEndpoint(s)),
+		decodeApprovalIDRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	reverseHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("payment.reverse")(makeReverseEndpoint(s)),
+		decodeTransferIDRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
 	router := mux.NewRouter()
 
 	router.Handle("/api/payments/v1/payments", newPaymentHandler).Methods("POST")
 	router.Handle("/api/payments/v1/payments", loadAllPaymentsHandler).Methods("GET")
 	router.Handle("/api/payments/v1/payments/{id}", loadPaymentsHandler).Methods("GET")
+	router.Handle("/api/payments/v1/splits", newSplitHandler).Methods("POST")
+	router.Handle("/api/payments/v1/assessments", assessHandler).Methods("POST")
+	router.Handle("/api/payments/v1/approvals", approvalsHandler).Methods("GET")
+	router.Handle("/api/payments/v1/approvals/{id}/approve", approveHandler).Methods("POST")
+	router.Handle("/api/payments/v1/approvals/{id}/reject", rejectHandler).Methods("POST")
+	router.Handle("/api/payments/v1/transfers/{id}/reversal", reverseHandler).Methods("POST")
 
 	return router
 }
@@ -55,10 +108,56 @@ func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
 func decodeNewPaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
 	var body newPaymentRequest
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		return nil, err
+		return nil, errs.MalformedRequestError{Err: err}
 	}
+	var problems govalidator.Errors
 	if _, err := govalidator.ValidateStruct(body); err != nil {
-		return nil, errs.ValidationError{Err: err}
+		problems = append(problems, err.(govalidator.Errors)...)
+	}
+	if err := metadata.Validate(body.Metadata); err != nil {
+		problems = append(problems, err.(govalidator.Errors)...)
+	}
+	if len(problems) > 0 {
+		return nil, errs.ValidationError{Err: problems}
+	}
+	return body, nil
+}
+
+// encodeNewPaymentResponse responds with 202 Accepted, if transfer is pending until approval.
+func encodeNewPaymentResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if r, ok := response.(approvalResponse); ok && r.Err == nil && r.Approval != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		return json.NewEncoder(w).Encode(response)
+	}
+	return errs.EncodeResponse(ctx, w, response)
+}
+
+func decodeNewSplitRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body newSplitRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+	var problems govalidator.Errors
+	if _, err := govalidator.ValidateStruct(body); err != nil {
+		problems = append(problems, err.(govalidator.Errors)...)
+	}
+	for i, val := range body.To {
+		if _, err := govalidator.ValidateStruct(val); err != nil {
+			for _, e := range err.(govalidator.Errors) {
+				if ve, ok := e.(govalidator.Error); ok {
+					ve.Name = fmt.Sprintf("to[%d].%s", i, ve.Name)
+					e = ve
+				}
+				problems = append(problems, e)
+			}
+		}
+	}
+	if err := metadata.Validate(body.Metadata); err != nil {
+		problems = append(problems, err.(govalidator.Errors)...)
+	}
+	if len(problems) > 0 {
+		return nil, errs.ValidationError{Err: problems}
 	}
 	return body, nil
 }
@@ -69,9 +168,71 @@ func decodeLoadPaymentsRequest(_ context.Context, r *http.Request) (interface{},
 	if !ok {
 		return nil, errs.ErrBadRoute
 	}
-	return loadPaymentsRequest{AccountID: account.ID(id)}, nil
+	req, err := decodeFilter(r)
+	if err != nil {
+		return nil, err
+	}
+	req.AccountID = account.ID(id)
+	return req, nil
+}
+
+func decodeLoadAllPaymentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return decodeFilter(r)
+}
+
+// decodeFilter returns request with filters of list from query: metadata and status.
+func decodeFilter(r *http.Request) (loadPaymentsRequest, error) {
+	query := r.URL.Query()
+	m, err := metadata.FromQuery(query)
+	if err != nil {
+		return loadPaymentsRequest{}, errs.ValidationError{Err: err}
+	}
+	status := Status(query.Get("status"))
+	if status != "" && !validStatus(status) {
+		return loadPaymentsRequest{}, errs.ValidationError{Err: govalidator.Errors{govalidator.Error{
+			Name:      "status",
+			Err:       fmt.Errorf("%q is not one of pending, completed, failed, reversed", status),
+			Validator: "in",
+		}}}
+	}
+	return loadPaymentsRequest{Metadata: m, Status: status}, nil
+}
+
+func validStatus(status Status) bool {
+	for _, val := range Statuses {
+		if val == status {
+			return true
+		}
+	}
+	return false
 }
 
-func decodeLoadAllPaymentsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+func decodeApprovalsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
 	return nil, nil
 }
+
+func decodeApprovalIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errs.ErrUnknownApproval
+	}
+	return approvalIDField{ID: uid}, nil
+}
+
+func decodeTransferIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errs.ErrUnknownTransfer
+	}
+	return transferIDField{ID: uid}, nil
+}
@@ -0,0 +1,66 @@
+// Package principal identifies operators, who make requests, by X-Principal header. The header must be set by an
+// authenticating proxy in front of the service, which proves itself with a shared secret in X-Principal-Secret
+// header and drops both headers of client.
+package principal
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/otetz/payments/header"
+)
+
+// Header carries name of authenticated principal in requests.
+const Header = "X-Principal"
+
+// SecretHeader carries secret shared with the trusted proxy, which sets Header.
+const SecretHeader = "X-Principal-Secret"
+
+// MaxLength is a maximal length of principal name.
+const MaxLength = 255
+
+type contextKey struct{}
+
+// NewContext returns context with principal name.
+func NewContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext returns principal name stored in context, or empty string for anonymous request.
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
+
+// Valid reports whether principal name is safe to be logged and stored: not empty, not too long, and consists of
+// printable ASCII characters only.
+func Valid(name string) bool {
+	return header.Valid(name, MaxLength)
+}
+
+// Trusted returns middleware, which keeps Header only in requests with SecretHeader equal to secret, so principal
+// can not be set by client bypassing the proxy. Both headers are removed from other requests, all of them are
+// anonymous if secret is empty. SecretHeader is removed always, so it is not passed further.
+func Trusted(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got := r.Header.Get(SecretHeader)
+			if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+				r.Header.Del(Header)
+			}
+			r.Header.Del(SecretHeader)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PopulateRequestContext stores principal name from request header in context, if it is valid. Otherwise request
+// is anonymous. It fits kithttp.ServerBefore.
+func PopulateRequestContext(ctx context.Context, r *http.Request) context.Context {
+	name := r.Header.Get(Header)
+	if !Valid(name) {
+		return ctx
+	}
+	return NewContext(ctx, name)
+}
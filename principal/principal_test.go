@@ -0,0 +1,78 @@
+package principal_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otetz/payments/principal"
+)
+
+func TestPopulateRequestContext(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Header   string
+		Accepted bool
+	}{
+		{"accepted", "alice@example.com", true},
+		{"anonymous", "", false},
+		{"too long", strings.Repeat("x", principal.MaxLength+1), false},
+		{"not printable", "alice bob", false},
+	}
+
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if item.Header != "" {
+				r.Header.Set(principal.Header, item.Header)
+			}
+			seen := principal.FromContext(principal.PopulateRequestContext(context.Background(), r))
+
+			if item.Accepted && seen != item.Header || !item.Accepted && seen != "" {
+				t.Errorf("wrong principal: got %q, header %q", seen, item.Header)
+			}
+		})
+	}
+}
+
+func TestTrusted(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Secret   string
+		Header   string
+		Accepted bool
+	}{
+		{"trusted proxy", "s3cret", "s3cret", true},
+		{"wrong secret", "s3cret", "guess", false},
+		{"no secret", "s3cret", "", false},
+		{"not configured", "", "", false},
+	}
+
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			var seen, secret string
+			handler := principal.Trusted(item.Secret)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = principal.FromContext(principal.PopulateRequestContext(r.Context(), r))
+				secret = r.Header.Get(principal.SecretHeader)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set(principal.Header, "alice@example.com")
+			if item.Header != "" {
+				r.Header.Set(principal.SecretHeader, item.Header)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if (seen == "alice@example.com") != item.Accepted || !item.Accepted && seen != "" {
+				t.Errorf("wrong principal: got %q", seen)
+			}
+			if secret != "" {
+				t.Errorf("secret must not be passed further, got %q", secret)
+			}
+		})
+	}
+}
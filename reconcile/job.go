@@ -0,0 +1,108 @@
+package reconcile
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// Metrics are instruments of reconciliation job.
+type Metrics struct {
+	// Discrepancies is a number of discrepancies found by the last run, labelled by check.
+	Discrepancies metrics.Gauge
+	// LastRun is a Unix time of the last successful run.
+	LastRun metrics.Gauge
+}
+
+// Job periodically reconciles the ledger, logs discrepancies and reports their number as metrics.
+type Job struct {
+	repository Repository
+	metrics    Metrics
+	logger     log.Logger
+	interval   time.Duration
+	timeout    time.Duration
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// JobOption sets an optional parameter for job.
+type JobOption func(*Job)
+
+// JobInterval sets pause between runs.
+func JobInterval(d time.Duration) JobOption {
+	return func(j *Job) { j.interval = d }
+}
+
+// JobTimeout sets deadline of a run.
+func JobTimeout(d time.Duration) JobOption {
+	return func(j *Job) { j.timeout = d }
+}
+
+// NewJob returns a new reconciliation job. Call Start to begin runs.
+func NewJob(repository Repository, m Metrics, logger log.Logger, options ...JobOption) *Job {
+	j := &Job{
+		repository: repository,
+		metrics:    m,
+		logger:     logger,
+		interval:   time.Hour,
+		timeout:    time.Minute,
+		quit:       make(chan struct{}),
+	}
+	for _, option := range options {
+		option(j)
+	}
+	return j
+}
+
+// Start runs reconciliation in background, the first run is immediate.
+func (j *Job) Start() {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			_, _ = j.Run()
+			select {
+			case <-j.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop terminates runs and waits for the current one to finish.
+func (j *Job) Stop() {
+	j.once.Do(func() { close(j.quit) })
+	j.wg.Wait()
+}
+
+// Run reconciles the ledger once. Metrics are not changed, if reconciliation failed.
+func (j *Job) Run() (*Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+
+	begin := time.Now()
+	report, err := Reconcile(ctx, j.repository)
+	if err != nil {
+		_ = j.logger.Log("method", "reconcile", "err", err)
+		return nil, err
+	}
+	for _, d := range report.Discrepancies {
+		_ = j.logger.Log("check", d.Check, "account", d.Account, "currency", d.Currency, "payment", d.Payment,
+			"expected", d.Expected, "actual", d.Actual, "detail", d.Detail)
+	}
+	for check, n := range report.Count() {
+		j.metrics.Discrepancies.With("check", string(check)).Set(float64(n))
+	}
+	j.metrics.LastRun.Set(float64(report.CheckedAt.Unix()))
+	_ = j.logger.Log("method", "reconcile", "accounts", report.Accounts, "payments", report.Payments,
+		"discrepancies", len(report.Discrepancies), "took", time.Since(begin))
+	return report, nil
+}
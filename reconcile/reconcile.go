@@ -0,0 +1,243 @@
+// Package reconcile verifies invariants of the ledger: both legs of every transfer are stored, money is neither
+// created nor lost by payments, and balances are not negative.
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// Check is a kind of verified invariant.
+type Check string
+
+const (
+	// UnmatchedPayment is a payment without the opposite leg of its transfer.
+	UnmatchedPayment Check = "unmatched_payment"
+	// AccountBalance is an account, which balance differs from its initial balance changed by its payments.
+	AccountBalance Check = "account_balance"
+	// MoneyTotal is a difference between total of balances and total of initial balances of all accounts in a
+	// currency, changed by payments with purged accounts.
+	MoneyTotal Check = "money_total"
+	// NegativeBalance is an account with balance below zero.
+	NegativeBalance Check = "negative_balance"
+)
+
+// Checks are all verified invariants.
+var Checks = []Check{UnmatchedPayment, AccountBalance, MoneyTotal, NegativeBalance}
+
+// Discrepancy is a violated invariant.
+type Discrepancy struct {
+	Check    Check            `json:"check"`
+	Account  account.ID       `json:"account,omitempty"`
+	Currency account.Currency `json:"currency,omitempty"`
+	Payment  string           `json:"payment,omitempty"`
+	Expected *decimal.Decimal `json:"expected,omitempty"`
+	Actual   *decimal.Decimal `json:"actual,omitempty"`
+	Detail   string           `json:"detail"`
+}
+
+// Total is money of accounts in one currency: sums of their initial balances and balances, and money moved by
+// payments with purged accounts.
+type Total struct {
+	Currency account.Currency `json:"currency"`
+	Initial  decimal.Decimal  `json:"initial"`
+	Balance  decimal.Decimal  `json:"balance"`
+	Purged   decimal.Decimal  `json:"purged"`
+}
+
+// Report is a result of reconciliation.
+type Report struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Accounts  int       `json:"accounts"`
+	Payments  int       `json:"payments"`
+	// Totals are ordered by currency, money of different currencies is never added up.
+	Totals        []*Total       `json:"totals"`
+	Discrepancies []*Discrepancy `json:"discrepancies"`
+}
+
+// Total returns total of currency, zero if ledger has no money in it.
+func (r *Report) Total(currency account.Currency) Total {
+	for _, val := range r.Totals {
+		if val.Currency == currency {
+			return *val
+		}
+	}
+	return Total{Currency: currency}
+}
+
+// Count returns number of discrepancies by checks, all checks are present.
+func (r *Report) Count() map[Check]int {
+	count := make(map[Check]int, len(Checks))
+	for _, c := range Checks {
+		count[c] = 0
+	}
+	for _, d := range r.Discrepancies {
+		count[d.Check]++
+	}
+	return count
+}
+
+// Repository provides the whole ledger for reconciliation.
+type Repository interface {
+	// Ledger returns all accounts and payments, deleted ones too, as they are at one point in time. Accounts have
+	// initial balances.
+	Ledger(ctx context.Context) ([]*account.Account, []*payment.Payment, error)
+}
+
+func amount(d decimal.Decimal) *decimal.Decimal {
+	return &d
+}
+
+// transfer identifies legs of the same transfer.
+type transfer struct {
+	from, to account.ID
+	amount   string
+}
+
+// Reconcile verifies invariants of the ledger. Deleted accounts and payments are verified too, deletion does not
+// return money. Outgoing payment of split transfer is paired with incoming payments by its splits. Purged accounts
+// are erased with their payments, so the other legs of their transfers have no pairs, money they moved is counted in
+// Purged of total. Payments, which are not completed, do not move money, so they are skipped.
+func Reconcile(ctx context.Context, repository Repository) (*Report, error) {
+	accounts, payments, err := repository.Ledger(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &Report{
+		CheckedAt:     time.Now().UTC(),
+		Accounts:      len(accounts),
+		Payments:      len(payments),
+		Totals:        make([]*Total, 0),
+		Discrepancies: make([]*Discrepancy, 0),
+	}
+	totals := make(map[account.Currency]*Total)
+	total := func(currency account.Currency) *Total {
+		t, ok := totals[currency]
+		if !ok {
+			t = &Total{Currency: currency}
+			totals[currency] = t
+			report.Totals = append(report.Totals, t)
+		}
+		return t
+	}
+
+	// Outgoing and incoming legs are paired by accounts and amount, the rest are unmatched.
+	known := make(map[account.ID]*account.Account, len(accounts))
+	for _, a := range accounts {
+		known[a.ID] = a
+	}
+	legs := make(map[transfer][]*payment.Payment)
+	pair := func(p *payment.Payment) {
+		t := transfer{from: p.Account, to: p.ToAccount, amount: p.Amount.String()}
+		effect, other := p.Amount.Neg(), p.ToAccount
+		if p.Direction == payment.Incoming {
+			t.from, t.to, effect, other = p.FromAccount, p.Account, p.Amount, p.FromAccount
+		}
+		if known[other] == nil {
+			// Payment is in currency of its own account, which is not purged.
+			var currency account.Currency
+			if a := known[p.Account]; a != nil {
+				currency = a.Currency
+			}
+			t := total(currency)
+			t.Purged = t.Purged.Add(effect)
+			return
+		}
+
+		if pending := legs[t]; len(pending) > 0 && pending[0].Direction != p.Direction {
+			legs[t] = pending[1:]
+			return
+		}
+		legs[t] = append(legs[t], p)
+	}
+	effects := make(map[account.ID]decimal.Decimal)
+	for _, p := range payments {
+		if !p.Completed() {
+			continue
+		}
+		effect := p.Amount.Neg()
+		if p.Direction == payment.Incoming {
+			effect = p.Amount
+		}
+		effects[p.Account] = effects[p.Account].Add(effect)
+		if len(p.Splits) == 0 {
+			pair(p)
+			continue
+		}
+		// Outgoing leg of split transfer is paired part by part with incoming legs of its targets.
+		for _, val := range p.Splits {
+			part := *p
+			part.Amount, part.ToAccount, part.Splits = val.Amount, val.Account, nil
+			pair(&part)
+		}
+	}
+	var unmatched []*payment.Payment
+	for _, pending := range legs {
+		unmatched = append(unmatched, pending...)
+	}
+	sort.Slice(unmatched, func(i, j int) bool {
+		if unmatched[i].ID != unmatched[j].ID {
+			return unmatched[i].ID.String() < unmatched[j].ID.String()
+		}
+		// Parts of split transfer have the same id.
+		return unmatched[i].ToAccount < unmatched[j].ToAccount
+	})
+	for _, p := range unmatched {
+		opposite := payment.Incoming
+		if p.Direction == payment.Incoming {
+			opposite = payment.Outgoing
+		}
+		report.Discrepancies = append(report.Discrepancies, &Discrepancy{
+			Check:   UnmatchedPayment,
+			Account: p.Account,
+			Payment: p.ID.String(),
+			Actual:  amount(p.Amount),
+			Detail:  fmt.Sprintf("%s payment has no %s leg", p.Direction, opposite),
+		})
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].ID < accounts[j].ID })
+	for _, a := range accounts {
+		t := total(a.Currency)
+		t.Initial = t.Initial.Add(a.InitialBalance)
+		t.Balance = t.Balance.Add(a.Balance)
+
+		expected := a.InitialBalance.Add(effects[a.ID])
+		if !expected.Equal(a.Balance) {
+			report.Discrepancies = append(report.Discrepancies, &Discrepancy{
+				Check:    AccountBalance,
+				Account:  a.ID,
+				Expected: amount(expected),
+				Actual:   amount(a.Balance),
+				Detail:   "balance differs from initial balance changed by payments",
+			})
+		}
+		if a.Balance.Sign() < 0 {
+			report.Discrepancies = append(report.Discrepancies, &Discrepancy{
+				Check:   NegativeBalance,
+				Account: a.ID,
+				Actual:  amount(a.Balance),
+				Detail:  "balance is negative",
+			})
+		}
+	}
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Currency < report.Totals[j].Currency })
+	for _, t := range report.Totals {
+		if expected := t.Initial.Add(t.Purged); !expected.Equal(t.Balance) {
+			report.Discrepancies = append(report.Discrepancies, &Discrepancy{
+				Check:    MoneyTotal,
+				Currency: t.Currency,
+				Expected: amount(expected),
+				Actual:   amount(t.Balance),
+				Detail:   "total of balances differs from total of initial balances",
+			})
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,243 @@
+package reconcile_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type ledger struct {
+	accounts []*account.Account
+	payments []*payment.Payment
+	err      error
+}
+
+func (l *ledger) Ledger(context.Context) ([]*account.Account, []*payment.Payment, error) {
+	return l.accounts, l.payments, l.err
+}
+
+// gauge remembers the last value by label values.
+type gauge struct {
+	mtx    *sync.Mutex
+	values map[string]float64
+	label  string
+}
+
+func newGauge() *gauge {
+	return &gauge{mtx: &sync.Mutex{}, values: make(map[string]float64)}
+}
+
+func (g *gauge) With(labelValues ...string) metrics.Gauge {
+	c := *g
+	c.label = labelValues[len(labelValues)-1]
+	return &c
+}
+
+func (g *gauge) Set(value float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.values[g.label] = value
+}
+
+func (g *gauge) Add(delta float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.values[g.label] += delta
+}
+
+func newAccount(id account.ID, initial, balance float64) *account.Account {
+	return &account.Account{
+		ID:             id,
+		Balance:        decimal.NewFromFloat(balance),
+		InitialBalance: decimal.NewFromFloat(initial),
+		Currency:       account.CurrencyUSD,
+	}
+}
+
+func newPayment(id string, acc account.ID, direction payment.Direction, amount float64,
+	other account.ID) *payment.Payment {
+	p := &payment.Payment{
+		ID:        uuid.MustParse(id),
+		Account:   acc,
+		Direction: direction,
+		Amount:    decimal.NewFromFloat(amount),
+	}
+	if direction == payment.Outgoing {
+		p.ToAccount = other
+	} else {
+		p.FromAccount = other
+	}
+	return p
+}
+
+func TestReconcile(t *testing.T) {
+	l := &ledger{
+		accounts: []*account.Account{
+			newAccount("carol", 0, 2),
+			newAccount("alice", 10, 7),
+			newAccount("bob", 0, -1),
+		},
+		payments: []*payment.Payment{
+			// Two equal transfers are matched pairwise, whatever the order of legs.
+			newPayment("00000000-0000-0000-0000-000000000001", "alice", payment.Outgoing, 1, "carol"),
+			newPayment("00000000-0000-0000-0000-000000000002", "alice", payment.Outgoing, 1, "carol"),
+			newPayment("00000000-0000-0000-0000-000000000003", "carol", payment.Incoming, 1, "alice"),
+			newPayment("00000000-0000-0000-0000-000000000004", "carol", payment.Incoming, 1, "alice"),
+			// Incoming leg is lost, so money is lost too.
+			newPayment("00000000-0000-0000-0000-000000000005", "alice", payment.Outgoing, 1, "bob"),
+		},
+	}
+	report, err := reconcile.Reconcile(context.Background(), l)
+	OK(t, err)
+	data, err := json.Marshal(report)
+	OK(t, err)
+	var got, want map[string]interface{}
+	OK(t, json.Unmarshal(data, &got))
+	if _, ok := got["checked_at"]; !ok {
+		t.Errorf("checked_at is missing: %s", data)
+	}
+	delete(got, "checked_at")
+	OK(t, json.Unmarshal([]byte(`{"accounts":3,"payments":5,
+		"totals":[{"currency":"USD","initial":10,"balance":8,"purged":0}],"discrepancies":[
+		{"check":"unmatched_payment","account":"alice","payment":"00000000-0000-0000-0000-000000000005",
+			"actual":1,"detail":"outgoing payment has no incoming leg"},
+		{"check":"account_balance","account":"bob","expected":0,"actual":-1,
+			"detail":"balance differs from initial balance changed by payments"},
+		{"check":"negative_balance","account":"bob","actual":-1,"detail":"balance is negative"},
+		{"check":"money_total","currency":"USD","expected":10,"actual":8,
+			"detail":"total of balances differs from total of initial balances"}]}`), &want))
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("wrong report (-want +got):\n%s", diff)
+	}
+}
+
+func TestReconcilePurged(t *testing.T) {
+	// Dave is purged with his legs, money he sent and received stays in balances of the others.
+	l := &ledger{
+		accounts: []*account.Account{newAccount("alice", 10, 12), newAccount("bob", 0, 1)},
+		payments: []*payment.Payment{
+			newPayment("00000000-0000-0000-0000-000000000001", "alice", payment.Incoming, 5, "dave"),
+			newPayment("00000000-0000-0000-0000-000000000002", "alice", payment.Outgoing, 2, "dave"),
+			newPayment("00000000-0000-0000-0000-000000000003", "alice", payment.Outgoing, 1, "bob"),
+			newPayment("00000000-0000-0000-0000-000000000004", "bob", payment.Incoming, 1, "alice"),
+		},
+	}
+	report, err := reconcile.Reconcile(context.Background(), l)
+	OK(t, err)
+	if len(report.Discrepancies) != 0 {
+		data, _ := json.Marshal(report.Discrepancies)
+		t.Errorf("unexpected discrepancies: %s", data)
+	}
+	if purged := report.Total(account.CurrencyUSD).Purged; !purged.Equal(decimal.NewFromFloat(3)) {
+		t.Errorf("wrong purged total %s", purged)
+	}
+}
+
+func TestReconcileSplit(t *testing.T) {
+	// Alice splits 7 among bob, carol and purged dave, incoming leg of carol is lost.
+	split := newPayment("00000000-0000-0000-0000-000000000001", "alice", payment.Outgoing, 7, "")
+	split.Splits = []payment.Split{
+		{Account: "bob", Amount: decimal.NewFromFloat(2)},
+		{Account: "carol", Amount: decimal.NewFromFloat(4)},
+		{Account: "dave", Amount: decimal.NewFromFloat(1)},
+	}
+	l := &ledger{
+		accounts: []*account.Account{newAccount("alice", 10, 3), newAccount("bob", 0, 2), newAccount("carol", 0, 0)},
+		payments: []*payment.Payment{
+			split,
+			newPayment("00000000-0000-0000-0000-000000000002", "bob", payment.Incoming, 2, "alice"),
+		},
+	}
+	report, err := reconcile.Reconcile(context.Background(), l)
+	OK(t, err)
+	expected := map[reconcile.Check]int{
+		reconcile.UnmatchedPayment: 1,
+		reconcile.AccountBalance:   0,
+		reconcile.MoneyTotal:       1,
+		reconcile.NegativeBalance:  0,
+	}
+	if diff := cmp.Diff(expected, report.Count()); diff != "" {
+		t.Errorf("wrong discrepancies (-want +got):\n%s", diff)
+	}
+	if d := report.Discrepancies[0]; d.Account != "alice" || !d.Actual.Equal(decimal.NewFromFloat(4)) {
+		t.Errorf("part of carol must be unmatched, got %+v", d)
+	}
+	if purged := report.Total(account.CurrencyUSD).Purged; !purged.Equal(decimal.NewFromFloat(-1)) {
+		t.Errorf("wrong purged total %s", purged)
+	}
+}
+
+func TestReconcileCurrencies(t *testing.T) {
+	erin := newAccount("erin", 5, 8)
+	erin.Currency = "EUR"
+	l := &ledger{accounts: []*account.Account{newAccount("alice", 10, 10), erin}}
+	report, err := reconcile.Reconcile(context.Background(), l)
+	OK(t, err)
+	want := []*reconcile.Total{
+		{Currency: "EUR", Initial: decimal.NewFromFloat(5), Balance: decimal.NewFromFloat(8)},
+		{Currency: "USD", Initial: decimal.NewFromFloat(10), Balance: decimal.NewFromFloat(10)},
+	}
+	if diff := cmp.Diff(want, report.Totals, cmp.Comparer(decimal.Decimal.Equal)); diff != "" {
+		t.Errorf("wrong totals (-want +got):\n%s", diff)
+	}
+	// Money of one currency does not cover another one.
+	if n := report.Count()[reconcile.MoneyTotal]; n != 1 {
+		t.Fatalf("wrong money_total discrepancies: %d", n)
+	}
+	if d := report.Discrepancies[len(report.Discrepancies)-1]; d.Check != reconcile.MoneyTotal || d.Currency != "EUR" {
+		t.Errorf("money_total of EUR expected, got %+v", d)
+	}
+}
+
+func TestJob(t *testing.T) {
+	discrepancies, lastRun := newGauge(), newGauge()
+	l := &ledger{accounts: []*account.Account{newAccount("alice", 1, -1)}}
+	job := reconcile.NewJob(l, reconcile.Metrics{Discrepancies: discrepancies, LastRun: lastRun},
+		log.NewNopLogger())
+
+	report, err := job.Run()
+	OK(t, err)
+	expected := map[string]float64{
+		"unmatched_payment": 0,
+		"account_balance":   1,
+		"money_total":       1,
+		"negative_balance":  1,
+	}
+	if diff := cmp.Diff(expected, discrepancies.values); diff != "" {
+		t.Errorf("wrong discrepancies metric (-want +got):\n%s", diff)
+	}
+	if lastRun.values[""] != float64(report.CheckedAt.Unix()) {
+		t.Errorf("wrong last run: got %v want %v", lastRun.values[""], report.CheckedAt.Unix())
+	}
+
+	// Failed run keeps metrics of the previous one.
+	l.err = errors.New("connection refused")
+	if _, err := job.Run(); err != l.err {
+		t.Errorf("wrong error: got %v want %v", err, l.err)
+	}
+	if discrepancies.values["money_total"] != 1 {
+		t.Errorf("metrics must not change on failure, got %v", discrepancies.values)
+	}
+
+	job.Start()
+	job.Stop()
+	job.Stop()
+}
@@ -0,0 +1,155 @@
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// ApprovalFactory returns new empty repositories, approval one stores payments of approved transfers to the others.
+type ApprovalFactory func(t *testing.T) (account.Repository, payment.Repository, payment.ApprovalRepository)
+
+// RunApprovals runs conformance tests of approval repository, each one with new repositories.
+func RunApprovals(t *testing.T, newRepositories ApprovalFactory) {
+	for _, item := range []struct {
+		Name string
+		Test func(t *testing.T, accounts account.Repository, payments payment.Repository,
+			r payment.ApprovalRepository)
+	}{
+		{"approvals:store", testApprovalStore},
+		{"approvals:reserved", testApprovalReserved},
+		{"approvals:decide", testApprovalDecide},
+	} {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			accounts, payments, r := newRepositories(t)
+			item.Test(t, accounts, payments, r)
+		})
+	}
+}
+
+func newApproval(from account.ID, amount float64, to account.ID, created time.Time) *payment.Approval {
+	return &payment.Approval{
+		ID:          uuid.New(),
+		FromAccount: from,
+		Amount:      decimal.NewFromFloat(amount),
+		ToAccount:   to,
+		Status:      payment.ApprovalPending,
+		RequestedBy: "maker",
+		CreatedAt:   created,
+		ExpiresAt:   created.Add(24 * time.Hour),
+	}
+}
+
+func testApprovalStore(t *testing.T, _ account.Repository, _ payment.Repository, r payment.ApprovalRepository) {
+	ctx := context.Background()
+	later := newApproval("alice", 5, "bob", date(1, 2))
+	first := newApproval("bob", 7.5, "alice", date(1, 1))
+	first.Description = "invoice"
+	first.Metadata = metadata.Metadata{"order": "42"}
+	first.Risk, first.RiskReasons = payment.DecisionReview, []string{"large_amount: amount 7.5 is above 5"}
+	ok(t, r.StoreApproval(ctx, later))
+	ok(t, r.StoreApproval(ctx, first))
+
+	a, err := r.FindApproval(ctx, first.ID)
+	ok(t, err)
+	if a.FromAccount != "bob" || !a.Amount.Equal(decimal.NewFromFloat(7.5)) || a.ToAccount != "alice" ||
+		a.Status != payment.ApprovalPending || a.RequestedBy != "maker" || a.Description != "invoice" ||
+		a.Metadata["order"] != "42" || !a.ExpiresAt.Equal(date(1, 2)) || a.DecidedAt != nil ||
+		a.Risk != payment.DecisionReview || len(a.RiskReasons) != 1 {
+		t.Errorf("wrong approval: %+v", a)
+	}
+	_, err = r.FindApproval(ctx, uuid.New())
+	expect(t, err, errs.ErrUnknownApproval)
+
+	aa, err := r.FindApprovals(ctx)
+	ok(t, err)
+	var got []uuid.UUID
+	for _, val := range aa {
+		got = append(got, val.ID)
+	}
+	if fmt.Sprint(got) != fmt.Sprint([]uuid.UUID{first.ID, later.ID}) {
+		t.Errorf("wrong approvals, they must be ordered by time of creation: got %v", got)
+	}
+}
+
+func testApprovalReserved(t *testing.T, _ account.Repository, _ payment.Repository, r payment.ApprovalRepository) {
+	ctx := context.Background()
+	ok(t, r.StoreApproval(ctx, newApproval("alice", 5, "bob", date(1, 1))))
+	ok(t, r.StoreApproval(ctx, newApproval("alice", 2.5, "carol", date(1, 2))))
+	ok(t, r.StoreApproval(ctx, newApproval("bob", 100, "alice", date(1, 1))))
+	rejected := newApproval("alice", 10, "bob", date(1, 1))
+	ok(t, r.StoreApproval(ctx, rejected))
+	rejected.Status = payment.ApprovalRejected
+	ok(t, r.Decide(ctx, rejected))
+
+	for _, item := range []struct {
+		Account  account.ID
+		Now      time.Time
+		Expected float64
+	}{
+		{"alice", date(1, 1), 7.5},
+		// Transfer expires at the end of its day, the other one is still pending.
+		{"alice", date(1, 2), 2.5},
+		{"alice", date(1, 3), 0},
+		{"bob", date(1, 1), 100},
+		{"carol", date(1, 1), 0},
+	} {
+		reserved, err := r.Reserved(ctx, item.Account, item.Now)
+		ok(t, err)
+		if !reserved.Equal(decimal.NewFromFloat(item.Expected)) {
+			t.Errorf("%s at %s: wrong reserved amount: got %s want %v", item.Account, item.Now, reserved,
+				item.Expected)
+		}
+	}
+}
+
+func testApprovalDecide(t *testing.T, accounts account.Repository, payments payment.Repository,
+	r payment.ApprovalRepository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	a := newApproval("alice", 7.5, "bob", date(1, 1))
+	ok(t, r.StoreApproval(ctx, a))
+
+	// Approval is not stored, if payments are not.
+	a.Status, a.DecidedBy = payment.ApprovalApproved, "checker"
+	expect(t, r.Decide(ctx, a, transfer("alice", 7.5, "unknown")...), errs.ErrUnknownAccount)
+	stored, err := r.FindApproval(ctx, a.ID)
+	ok(t, err)
+	if stored.Status != payment.ApprovalPending || stored.DecidedBy != "" {
+		t.Errorf("approval must stay pending: %+v", stored)
+	}
+
+	decided := date(1, 1).Add(time.Hour)
+	a.DecidedAt = &decided
+	ok(t, r.Decide(ctx, a, transfer("alice", 7.5, "bob")...))
+	checkBalance(t, accounts, "alice", 2.5)
+	checkBalance(t, accounts, "bob", 7.5)
+	pp, err := payments.Find(ctx, "bob")
+	ok(t, err)
+	if len(pp) != 1 {
+		t.Errorf("wrong number of payments: got %d want 1", len(pp))
+	}
+	stored, err = r.FindApproval(ctx, a.ID)
+	ok(t, err)
+	if stored.Status != payment.ApprovalApproved || stored.DecidedBy != "checker" || stored.DecidedAt == nil ||
+		!stored.DecidedAt.Equal(decided) {
+		t.Errorf("wrong decided approval: %+v", stored)
+	}
+
+	// Transfer is decided only once.
+	a.Status = payment.ApprovalRejected
+	expect(t, r.Decide(ctx, a), errs.ErrApprovalDecided)
+	expect(t, r.Decide(ctx, a, transfer("alice", 7.5, "bob")...), errs.ErrApprovalDecided)
+	checkBalance(t, accounts, "bob", 7.5)
+	expect(t, r.Decide(ctx, newApproval("alice", 1, "bob", date(1, 1))), errs.ErrUnknownApproval)
+}
@@ -0,0 +1,197 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+)
+
+// BulkFactory returns new empty repositories, bulk one imports to and exports from the others.
+type BulkFactory func(t *testing.T) (account.Repository, payment.Repository, bulk.Repository)
+
+// RunBulk runs conformance tests of bulk repository, each one with new repositories.
+func RunBulk(t *testing.T, newRepositories BulkFactory) {
+	for _, item := range []struct {
+		Name string
+		Test func(t *testing.T, accounts account.Repository, payments payment.Repository, b bulk.Repository)
+	}{
+		{"bulk:import accounts", testImportAccounts},
+		{"bulk:import payments", testImportPayments},
+		{"bulk:export", testExport},
+		{"bulk:fields", testBulkFields},
+	} {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			accounts, payments, b := newRepositories(t)
+			item.Test(t, accounts, payments, b)
+		})
+	}
+}
+
+func checkRejects(t *testing.T, rejects map[int]error, expected map[int]error) {
+	t.Helper()
+	if len(rejects) != len(expected) {
+		t.Errorf("wrong rejects: got %v want %v", rejects, expected)
+	}
+	for i, err := range expected {
+		expect(t, rejects[i], err)
+	}
+}
+
+func testImportAccounts(t *testing.T, accounts account.Repository, _ payment.Repository, b bulk.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 1)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 1)))
+	ok(t, accounts.MarkDeleted(ctx, "bob"))
+
+	batch := []*account.Account{newAccount("alice", 5), newAccount("bob", 5), newAccount("carol", 2.5)}
+	rejects, err := b.ImportAccounts(ctx, batch, true)
+	ok(t, err)
+	checkRejects(t, rejects, map[int]error{0: errs.ErrAccountExists, 1: errs.ErrAccountExists})
+	_, err = accounts.Find(ctx, "carol")
+	expect(t, err, errs.ErrUnknownAccount)
+
+	rejects, err = b.ImportAccounts(ctx, batch, false)
+	ok(t, err)
+	checkRejects(t, rejects, map[int]error{0: errs.ErrAccountExists, 1: errs.ErrAccountExists})
+	checkBalance(t, accounts, "alice", 1)
+	checkBalance(t, accounts, "carol", 2.5)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = b.ImportAccounts(cancelled, []*account.Account{newAccount("dave", 0)}, false)
+	expect(t, err, context.Canceled)
+}
+
+func testImportPayments(t *testing.T, accounts account.Repository, payments payment.Repository, b bulk.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 5)))
+	ok(t, accounts.Store(ctx, newAccount("carol", 0)))
+	ok(t, accounts.MarkDeleted(ctx, "carol"))
+	stored := transfer("alice", 1, "bob")
+	ok(t, payments.Store(ctx, stored...))
+
+	history := transfer("bob", 3, "alice")
+	batch := append(history, transfer("carol", 1, "dave")[0], stored[0])
+	rejects, err := b.ImportPayments(ctx, batch, true)
+	ok(t, err)
+	expected := map[int]error{2: errs.ErrUnknownAccount, 3: errs.ErrPaymentExists}
+	checkRejects(t, rejects, expected)
+	pp, err := payments.FindAll(ctx)
+	ok(t, err)
+	if len(pp) != 2 {
+		t.Errorf("dry run must not store payments, got %d", len(pp))
+	}
+
+	rejects, err = b.ImportPayments(ctx, batch, false)
+	ok(t, err)
+	checkRejects(t, rejects, expected)
+	pp, err = payments.Find(ctx, "alice")
+	ok(t, err)
+	if len(pp) != 2 {
+		t.Errorf("stored and imported payments of alice expected, got %+v", pp)
+	}
+	// Imported payments are history, balances are already final.
+	checkBalance(t, accounts, "alice", 9)
+	checkBalance(t, accounts, "bob", 6)
+}
+
+func testExport(t *testing.T, accounts account.Repository, payments payment.Repository, b bulk.Repository) {
+	ctx := context.Background()
+	for _, id := range []account.ID{"carol", "alice", "bob"} {
+		ok(t, accounts.Store(ctx, newAccount(id, 10)))
+	}
+	ok(t, accounts.MarkDeleted(ctx, "carol"))
+	pp := transfer("alice", 2, "bob")
+	ok(t, payments.Store(ctx, pp...))
+	ok(t, payments.MarkDeleted(ctx, pp[1].ID))
+
+	var exported []*account.Account
+	ok(t, b.ExportAccounts(ctx, func(a *account.Account) error {
+		exported = append(exported, a)
+		return nil
+	}))
+	if len(exported) != 2 || exported[0].ID != "alice" || exported[1].ID != "bob" ||
+		!exported[0].Balance.Equal(newAccount("", 8).Balance) {
+		t.Errorf("alice and bob expected, got %+v", exported)
+	}
+
+	var exportedPayments []*payment.Payment
+	ok(t, b.ExportPayments(ctx, func(p *payment.Payment) error {
+		exportedPayments = append(exportedPayments, p)
+		return nil
+	}))
+	if len(exportedPayments) != 1 || exportedPayments[0].ID != pp[0].ID {
+		t.Errorf("outgoing payment expected, got %+v", exportedPayments)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	expect(t, b.ExportAccounts(cancelled, func(*account.Account) error { return nil }), context.Canceled)
+	expect(t, b.ExportPayments(cancelled, func(*payment.Payment) error { return nil }), context.Canceled)
+}
+
+// testBulkFields checks, that imported fields are exported as they are, child account may be imported before its
+// parent.
+func testBulkFields(t *testing.T, accounts account.Repository, payments payment.Repository, b bulk.Repository) {
+	ctx := context.Background()
+	child := newAccount("bob", 5)
+	child.Status, child.Parent, child.Name = account.StatusFrozen, "carol", "Bob"
+	child.Description, child.Reference, child.Metadata = "savings", "C-1", metadata.Metadata{"tier": "gold"}
+	rejects, err := b.ImportAccounts(ctx, []*account.Account{child, newAccount("carol", 10)}, false)
+	ok(t, err)
+	checkRejects(t, rejects, nil)
+
+	at := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	pp, id := pending("carol", 2, "bob", at)
+	for _, val := range pp {
+		ok(t, val.Transition(payment.StatusCompleted, "", at.Add(time.Minute)))
+		val.Description, val.Reference, val.Metadata = "rent", "INV-1", metadata.Metadata{"order": "42"}
+	}
+	rejects, err = b.ImportPayments(ctx, pp, false)
+	ok(t, err)
+	checkRejects(t, rejects, nil)
+
+	var exported []*account.Account
+	ok(t, b.ExportAccounts(ctx, func(a *account.Account) error {
+		exported = append(exported, a)
+		return nil
+	}))
+	if len(exported) != 2 {
+		t.Fatalf("bob and carol expected, got %+v", exported)
+	}
+	a := exported[0]
+	if a.ID != "bob" || a.Status != account.StatusFrozen || a.Parent != "carol" || a.Name != "Bob" ||
+		a.Description != "savings" || a.Reference != "C-1" || a.Metadata["tier"] != "gold" {
+		t.Errorf("fields of bob are not kept, got %+v", a)
+	}
+
+	var exportedPayments []*payment.Payment
+	ok(t, b.ExportPayments(ctx, func(p *payment.Payment) error {
+		exportedPayments = append(exportedPayments, p)
+		return nil
+	}))
+	if len(exportedPayments) != 2 {
+		t.Fatalf("both payments expected, got %+v", exportedPayments)
+	}
+	for _, p := range exportedPayments {
+		if p.Transfer != id || p.CurrentStatus() != payment.StatusCompleted || !p.CreatedAt.Equal(at) ||
+			p.CompletedAt == nil || !p.CompletedAt.Equal(at.Add(time.Minute)) || p.Description != "rent" ||
+			p.Reference != "INV-1" || p.Metadata["order"] != "42" {
+			t.Errorf("fields of payment are not kept, got %+v", p)
+		}
+	}
+	found, err := payments.Search(ctx, payment.Filter{Transfer: id})
+	ok(t, err)
+	if len(found) != 2 {
+		t.Errorf("payments of imported transfer expected, got %+v", found)
+	}
+	checkBalance(t, accounts, "bob", 5)
+}
@@ -0,0 +1,155 @@
+package repotest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/interest"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// InterestFactory returns new empty repositories, interest one posts payments to the others.
+type InterestFactory func(t *testing.T) (account.Repository, payment.Repository, interest.Repository)
+
+// RunInterest runs conformance tests of interest repository, each one with new repositories.
+func RunInterest(t *testing.T, newRepositories InterestFactory) {
+	for _, item := range []struct {
+		Name string
+		Test func(t *testing.T, accounts account.Repository, payments payment.Repository, r interest.Repository)
+	}{
+		{"interest:plans", testInterestPlans},
+		{"interest:assign", testInterestAssign},
+		{"interest:save", testInterestSave},
+	} {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			accounts, payments, r := newRepositories(t)
+			item.Test(t, accounts, payments, r)
+		})
+	}
+}
+
+func newPlan(id string, rate float64) *interest.Plan {
+	return &interest.Plan{
+		ID:          id,
+		Rate:        decimal.NewFromFloat(rate),
+		DayCount:    interest.Actual365,
+		Compounding: interest.CompoundMonthly,
+		CreatedAt:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func date(month time.Month, day int) time.Time {
+	return time.Date(2026, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func testInterestPlans(t *testing.T, _ account.Repository, _ payment.Repository, r interest.Repository) {
+	ctx := context.Background()
+	ok(t, r.StorePlan(ctx, newPlan("savings", 3.5)))
+	ok(t, r.StorePlan(ctx, newPlan("deposit", 5)))
+	expect(t, r.StorePlan(ctx, newPlan("savings", 1)), errs.ErrInterestPlanExists)
+
+	p, err := r.FindPlan(ctx, "savings")
+	ok(t, err)
+	if !p.Rate.Equal(decimal.NewFromFloat(3.5)) || p.DayCount != interest.Actual365 ||
+		p.Compounding != interest.CompoundMonthly {
+		t.Errorf("wrong plan: %+v", p)
+	}
+	_, err = r.FindPlan(ctx, "unknown")
+	expect(t, err, errs.ErrUnknownInterestPlan)
+
+	pp, err := r.FindPlans(ctx)
+	ok(t, err)
+	var got []string
+	for _, val := range pp {
+		got = append(got, val.ID)
+	}
+	if fmt.Sprint(got) != "[deposit savings]" {
+		t.Errorf("wrong plans: got %v", got)
+	}
+}
+
+func testInterestAssign(t *testing.T, accounts account.Repository, _ payment.Repository, r interest.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, accounts.Store(ctx, newAccount("alice", 0)))
+	ok(t, r.StorePlan(ctx, newPlan("savings", 3.5)))
+	ok(t, r.StorePlan(ctx, newPlan("deposit", 5)))
+
+	_, err := r.FindAccrual(ctx, "alice")
+	expect(t, err, errs.ErrNoInterestPlan)
+	a, err := r.Assign(ctx, &interest.Accrual{Account: "alice", Plan: "savings", AccruedThrough: date(1, 31),
+		PostedThrough: date(1, 31)})
+	ok(t, err)
+	if a.Plan != "savings" || !a.AccruedThrough.Equal(date(1, 31)) {
+		t.Errorf("wrong accrual: %+v", a)
+	}
+	_, err = r.Assign(ctx, &interest.Accrual{Account: "bob", Plan: "savings", AccruedThrough: date(2, 10),
+		PostedThrough: date(2, 10)})
+	ok(t, err)
+
+	// Reassignment changes plan only, interest accrued before is kept.
+	ok(t, r.Save(ctx, &interest.Accrual{Account: "alice", Accrued: decimal.NewFromFloat(0.5),
+		AccruedThrough: date(2, 5), PostedThrough: date(1, 31)}, date(1, 31)))
+	a, err = r.Assign(ctx, &interest.Accrual{Account: "alice", Plan: "deposit", AccruedThrough: date(3, 1),
+		PostedThrough: date(3, 1)})
+	ok(t, err)
+	if a.Plan != "deposit" || !a.AccruedThrough.Equal(date(2, 5)) || !a.Accrued.Equal(decimal.NewFromFloat(0.5)) {
+		t.Errorf("wrong reassigned accrual: %+v", a)
+	}
+
+	aa, err := r.FindAccruals(ctx)
+	ok(t, err)
+	var got []account.ID
+	for _, val := range aa {
+		got = append(got, val.Account)
+	}
+	if fmt.Sprint(got) != "[alice bob]" {
+		t.Errorf("wrong accruals: got %v", got)
+	}
+}
+
+func testInterestSave(t *testing.T, accounts account.Repository, payments payment.Repository, r interest.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("bank", 100)))
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, r.StorePlan(ctx, newPlan("savings", 3.5)))
+	_, err := r.Assign(ctx, &interest.Accrual{Account: "alice", Plan: "savings", AccruedThrough: date(1, 30),
+		PostedThrough: date(1, 30)})
+	ok(t, err)
+
+	posted := &interest.Accrual{Account: "alice", Accrued: decimal.NewFromFloat(0.0012), AccruedThrough: date(1, 31),
+		PostedThrough: date(1, 31)}
+	ok(t, r.Save(ctx, posted, date(1, 30), transfer("bank", 1.25, "alice")...))
+	checkBalance(t, accounts, "bank", 98.75)
+	checkBalance(t, accounts, "alice", 11.25)
+	pp, err := payments.Find(ctx, "alice")
+	ok(t, err)
+	if len(pp) != 1 {
+		t.Errorf("wrong number of payments: got %d want 1", len(pp))
+	}
+
+	// The same period is not posted twice.
+	expect(t, r.Save(ctx, posted, date(1, 30), transfer("bank", 1.25, "alice")...), errs.ErrAccrualChanged)
+	checkBalance(t, accounts, "alice", 11.25)
+
+	// Accrual is not changed, if payments are not stored.
+	expect(t, r.Save(ctx, &interest.Accrual{Account: "alice", AccruedThrough: date(2, 28), PostedThrough: date(2, 28)},
+		date(1, 31), transfer("unknown", 1, "alice")...), errs.ErrUnknownAccount)
+	a, err := r.FindAccrual(ctx, "alice")
+	ok(t, err)
+	if a.Plan != "savings" || !a.AccruedThrough.Equal(date(1, 31)) || !a.PostedThrough.Equal(date(1, 31)) ||
+		!a.Accrued.Equal(decimal.NewFromFloat(0.0012)) {
+		t.Errorf("wrong accrual: %+v", a)
+	}
+
+	ok(t, r.Save(ctx, &interest.Accrual{Account: "alice", Accrued: decimal.NewFromFloat(0.3),
+		AccruedThrough: date(2, 3), PostedThrough: date(1, 31)}, date(1, 31)))
+	expect(t, r.Save(ctx, &interest.Accrual{Account: "bob", AccruedThrough: date(2, 3)}, date(1, 31)),
+		errs.ErrNoInterestPlan)
+}
@@ -0,0 +1,96 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/bulk"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/reconcile"
+	"github.com/shopspring/decimal"
+)
+
+// LedgerFactory returns new empty repositories, bulk and ledger ones work with the others.
+type LedgerFactory func(t *testing.T) (account.Repository, payment.Repository, bulk.Repository,
+	reconcile.Repository)
+
+// RunLedger runs conformance tests of ledger repository, each one with new repositories.
+func RunLedger(t *testing.T, newRepositories LedgerFactory) {
+	for _, item := range []struct {
+		Name string
+		Test func(t *testing.T, accounts account.Repository, payments payment.Repository, b bulk.Repository,
+			ledger reconcile.Repository)
+	}{
+		{"ledger:consistent", testLedgerConsistent},
+		{"ledger:discrepancies", testLedgerDiscrepancies},
+	} {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			accounts, payments, b, ledger := newRepositories(t)
+			item.Test(t, accounts, payments, b, ledger)
+		})
+	}
+}
+
+func checkChecks(t *testing.T, report *reconcile.Report, expected map[reconcile.Check]int) {
+	t.Helper()
+	for check, n := range report.Count() {
+		if n != expected[check] {
+			t.Errorf("%s: wrong number of discrepancies: got %d want %d: %+v", check, n, expected[check],
+				report.Discrepancies)
+		}
+	}
+}
+
+func testLedgerConsistent(t *testing.T, accounts account.Repository, payments payment.Repository,
+	b bulk.Repository, ledger reconcile.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 5)))
+	ok(t, accounts.Store(ctx, newAccount("carol", 0)))
+	ok(t, payments.Store(ctx, transfer("alice", 3, "bob")...))
+	ok(t, payments.Store(ctx, transfer("bob", 1.5, "carol")...))
+	deleted := transfer("alice", 1, "carol")
+	ok(t, payments.Store(ctx, deleted...))
+	ok(t, payments.MarkDeleted(ctx, deleted[0].ID))
+	ok(t, accounts.MarkDeleted(ctx, "carol"))
+	_, err := b.ImportPayments(ctx, transfer("bob", 2, "alice"), false)
+	ok(t, err)
+
+	report, err := reconcile.Reconcile(ctx, ledger)
+	ok(t, err)
+	checkChecks(t, report, nil)
+	if report.Accounts != 3 || report.Payments != 8 {
+		t.Errorf("wrong size of ledger: got %d accounts and %d payments", report.Accounts, report.Payments)
+	}
+	if total := report.Total(account.CurrencyUSD); !total.Balance.Equal(decimal.NewFromFloat(15)) ||
+		!total.Initial.Equal(total.Balance) {
+		t.Errorf("wrong totals: got %s initial and %s balance", total.Initial, total.Balance)
+	}
+}
+
+func testLedgerDiscrepancies(t *testing.T, accounts account.Repository, payments payment.Repository,
+	_ bulk.Repository, ledger reconcile.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", -1)))
+	ok(t, payments.Store(ctx, transfer("alice", 2, "bob")[:1]...))
+
+	report, err := reconcile.Reconcile(ctx, ledger)
+	ok(t, err)
+	checkChecks(t, report, map[reconcile.Check]int{
+		reconcile.UnmatchedPayment: 1,
+		reconcile.MoneyTotal:       1,
+		reconcile.NegativeBalance:  1,
+	})
+	if total := report.Total(account.CurrencyUSD); !total.Balance.Equal(decimal.NewFromFloat(7)) ||
+		!total.Initial.Equal(decimal.NewFromFloat(9)) {
+		t.Errorf("wrong totals: got %s initial and %s balance", total.Initial, total.Balance)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = reconcile.Reconcile(cancelled, ledger)
+	expect(t, err, context.Canceled)
+}
@@ -0,0 +1,899 @@
+// Package repotest provides conformance tests for implementations of account.Repository and payment.Repository,
+// and of bulk.Repository by RunBulk.
+// Every storage backend runs them from its own tests, so backends are interchangeable for services:
+//
+//	func TestConformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) (account.Repository, payment.Repository) {
+//			accounts := inmem.NewAccountRepository()
+//			return accounts, inmem.NewPaymentRepository(accounts)
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/metadata"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// Factory returns new empty repositories. Payments must change balances of accounts from the same repository.
+type Factory func(t *testing.T) (account.Repository, payment.Repository)
+
+// Run runs all conformance tests, each one with new repositories.
+func Run(t *testing.T, newRepositories Factory) {
+	for _, item := range []struct {
+		Name string
+		Test func(t *testing.T, accounts account.Repository, payments payment.Repository)
+	}{
+		{"accounts:store and find", testStoreAndFind},
+		{"accounts:soft delete", testAccountSoftDelete},
+		{"accounts:update", testUpdate},
+		{"accounts:restore", testRestore},
+		{"accounts:purge", testPurge},
+		{"accounts:hierarchy", testHierarchy},
+		{"metadata:search", testMetadataSearch},
+		{"payments:balance effects", testBalanceEffects},
+		{"payments:order of storing", testPaymentOrder},
+		{"payments:split transfer", testSplitTransfer},
+		{"payments:unknown account", testUnknownAccount},
+		{"payments:soft delete", testPaymentSoftDelete},
+		{"payments:status transitions", testTransition},
+		{"payments:failed transfer", testFailedTransfer},
+		{"payments:concurrent transfers", testConcurrentTransfers},
+		{"payments:concurrent overdraft", testConcurrentOverdraft},
+		{"payments:concurrent completion", testConcurrentCompletion},
+		{"cancelled context", testCancelledContext},
+	} {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			accounts, payments := newRepositories(t)
+			item.Test(t, accounts, payments)
+		})
+	}
+}
+
+func ok(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expect(t *testing.T, err, expected error) {
+	t.Helper()
+	if err != expected {
+		t.Errorf("wrong error: got %v want %v", err, expected)
+	}
+}
+
+func newAccount(id account.ID, balance float64) *account.Account {
+	return &account.Account{
+		ID:       id,
+		Balance:  decimal.NewFromFloat(balance),
+		Currency: account.CurrencyUSD,
+		Status:   account.StatusActive,
+		Version:  1,
+	}
+}
+
+// transfer returns both payments of a money transfer, as payment service creates them.
+func transfer(from account.ID, amount float64, to account.ID) []*payment.Payment {
+	return []*payment.Payment{
+		{
+			ID:        uuid.New(),
+			Account:   from,
+			Amount:    decimal.NewFromFloat(amount),
+			ToAccount: to,
+			Direction: payment.Outgoing,
+		},
+		{
+			ID:          uuid.New(),
+			Account:     to,
+			Amount:      decimal.NewFromFloat(amount),
+			FromAccount: from,
+			Direction:   payment.Incoming,
+		},
+	}
+}
+
+// pending returns both payments of a money transfer, which is not completed yet, and id of the transfer.
+func pending(from account.ID, amount float64, to account.ID, at time.Time) ([]*payment.Payment, uuid.UUID) {
+	id := uuid.New()
+	pp := transfer(from, amount, to)
+	for _, val := range pp {
+		val.Transfer, val.Status, val.CreatedAt = id, payment.StatusPending, at
+	}
+	return pp, id
+}
+
+// checkStatus checks that transfer has both payments in the status and returns them.
+func checkStatus(t *testing.T, payments payment.Repository, id uuid.UUID, status payment.Status) []*payment.Payment {
+	t.Helper()
+	pp, err := payments.Search(context.Background(), payment.Filter{Transfer: id})
+	ok(t, err)
+	if len(pp) != 2 {
+		t.Fatalf("2 payments of transfer expected, got %d", len(pp))
+	}
+	for _, val := range pp {
+		if val.Status != status {
+			t.Errorf("%s payment: wrong status: got %q want %q", val.Direction, val.Status, status)
+		}
+	}
+	return pp
+}
+
+func checkBalance(t *testing.T, accounts account.Repository, id account.ID, expected float64) {
+	t.Helper()
+	a, err := accounts.Find(context.Background(), id)
+	ok(t, err)
+	if !a.Balance.Equal(decimal.NewFromFloat(expected)) {
+		t.Errorf("%s: wrong balance: got %s want %v", id, a.Balance, expected)
+	}
+}
+
+func testStoreAndFind(t *testing.T, accounts account.Repository, _ payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, accounts.Store(ctx, newAccount("alice", 1.23)))
+	expect(t, accounts.Store(ctx, newAccount("alice", 100)), errs.ErrAccountExists)
+
+	a, err := accounts.Find(ctx, "alice")
+	ok(t, err)
+	if a.ID != "alice" || !a.Balance.Equal(decimal.NewFromFloat(1.23)) || a.Currency != account.CurrencyUSD ||
+		a.Deleted {
+		t.Errorf("wrong account: %+v", a)
+	}
+	// Returned account is a copy, changes are not stored without repository.
+	a.Balance = decimal.NewFromFloat(1000)
+	checkBalance(t, accounts, "alice", 1.23)
+
+	_, err = accounts.Find(ctx, "carol")
+	expect(t, err, errs.ErrUnknownAccount)
+
+	all, err := accounts.FindAll(ctx)
+	ok(t, err)
+	if len(all) != 2 || all[0].ID != "alice" || all[1].ID != "bob" {
+		t.Errorf("accounts ordered by id expected, got %v", all)
+	}
+}
+
+func testAccountSoftDelete(t *testing.T, accounts account.Repository, _ payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 1)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 1)))
+
+	ok(t, accounts.MarkDeleted(ctx, "alice"))
+	expect(t, accounts.MarkDeleted(ctx, "alice"), errs.ErrUnknownAccount)
+	expect(t, accounts.MarkDeleted(ctx, "carol"), errs.ErrUnknownAccount)
+
+	_, err := accounts.Find(ctx, "alice")
+	expect(t, err, errs.ErrUnknownAccount)
+	all, err := accounts.FindAll(ctx)
+	ok(t, err)
+	if len(all) != 1 || all[0].ID != "bob" {
+		t.Errorf("deleted account must not be listed, got %v", all)
+	}
+	// Id of deleted account is not reused.
+	expect(t, accounts.Store(ctx, newAccount("alice", 1)), errs.ErrAccountExists)
+}
+
+func testUpdate(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, accounts.MarkDeleted(ctx, "bob"))
+
+	limit := decimal.NewFromFloat(5)
+	a, err := accounts.Update(ctx, "alice", func(a *account.Account) error {
+		a.Name, a.Status, a.TransferLimit = "Alice", account.StatusFrozen, &limit
+		// Balance is changed only by payments.
+		a.Balance = decimal.NewFromFloat(100)
+		return nil
+	})
+	ok(t, err)
+	if a.Name != "Alice" || a.Status != account.StatusFrozen || a.Version != 2 ||
+		!a.Balance.Equal(decimal.NewFromFloat(10)) {
+		t.Errorf("wrong updated account: %+v", a)
+	}
+	found, err := accounts.Find(ctx, "alice")
+	ok(t, err)
+	if found.Name != "Alice" || found.Version != 2 || found.TransferLimit == nil || !found.TransferLimit.Equal(limit) {
+		t.Errorf("update is not stored: %+v", found)
+	}
+	checkBalance(t, accounts, "alice", 10)
+
+	// Update does not overwrite balance changed by payments.
+	ok(t, accounts.Store(ctx, newAccount("carol", 0)))
+	ok(t, payments.Store(ctx, transfer("alice", 1, "carol")...))
+	_, err = accounts.Update(ctx, "alice", func(a *account.Account) error {
+		a.TransferLimit = nil
+		return nil
+	})
+	ok(t, err)
+	checkBalance(t, accounts, "alice", 9)
+
+	failure := errors.New("rejected")
+	_, err = accounts.Update(ctx, "alice", func(a *account.Account) error {
+		a.Name = "Bob"
+		return failure
+	})
+	expect(t, err, failure)
+	found, err = accounts.Find(ctx, "alice")
+	ok(t, err)
+	if found.Name != "Alice" || found.Version != 3 || found.TransferLimit != nil {
+		t.Errorf("failed update must not change account: %+v", found)
+	}
+
+	for _, id := range []account.ID{"bob", "dave"} {
+		_, err = accounts.Update(ctx, id, func(a *account.Account) error { return nil })
+		expect(t, err, errs.ErrUnknownAccount)
+	}
+}
+
+func testRestore(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, payments.Store(ctx, transfer("alice", 3, "bob")...))
+	ok(t, accounts.MarkDeleted(ctx, "bob"))
+
+	deleted, err := accounts.FindDeleted(ctx)
+	ok(t, err)
+	if len(deleted) != 1 || deleted[0].ID != "bob" || deleted[0].DeletedAt == nil {
+		t.Errorf("deleted bob with time of deletion expected, got %+v", deleted)
+	}
+	for _, id := range []account.ID{"alice", "carol"} {
+		_, err = accounts.Restore(ctx, id)
+		expect(t, err, errs.ErrUnknownAccount)
+	}
+
+	a, err := accounts.Restore(ctx, "bob")
+	ok(t, err)
+	if a.ID != "bob" || a.Deleted || a.DeletedAt != nil || !a.Balance.Equal(decimal.NewFromFloat(3)) {
+		t.Errorf("wrong restored account: %+v", a)
+	}
+	_, err = accounts.Restore(ctx, "bob")
+	expect(t, err, errs.ErrUnknownAccount)
+	deleted, err = accounts.FindDeleted(ctx)
+	ok(t, err)
+	if len(deleted) != 0 {
+		t.Errorf("no deleted accounts expected, got %v", ids(deleted))
+	}
+
+	// Restored account has its payments and takes part in transfers again.
+	pp, err := payments.Find(ctx, "bob")
+	ok(t, err)
+	if len(pp) != 1 {
+		t.Errorf("incoming payment of bob expected, got %+v", pp)
+	}
+	ok(t, payments.Store(ctx, transfer("bob", 1, "alice")...))
+	checkBalance(t, accounts, "alice", 8)
+	checkBalance(t, accounts, "bob", 2)
+}
+
+func testPurge(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, accounts.Store(ctx, newAccount("carol", 0)))
+	ok(t, payments.Store(ctx, transfer("alice", 4, "bob")...))
+	ok(t, payments.Store(ctx, transfer("bob", 1, "carol")...))
+	ok(t, accounts.MarkDeleted(ctx, "bob"))
+
+	purged, err := accounts.Purge(ctx, time.Now().Add(-time.Hour))
+	ok(t, err)
+	if len(purged) != 0 {
+		t.Errorf("recently deleted account must not be purged, got %v", purged)
+	}
+	purged, err = accounts.Purge(ctx, time.Now().Add(time.Minute))
+	ok(t, err)
+	if len(purged) != 1 || purged[0] != "bob" {
+		t.Errorf("bob expected to be purged, got %v", purged)
+	}
+
+	deleted, err := accounts.FindDeleted(ctx)
+	ok(t, err)
+	if len(deleted) != 0 {
+		t.Errorf("purged account must not be listed, got %v", ids(deleted))
+	}
+	_, err = accounts.Restore(ctx, "bob")
+	expect(t, err, errs.ErrUnknownAccount)
+
+	// Payments of purged account are erased, the other legs of its transfers stay with balances.
+	pp, err := payments.FindAll(ctx)
+	ok(t, err)
+	if len(pp) != 2 || pp[0].Account == "bob" || pp[1].Account == "bob" {
+		t.Errorf("payments of alice and carol expected, got %+v", pp)
+	}
+	checkBalance(t, accounts, "alice", 6)
+	checkBalance(t, accounts, "carol", 1)
+
+	// Id of purged account is free.
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	checkBalance(t, accounts, "bob", 0)
+	pp, err = payments.Find(ctx, "bob")
+	ok(t, err)
+	if len(pp) != 0 {
+		t.Errorf("new bob must not have payments, got %+v", pp)
+	}
+}
+
+func child(id account.ID, balance float64, parent account.ID) *account.Account {
+	a := newAccount(id, balance)
+	a.Parent = parent
+	return a
+}
+
+func testHierarchy(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	expect(t, accounts.Store(ctx, child("alice", 1, "root")), errs.ErrUnknownParentAccount)
+	ok(t, accounts.Store(ctx, newAccount("root", 10)))
+	ok(t, accounts.Store(ctx, child("bob", 2, "root")))
+	ok(t, accounts.Store(ctx, child("alice", 1, "root")))
+	ok(t, accounts.Store(ctx, child("carol", 3, "bob")))
+	ok(t, accounts.Store(ctx, newAccount("dave", 4)))
+	ok(t, payments.Store(ctx, transfer("dave", 1, "carol")...))
+
+	tree, err := accounts.FindTree(ctx, "root")
+	ok(t, err)
+	if got := ids(tree); fmt.Sprint(got) != fmt.Sprint([]account.ID{"alice", "bob", "carol", "root"}) {
+		t.Errorf("wrong tree: %v", got)
+	}
+	for _, a := range tree {
+		if a.ID == "carol" && (a.Parent != "bob" || !a.Balance.Equal(decimal.NewFromFloat(4))) {
+			t.Errorf("wrong descendant: %+v", a)
+		}
+	}
+	tree, err = accounts.FindTree(ctx, "bob")
+	ok(t, err)
+	if got := ids(tree); fmt.Sprint(got) != fmt.Sprint([]account.ID{"bob", "carol"}) {
+		t.Errorf("wrong subtree: %v", got)
+	}
+	_, err = accounts.FindTree(ctx, "eve")
+	expect(t, err, errs.ErrUnknownAccount)
+
+	// Parent is deleted after its children only, deleted children are not in the tree.
+	expect(t, accounts.MarkDeleted(ctx, "bob"), errs.ErrAccountHasChildren)
+	checkBalance(t, accounts, "bob", 2)
+	ok(t, accounts.MarkDeleted(ctx, "carol"))
+	ok(t, accounts.MarkDeleted(ctx, "bob"))
+	tree, err = accounts.FindTree(ctx, "root")
+	ok(t, err)
+	if got := ids(tree); fmt.Sprint(got) != fmt.Sprint([]account.ID{"alice", "root"}) {
+		t.Errorf("wrong tree after deletion: %v", got)
+	}
+	_, err = accounts.FindTree(ctx, "bob")
+	expect(t, err, errs.ErrUnknownAccount)
+	expect(t, accounts.Store(ctx, child("eve", 0, "bob")), errs.ErrUnknownParentAccount)
+
+	// Child is restored after its parent only.
+	_, err = accounts.Restore(ctx, "carol")
+	expect(t, err, errs.ErrUnknownParentAccount)
+	_, err = accounts.Restore(ctx, "bob")
+	ok(t, err)
+	_, err = accounts.Restore(ctx, "carol")
+	ok(t, err)
+
+	limit := decimal.NewFromFloat(5)
+	_, err = accounts.Update(ctx, "root", func(a *account.Account) error {
+		a.TreeTransferLimit = &limit
+		return nil
+	})
+	ok(t, err)
+	a, err := accounts.Find(ctx, "root")
+	ok(t, err)
+	if a.TreeTransferLimit == nil || !a.TreeTransferLimit.Equal(limit) {
+		t.Errorf("wrong tree transfer limit: %v", a.TreeTransferLimit)
+	}
+}
+
+func ids(accounts []*account.Account) []account.ID {
+	result := make([]account.ID, 0, len(accounts))
+	for _, a := range accounts {
+		result = append(result, a.ID)
+	}
+	return result
+}
+
+func testMetadataSearch(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	for _, item := range []struct {
+		ID       account.ID
+		Metadata metadata.Metadata
+	}{
+		{"carol", metadata.Metadata{"customer": "42", "tier": "gold"}},
+		{"alice", metadata.Metadata{"customer": "42"}},
+		{"bob", nil},
+		{"dave", metadata.Metadata{"customer": "42"}},
+	} {
+		a := newAccount(item.ID, 10)
+		a.Reference, a.Metadata = "ref-"+string(item.ID), item.Metadata
+		ok(t, accounts.Store(ctx, a))
+	}
+	ok(t, accounts.MarkDeleted(ctx, "dave"))
+
+	a, err := accounts.Find(ctx, "carol")
+	ok(t, err)
+	if a.Reference != "ref-carol" || a.Metadata["tier"] != "gold" {
+		t.Errorf("wrong account: %+v", a)
+	}
+	// Returned metadata is a copy, changes are not stored without repository, so carol is still gold.
+	a.Metadata["tier"] = "silver"
+
+	for _, item := range []struct {
+		Filter   metadata.Metadata
+		Expected []account.ID
+	}{
+		{nil, []account.ID{"alice", "bob", "carol"}},
+		{metadata.Metadata{"customer": "42"}, []account.ID{"alice", "carol"}},
+		{metadata.Metadata{"customer": "42", "tier": "gold"}, []account.ID{"carol"}},
+		{metadata.Metadata{"customer": "43"}, []account.ID{}},
+	} {
+		found, err := accounts.Search(ctx, account.Filter{Metadata: item.Filter})
+		ok(t, err)
+		if got := ids(found); fmt.Sprint(got) != fmt.Sprint(item.Expected) {
+			t.Errorf("accounts by %v: got %v want %v", item.Filter, got, item.Expected)
+		}
+	}
+
+	_, err = accounts.Update(ctx, "alice", func(a *account.Account) error {
+		a.Description, a.Metadata = "main", metadata.Metadata{"customer": "7"}
+		return nil
+	})
+	ok(t, err)
+	found, err := accounts.Search(ctx, account.Filter{Metadata: metadata.Metadata{"customer": "7"}})
+	ok(t, err)
+	if len(found) != 1 || found[0].Description != "main" || found[0].Reference != "ref-alice" {
+		t.Errorf("updated metadata is not searched: %v", found)
+	}
+
+	invoice := transfer("alice", 1, "bob")
+	for _, p := range invoice {
+		p.Reference, p.Metadata = "INV-1", metadata.Metadata{"invoice": "1"}
+	}
+	ok(t, payments.Store(ctx, invoice...))
+	ok(t, payments.Store(ctx, transfer("carol", 1, "bob")...))
+
+	for _, item := range []struct {
+		Filter   payment.Filter
+		Expected int
+	}{
+		{payment.Filter{}, 4},
+		{payment.Filter{Metadata: metadata.Metadata{"invoice": "1"}}, 2},
+		{payment.Filter{Account: "bob", Metadata: metadata.Metadata{"invoice": "1"}}, 1},
+		{payment.Filter{Account: "carol", Metadata: metadata.Metadata{"invoice": "1"}}, 0},
+		{payment.Filter{Account: "carol"}, 1},
+	} {
+		pp, err := payments.Search(ctx, item.Filter)
+		ok(t, err)
+		if len(pp) != item.Expected {
+			t.Errorf("payments by %+v: got %d want %d", item.Filter, len(pp), item.Expected)
+		}
+		for _, p := range pp {
+			if len(item.Filter.Metadata) > 0 && p.Reference != "INV-1" {
+				t.Errorf("wrong payment: %+v", p)
+			}
+		}
+	}
+	ok(t, payments.MarkDeleted(ctx, invoice[0].ID))
+	pp, err := payments.Search(ctx, payment.Filter{Metadata: metadata.Metadata{"invoice": "1"}})
+	ok(t, err)
+	if len(pp) != 1 || pp[0].ID != invoice[1].ID {
+		t.Errorf("deleted payment must not be found, got %v", pp)
+	}
+}
+
+func testBalanceEffects(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, accounts.Store(ctx, newAccount("carol", 0)))
+
+	first := transfer("alice", 2.5, "bob")
+	ok(t, payments.Store(ctx, first...))
+	ok(t, payments.Store(ctx, transfer("bob", 1, "carol")...))
+	checkBalance(t, accounts, "alice", 7.5)
+	checkBalance(t, accounts, "bob", 1.5)
+	checkBalance(t, accounts, "carol", 1)
+
+	pp, err := payments.Find(ctx, "alice")
+	ok(t, err)
+	if len(pp) != 1 || pp[0].ID != first[0].ID || pp[0].Direction != payment.Outgoing || pp[0].ToAccount != "bob" ||
+		!pp[0].Amount.Equal(decimal.NewFromFloat(2.5)) {
+		t.Errorf("outgoing payment of alice expected, got %+v", pp)
+	}
+	pp, err = payments.Find(ctx, "bob")
+	ok(t, err)
+	if len(pp) != 2 {
+		t.Errorf("incoming and outgoing payments of bob expected, got %+v", pp)
+	}
+	pp, err = payments.FindAll(ctx)
+	ok(t, err)
+	if len(pp) != 4 {
+		t.Errorf("4 payments expected, got %d", len(pp))
+	}
+}
+
+func testPaymentOrder(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+
+	// Random ids of payments do not follow the order, in which they are stored.
+	var all, alice []uuid.UUID
+	for i := 0; i < 5; i++ {
+		pp := transfer("alice", 1, "bob")
+		if i%2 == 1 {
+			pp = transfer("bob", 1, "alice")
+		}
+		ok(t, payments.Store(ctx, pp...))
+		for _, val := range pp {
+			all = append(all, val.ID)
+			if val.Account == "alice" {
+				alice = append(alice, val.ID)
+			}
+		}
+	}
+	check := func(name string, pp []*payment.Payment, err error, want []uuid.UUID) {
+		t.Helper()
+		ok(t, err)
+		got := make([]uuid.UUID, 0, len(pp))
+		for _, val := range pp {
+			got = append(got, val.ID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: payments must be in order of storing: got %v want %v", name, got, want)
+		}
+	}
+	pp, err := payments.Find(ctx, "alice")
+	check("find", pp, err, alice)
+	pp, err = payments.FindAll(ctx)
+	check("find all", pp, err, all)
+	pp, err = payments.Search(ctx, payment.Filter{Account: "alice"})
+	check("search", pp, err, alice)
+}
+
+func testSplitTransfer(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	for _, id := range []account.ID{"alice", "bob", "carol"} {
+		ok(t, accounts.Store(ctx, newAccount(id, 0)))
+	}
+	ok(t, accounts.Store(ctx, newAccount("dave", 10)))
+
+	splits := []payment.Split{
+		{Account: "alice", Amount: decimal.NewFromFloat(6.5)},
+		{Account: "bob", Amount: decimal.NewFromFloat(2.5)},
+		{Account: "carol", Amount: decimal.NewFromFloat(1)},
+	}
+	legs := []*payment.Payment{{ID: uuid.New(), Account: "dave", Amount: decimal.NewFromFloat(10),
+		Direction: payment.Outgoing, Splits: splits, Risk: payment.DecisionReview,
+		RiskReasons: []string{"velocity: 3 transfers"}}}
+	for _, val := range splits {
+		legs = append(legs, &payment.Payment{ID: uuid.New(), Account: val.Account, Amount: val.Amount,
+			FromAccount: "dave", Direction: payment.Incoming})
+	}
+	ok(t, payments.Store(ctx, legs...))
+	checkBalance(t, accounts, "dave", 0)
+	checkBalance(t, accounts, "alice", 6.5)
+	checkBalance(t, accounts, "bob", 2.5)
+	checkBalance(t, accounts, "carol", 1)
+
+	pp, err := payments.Find(ctx, "dave")
+	ok(t, err)
+	if len(pp) != 1 || len(pp[0].Splits) != 3 || pp[0].Splits[0].Account != "alice" ||
+		!pp[0].Splits[0].Amount.Equal(decimal.NewFromFloat(6.5)) || pp[0].Risk != payment.DecisionReview ||
+		len(pp[0].RiskReasons) != 1 {
+		t.Errorf("outgoing payment of dave with splits expected, got %+v", pp)
+	}
+	pp, err = payments.FindAll(ctx)
+	ok(t, err)
+	if len(pp) != 4 {
+		t.Errorf("4 legs of split transfer expected, got %d", len(pp))
+	}
+}
+
+func testUnknownAccount(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	ok(t, accounts.MarkDeleted(ctx, "bob"))
+
+	for _, to := range []account.ID{"bob", "carol"} {
+		// Nothing is stored, if any account is unknown.
+		expect(t, payments.Store(ctx, transfer("alice", 1, to)...), errs.ErrUnknownAccount)
+	}
+	checkBalance(t, accounts, "alice", 10)
+	pp, err := payments.FindAll(ctx)
+	ok(t, err)
+	if len(pp) != 0 {
+		t.Errorf("no payments expected, got %+v", pp)
+	}
+}
+
+func testPaymentSoftDelete(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	pp := transfer("alice", 1, "bob")
+	ok(t, payments.Store(ctx, pp...))
+
+	ok(t, payments.MarkDeleted(ctx, pp[0].ID))
+	expect(t, payments.MarkDeleted(ctx, pp[0].ID), errs.ErrUnknownPayment)
+	expect(t, payments.MarkDeleted(ctx, uuid.New()), errs.ErrUnknownPayment)
+
+	found, err := payments.Find(ctx, "alice")
+	ok(t, err)
+	if len(found) != 0 {
+		t.Errorf("deleted payment must not be listed, got %+v", found)
+	}
+	found, err = payments.FindAll(ctx)
+	ok(t, err)
+	if len(found) != 1 || found[0].ID != pp[1].ID {
+		t.Errorf("only incoming payment expected, got %+v", found)
+	}
+}
+
+func testTransition(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	created := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	completed, reversed := created.Add(time.Second), created.Add(time.Hour)
+
+	// Pending payments do not change balances until they are completed.
+	pp, id := pending("alice", 4, "bob", created)
+	ok(t, payments.Store(ctx, pp...))
+	checkBalance(t, accounts, "alice", 10)
+	checkBalance(t, accounts, "bob", 0)
+	checkStatus(t, payments, id, payment.StatusPending)
+
+	ok(t, payments.Transition(ctx, id, payment.StatusCompleted, "", completed))
+	checkBalance(t, accounts, "alice", 6)
+	checkBalance(t, accounts, "bob", 4)
+	for _, val := range checkStatus(t, payments, id, payment.StatusCompleted) {
+		if !val.CreatedAt.Equal(created) || val.CompletedAt == nil || !val.CompletedAt.Equal(completed) {
+			t.Errorf("wrong times of payment: %v %v", val.CreatedAt, val.CompletedAt)
+		}
+	}
+	expect(t, payments.Transition(ctx, id, payment.StatusFailed, "late", completed), errs.ErrStatusTransition)
+	expect(t, payments.Transition(ctx, id, payment.StatusCompleted, "", completed), errs.ErrStatusTransition)
+
+	// Reversal returns the money, reversed transfer is final.
+	ok(t, payments.Transition(ctx, id, payment.StatusReversed, "", reversed))
+	checkBalance(t, accounts, "alice", 10)
+	checkBalance(t, accounts, "bob", 0)
+	for _, val := range checkStatus(t, payments, id, payment.StatusReversed) {
+		if val.ReversedAt == nil || !val.ReversedAt.Equal(reversed) {
+			t.Errorf("wrong time of reversal: %v", val.ReversedAt)
+		}
+	}
+	expect(t, payments.Transition(ctx, id, payment.StatusReversed, "", reversed), errs.ErrStatusTransition)
+	expect(t, payments.Transition(ctx, uuid.New(), payment.StatusCompleted, "", completed), errs.ErrUnknownTransfer)
+	expect(t, payments.Transition(ctx, uuid.Nil, payment.StatusCompleted, "", completed), errs.ErrUnknownTransfer)
+
+	// Payments stored before statuses were introduced are completed.
+	ok(t, payments.Store(ctx, transfer("alice", 1, "bob")...))
+	checkBalance(t, accounts, "alice", 9)
+	for _, item := range []struct {
+		Status payment.Status
+		Count  int
+	}{
+		{payment.StatusCompleted, 2},
+		{payment.StatusReversed, 2},
+		{payment.StatusPending, 0},
+	} {
+		found, err := payments.Search(ctx, payment.Filter{Status: item.Status})
+		ok(t, err)
+		if len(found) != item.Count {
+			t.Errorf("%s: %d payments expected, got %d", item.Status, item.Count, len(found))
+		}
+	}
+}
+
+func testFailedTransfer(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", 10)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+	created := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	failed := created.Add(time.Second)
+
+	pp, id := pending("alice", 4, "bob", created)
+	ok(t, payments.Store(ctx, pp...))
+	ok(t, payments.Transition(ctx, id, payment.StatusFailed, "storage is unavailable", failed))
+	checkBalance(t, accounts, "alice", 10)
+	checkBalance(t, accounts, "bob", 0)
+	for _, val := range checkStatus(t, payments, id, payment.StatusFailed) {
+		if val.FailureReason != "storage is unavailable" || val.FailedAt == nil || !val.FailedAt.Equal(failed) ||
+			val.CompletedAt != nil {
+			t.Errorf("wrong failure of payment: %q %v %v", val.FailureReason, val.FailedAt, val.CompletedAt)
+		}
+	}
+	expect(t, payments.Transition(ctx, id, payment.StatusCompleted, "", failed), errs.ErrStatusTransition)
+	expect(t, payments.Transition(ctx, id, payment.StatusReversed, "", failed), errs.ErrStatusTransition)
+
+	// Payments stored as failed at once do not change balances too.
+	pp, id = pending("alice", 3, "bob", created)
+	for _, val := range pp {
+		ok(t, val.Transition(payment.StatusFailed, "denied", failed))
+	}
+	ok(t, payments.Store(ctx, pp...))
+	checkBalance(t, accounts, "alice", 10)
+	checkStatus(t, payments, id, payment.StatusFailed)
+}
+
+func testConcurrentTransfers(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	const (
+		workers   = 8
+		transfers = 10
+	)
+	ctx := context.Background()
+	ids := make([]account.ID, workers)
+	for i := range ids {
+		ids[i] = account.ID(fmt.Sprintf("acc%d", i))
+		ok(t, accounts.Store(ctx, newAccount(ids[i], 100)))
+	}
+
+	var wg sync.WaitGroup
+	failures := make(chan error, workers*transfers)
+	for i := range ids {
+		wg.Add(1)
+		go func(from, to account.ID) {
+			defer wg.Done()
+			for j := 0; j < transfers; j++ {
+				if err := payments.Store(ctx, transfer(from, 1, to)...); err != nil {
+					failures <- err
+				}
+			}
+		}(ids[i], ids[(i+1)%workers])
+	}
+	wg.Wait()
+	close(failures)
+	for err := range failures {
+		t.Error(err)
+	}
+
+	// Every account sends and receives the same amount, so nothing is lost or created.
+	for _, id := range ids {
+		checkBalance(t, accounts, id, 100)
+	}
+	pp, err := payments.FindAll(ctx)
+	ok(t, err)
+	if len(pp) != 2*workers*transfers {
+		t.Errorf("%d payments expected, got %d", 2*workers*transfers, len(pp))
+	}
+}
+
+func testConcurrentOverdraft(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	const (
+		balance   = 10
+		transfers = 25
+	)
+	ctx := context.Background()
+	ok(t, accounts.Store(ctx, newAccount("alice", balance)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+
+	// Transfers total more than the source balance, each one is checked at the moment of commit.
+	var wg sync.WaitGroup
+	results := make(chan error, transfers)
+	for i := 0; i < transfers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- payments.Store(ctx, transfer("alice", 1, "bob")...)
+		}()
+	}
+	wg.Wait()
+	close(results)
+	stored := 0
+	for err := range results {
+		switch err {
+		case nil:
+			stored++
+		case errs.ErrInsufficientMoney:
+		default:
+			t.Errorf("wrong error of rejected transfer: %v", err)
+		}
+	}
+	if stored != balance {
+		t.Errorf("%d transfers must be stored, got %d", balance, stored)
+	}
+	checkBalance(t, accounts, "alice", 0)
+	checkBalance(t, accounts, "bob", balance)
+	pp, err := payments.Find(ctx, "alice")
+	ok(t, err)
+	if len(pp) != balance {
+		t.Errorf("rejected transfers must not be stored, got %d payments", len(pp))
+	}
+}
+
+func testConcurrentCompletion(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	const (
+		balance   = 10
+		transfers = 25
+	)
+	ctx := context.Background()
+	at := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	ok(t, accounts.Store(ctx, newAccount("alice", balance)))
+	ok(t, accounts.Store(ctx, newAccount("bob", 0)))
+
+	// Pending transfers do not change balance, so all of them are stored, but not all can be completed.
+	ids := make([]uuid.UUID, transfers)
+	for i := range ids {
+		var pp []*payment.Payment
+		pp, ids[i] = pending("alice", 1, "bob", at)
+		ok(t, payments.Store(ctx, pp...))
+	}
+	var wg sync.WaitGroup
+	results := make(chan error, transfers)
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id uuid.UUID) {
+			defer wg.Done()
+			results <- payments.Transition(ctx, id, payment.StatusCompleted, "", at)
+		}(id)
+	}
+	wg.Wait()
+	close(results)
+	completed := 0
+	for err := range results {
+		switch err {
+		case nil:
+			completed++
+		case errs.ErrInsufficientMoney:
+		default:
+			t.Errorf("wrong error of rejected completion: %v", err)
+		}
+	}
+	if completed != balance {
+		t.Errorf("%d transfers must be completed, got %d", balance, completed)
+	}
+	checkBalance(t, accounts, "alice", 0)
+	checkBalance(t, accounts, "bob", balance)
+	pp, err := payments.Search(ctx, payment.Filter{Status: payment.StatusPending})
+	ok(t, err)
+	if len(pp) != 2*(transfers-balance) {
+		t.Errorf("rejected transfers must stay pending, got %d pending payments", len(pp))
+	}
+}
+
+func testCancelledContext(t *testing.T, accounts account.Repository, payments payment.Repository) {
+	ok(t, accounts.Store(context.Background(), newAccount("alice", 10)))
+	ok(t, accounts.Store(context.Background(), newAccount("bob", 0)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	expect(t, accounts.Store(ctx, newAccount("carol", 0)), context.Canceled)
+	_, err := accounts.Find(ctx, "alice")
+	expect(t, err, context.Canceled)
+	_, err = accounts.FindAll(ctx)
+	expect(t, err, context.Canceled)
+	expect(t, accounts.MarkDeleted(ctx, "alice"), context.Canceled)
+	_, err = accounts.FindDeleted(ctx)
+	expect(t, err, context.Canceled)
+	_, err = accounts.Restore(ctx, "alice")
+	expect(t, err, context.Canceled)
+	_, err = accounts.Purge(ctx, time.Now())
+	expect(t, err, context.Canceled)
+	_, err = accounts.FindTree(ctx, "alice")
+	expect(t, err, context.Canceled)
+	expect(t, payments.Store(ctx, transfer("alice", 1, "bob")...), context.Canceled)
+	_, err = payments.Find(ctx, "alice")
+	expect(t, err, context.Canceled)
+	_, err = payments.FindAll(ctx)
+	expect(t, err, context.Canceled)
+	expect(t, payments.MarkDeleted(ctx, uuid.New()), context.Canceled)
+
+	checkBalance(t, accounts, "alice", 10)
+	_, err = accounts.Find(context.Background(), "carol")
+	expect(t, err, errs.ErrUnknownAccount)
+}
@@ -0,0 +1,79 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/screening"
+)
+
+// ScreeningFactory returns new empty screening repository.
+type ScreeningFactory func(t *testing.T) screening.Repository
+
+// RunScreening runs conformance tests of screening repository, each one with new repository.
+func RunScreening(t *testing.T, newRepository ScreeningFactory) {
+	for _, item := range []struct {
+		Name string
+		Test func(t *testing.T, r screening.Repository)
+	}{
+		{"screening:hits", testScreeningHits},
+	} {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			item.Test(t, newRepository(t))
+		})
+	}
+}
+
+func newHit(subject screening.Subject, id, from account.ID, name string) *screening.Hit {
+	return &screening.Hit{
+		ID:        uuid.New(),
+		Subject:   subject,
+		Account:   id,
+		From:      from,
+		Name:      name,
+		EntryID:   "sdn-1",
+		EntryName: "John Doe",
+		Score:     0.9,
+		CreatedAt: date(1, 1),
+	}
+}
+
+func testScreeningHits(t *testing.T, r screening.Repository) {
+	ctx := context.Background()
+	hits := []*screening.Hit{
+		newHit(screening.SubjectAccount, "bob", "", "Jon Doe"),
+		newHit(screening.SubjectTransfer, "alice", "alice", "John Do"),
+		newHit(screening.SubjectTransfer, "bob", "alice", "Jon Doe"),
+	}
+	ok(t, r.StoreHits(ctx, hits[0]))
+	ok(t, r.StoreHits(ctx, hits[1:]...))
+	ok(t, r.StoreHits(ctx))
+
+	found, err := r.FindHits(ctx, "")
+	ok(t, err)
+	if len(found) != len(hits) {
+		t.Fatalf("wrong number of hits: got %d want %d", len(found), len(hits))
+	}
+	for i, val := range found {
+		want := hits[i]
+		if val.ID != want.ID || val.Subject != want.Subject || val.Account != want.Account || val.From != want.From ||
+			val.Name != want.Name || val.EntryID != want.EntryID || val.EntryName != want.EntryName ||
+			val.Score != want.Score || !val.CreatedAt.Equal(want.CreatedAt) {
+			t.Errorf("wrong hit %d: got %+v want %+v", i, val, want)
+		}
+	}
+
+	found, err = r.FindHits(ctx, "bob")
+	ok(t, err)
+	if len(found) != 2 || found[0].ID != hits[0].ID || found[1].ID != hits[2].ID {
+		t.Errorf("wrong hits of bob: %+v", found)
+	}
+	found, err = r.FindHits(ctx, "carol")
+	ok(t, err)
+	if found == nil || len(found) != 0 {
+		t.Errorf("wrong hits of carol: %+v", found)
+	}
+}
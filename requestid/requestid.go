@@ -0,0 +1,48 @@
+// Package requestid identifies requests with X-Request-ID header, which is accepted from client or generated.
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/header"
+)
+
+// Header carries request ID in requests and responses.
+const Header = "X-Request-ID"
+
+// MaxLength is a maximal length of request ID accepted from client.
+const MaxLength = 128
+
+type contextKey struct{}
+
+// NewContext returns context with request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns request ID stored in context, or empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Valid reports whether request ID from client is safe to be logged and returned: not empty, not too long,
+// and consists of printable ASCII characters only.
+func Valid(id string) bool {
+	return header.Valid(id, MaxLength)
+}
+
+// Middleware stores request ID in request context and returns it in response header. ID of client is used
+// if it is valid, otherwise a new one is generated.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
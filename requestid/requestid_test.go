@@ -0,0 +1,47 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otetz/payments/requestid"
+)
+
+func TestMiddleware(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Header   string
+		Accepted bool
+	}{
+		{"accepted", "req-42", true},
+		{"generated", "", false},
+		{"too long", strings.Repeat("x", requestid.MaxLength+1), false},
+		{"not printable", "req\n42", false},
+	}
+
+	for _, item := range cases {
+		item := item
+		t.Run(item.Name, func(t *testing.T) {
+			var seen string
+			handler := requestid.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestid.FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if item.Header != "" {
+				r.Header.Set(requestid.Header, item.Header)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if seen == "" || rr.Header().Get(requestid.Header) != seen {
+				t.Errorf("request ID must be in context and response: %q, %q", seen, rr.Header().Get(requestid.Header))
+			}
+			if (seen == item.Header) != item.Accepted {
+				t.Errorf("wrong request ID: got %q, header %q", seen, item.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package risk
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+	"gopkg.in/yaml.v2"
+)
+
+// Types of rules in rules file.
+const (
+	TypeLargeAmount = "large_amount"
+	TypeVelocity    = "velocity"
+	TypeNewAccount  = "new_account"
+	TypeRoundTrip   = "round_trip"
+)
+
+// number is a decimal in rules file, written as number or string.
+type number struct {
+	decimal.Decimal
+}
+
+// UnmarshalYAML parses decimal from YAML scalar.
+func (n *number) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val interface{}
+	if err := unmarshal(&val); err != nil {
+		return err
+	}
+	d, err := decimal.NewFromString(fmt.Sprint(val))
+	if err != nil {
+		return fmt.Errorf("%v is not a decimal", val)
+	}
+	n.Decimal = d
+	return nil
+}
+
+// duration is a duration in rules file, written as string, e.g. "10m".
+type duration struct {
+	time.Duration
+}
+
+// UnmarshalYAML parses duration from YAML string.
+func (d *duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var val string
+	if err := unmarshal(&val); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(val)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// ruleConfig is a rule in rules file, fields which are not used by type of rule are ignored.
+type ruleConfig struct {
+	Type string `yaml:"type"`
+	// Name is unique name of rule, type by default.
+	Name   string `yaml:"name"`
+	Action string `yaml:"action"`
+
+	MaxCount    int      `yaml:"max_count"`
+	MaxAmount   *number  `yaml:"max_amount"`
+	Window      duration `yaml:"window"`
+	MinPayments int      `yaml:"min_payments"`
+	Lookback    int      `yaml:"lookback"`
+	Tolerance   *number  `yaml:"tolerance"`
+}
+
+// Load reads rules file, see Parse.
+func Load(path string, payments payment.Repository) ([]payment.Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := Parse(data, payments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return rules, nil
+}
+
+// Parse returns rules from YAML or JSON with list of rules under "rules" key. Rules, which look into history of
+// accounts, find payments in repository.
+func Parse(data []byte, payments payment.Repository) ([]payment.Rule, error) {
+	var file struct {
+		Rules []ruleConfig `yaml:"rules"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	rules := make([]payment.Rule, 0, len(file.Rules))
+	names := make(map[string]bool, len(file.Rules))
+	for i, c := range file.Rules {
+		if c.Name == "" {
+			c.Name = c.Type
+		}
+		r, err := c.rule(payments)
+		if err == nil && names[c.Name] {
+			err = fmt.Errorf("name %q is not unique", c.Name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %s", i, err)
+		}
+		names[c.Name] = true
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// rule returns configured rule of its type.
+func (c ruleConfig) rule(payments payment.Repository) (payment.Rule, error) {
+	action := payment.Decision(c.Action)
+	if action != payment.DecisionReview && action != payment.DecisionDeny {
+		return nil, fmt.Errorf("action: %q is not one of review, deny", c.Action)
+	}
+	switch c.Type {
+	case TypeLargeAmount:
+		if c.MaxAmount == nil {
+			return nil, errors.New("max_amount: is required")
+		}
+		return NewLargeAmount(c.Name, action, c.MaxAmount.Decimal), nil
+	case TypeVelocity:
+		if c.Window.Duration <= 0 {
+			return nil, errors.New("window: must be positive")
+		}
+		if c.MaxCount <= 0 && c.MaxAmount == nil {
+			return nil, errors.New("max_count or max_amount: is required")
+		}
+		var amount *decimal.Decimal
+		if c.MaxAmount != nil {
+			amount = &c.MaxAmount.Decimal
+		}
+		return NewVelocity(c.Name, action, c.MaxCount, amount, c.Window.Duration), nil
+	case TypeNewAccount:
+		if c.MinPayments <= 0 {
+			return nil, errors.New("min_payments: must be positive")
+		}
+		if c.MaxAmount == nil {
+			return nil, errors.New("max_amount: is required")
+		}
+		return NewNewAccount(c.Name, action, payments, c.MinPayments, c.MaxAmount.Decimal), nil
+	case TypeRoundTrip:
+		if c.Lookback <= 0 {
+			return nil, errors.New("lookback: must be positive")
+		}
+		tolerance := decimal.Zero
+		if c.Tolerance != nil {
+			tolerance = c.Tolerance.Decimal
+		}
+		if tolerance.Sign() < 0 || tolerance.GreaterThanOrEqual(decimal.New(1, 0)) {
+			return nil, errors.New("tolerance: must be at least 0 and less than 1")
+		}
+		return NewRoundTrip(c.Name, action, payments, c.Lookback, tolerance), nil
+	default:
+		return nil, fmt.Errorf("type: %q is not one of %s, %s, %s, %s", c.Type, TypeLargeAmount, TypeVelocity,
+			TypeNewAccount, TypeRoundTrip)
+	}
+}
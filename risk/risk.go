@@ -0,0 +1,215 @@
+// Package risk provides built-in fraud rules, which are evaluated by payment service before every transfer, and
+// loading of them from a rules file.
+package risk
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+// rule holds name and decision of a rule, which are set in rules file.
+type rule struct {
+	name   string
+	action payment.Decision
+}
+
+// Name identifies rule in reasons of decisions.
+func (r rule) Name() string {
+	return r.name
+}
+
+// LargeAmount is a rule for transfers above maximum amount.
+type LargeAmount struct {
+	rule
+	max decimal.Decimal
+}
+
+// Evaluate decides about transfer with amount above maximum.
+func (r *LargeAmount) Evaluate(_ context.Context, t *payment.Transfer) (payment.Decision, string, error) {
+	if !t.Amount.GreaterThan(r.max) {
+		return payment.DecisionAllow, "", nil
+	}
+	return r.action, fmt.Sprintf("amount %s is above %s", t.Amount, r.max), nil
+}
+
+// NewLargeAmount returns a rule, which makes decision about transfers above max.
+func NewLargeAmount(name string, action payment.Decision, max decimal.Decimal) *LargeAmount {
+	return &LargeAmount{rule: rule{name, action}, max: max}
+}
+
+// Velocity is a rule for spikes of transfers from an account: too many transfers or too much money within a sliding
+// window. History of completed transfers is kept in memory of the server, so it starts empty on restart, and
+// every instance of the server counts its own transfers.
+type Velocity struct {
+	rule
+	count  int
+	amount *decimal.Decimal
+	window time.Duration
+
+	mtx     sync.Mutex
+	history map[account.ID][]record
+}
+
+// record is a completed transfer in history of velocity rule.
+type record struct {
+	at     time.Time
+	amount decimal.Decimal
+}
+
+// recent returns transfers of account within the window before now, older ones are forgotten. It must be called
+// under the lock.
+func (r *Velocity) recent(id account.ID, now time.Time) []record {
+	rr := r.history[id]
+	since := now.Add(-r.window)
+	i := 0
+	for i < len(rr) && !rr[i].at.After(since) {
+		i++
+	}
+	rr = rr[i:]
+	if len(rr) == 0 {
+		delete(r.history, id)
+	} else {
+		r.history[id] = rr
+	}
+	return rr
+}
+
+// Evaluate decides about transfer, which makes number or total amount of transfers of source account within the
+// window exceed limits.
+func (r *Velocity) Evaluate(_ context.Context, t *payment.Transfer) (payment.Decision, string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	rr := r.recent(t.From.ID, t.At)
+	if r.count > 0 && len(rr)+1 > r.count {
+		return r.action, fmt.Sprintf("%d transfers within %s, at most %d are expected", len(rr)+1, r.window,
+			r.count), nil
+	}
+	if r.amount != nil {
+		total := t.Amount
+		for _, val := range rr {
+			total = total.Add(val.amount)
+		}
+		if total.GreaterThan(*r.amount) {
+			return r.action, fmt.Sprintf("%s transferred within %s, at most %s is expected", total, r.window,
+				r.amount), nil
+		}
+	}
+	return payment.DecisionAllow, "", nil
+}
+
+// Observe adds completed transfer to history of its source account.
+func (r *Velocity) Observe(t *payment.Transfer) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.history[t.From.ID] = append(r.recent(t.From.ID, t.At), record{at: t.At, amount: t.Amount})
+}
+
+// NewVelocity returns a rule, which makes decision about transfers, if source account makes more than count
+// transfers (no limit if 0) or transfers more than amount (no limit if nil) within window.
+func NewVelocity(name string, action payment.Decision, count int, amount *decimal.Decimal,
+	window time.Duration) *Velocity {
+	return &Velocity{
+		rule:    rule{name, action},
+		count:   count,
+		amount:  amount,
+		window:  window,
+		history: make(map[account.ID][]record),
+	}
+}
+
+// NewAccount is a rule for large payouts from new accounts. Account is new, while it has few completed payments.
+type NewAccount struct {
+	rule
+	payments    payment.Repository
+	minPayments int
+	max         decimal.Decimal
+}
+
+// Evaluate decides about transfer above maximum amount from account with less than minimum number of payments.
+func (r *NewAccount) Evaluate(ctx context.Context, t *payment.Transfer) (payment.Decision, string, error) {
+	if !t.Amount.GreaterThan(r.max) {
+		return payment.DecisionAllow, "", nil
+	}
+	pp, err := completed(ctx, r.payments, t.From.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if len(pp) >= r.minPayments {
+		return payment.DecisionAllow, "", nil
+	}
+	return r.action, fmt.Sprintf("account with %d payments transfers %s, above %s", len(pp), t.Amount, r.max), nil
+}
+
+// NewNewAccount returns a rule, which makes decision about transfers above max from accounts with less than
+// minPayments payments.
+func NewNewAccount(name string, action payment.Decision, payments payment.Repository, minPayments int,
+	max decimal.Decimal) *NewAccount {
+	return &NewAccount{rule: rule{name, action}, payments: payments, minPayments: minPayments, max: max}
+}
+
+// completed returns completed payments of account in order of completion. Payments stored before statuses were
+// introduced have no time of completion, they are the first ones.
+func completed(ctx context.Context, payments payment.Repository, id account.ID) ([]*payment.Payment, error) {
+	pp, err := payments.Search(ctx, payment.Filter{Account: id, Status: payment.StatusCompleted})
+	if err != nil {
+		return nil, err
+	}
+	at := func(p *payment.Payment) time.Time {
+		if p.CompletedAt != nil {
+			return *p.CompletedAt
+		}
+		return p.CreatedAt
+	}
+	sort.SliceStable(pp, func(i, j int) bool { return at(pp[i]).Before(at(pp[j])) })
+	return pp, nil
+}
+
+// RoundTrip is a rule for money sent back to an account, which has just sent it, e.g. to launder money or to
+// inflate turnover.
+type RoundTrip struct {
+	rule
+	payments  payment.Repository
+	lookback  int
+	tolerance decimal.Decimal
+}
+
+// Evaluate decides about transfer to an account, which sent about the same amount to source account among its
+// last completed payments. Amounts are about the same, if they differ by tolerance share of transfer amount at most.
+func (r *RoundTrip) Evaluate(ctx context.Context, t *payment.Transfer) (payment.Decision, string, error) {
+	pp, err := completed(ctx, r.payments, t.From.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if len(pp) > r.lookback {
+		pp = pp[len(pp)-r.lookback:]
+	}
+	delta := t.Amount.Mul(r.tolerance)
+	for i := len(pp) - 1; i >= 0; i-- {
+		p := pp[i]
+		if p.Direction != payment.Incoming || p.Amount.Sub(t.Amount).Abs().GreaterThan(delta) {
+			continue
+		}
+		for _, to := range t.Targets {
+			if p.FromAccount == to.ID {
+				return r.action, fmt.Sprintf("money returns to %s, which sent %s", to.ID, p.Amount), nil
+			}
+		}
+	}
+	return payment.DecisionAllow, "", nil
+}
+
+// NewRoundTrip returns a rule, which makes decision about transfers back to senders of about the same amount
+// among lookback last payments of source account.
+func NewRoundTrip(name string, action payment.Decision, payments payment.Repository, lookback int,
+	tolerance decimal.Decimal) *RoundTrip {
+	return &RoundTrip{rule: rule{name, action}, payments: payments, lookback: lookback, tolerance: tolerance}
+}
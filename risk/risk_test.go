@@ -0,0 +1,238 @@
+package risk_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/risk"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, item := range []struct {
+		Name  string
+		Data  string
+		Names []string
+		Err   string
+	}{
+		{Name: "yaml", Data: `
+rules:
+  - type: large_amount
+    action: review
+    max_amount: 10000
+  - type: velocity
+    name: burst
+    action: deny
+    max_count: 5
+    window: 10m
+  - type: new_account
+    action: review
+    min_payments: 3
+    max_amount: "500.50"
+  - type: round_trip
+    action: review
+    lookback: 20
+    tolerance: 0.05
+`, Names: []string{"large_amount", "burst", "new_account", "round_trip"}},
+		{Name: "json", Data: `{"rules": [{"type": "velocity", "action": "review", "max_amount": 1000,
+			"window": "1h"}]}`, Names: []string{"velocity"}},
+		{Name: "empty", Data: `{}`},
+		{Name: "unknown type", Data: `{"rules": [{"type": "magic", "action": "deny"}]}`,
+			Err: `rules[0]: type: "magic" is not one of`},
+		{Name: "unknown action", Data: `{"rules": [{"type": "large_amount", "action": "allow", "max_amount": 1}]}`,
+			Err: `rules[0]: action: "allow" is not one of review, deny`},
+		{Name: "unknown setting", Data: `{"rules": [{"type": "large_amount", "action": "deny", "limit": 1}]}`,
+			Err: "field limit not found"},
+		{Name: "no maximum", Data: `{"rules": [{"type": "large_amount", "action": "deny"}]}`,
+			Err: "rules[0]: max_amount: is required"},
+		{Name: "bad amount", Data: `{"rules": [{"type": "large_amount", "action": "deny", "max_amount": "lots"}]}`,
+			Err: "lots is not a decimal"},
+		{Name: "no window", Data: `{"rules": [{"type": "velocity", "action": "deny", "max_count": 1}]}`,
+			Err: "rules[0]: window: must be positive"},
+		{Name: "no velocity limit", Data: `{"rules": [{"type": "velocity", "action": "deny", "window": "1m"}]}`,
+			Err: "rules[0]: max_count or max_amount: is required"},
+		{Name: "bad tolerance", Data: `{"rules": [{"type": "round_trip", "action": "deny", "lookback": 1,
+			"tolerance": 1}]}`, Err: "rules[0]: tolerance: must be at least 0 and less than 1"},
+		{Name: "same names", Data: `{"rules": [{"type": "large_amount", "action": "deny", "max_amount": 1},
+			{"type": "large_amount", "action": "review", "max_amount": 1}]}`,
+			Err: `rules[1]: name "large_amount" is not unique`},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			rules, err := risk.Parse([]byte(item.Data), nil)
+			if item.Err != "" {
+				if err == nil || !strings.Contains(err.Error(), item.Err) {
+					t.Fatalf("wrong error: got %v want %q", err, item.Err)
+				}
+				return
+			}
+			OK(t, err)
+			var names []string
+			for _, r := range rules {
+				names = append(names, r.Name())
+			}
+			if strings.Join(names, ",") != strings.Join(item.Names, ",") {
+				t.Errorf("wrong rules: got %v want %v", names, item.Names)
+			}
+		})
+	}
+}
+
+func transfer(from *account.Account, amount float64, at time.Time, to ...*account.Account) *payment.Transfer {
+	return &payment.Transfer{From: from, Amount: decimal.NewFromFloat(amount), Targets: to, At: at}
+}
+
+func TestRules(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	alice := &account.Account{ID: "alice", Balance: decimal.NewFromFloat(1000), Currency: "USD"}
+	bob := &account.Account{ID: "bob", Balance: decimal.NewFromFloat(1000), Currency: "USD"}
+	carol := &account.Account{ID: "carol", Currency: "USD"}
+	for _, a := range []*account.Account{alice, bob, carol} {
+		OK(t, accounts.Store(ctx, a))
+	}
+	ps := payment.NewService(payments, accounts)
+	// Bob sends 100 to alice and 5 to carol.
+	for _, val := range []struct {
+		To     account.ID
+		Amount float64
+	}{{"alice", 100}, {"carol", 5}} {
+		_, err := ps.New(ctx, "bob", decimal.NewFromFloat(val.Amount), val.To, payment.Details{})
+		OK(t, err)
+	}
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	velocity := risk.NewVelocity("velocity", payment.DecisionDeny, 2, nil, time.Minute)
+	amountVelocity := decimal.NewFromFloat(100)
+
+	for _, item := range []struct {
+		Name     string
+		Rule     payment.Rule
+		Transfer *payment.Transfer
+		Decision payment.Decision
+		Reason   string
+	}{
+		{"large amount:allowed", risk.NewLargeAmount("large", payment.DecisionReview, decimal.NewFromFloat(100)),
+			transfer(alice, 100, now, bob), payment.DecisionAllow, ""},
+		{"large amount", risk.NewLargeAmount("large", payment.DecisionReview, decimal.NewFromFloat(100)),
+			transfer(alice, 100.01, now, bob), payment.DecisionReview, "amount 100.01 is above 100"},
+		{"new account:small amount", risk.NewNewAccount("new", payment.DecisionDeny, payments, 2,
+			decimal.NewFromFloat(50)), transfer(carol, 50, now, bob), payment.DecisionAllow, ""},
+		{"new account", risk.NewNewAccount("new", payment.DecisionDeny, payments, 2, decimal.NewFromFloat(50)),
+			transfer(carol, 60, now, bob), payment.DecisionDeny, "account with 1 payments transfers 60, above 50"},
+		{"new account:old one", risk.NewNewAccount("new", payment.DecisionDeny, payments, 2,
+			decimal.NewFromFloat(50)), transfer(bob, 60, now, carol), payment.DecisionAllow, ""},
+		{"round trip", risk.NewRoundTrip("trip", payment.DecisionReview, payments, 10, decimal.NewFromFloat(0.1)),
+			transfer(alice, 95, now, carol, bob), payment.DecisionReview, "money returns to bob, which sent 100"},
+		{"round trip:other amount", risk.NewRoundTrip("trip", payment.DecisionReview, payments, 10,
+			decimal.NewFromFloat(0.1)), transfer(alice, 80, now, bob), payment.DecisionAllow, ""},
+		{"round trip:other account", risk.NewRoundTrip("trip", payment.DecisionReview, payments, 10,
+			decimal.NewFromFloat(0.1)), transfer(alice, 100, now, carol), payment.DecisionAllow, ""},
+		{"velocity:first", velocity, transfer(alice, 1, now, bob), payment.DecisionAllow, ""},
+		{"velocity:second", velocity, transfer(alice, 1, now.Add(30*time.Second), bob), payment.DecisionAllow, ""},
+		{"velocity:third", velocity, transfer(alice, 1, now.Add(50*time.Second), bob), payment.DecisionDeny,
+			"3 transfers within 1m0s, at most 2 are expected"},
+		{"velocity:other account", velocity, transfer(bob, 1, now.Add(50*time.Second), alice),
+			payment.DecisionAllow, ""},
+		{"velocity:window moved", velocity, transfer(alice, 1, now.Add(time.Minute), bob), payment.DecisionAllow, ""},
+		{"velocity:amount", risk.NewVelocity("velocity", payment.DecisionReview, 0, &amountVelocity, time.Minute),
+			transfer(alice, 100.5, now, bob), payment.DecisionReview,
+			"100.5 transferred within 1m0s, at most 100 is expected"},
+	} {
+		t.Run(item.Name, func(t *testing.T) {
+			decision, reason, err := item.Rule.Evaluate(ctx, item.Transfer)
+			OK(t, err)
+			if decision != item.Decision || reason != item.Reason {
+				t.Errorf("wrong decision: got %s %q want %s %q", decision, reason, item.Decision, item.Reason)
+			}
+			if o, ok := item.Rule.(payment.Observer); ok && decision == payment.DecisionAllow {
+				o.Observe(item.Transfer)
+			}
+		})
+	}
+}
+
+func TestAssess(t *testing.T) {
+	rules := []payment.Rule{
+		risk.NewLargeAmount("review", payment.DecisionReview, decimal.NewFromFloat(10)),
+		risk.NewLargeAmount("deny", payment.DecisionDeny, decimal.NewFromFloat(100)),
+	}
+	alice, bob := &account.Account{ID: "alice"}, &account.Account{ID: "bob"}
+	for _, item := range []struct {
+		Amount   float64
+		Decision payment.Decision
+		Reasons  string
+	}{
+		{10, payment.DecisionAllow, ""},
+		{50, payment.DecisionReview, "review: amount 50 is above 10"},
+		{500, payment.DecisionDeny, "review: amount 500 is above 10; deny: amount 500 is above 100"},
+	} {
+		a, err := payment.Assess(context.Background(), rules, transfer(alice, item.Amount, time.Now(), bob))
+		OK(t, err)
+		if a.Decision != item.Decision || strings.Join(a.Reasons, "; ") != item.Reasons {
+			t.Errorf("%v: wrong assessment: %+v", item.Amount, a)
+		}
+	}
+}
+
+// reversed returns payments found by the repository in reverse order, like a store without ordering may do.
+type reversed struct {
+	payment.Repository
+}
+
+func (r reversed) Search(ctx context.Context, filter payment.Filter) ([]*payment.Payment, error) {
+	pp, err := r.Repository.Search(ctx, filter)
+	for i, j := 0, len(pp)-1; i < j; i, j = i+1, j-1 {
+		pp[i], pp[j] = pp[j], pp[i]
+	}
+	return pp, err
+}
+
+func TestRoundTripOrder(t *testing.T) {
+	ctx := context.Background()
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	alice := &account.Account{ID: "alice", Currency: "USD"}
+	bob := &account.Account{ID: "bob", Balance: decimal.NewFromFloat(1000), Currency: "USD"}
+	carol := &account.Account{ID: "carol", Balance: decimal.NewFromFloat(1000), Currency: "USD"}
+	for _, a := range []*account.Account{alice, bob, carol} {
+		OK(t, accounts.Store(ctx, a))
+	}
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	ps := payment.NewService(payments, accounts, payment.ServiceClock(clock))
+	// Bob sends 100 to alice first, then carol sends 10 twice.
+	for _, from := range []account.ID{"bob", "carol", "carol"} {
+		amount := decimal.NewFromFloat(10)
+		if from == "bob" {
+			amount = decimal.NewFromFloat(100)
+		}
+		_, err := ps.New(ctx, from, amount, "alice", payment.Details{})
+		OK(t, err)
+		now = now.Add(time.Minute)
+	}
+
+	// Payment of bob is not among the last two, whatever order the repository returns.
+	rule := risk.NewRoundTrip("trip", payment.DecisionReview, reversed{payments}, 2, decimal.NewFromFloat(0.1))
+	decision, reason, err := rule.Evaluate(ctx, transfer(alice, 100, now, bob))
+	OK(t, err)
+	if decision != payment.DecisionAllow {
+		t.Errorf("wrong decision: got %s %q", decision, reason)
+	}
+	decision, _, err = rule.Evaluate(ctx, transfer(alice, 10, now, carol))
+	OK(t, err)
+	if decision != payment.DecisionReview {
+		t.Errorf("wrong decision: got %s want %s", decision, payment.DecisionReview)
+	}
+}
@@ -0,0 +1,27 @@
+package screening
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/otetz/payments/account"
+)
+
+type hitsRequest struct {
+	Account account.ID
+}
+
+type hitsResponse struct {
+	Hits []*Hit `json:"hits"`
+	Err  error  `json:"error,omitempty"`
+}
+
+func (r hitsResponse) ErrError() error { return r.Err }
+
+func makeHitsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(hitsRequest)
+		h, err := s.Hits(ctx, req.Account)
+		return hitsResponse{Hits: h, Err: err}, nil
+	}
+}
@@ -0,0 +1,221 @@
+// Package screening checks holders of accounts and counterparties of transfers against a blocklist, e.g. sanctions
+// list, with fuzzy matching of names, and keeps audit trail of matches.
+package screening
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Entry is a blocked party of the list.
+type Entry struct {
+	// ID identifies entry in the list, it is the name if the list has no ids.
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// List is a parsed blocklist with normalized names of its entries.
+type List struct {
+	entries []Entry
+	// names are normalized names and aliases, each one refers to its entry.
+	names []listName
+}
+
+type listName struct {
+	normalized string
+	entry      int
+}
+
+// Len returns number of entries of the list.
+func (l *List) Len() int {
+	return len(l.entries)
+}
+
+// Match is a name, which is similar to a name or alias of blocklist entry.
+type Match struct {
+	Name  string
+	Entry Entry
+	// Score is a similarity of normalized names from 0 to 1, identical names have 1.
+	Score float64
+}
+
+// Match returns the best match of name among entries with score at least threshold, or false if there is none.
+func (l *List) Match(name string, threshold float64) (Match, bool) {
+	normalized := Normalize(name)
+	if normalized == "" {
+		return Match{}, false
+	}
+	best, found := Match{Name: name}, false
+	for _, val := range l.names {
+		score := Similarity(normalized, val.normalized)
+		if score >= threshold && score > best.Score {
+			best.Entry, best.Score, found = l.entries[val.entry], score, true
+		}
+	}
+	return best, found
+}
+
+// NewList returns a list of entries, entries without names are skipped.
+func NewList(entries []Entry) *List {
+	l := &List{}
+	for _, val := range entries {
+		if strings.TrimSpace(val.Name) == "" {
+			continue
+		}
+		if val.ID == "" {
+			val.ID = val.Name
+		}
+		l.entries = append(l.entries, val)
+		for _, name := range append([]string{val.Name}, val.Aliases...) {
+			if normalized := Normalize(name); normalized != "" {
+				l.names = append(l.names, listName{normalized: normalized, entry: len(l.entries) - 1})
+			}
+		}
+	}
+	return l
+}
+
+// Parse returns list from file data, format is chosen by extension of path: .csv or .json. CSV file has header
+// with name column and optional id and aliases columns, aliases are separated by semicolons. JSON file is an array
+// of entries.
+func Parse(path string, data []byte) (*List, error) {
+	var (
+		entries []Entry
+		err     error
+	)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		entries, err = parseCSV(data)
+	case ".json":
+		err = json.Unmarshal(data, &entries)
+	default:
+		err = fmt.Errorf("unsupported format %q, must be .csv or .json", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewList(entries), nil
+}
+
+func parseCSV(data []byte) ([]Entry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("header is missing")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{"id": -1, "name": -1, "aliases": -1}
+	for i, val := range header {
+		if _, ok := columns[strings.TrimSpace(val)]; ok {
+			columns[strings.TrimSpace(val)] = i
+		}
+	}
+	if columns["name"] < 0 {
+		return nil, errors.New("name column is missing")
+	}
+	field := func(record []string, column string) string {
+		i := columns[column]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	var entries []Entry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := Entry{ID: field(record, "id"), Name: field(record, "name")}
+		for _, val := range strings.Split(field(record, "aliases"), ";") {
+			if val = strings.TrimSpace(val); val != "" {
+				e.Aliases = append(e.Aliases, val)
+			}
+		}
+		entries = append(entries, e)
+	}
+}
+
+// folding replaces letters with diacritics by base ones, so names written with and without them match.
+var folding = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "č", "c", "ć", "c", "ď", "d", "đ", "d",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ě", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ł", "l", "ñ", "n", "ň", "n", "ń", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "œ", "oe",
+	"ř", "r", "š", "s", "ś", "s", "ß", "ss", "ť", "t",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ů", "u",
+	"ý", "y", "ÿ", "y", "ž", "z", "ź", "z", "ż", "z",
+	"ё", "е", "й", "и",
+)
+
+// Normalize returns name in lower case without diacritics and punctuation, with words sorted, so the same name
+// written in different order or with different separators is normalized the same way.
+func Normalize(name string) string {
+	name = folding.Replace(strings.ToLower(name))
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// Similarity returns similarity of normalized names from 0 to 1 by edit distance between them.
+func Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(distance(ra, rb))/float64(longest)
+}
+
+// distance returns Levenshtein distance between strings.
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(values ...int) int {
+	result := values[0]
+	for _, val := range values[1:] {
+		if val < result {
+			result = val
+		}
+	}
+	return result
+}
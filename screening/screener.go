@@ -0,0 +1,122 @@
+package screening
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Screener matches names against blocklist file and reloads the file, when it is changed. If a changed file can not
+// be loaded, the previous list is kept.
+type Screener struct {
+	path      string
+	threshold float64
+	interval  time.Duration
+	logger    log.Logger
+
+	mtx  sync.RWMutex
+	list *List
+	// modTime and size of the loaded file detect its changes.
+	modTime time.Time
+	size    int64
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// ScreenerOption sets an optional parameter for screener.
+type ScreenerOption func(*Screener)
+
+// ScreenerThreshold sets minimal similarity of names, which is a match.
+func ScreenerThreshold(threshold float64) ScreenerOption {
+	return func(s *Screener) { s.threshold = threshold }
+}
+
+// ScreenerInterval sets pause between checks of the file for changes.
+func ScreenerInterval(d time.Duration) ScreenerOption {
+	return func(s *Screener) { s.interval = d }
+}
+
+// NewScreener returns a screener of the list from file, see Parse for its formats. It fails, if the file can not be
+// loaded. Call Start to begin reloading.
+func NewScreener(path string, logger log.Logger, options ...ScreenerOption) (*Screener, error) {
+	s := &Screener{
+		path:      path,
+		threshold: 0.85,
+		interval:  10 * time.Second,
+		logger:    logger,
+		quit:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Match returns the best match of name in the current list.
+func (s *Screener) Match(name string) (Match, bool) {
+	s.mtx.RLock()
+	list := s.list
+	s.mtx.RUnlock()
+	return list.Match(name, s.threshold)
+}
+
+// Reload loads the file, if it is changed since the last load, and reports whether it is loaded.
+func (s *Screener) Reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.mtx.RLock()
+	changed := s.list == nil || !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+	s.mtx.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return false, err
+	}
+	list, err := Parse(s.path, data)
+	if err != nil {
+		return false, err
+	}
+	s.mtx.Lock()
+	s.list, s.modTime, s.size = list, info.ModTime(), info.Size()
+	s.mtx.Unlock()
+	_ = s.logger.Log("method", "reload", "path", s.path, "entries", list.Len())
+	return true, nil
+}
+
+// Start checks the file for changes in background.
+func (s *Screener) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.quit:
+				return
+			case <-ticker.C:
+				if _, err := s.Reload(); err != nil {
+					_ = s.logger.Log("method", "reload", "path", s.path, "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop terminates reloading.
+func (s *Screener) Stop() {
+	s.once.Do(func() { close(s.quit) })
+	s.wg.Wait()
+}
@@ -0,0 +1,145 @@
+package screening
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+)
+
+// Subject of screening, which found a match.
+type Subject string
+
+const (
+	SubjectAccount  Subject = "account"
+	SubjectTransfer Subject = "transfer"
+)
+
+// Hit is a record of audit trail about holder of account, which matched entry of the list.
+type Hit struct {
+	TableName struct{}  `json:"-" sql:"screening_hits"`
+	Sequence  int64     `json:"-" sql:"sequence,pk"`
+	ID        uuid.UUID `json:"id" sql:"id,notnull,unique,type:varchar(36)"`
+	Subject   Subject   `json:"subject" sql:"subject,notnull,type:varchar(16)"`
+	// Account is an account, which holder matched.
+	Account account.ID `json:"account" sql:"account,notnull,type:varchar(255)"`
+	// From is a source account of screened transfer, it is Account itself for outgoing transfer.
+	From      account.ID `json:"from,omitempty" sql:"from_account,type:varchar(255)"`
+	Name      string     `json:"name" sql:"name,notnull"`
+	EntryID   string     `json:"entry_id" sql:"entry_id,notnull"`
+	EntryName string     `json:"entry_name" sql:"entry_name,notnull"`
+	Score     float64    `json:"score" sql:"score,notnull"`
+	CreatedAt time.Time  `json:"created_at" sql:"created_at,notnull"`
+}
+
+// Matcher finds names in blocklist, Screener is the one of the application.
+type Matcher interface {
+	// Match returns the best match of name, or false if name is not in the list.
+	Match(name string) (Match, bool)
+}
+
+// Checker screens holders of new accounts and parties of transfers, and records hits. It is account.Screening and
+// payment.Rule, transfer, which party matches, is held for review.
+type Checker struct {
+	matcher Matcher
+	hits    Repository
+	now     func() time.Time
+}
+
+// NewChecker returns a checker, which stores hits in repository.
+func NewChecker(matcher Matcher, hits Repository) *Checker {
+	return &Checker{matcher: matcher, hits: hits, now: time.Now}
+}
+
+func (c *Checker) hit(subject Subject, a *account.Account, from account.ID, m Match) *Hit {
+	return &Hit{
+		ID:        uuid.New(),
+		Subject:   subject,
+		Account:   a.ID,
+		From:      from,
+		Name:      m.Name,
+		EntryID:   m.Entry.ID,
+		EntryName: m.Entry.Name,
+		Score:     m.Score,
+		CreatedAt: c.now().UTC(),
+	}
+}
+
+// ScreenAccount reports whether holder of new account matches the list, and records the hit.
+func (c *Checker) ScreenAccount(ctx context.Context, a *account.Account) (bool, error) {
+	m, ok := c.matcher.Match(a.Name)
+	if !ok {
+		return false, nil
+	}
+	if err := c.hits.StoreHits(ctx, c.hit(SubjectAccount, a, "", m)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Name identifies rule in reasons of decisions.
+func (c *Checker) Name() string {
+	return "screening"
+}
+
+// Evaluate holds transfer for review, if holder of source or any target account matches the list. Hits of dry run
+// transfers are not recorded.
+func (c *Checker) Evaluate(ctx context.Context, t *payment.Transfer) (payment.Decision, string, error) {
+	var (
+		hits    []*Hit
+		reasons []string
+	)
+	for _, a := range append([]*account.Account{t.From}, t.Targets...) {
+		m, ok := c.matcher.Match(a.Name)
+		if !ok {
+			continue
+		}
+		hits = append(hits, c.hit(SubjectTransfer, a, t.From.ID, m))
+		reasons = append(reasons, fmt.Sprintf("holder of %s matches %q with score %.2f", a.ID, m.Entry.Name,
+			m.Score))
+	}
+	if len(hits) == 0 {
+		return payment.DecisionAllow, "", nil
+	}
+	if !t.DryRun {
+		if err := c.hits.StoreHits(ctx, hits...); err != nil {
+			return "", "", err
+		}
+	}
+	return payment.DecisionReview, strings.Join(reasons, ", "), nil
+}
+
+// Service is the interface that provides audit trail of screening.
+type Service interface {
+	// Hits returns hits of account, or of all accounts if id is empty, in order of their registration.
+	Hits(ctx context.Context, id account.ID) ([]*Hit, error)
+}
+
+type service struct {
+	hits Repository
+}
+
+// Hits returns hits of account, or of all accounts if id is empty, in order of their registration.
+func (s *service) Hits(ctx context.Context, id account.ID) ([]*Hit, error) {
+	return s.hits.FindHits(ctx, id)
+}
+
+// NewService creates a screening service with necessary dependencies.
+func NewService(hits Repository) Service {
+	return &service{
+		hits: hits,
+	}
+}
+
+// Repository interface for screening hits storing.
+type Repository interface {
+	// StoreHits saves new hits.
+	StoreHits(ctx context.Context, hits ...*Hit) error
+
+	// FindHits returns hits of account, or of all accounts if id is empty, in order of their storing.
+	FindHits(ctx context.Context, id account.ID) ([]*Hit, error)
+}
@@ -0,0 +1,250 @@
+package screening_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/admin"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/principal"
+	"github.com/otetz/payments/screening"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for _, item := range []struct {
+		Name, Normalized string
+	}{
+		{"John Doe", "doe john"},
+		{"  DOE,  John ", "doe john"},
+		{"José Müller-Łukasz", "jose lukasz muller"},
+		{"O'Brien & Sons, Ltd.", "brien ltd o sons"},
+		{"Пётр Иванов", "иванов петр"},
+		{"!!!", ""},
+	} {
+		if got := screening.Normalize(item.Name); got != item.Normalized {
+			t.Errorf("%q: got %q want %q", item.Name, got, item.Normalized)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	list := screening.NewList([]screening.Entry{
+		{ID: "sdn-1", Name: "John Doe", Aliases: []string{"Johnny D"}},
+		{Name: "Acme Trading LLC"},
+		{ID: "empty"},
+	})
+	if list.Len() != 2 {
+		t.Fatalf("wrong number of entries: %d", list.Len())
+	}
+	for _, item := range []struct {
+		Name  string
+		Entry string
+	}{
+		{"Doe, John", "sdn-1"},
+		{"Jon Doe", "sdn-1"},
+		{"johnny d.", "sdn-1"},
+		{"ACME Trading, LLC", "Acme Trading LLC"},
+		{"Jane Roe", ""},
+		{"", ""},
+	} {
+		m, ok := list.Match(item.Name, 0.85)
+		if ok != (item.Entry != "") || m.Entry.ID != item.Entry {
+			t.Errorf("%q: wrong match %+v %v, want %q", item.Name, m, ok, item.Entry)
+		}
+	}
+	if got := screening.Similarity("doe jon", "doe john"); got != 0.875 {
+		t.Errorf("wrong similarity: %v", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, item := range []struct {
+		Path string
+		Data string
+		Len  int
+		Err  string
+	}{
+		{Path: "list.csv", Data: "id,name,aliases\nsdn-1,John Doe,Johnny D; J. Doe\nsdn-2,\"Acme, LLC\",\n", Len: 2},
+		{Path: "list.CSV", Data: "name\nJohn Doe\n\n", Len: 1},
+		{Path: "list.json", Data: `[{"id": "sdn-1", "name": "John Doe", "aliases": ["Johnny D"]}]`, Len: 1},
+		{Path: "list.csv", Data: "", Err: "header is missing"},
+		{Path: "list.csv", Data: "id,alias\n1,John\n", Err: "name column is missing"},
+		{Path: "list.json", Data: `{"name": "John Doe"}`, Err: "cannot unmarshal"},
+		{Path: "list.txt", Data: "John Doe", Err: `unsupported format ".txt"`},
+	} {
+		list, err := screening.Parse(item.Path, []byte(item.Data))
+		if item.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), item.Err) {
+				t.Errorf("%s %q: wrong error: got %v want %q", item.Path, item.Data, err, item.Err)
+			}
+			continue
+		}
+		OK(t, err)
+		if list.Len() != item.Len {
+			t.Errorf("%s %q: wrong number of entries: got %d want %d", item.Path, item.Data, list.Len(), item.Len)
+		}
+	}
+}
+
+func writeList(t *testing.T, path, data string, modTime time.Time) {
+	t.Helper()
+	OK(t, ioutil.WriteFile(path, []byte(data), 0600))
+	OK(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestScreenerReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "screening")
+	OK(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "blocklist.csv")
+	now := time.Now()
+
+	_, err = screening.NewScreener(path, log.NewNopLogger())
+	if err == nil {
+		t.Fatal("screener of missing file is created")
+	}
+	writeList(t, path, "name\nJohn Doe\n", now)
+	s, err := screening.NewScreener(path, log.NewNopLogger(), screening.ScreenerThreshold(1))
+	OK(t, err)
+	if _, ok := s.Match("Doe John"); !ok {
+		t.Error("name of the list is not matched")
+	}
+	if _, ok := s.Match("Jon Doe"); ok {
+		t.Error("similar name is matched with threshold 1")
+	}
+
+	loaded, err := s.Reload()
+	OK(t, err)
+	if loaded {
+		t.Error("not changed file is reloaded")
+	}
+	writeList(t, path, "name\nJane Roe\n", now.Add(time.Second))
+	loaded, err = s.Reload()
+	OK(t, err)
+	if !loaded {
+		t.Error("changed file is not reloaded")
+	}
+	if _, ok := s.Match("John Doe"); ok {
+		t.Error("removed name is matched")
+	}
+	if _, ok := s.Match("Jane Roe"); !ok {
+		t.Error("added name is not matched")
+	}
+
+	// Broken file does not replace the loaded list.
+	writeList(t, path, "id\n1\n", now.Add(2*time.Second))
+	if _, err := s.Reload(); err == nil {
+		t.Error("broken file is reloaded")
+	}
+	if _, ok := s.Match("Jane Roe"); !ok {
+		t.Error("list is lost after failed reload")
+	}
+}
+
+type matcher struct {
+	*screening.List
+}
+
+func (m matcher) Match(name string) (screening.Match, bool) {
+	return m.List.Match(name, 0.85)
+}
+
+func TestChecker(t *testing.T) {
+	ctx := principal.NewContext(context.Background(), "maker")
+	hits := inmem.NewScreeningRepository()
+	checker := screening.NewChecker(matcher{screening.NewList([]screening.Entry{{ID: "sdn-1", Name: "John Doe"}})},
+		hits)
+	accounts := inmem.NewAccountRepository()
+	payments := inmem.NewPaymentRepository(accounts)
+	as := account.NewService(accounts, account.ServiceScreening(checker))
+	ps := payment.NewService(payments, accounts, payment.ServiceRules(checker),
+		payment.ServiceApprovals(inmem.NewApprovalRepository(payments), decimal.NewFromFloat(1000), time.Hour))
+
+	// Holder of a new account matches the list, so account is frozen until review.
+	OK(t, as.New(ctx, "doe", account.CurrencyUSD, decimal.Zero, account.Details{Name: "Doe, Jon"}))
+	OK(t, as.New(ctx, "alice", account.CurrencyUSD, decimal.NewFromFloat(100), account.Details{Name: "Alice"}))
+	a, err := as.Load(ctx, "doe")
+	OK(t, err)
+	if a.Status != account.StatusFrozen {
+		t.Errorf("wrong status of matched account: %s", a.Status)
+	}
+	a, err = as.Load(ctx, "alice")
+	OK(t, err)
+	if a.Status != account.StatusActive {
+		t.Errorf("wrong status of account: %s", a.Status)
+	}
+
+	// Holder matches the list after account is opened, e.g. list is updated, so transfer is held for review.
+	OK(t, accounts.Store(ctx, &account.Account{ID: "john", Name: "John Doe", Currency: "USD", Status: "active"}))
+	assessment, err := ps.Assess(ctx, "alice", decimal.NewFromFloat(10), "john", payment.Details{})
+	OK(t, err)
+	if assessment.Decision != payment.DecisionReview ||
+		strings.Join(assessment.Reasons, "; ") != `screening: holder of john matches "John Doe" with score 1.00` {
+		t.Errorf("wrong assessment: %+v", assessment)
+	}
+	approval, err := ps.New(ctx, "alice", decimal.NewFromFloat(10), "john", payment.Details{})
+	OK(t, err)
+	if approval == nil || approval.Status != payment.ApprovalPending || approval.Risk != payment.DecisionReview {
+		t.Errorf("transfer is not held for review: %+v", approval)
+	}
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(10), "doe", payment.Details{}); err == nil {
+		t.Error("transfer to frozen account is registered")
+	}
+
+	// Client can not unfreeze the account by update, only review in admin API does.
+	do := func(handler http.Handler, method, path, body string, status int) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", "*")
+		req.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("%s %s: wrong status: got %d want %d: %s", method, path, w.Code, status, w.Body)
+		}
+	}
+	do(account.MakeHandler(as, log.NewNopLogger()), http.MethodPatch, "/api/accounts/v1/accounts/doe",
+		`{"status": "active"}`, http.StatusUnprocessableEntity)
+	if a, err := as.Load(ctx, "doe"); err != nil || a.Status != account.StatusFrozen {
+		t.Errorf("screened account is unfrozen by update: %+v, %v", a, err)
+	}
+	do(admin.MakeHandler(admin.NewService(nil, as), "s3cret", log.NewNopLogger()), http.MethodPost,
+		"/api/admin/v1/accounts/doe/unfreeze", "", http.StatusOK)
+	if a, err := as.Load(ctx, "doe"); err != nil || a.Status != account.StatusActive {
+		t.Errorf("screened account is not unfrozen by review: %+v, %v", a, err)
+	}
+
+	// Dry run is not recorded.
+	found, err := screening.NewService(hits).Hits(ctx, "")
+	OK(t, err)
+	if len(found) != 2 {
+		t.Fatalf("wrong number of hits: %d", len(found))
+	}
+	if h := found[0]; h.Subject != screening.SubjectAccount || h.Account != "doe" || h.Name != "Doe, Jon" ||
+		h.EntryID != "sdn-1" || h.Score != 0.875 {
+		t.Errorf("wrong hit of account: %+v", h)
+	}
+	if h := found[1]; h.Subject != screening.SubjectTransfer || h.Account != "john" || h.From != "alice" ||
+		h.Score != 1 {
+		t.Errorf("wrong hit of transfer: %+v", h)
+	}
+}
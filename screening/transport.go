@@ -0,0 +1,40 @@
+package screening
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/tracing"
+
+	kitlog "github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// MakeHandler returns a handler for the screening service.
+func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext),
+	}
+
+	hitsHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("screening.hits")(makeHitsEndpoint(s)),
+		decodeHitsRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	router := mux.NewRouter()
+
+	router.Handle("/api/screening/v1/hits", hitsHandler).Methods("GET")
+
+	return router
+}
+
+func decodeHitsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return hitsRequest{Account: account.ID(r.URL.Query().Get("account"))}, nil
+}
@@ -0,0 +1,300 @@
+// Package stream provides live feed of account activity to HTTP clients, as Server-Sent Events.
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/event"
+)
+
+// Reset message starts the feed instead of missed messages, if they are not kept in the outbox any more. Client
+// must load the current state, messages after it follow the state.
+const Reset event.Type = "Reset"
+
+// Message is a single item of the feed. It describes a domain event and balances of involved accounts.
+type Message struct {
+	Sequence int64        `json:"sequence"`
+	Type     event.Type   `json:"type"`
+	Accounts []account.ID `json:"-"`
+
+	Account   account.ID      `json:"account,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Balances  []event.Balance `json:"balances,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Involves reports whether message concerns specified account. Empty account means any.
+func (m *Message) Involves(id account.ID) bool {
+	if id == "" {
+		return true
+	}
+	for _, val := range m.Accounts {
+		if val == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscriber receives messages from the broker. Channel C is closed when subscriber is dropped by the broker,
+// because it did not keep up with the feed, or when broker is stopped.
+type Subscriber struct {
+	C       <-chan *Message
+	c       chan *Message
+	account account.ID
+}
+
+// Broker tails the outbox and fans out new events to subscribers.
+//
+// Publishing never blocks: subscriber, whose buffer is full, is dropped. Client is expected to reconnect with
+// Last-Event-ID header, and to get missed messages from the outbox.
+type Broker struct {
+	outbox   event.Outbox
+	accounts account.Repository
+	logger   log.Logger
+	interval time.Duration
+	buffer   int
+	gapWait  time.Duration
+
+	mtx         sync.Mutex
+	subscribers map[*Subscriber]struct{}
+	last        int64
+	gapSince    time.Time
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+	once sync.Once
+}
+
+// BrokerOption sets an optional parameter for broker.
+type BrokerOption func(*Broker)
+
+// BrokerInterval sets pause between outbox polls.
+func BrokerInterval(d time.Duration) BrokerOption {
+	return func(b *Broker) { b.interval = d }
+}
+
+// BrokerBuffer sets number of messages, which may wait for a slow subscriber before it is dropped.
+func BrokerBuffer(n int) BrokerOption {
+	return func(b *Broker) { b.buffer = n }
+}
+
+// NewBroker returns a new broker. Call Start to begin tailing the outbox.
+func NewBroker(outbox event.Outbox, accounts account.Repository, logger log.Logger, options ...BrokerOption) *Broker {
+	b := &Broker{
+		outbox:      outbox,
+		accounts:    accounts,
+		logger:      logger,
+		interval:    200 * time.Millisecond,
+		buffer:      64,
+		gapWait:     5 * time.Second,
+		subscribers: make(map[*Subscriber]struct{}),
+		quit:        make(chan struct{}),
+	}
+	for _, option := range options {
+		option(b)
+	}
+	return b
+}
+
+// Start tails the outbox in background, beginning from its current end.
+func (b *Broker) Start() error {
+	last, err := b.outbox.Latest()
+	if err != nil {
+		return err
+	}
+	b.last = last
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.quit:
+				return
+			case <-ticker.C:
+				b.poll(ctx)
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop terminates tailing and closes all subscribers.
+func (b *Broker) Stop() {
+	b.once.Do(func() { close(b.quit) })
+	b.wg.Wait()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	for s := range b.subscribers {
+		b.drop(s)
+	}
+}
+
+// Subscribe registers a new subscriber, interested in specified account, or in all accounts if it is empty.
+func (b *Broker) Subscribe(id account.ID) *Subscriber {
+	c := make(chan *Message, b.buffer)
+	s := &Subscriber{C: c, c: c, account: id}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe removes subscriber from the broker.
+func (b *Broker) Unsubscribe(s *Subscriber) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.drop(s)
+}
+
+// Replay returns messages about events after specified sequence, up to the last one delivered to subscribers. If
+// events after the sequence are not kept in the outbox, e.g. they are pruned or sequence is not from this outbox,
+// it returns Reset message with the last delivered sequence instead.
+func (b *Broker) Replay(ctx context.Context, since int64, id account.ID) ([]*Message, error) {
+	b.mtx.Lock()
+	last := b.last
+	b.mtx.Unlock()
+
+	kept, err := b.kept(since)
+	if err != nil {
+		return nil, err
+	}
+	if !kept {
+		return []*Message{{Sequence: last, Type: Reset, CreatedAt: time.Now().UTC()}}, nil
+	}
+	var result []*Message
+	for since < last {
+		events, err := b.outbox.Since(since, 100)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, e := range events {
+			if e.Sequence > last {
+				return result, nil
+			}
+			if m := b.message(ctx, e); m.Involves(id) {
+				result = append(result, m)
+			}
+			since = e.Sequence
+		}
+	}
+	return result, nil
+}
+
+// kept reports whether all events after sequence are in the outbox.
+func (b *Broker) kept(since int64) (bool, error) {
+	oldest, err := b.outbox.Oldest()
+	if err != nil {
+		return false, err
+	}
+	latest, err := b.outbox.Latest()
+	if err != nil {
+		return false, err
+	}
+	return since >= oldest-1 && since <= latest, nil
+}
+
+func (b *Broker) poll(ctx context.Context) {
+	for {
+		events, err := b.outbox.Since(b.last, 100)
+		if err != nil {
+			_ = b.logger.Log("method", "since", "err", err)
+			return
+		}
+		for _, e := range events {
+			if e.Sequence != b.last+1 && !b.gapExpired() {
+				// Transaction with the missing sequence may be not committed yet, wait for it a bit.
+				return
+			}
+			b.gapSince = time.Time{}
+			b.publish(b.message(ctx, e))
+		}
+		if len(events) < 100 {
+			return
+		}
+	}
+}
+
+func (b *Broker) gapExpired() bool {
+	if b.gapSince.IsZero() {
+		b.gapSince = time.Now()
+		return false
+	}
+	return time.Since(b.gapSince) > b.gapWait
+}
+
+func (b *Broker) publish(m *Message) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.last = m.Sequence
+	for s := range b.subscribers {
+		if !m.Involves(s.account) {
+			continue
+		}
+		select {
+		case s.c <- m:
+		default:
+			_ = b.logger.Log("method", "publish", "account", s.account, "msg", "slow subscriber dropped")
+			b.drop(s)
+		}
+	}
+}
+
+// drop must be called with mtx locked.
+func (b *Broker) drop(s *Subscriber) {
+	if _, ok := b.subscribers[s]; ok {
+		delete(b.subscribers, s)
+		close(s.c)
+	}
+}
+
+// message describes event with balances of involved accounts. Transfer events have balances right after the
+// transfer, others have current ones.
+func (b *Broker) message(ctx context.Context, e *event.Event) *Message {
+	m := &Message{
+		Sequence:  e.Sequence,
+		Type:      e.Type,
+		Accounts:  []account.ID{e.Account},
+		Account:   e.Account,
+		Payload:   e.Payload,
+		CreatedAt: e.CreatedAt,
+	}
+	if e.Type == event.TransferCompleted || e.Type == event.TransferReversed {
+		var p event.TransferCompletedPayload
+		if err := json.Unmarshal(e.Payload, &p); err == nil && p.To != "" {
+			m.Accounts = append(m.Accounts, p.To)
+		}
+		for _, val := range p.Splits {
+			m.Accounts = append(m.Accounts, val.Account)
+		}
+		// Events written before balances were added to payloads get current ones.
+		if len(p.Balances) > 0 {
+			m.Balances = p.Balances
+			return m
+		}
+	}
+	if e.Type != event.AccountDeleted {
+		for _, id := range m.Accounts {
+			if a, err := b.accounts.Find(ctx, id); err == nil {
+				m.Balances = append(m.Balances, event.Balance{ID: a.ID, Balance: a.Balance, Currency: a.Currency})
+			}
+		}
+	}
+	return m
+}
@@ -0,0 +1,235 @@
+package stream_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/event"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/stream"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	EndpointURL = "/api/stream/v1/accounts"
+)
+
+type sse struct {
+	ID    int64
+	Event string
+	Data  stream.Message
+}
+
+type fixture struct {
+	as     account.Service
+	ps     payment.Service
+	broker *stream.Broker
+	server *httptest.Server
+}
+
+func setup(t *testing.T, options ...stream.BrokerOption) *fixture {
+	logger := log.NewLogfmtLogger(os.Stderr)
+
+	outbox := inmem.NewOutbox()
+	accounts := inmem.NewAccountRepository(inmem.WithOutbox(outbox))
+	payments := inmem.NewPaymentRepository(accounts, inmem.WithOutbox(outbox))
+
+	f := &fixture{
+		as: account.NewService(accounts),
+		ps: payment.NewService(payments, accounts),
+	}
+	ctx := context.Background()
+	OK(t, f.as.New(ctx, "alice", account.CurrencyUSD, decimal.NewFromFloat(100), account.Details{}))
+	OK(t, f.as.New(ctx, "bob", account.CurrencyUSD, decimal.NewFromFloat(100), account.Details{}))
+	OK(t, f.as.New(ctx, "carol", account.CurrencyUSD, decimal.NewFromFloat(100), account.Details{}))
+
+	f.broker = stream.NewBroker(outbox, accounts, logger,
+		append([]stream.BrokerOption{stream.BrokerInterval(5 * time.Millisecond)}, options...)...)
+	OK(t, f.broker.Start())
+	f.server = httptest.NewServer(stream.MakeHandler(f.broker, logger))
+	return f
+}
+
+func (f *fixture) close() {
+	f.broker.Stop()
+	f.server.Close()
+}
+
+// listen connects to the feed and returns channel of received messages. Connection is closed with ctx.
+func (f *fixture) listen(ctx context.Context, t *testing.T, path string, lastEventID int64) <-chan sse {
+	req, err := http.NewRequest(http.MethodGet, f.server.URL+path, nil)
+	OK(t, err)
+	if lastEventID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatInt(lastEventID, 10))
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	OK(t, err)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("wrong content type: %v", ct)
+	}
+
+	c := make(chan sse, 100)
+	go func() {
+		defer close(c)
+		defer func() { _ = resp.Body.Close() }()
+		var cur sse
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if cur.Event != "" {
+					c <- cur
+				}
+				cur = sse{}
+			case strings.HasPrefix(line, "id: "):
+				cur.ID, _ = strconv.ParseInt(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "event: "):
+				cur.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &cur.Data)
+			}
+		}
+	}()
+	return c
+}
+
+func receive(t *testing.T, c <-chan sse) sse {
+	select {
+	case m, ok := <-c:
+		if !ok {
+			t.Fatal("feed closed")
+		}
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received in time")
+	}
+	return sse{}
+}
+
+func TestFeed(t *testing.T) {
+	f := setup(t)
+	defer f.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all := f.listen(ctx, t, EndpointURL, 0)
+	carol := f.listen(ctx, t, EndpointURL+"/carol", 0)
+
+	if _, err := f.ps.New(ctx, "alice", decimal.NewFromFloat(10), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ps.New(ctx, "bob", decimal.NewFromFloat(5), "carol", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, all)
+	if first.Event != string(event.TransferCompleted) || first.Data.Account != "alice" {
+		t.Errorf("unexpected message: %+v", first)
+	}
+	if len(first.Data.Balances) != 2 || first.Data.Balances[0].ID != "alice" || first.Data.Balances[1].ID != "bob" {
+		t.Fatalf("balances of both accounts expected: %+v", first.Data.Balances)
+	}
+	// Balance of bob is changed by the second transfer too, message has the one right after the first.
+	if !first.Data.Balances[0].Balance.Equal(decimal.NewFromFloat(90)) ||
+		!first.Data.Balances[1].Balance.Equal(decimal.NewFromFloat(110)) {
+		t.Errorf("balances after transfer expected: %+v", first.Data.Balances)
+	}
+	second := receive(t, all)
+	if second.ID != first.ID+1 || second.Data.Account != "bob" {
+		t.Errorf("unexpected message: %+v", second)
+	}
+
+	// Feed of carol contains only the transfer to carol.
+	if m := receive(t, carol); m.ID != second.ID {
+		t.Errorf("unexpected message for carol: %+v", m)
+	}
+
+	t.Run("resume with Last-Event-ID", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		resumed := f.listen(ctx, t, EndpointURL, first.ID)
+
+		if m := receive(t, resumed); m.ID != second.ID {
+			t.Errorf("missed message expected, got %+v", m)
+		}
+		OK(t, f.as.Delete(ctx, "carol"))
+		if m := receive(t, resumed); m.Event != string(event.AccountDeleted) || m.Data.Account != "carol" {
+			t.Errorf("live message expected, got %+v", m)
+		}
+	})
+
+	t.Run("unknown Last-Event-ID", func(t *testing.T) {
+		// Sequence of another outbox, e.g. before restart, is either above the latest or before the oldest one.
+		for _, lastEventID := range []int64{second.ID + 1000, 1} {
+			ctx, cancel := context.WithCancel(context.Background())
+			m := receive(t, f.listen(ctx, t, EndpointURL, lastEventID))
+			if m.Event != string(stream.Reset) || m.ID < second.ID {
+				t.Errorf("%d: reset expected, got %+v", lastEventID, m)
+			}
+			cancel()
+		}
+	})
+
+	t.Run("wrong Last-Event-ID", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, f.server.URL+EndpointURL, nil)
+		OK(t, err)
+		req.Header.Set("Last-Event-ID", "abc")
+		resp, err := http.DefaultClient.Do(req)
+		OK(t, err)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusUnprocessableEntity {
+			t.Errorf("wrong status code: got %v want %v", resp.StatusCode, http.StatusUnprocessableEntity)
+		}
+	})
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	f := setup(t, stream.BrokerBuffer(1))
+	defer f.close()
+
+	s := f.broker.Subscribe("")
+	ctx := context.Background()
+	if _, err := f.ps.New(ctx, "alice", decimal.NewFromFloat(1), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ps.New(ctx, "alice", decimal.NewFromFloat(1), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	// Let broker publish both events while nobody reads.
+	time.Sleep(50 * time.Millisecond)
+
+	deadline := time.After(5 * time.Second)
+	received := 0
+	for {
+		select {
+		case _, ok := <-s.C:
+			if !ok {
+				if received != 1 {
+					t.Errorf("only buffered message expected before drop, got %d", received)
+				}
+				return
+			}
+			received++
+		case <-deadline:
+			t.Fatal("slow subscriber is not dropped")
+		}
+	}
+}
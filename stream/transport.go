@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/requestid"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// HeartbeatInterval is a pause between comments, sent to keep idle connection alive through proxies.
+var HeartbeatInterval = 15 * time.Second
+
+// MakeHandler returns a handler for the live feed.
+func MakeHandler(b *Broker, logger kitlog.Logger) http.Handler {
+	router := mux.NewRouter()
+
+	router.Handle("/api/stream/v1/accounts", feedHandler(b, logger)).Methods("GET")
+	router.Handle("/api/stream/v1/accounts/{id}", feedHandler(b, logger)).Methods("GET")
+
+	return router
+}
+
+func feedHandler(b *Broker, logger kitlog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := errs.PopulateRequestContext(r.Context(), r)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			errs.EncodeError(ctx, fmt.Errorf("streaming is not supported"), w)
+			return
+		}
+		id := account.ID(mux.Vars(r)["id"])
+		since, err := lastEventID(r)
+		if err != nil {
+			errs.EncodeError(ctx, errs.ValidationError{Err: err}, w)
+			return
+		}
+
+		// Subscribe before replay, so nothing is lost in between. Duplicates are skipped by sequence.
+		s := b.Subscribe(id)
+		defer b.Unsubscribe(s)
+
+		var missed []*Message
+		if since > 0 {
+			if missed, err = b.Replay(r.Context(), since, id); err != nil {
+				errs.EncodeError(ctx, err, w)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		for _, m := range missed {
+			if err := write(w, m); err != nil {
+				return
+			}
+			since = m.Sequence
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(HeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case m, ok := <-s.C:
+				if !ok {
+					// Dropped as slow, client will reconnect with Last-Event-ID.
+					return
+				}
+				if m.Sequence <= since {
+					continue
+				}
+				if err := write(w, m); err != nil {
+					_ = logger.Log("account", id, "request_id", requestid.FromContext(ctx), "err", err)
+					return
+				}
+				since = m.Sequence
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func lastEventID(r *http.Request) (int64, error) {
+	val := r.Header.Get("Last-Event-ID")
+	if val == "" {
+		// EventSource can not set headers on the first connection, so it is allowed to be a query parameter.
+		val = r.URL.Query().Get("last_event_id")
+	}
+	if val == "" {
+		return 0, nil
+	}
+	since, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || since < 0 {
+		return 0, govalidator.Error{
+			Name:      "Last-Event-ID",
+			Err:       fmt.Errorf("%s is not a sequence number", val),
+			Validator: "int",
+		}
+	}
+	return since, nil
+}
+
+func write(w http.ResponseWriter, m *Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", m.Sequence, m.Type, data)
+	return err
+}
@@ -0,0 +1,71 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/endpoint"
+)
+
+// Handler is an HTTP middleware, which starts server span of request. Trace continues from traceparent header,
+// if it is valid, or starts a new one.
+func (t *Tracer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+			ctx = WithRemoteParent(ctx, sc)
+		}
+		ctx, span := t.Start(ctx, "HTTP "+r.Method, KindServer)
+		defer span.Finish()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", sw.status)
+		if sw.status >= http.StatusInternalServerError {
+			span.SetError(httpError(sw.status))
+		}
+	})
+}
+
+type httpError int
+
+func (e httpError) Error() string {
+	return http.StatusText(int(e))
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming responses (live feed) working through the middleware.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// EndpointMiddleware traces calls of go-kit endpoint as child spans of request.
+func EndpointMiddleware(name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := StartSpan(ctx, name, KindInternal)
+			defer func() {
+				if e, ok := response.(interface{ ErrError() error }); ok {
+					span.SetError(e.ErrError())
+				}
+				span.SetError(err)
+				span.Finish()
+			}()
+			return next(ctx, request)
+		}
+	}
+}
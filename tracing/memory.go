@@ -0,0 +1,25 @@
+package tracing
+
+import "sync"
+
+// MemoryExporter keeps finished spans in memory, for tests.
+type MemoryExporter struct {
+	mtx   sync.Mutex
+	spans []*Span
+}
+
+// ExportSpan stores span.
+func (e *MemoryExporter) ExportSpan(s *Span) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	e.spans = append(e.spans, s)
+}
+
+// Spans returns all exported spans, in order of finishing.
+func (e *MemoryExporter) Spans() []*Span {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	return append([]*Span(nil), e.spans...)
+}
@@ -0,0 +1,255 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// OTLPExporter sends spans in batches to OpenTelemetry collector, using OTLP/HTTP protocol with JSON encoding.
+// Spans are dropped if the queue is full, tracing must not slow requests down.
+type OTLPExporter struct {
+	url      string
+	client   *http.Client
+	logger   log.Logger
+	interval time.Duration
+	batch    int
+
+	queue chan *Span
+	quit  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// OTLPOption sets an optional parameter of exporter.
+type OTLPOption func(*OTLPExporter)
+
+// OTLPInterval sets maximal pause between sending of collected spans.
+func OTLPInterval(d time.Duration) OTLPOption {
+	return func(e *OTLPExporter) { e.interval = d }
+}
+
+// OTLPBatch sets number of spans, which are sent without waiting for interval.
+func OTLPBatch(n int) OTLPOption {
+	return func(e *OTLPExporter) { e.batch = n }
+}
+
+// OTLPClient sets HTTP client, which is used for sending spans.
+func OTLPClient(c *http.Client) OTLPOption {
+	return func(e *OTLPExporter) { e.client = c }
+}
+
+// NewOTLPExporter returns a new instance of exporter, sending to url (e.g. http://localhost:4318/v1/traces).
+func NewOTLPExporter(url string, logger log.Logger, opts ...OTLPOption) *OTLPExporter {
+	e := &OTLPExporter{
+		url:      url,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		logger:   logger,
+		interval: 5 * time.Second,
+		batch:    512,
+		quit:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.queue = make(chan *Span, 4*e.batch)
+	return e
+}
+
+// ExportSpan queues span for sending.
+func (e *OTLPExporter) ExportSpan(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+	}
+}
+
+// Start runs sending in background.
+func (e *OTLPExporter) Start() {
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop sends queued spans and stops sending.
+func (e *OTLPExporter) Stop() {
+	e.once.Do(func() { close(e.quit) })
+	e.wg.Wait()
+}
+
+func (e *OTLPExporter) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	spans := make([]*Span, 0, e.batch)
+	flush := func() {
+		if len(spans) == 0 {
+			return
+		}
+		if err := e.send(spans); err != nil {
+			_ = e.logger.Log("spans", len(spans), "err", err)
+		}
+		spans = spans[:0]
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			spans = append(spans, s)
+			if len(spans) >= e.batch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.quit:
+			for {
+				select {
+				case s := <-e.queue:
+					spans = append(spans, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	data, err := json.Marshal(encodeOTLP(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// OTLP/JSON request, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              SpanKind        `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+func encodeOTLP(spans []*Span) otlpRequest {
+	byService := make(map[string][]otlpSpan)
+	var services []string
+	for _, s := range spans {
+		service := s.tracer.service
+		if _, ok := byService[service]; !ok {
+			services = append(services, service)
+		}
+		byService[service] = append(byService[service], encodeSpan(s))
+	}
+
+	req := otlpRequest{}
+	for _, service := range services {
+		req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+			Resource: otlpResource{Attributes: []otlpAttribute{attribute("service.name", service)}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/otetz/payments/tracing"},
+				Spans: byService[service],
+			}},
+		})
+	}
+	return req
+}
+
+func encodeSpan(s *Span) otlpSpan {
+	result := otlpSpan{
+		TraceID:           s.Context.TraceID.String(),
+		SpanID:            s.Context.SpanID.String(),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusUnset},
+	}
+	if s.ParentID.IsValid() {
+		result.ParentSpanID = s.ParentID.String()
+	}
+	attributes := s.Attributes()
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result.Attributes = append(result.Attributes, attribute(key, attributes[key]))
+	}
+	if msg := s.Error(); msg != "" {
+		result.Status = otlpStatus{Code: otlpStatusError, Message: msg}
+	}
+	return result
+}
+
+func attribute(key string, val interface{}) otlpAttribute {
+	var value map[string]interface{}
+	switch v := val.(type) {
+	case string:
+		value = map[string]interface{}{"stringValue": v}
+	case bool:
+		value = map[string]interface{}{"boolValue": v}
+	case int:
+		value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]interface{}{"doubleValue": v}
+	default:
+		value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+	return otlpAttribute{Key: key, Value: value}
+}
@@ -0,0 +1,259 @@
+// Package tracing provides distributed tracing of requests across HTTP, services and the database.
+//
+// Trace context comes from W3C traceparent header of incoming request, spans of nested operations are started
+// from context by StartSpan. Without a parent span in context nothing is traced, so background jobs and tests
+// do not need a tracer.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, all spans of a request share it.
+type TraceID [16]byte
+
+// String returns lower-case hex representation of ID.
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// IsValid reports whether ID is not all zeroes.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+// SpanID identifies a span inside of a trace.
+type SpanID [8]byte
+
+// String returns lower-case hex representation of ID.
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// IsValid reports whether ID is not all zeroes.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+// SpanContext is a part of span, which is propagated between processes.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent returns value of W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses value of W3C traceparent header (https://www.w3.org/TR/trace-context/).
+func ParseTraceparent(val string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(val), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("malformed traceparent %q", val)
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("malformed traceparent %q", val)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("malformed trace id: %s", err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("malformed parent id: %s", err)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("malformed trace flags: %s", err)
+	}
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q", val)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// SpanKind describes relation of span to other participants of the trace.
+type SpanKind int
+
+// Values match OTLP span kinds.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Span is a timed operation of a trace. Methods of nil span do nothing, so callers do not need to check it.
+type Span struct {
+	Name     string
+	Kind     SpanKind
+	Context  SpanContext
+	ParentID SpanID
+	Start    time.Time
+	End      time.Time
+
+	mtx        sync.Mutex
+	attributes map[string]interface{}
+	err        string
+	tracer     *Tracer
+}
+
+// SetAttribute records a property of operation. Value must be a string, bool, integer or float.
+func (s *Span) SetAttribute(key string, val interface{}) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.attributes[key] = val
+}
+
+// SetError marks span as failed, if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.err = err.Error()
+}
+
+// Attributes returns a copy of recorded attributes.
+func (s *Span) Attributes() map[string]interface{} {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result := make(map[string]interface{}, len(s.attributes))
+	for key, val := range s.attributes {
+		result[key] = val
+	}
+	return result
+}
+
+// Error returns error message of failed span, or empty string.
+func (s *Span) Error() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.err
+}
+
+// Finish ends span and sends it to exporter, if sampled. Span must not be changed after that.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	if !s.End.IsZero() {
+		s.mtx.Unlock()
+		return
+	}
+	s.End = time.Now()
+	s.mtx.Unlock()
+
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
+
+// Exporter sends finished spans to a tracing backend. It must not block.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+// Tracer starts root spans of the service.
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer returns a new instance of Tracer. Service name is reported to tracing backend.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// Service returns name of the traced service.
+func (t *Tracer) Service() string {
+	return t.service
+}
+
+// Start starts a span, which is a child of span from context, or of remote parent, or a new root span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+
+	parent := SpanContext{Sampled: true}
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.Context
+	} else if sc, ok := ctx.Value(contextKeyRemote).(SpanContext); ok {
+		parent = sc
+	}
+	s.Context.TraceID = parent.TraceID
+	s.ParentID = parent.SpanID
+	s.Context.Sampled = parent.Sampled
+	if !s.Context.TraceID.IsValid() {
+		_, _ = rand.Read(s.Context.TraceID[:])
+	}
+	_, _ = rand.Read(s.Context.SpanID[:])
+
+	return context.WithValue(ctx, contextKeySpan, s), s
+}
+
+type contextKey int
+
+const (
+	contextKeySpan contextKey = iota
+	contextKeyRemote
+)
+
+// SpanFromContext returns current span, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKeySpan).(*Span)
+	return s
+}
+
+// WithRemoteParent returns context with span context received from another process.
+func WithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKeyRemote, sc)
+}
+
+// StartSpan starts a child of the current span. If there is no current span, nothing is traced
+// and returned span is nil.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	return parent.tracer.Start(ctx, name, kind)
+}
+
+// TraceIDFromContext returns ID of the current trace for logging, or empty string.
+func TraceIDFromContext(ctx context.Context) string {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.Context.TraceID.String()
+	}
+	return ""
+}
@@ -0,0 +1,162 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/otetz/payments/tracing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		Value   string
+		Valid   bool
+		Sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	}
+	for _, item := range cases {
+		sc, err := tracing.ParseTraceparent(item.Value)
+		if (err == nil) != item.Valid {
+			t.Errorf("%q: wrong validity, err: %v", item.Value, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if sc.Sampled != item.Sampled {
+			t.Errorf("%q: wrong sampled flag", item.Value)
+		}
+		if item.Value[:2] == "00" && sc.Traceparent() != item.Value {
+			t.Errorf("%q: wrong traceparent %q", item.Value, sc.Traceparent())
+		}
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	exporter := &tracing.MemoryExporter{}
+	tracer := tracing.NewTracer("payments", exporter)
+
+	failing := tracing.EndpointMiddleware("account.load")(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		_, span := tracing.StartSpan(ctx, "db.query", tracing.KindClient)
+		span.SetAttribute("db.system", "postgresql")
+		span.SetError(errors.New("no rows"))
+		span.Finish()
+		return nil, nil
+	})
+	handler := tracer.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = failing(r.Context(), nil)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/accounts/v1/accounts/alice", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := exporter.Spans()
+	if len(spans) != 3 {
+		t.Fatalf("3 spans expected, got %d", len(spans))
+	}
+	query, endpoint, server := spans[0], spans[1], spans[2]
+	for _, s := range spans {
+		if s.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %q must continue remote trace, got %s", s.Name, s.Context.TraceID)
+		}
+	}
+	if server.ParentID.String() != "00f067aa0ba902b7" || server.Kind != tracing.KindServer {
+		t.Errorf("server span must be a child of remote parent, got %s", server.ParentID)
+	}
+	if endpoint.ParentID != server.Context.SpanID || endpoint.Name != "account.load" {
+		t.Errorf("wrong endpoint span: %+v", endpoint)
+	}
+	if query.ParentID != endpoint.Context.SpanID || query.Error() != "no rows" {
+		t.Errorf("wrong query span: %+v", query)
+	}
+	if server.Attributes()["http.status_code"] != http.StatusInternalServerError || server.Error() == "" {
+		t.Errorf("server span must be failed, got %v", server.Attributes())
+	}
+
+	// Not sampled traces are propagated, but not exported.
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if len(exporter.Spans()) != 3 {
+		t.Errorf("not sampled spans must not be exported")
+	}
+
+	// Without current span nothing is traced.
+	ctx, span := tracing.StartSpan(context.Background(), "background", tracing.KindInternal)
+	if span != nil || tracing.TraceIDFromContext(ctx) != "" {
+		t.Errorf("span without parent must not be started")
+	}
+	span.SetError(errors.New("ignored"))
+	span.Finish()
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var (
+		mtx      sync.Mutex
+		received []map[string]interface{}
+	)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Error(err)
+		}
+		mtx.Lock()
+		received = append(received, body)
+		mtx.Unlock()
+	}))
+	defer collector.Close()
+
+	exporter := tracing.NewOTLPExporter(collector.URL, log.NewNopLogger(), tracing.OTLPInterval(time.Hour))
+	exporter.Start()
+	tracer := tracing.NewTracer("payments", exporter)
+	ctx, parent := tracer.Start(context.Background(), "HTTP GET", tracing.KindServer)
+	_, child := tracing.StartSpan(ctx, "db.query", tracing.KindClient)
+	child.SetAttribute("db.system", "postgresql")
+	child.SetError(errors.New("timeout"))
+	child.Finish()
+	parent.Finish()
+	exporter.Stop()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("one batch expected, got %d", len(received))
+	}
+	rs := received[0]["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	service := rs["resource"].(map[string]interface{})["attributes"].([]interface{})[0].(map[string]interface{})
+	if service["key"] != "service.name" || service["value"].(map[string]interface{})["stringValue"] != "payments" {
+		t.Errorf("wrong resource: %v", service)
+	}
+	spans := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("2 spans expected, got %d", len(spans))
+	}
+	s := spans[0].(map[string]interface{})
+	if s["name"] != "db.query" || s["kind"] != 3.0 || s["traceId"] != parent.Context.TraceID.String() ||
+		s["parentSpanId"] != parent.Context.SpanID.String() {
+		t.Errorf("wrong span: %v", s)
+	}
+	if status := s["status"].(map[string]interface{}); status["code"] != 2.0 || status["message"] != "timeout" {
+		t.Errorf("wrong status: %v", status)
+	}
+}
@@ -0,0 +1,296 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+)
+
+// HTTP headers sent with every delivery.
+const (
+	HeaderEvent     = "X-Payments-Event"
+	HeaderDelivery  = "X-Payments-Delivery"
+	HeaderSignature = "X-Payments-Signature"
+)
+
+// Notifier accepts events happened in the system.
+type Notifier interface {
+	// Notify about an event with specified type and data.
+	Notify(t EventType, data interface{})
+}
+
+// Dispatcher delivers events to subscribers in background, with retries and exponential backoff.
+type Dispatcher struct {
+	webhooks    Repository
+	logger      log.Logger
+	client      *http.Client
+	workers     int
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+
+	queue chan *Delivery
+	quit  chan struct{}
+	wg    sync.WaitGroup
+	once  sync.Once
+}
+
+// DispatcherOption sets an optional parameter for dispatcher.
+type DispatcherOption func(*Dispatcher)
+
+// DispatcherHTTPClient sets HTTP client used for deliveries.
+func DispatcherHTTPClient(c *http.Client) DispatcherOption {
+	return func(d *Dispatcher) { d.client = c }
+}
+
+// DispatcherWorkers sets number of concurrent delivery workers.
+func DispatcherWorkers(n int) DispatcherOption {
+	return func(d *Dispatcher) { d.workers = n }
+}
+
+// DispatcherMaxAttempts sets number of attempts after which delivery goes to the dead letter.
+func DispatcherMaxAttempts(n int) DispatcherOption {
+	return func(d *Dispatcher) { d.maxAttempts = n }
+}
+
+// DispatcherBackoff sets delay before the first retry and upper limit of delay between retries.
+func DispatcherBackoff(min, max time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.minBackoff = min
+		d.maxBackoff = max
+	}
+}
+
+// NewDispatcher returns a new dispatcher. Call Start to begin deliveries.
+func NewDispatcher(webhooks Repository, logger log.Logger, options ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		webhooks:    webhooks,
+		logger:      logger,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		workers:     4,
+		maxAttempts: 8,
+		minBackoff:  time.Second,
+		maxBackoff:  10 * time.Minute,
+		queue:       make(chan *Delivery, 1024),
+		quit:        make(chan struct{}),
+	}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// Start runs delivery workers and resumes pending deliveries left in the repository, e.g. by restart.
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	d.resume()
+}
+
+// resume enqueues pending deliveries of active subscriptions, retries wait for their time.
+func (d *Dispatcher) resume() {
+	ctx := context.Background()
+	subscriptions, err := d.webhooks.FindAllSubscriptions(ctx)
+	if err != nil {
+		_ = d.logger.Log("msg", "pending deliveries are not resumed", "err", err)
+		return
+	}
+	now := time.Now()
+	for _, sub := range subscriptions {
+		deliveries, err := d.webhooks.FindDeliveries(ctx, sub.ID)
+		if err != nil {
+			_ = d.logger.Log("subscription", sub.ID, "msg", "pending deliveries are not resumed", "err", err)
+			continue
+		}
+		for _, delivery := range deliveries {
+			if delivery.Status != Pending {
+				continue
+			}
+			if wait := delivery.NextAttemptAt.Sub(now); wait > 0 {
+				d.schedule(delivery, wait)
+				continue
+			}
+			d.enqueue(delivery)
+		}
+	}
+}
+
+// Stop terminates delivery workers and waits for them. Not delivered events stay pending in the repository.
+func (d *Dispatcher) Stop() {
+	d.once.Do(func() { close(d.quit) })
+	d.wg.Wait()
+}
+
+// Notify creates deliveries of the event for all interested subscribers.
+func (d *Dispatcher) Notify(t EventType, data interface{}) {
+	e := Event{
+		ID:        uuid.New(),
+		Type:      t,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		_ = d.logger.Log("event", e.ID, "type", t, "err", err)
+		return
+	}
+	ctx := context.Background()
+	subscriptions, err := d.webhooks.FindAllSubscriptions(ctx)
+	if err != nil {
+		_ = d.logger.Log("event", e.ID, "type", t, "err", err)
+		return
+	}
+	for _, sub := range subscriptions {
+		if !sub.Accepts(t) {
+			continue
+		}
+		delivery := &Delivery{
+			ID:           uuid.New(),
+			Subscription: sub.ID,
+			Event:        e.ID,
+			EventType:    t,
+			Payload:      string(payload),
+			Status:       Pending,
+			CreatedAt:    e.CreatedAt,
+			UpdatedAt:    e.CreatedAt,
+		}
+		if err := d.webhooks.StoreDelivery(ctx, delivery); err != nil {
+			_ = d.logger.Log("delivery", delivery.ID, "subscription", sub.ID, "err", err)
+			continue
+		}
+		d.enqueue(delivery)
+	}
+}
+
+func (d *Dispatcher) enqueue(delivery *Delivery) {
+	select {
+	case <-d.quit:
+	case d.queue <- delivery:
+	default:
+		// Queue is overloaded, try again later instead of blocking the caller.
+		d.schedule(delivery, d.minBackoff)
+	}
+}
+
+func (d *Dispatcher) schedule(delivery *Delivery, after time.Duration) {
+	time.AfterFunc(after, func() { d.enqueue(delivery) })
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.quit:
+			return
+		case delivery := <-d.queue:
+			d.deliver(delivery)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(delivery *Delivery) {
+	sub, err := d.webhooks.FindSubscription(context.Background(), delivery.Subscription)
+	if err == errs.ErrUnknownSubscription {
+		// Subscription was removed, there is nobody to deliver to.
+		delivery.Status = DeadLetter
+		delivery.LastError = err.Error()
+		d.store(delivery)
+		return
+	}
+	if err != nil {
+		// Storage failed, the attempt is not made and not counted.
+		_ = d.logger.Log("delivery", delivery.ID, "subscription", delivery.Subscription, "err", err)
+		d.schedule(delivery, d.minBackoff)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastStatusCode, err = d.send(sub, delivery)
+	delivery.UpdatedAt = time.Now().UTC()
+	switch {
+	case err == nil:
+		delivery.Status = Delivered
+		delivery.LastError = ""
+		delivery.NextAttemptAt = time.Time{}
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = DeadLetter
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = time.Time{}
+	default:
+		backoff := d.backoff(delivery.Attempts)
+		delivery.LastError = err.Error()
+		delivery.NextAttemptAt = delivery.UpdatedAt.Add(backoff)
+		// Retry is scheduled after the attempt is stored, with a copy, so the next attempt neither races with
+		// storing nor is overwritten by it.
+		d.store(delivery)
+		retry := *delivery
+		d.schedule(&retry, backoff)
+		return
+	}
+	d.store(delivery)
+}
+
+func (d *Dispatcher) send(sub *Subscription, delivery *Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(HeaderEvent, string(delivery.EventType))
+	req.Header.Set(HeaderDelivery, delivery.ID.String())
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns delay before next attempt: minBackoff doubled for every failed attempt, but not more than maxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.minBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.maxBackoff {
+			return d.maxBackoff
+		}
+	}
+	return delay
+}
+
+func (d *Dispatcher) store(delivery *Delivery) {
+	if err := d.webhooks.StoreDelivery(context.Background(), delivery); err != nil {
+		_ = d.logger.Log("delivery", delivery.ID, "subscription", delivery.Subscription, "err", err)
+	}
+}
+
+// Sign returns signature of the payload, which is sent in X-Payments-Signature header.
+// Receiver should compute HMAC-SHA256 of the request body with subscription secret and compare it with the header.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
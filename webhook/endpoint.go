@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+)
+
+type idField struct {
+	ID uuid.UUID `json:"id"`
+}
+
+type errorOnlyResponse struct {
+	Err error `json:"error,omitempty"`
+}
+
+func (r errorOnlyResponse) ErrError() error { return r.Err }
+
+type subscribeRequest struct {
+	URL    string      `json:"url" valid:"url,required"`
+	Events []EventType `json:"events" valid:"-"`
+	Secret string      `json:"secret,omitempty" valid:"stringlength(16|255),optional"`
+}
+
+// subscribeResponse contains secret, because it is the only response where it is shown to the client.
+type subscribeResponse struct {
+	ID        uuid.UUID   `json:"id,omitempty"`
+	URL       string      `json:"url,omitempty"`
+	Events    []EventType `json:"events,omitempty"`
+	Secret    string      `json:"secret,omitempty"`
+	CreatedAt *time.Time  `json:"created_at,omitempty"`
+	Err       error       `json:"error,omitempty"`
+}
+
+func (r subscribeResponse) ErrError() error { return r.Err }
+
+func makeSubscribeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(subscribeRequest)
+		sub, err := s.Subscribe(ctx, req.URL, req.Events, req.Secret)
+		if err != nil {
+			return subscribeResponse{Err: err}, nil
+		}
+		return subscribeResponse{
+			ID:        sub.ID,
+			URL:       sub.URL,
+			Events:    sub.Events,
+			Secret:    sub.Secret,
+			CreatedAt: &sub.CreatedAt,
+		}, nil
+	}
+}
+
+func makeSubscriptionsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		r, err := s.Subscriptions(ctx)
+		if err != nil {
+			return errorOnlyResponse{Err: err}, nil
+		}
+		return r, nil
+	}
+}
+
+func makeUnsubscribeEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(idField)
+		err := s.Unsubscribe(ctx, req.ID)
+		return errorOnlyResponse{Err: err}, nil
+	}
+}
+
+type deliveriesResponse struct {
+	Deliveries []*Delivery `json:"deliveries"`
+	Err        error       `json:"error,omitempty"`
+}
+
+func (r deliveriesResponse) ErrError() error { return r.Err }
+
+func makeDeliveriesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(idField)
+		r, err := s.Deliveries(ctx, req.ID)
+		return deliveriesResponse{Deliveries: r, Err: err}, nil
+	}
+}
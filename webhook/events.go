@@ -0,0 +1,169 @@
+package webhook
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/payment"
+	"github.com/shopspring/decimal"
+)
+
+type accountService struct {
+	notifier Notifier
+	account.Service
+}
+
+// NewAccountService returns an account Service, which notifies about successfully created, updated, deleted and
+// restored accounts.
+func NewAccountService(notifier Notifier, s account.Service) account.Service {
+	return &accountService{notifier, s}
+}
+
+// New is notifying wrapper for new account creation.
+func (s *accountService) New(ctx context.Context, id account.ID, currency account.Currency,
+	balance decimal.Decimal, details account.Details) error {
+	if err := s.Service.New(ctx, id, currency, balance, details); err != nil {
+		return err
+	}
+	// Stored account has status set by the service, e.g. it may be frozen by screening.
+	if a, err := s.Service.Load(ctx, id); err == nil {
+		s.notifier.Notify(AccountCreated, a)
+		return nil
+	}
+	if currency == "" {
+		currency = account.CurrencyUSD
+	}
+	s.notifier.Notify(AccountCreated, &account.Account{
+		ID:          id,
+		Balance:     balance,
+		Currency:    currency,
+		Name:        details.Name,
+		Status:      account.StatusActive,
+		Version:     1,
+		Description: details.Description,
+		Reference:   details.Reference,
+		Metadata:    details.Metadata,
+		Parent:      details.Parent,
+	})
+	return nil
+}
+
+// Delete is notifying wrapper for delete account (mark it deleted).
+func (s *accountService) Delete(ctx context.Context, id account.ID) error {
+	if err := s.Service.Delete(ctx, id); err != nil {
+		return err
+	}
+	s.notifier.Notify(AccountDeleted, map[string]interface{}{"id": id})
+	return nil
+}
+
+// Update is notifying wrapper for update account.
+func (s *accountService) Update(ctx context.Context, id account.ID, patch account.Patch, version int64) (
+	*account.Account, error) {
+	a, err := s.Service.Update(ctx, id, patch, version)
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.Notify(AccountUpdated, a)
+	return a, nil
+}
+
+// Unfreeze is notifying wrapper for unfreeze account, it is notified as update.
+func (s *accountService) Unfreeze(ctx context.Context, id account.ID) (*account.Account, error) {
+	a, err := s.Service.Unfreeze(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.Notify(AccountUpdated, a)
+	return a, nil
+}
+
+// Restore is notifying wrapper for restore deleted account.
+func (s *accountService) Restore(ctx context.Context, id account.ID) (*account.Account, error) {
+	a, err := s.Service.Restore(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s.notifier.Notify(AccountRestored, a)
+	return a, nil
+}
+
+type paymentService struct {
+	notifier Notifier
+	payment.Service
+}
+
+// NewPaymentService returns a payment Service, which notifies about reversed transfers. Registered transfers are
+// notified by observer of NewPaymentObserver.
+func NewPaymentService(notifier Notifier, s payment.Service) payment.Service {
+	return &paymentService{notifier, s}
+}
+
+// Reverse is notifying wrapper for reversal of completed transfer, payload describes it by its outgoing payment.
+func (s *paymentService) Reverse(ctx context.Context, transfer uuid.UUID) ([]*payment.Payment, error) {
+	payments, err := s.Service.Reverse(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+	for _, val := range payments {
+		if val.Direction == payment.Outgoing {
+			s.notifier.Notify(PaymentReversed, transferPayload(val))
+		}
+	}
+	return payments, nil
+}
+
+type paymentObserver struct {
+	notifier Notifier
+}
+
+// NewPaymentObserver returns an observer of payment service, which notifies about registered transfers. Pending
+// transfer is notified, when it is approved.
+func NewPaymentObserver(notifier Notifier) payment.Observer {
+	return &paymentObserver{notifier}
+}
+
+// Observe sends PaymentCreated notification, payload describes transfer by its outgoing payment and has IDs of all
+// its payments.
+func (o *paymentObserver) Observe(t *payment.Transfer) {
+	ids := make([]uuid.UUID, 0, len(t.Payments))
+	for _, val := range t.Payments {
+		ids = append(ids, val.ID)
+	}
+	for _, val := range t.Payments {
+		if val.Direction != payment.Outgoing {
+			continue
+		}
+		payload := transferPayload(val)
+		payload["payments"] = ids
+		if t.Approval != uuid.Nil {
+			payload["approval"] = t.Approval
+		}
+		if val.Description != "" {
+			payload["description"] = val.Description
+		}
+		if val.Reference != "" {
+			payload["reference"] = val.Reference
+		}
+		if len(val.Metadata) > 0 {
+			payload["metadata"] = val.Metadata
+		}
+		o.notifier.Notify(PaymentCreated, payload)
+	}
+}
+
+// transferPayload describes transfer by its outgoing payment, split transfer has splits instead of target account.
+func transferPayload(p *payment.Payment) map[string]interface{} {
+	payload := map[string]interface{}{
+		"transfer": p.Transfer,
+		"from":     p.Account,
+		"amount":   p.Amount,
+	}
+	if p.ToAccount != "" {
+		payload["to"] = p.ToAccount
+	} else {
+		payload["splits"] = p.Splits
+	}
+	return payload
+}
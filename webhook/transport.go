@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+	"github.com/otetz/payments/tracing"
+
+	kitlog "github.com/go-kit/kit/log"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+)
+
+// MakeHandler returns a handler for the webhook service.
+func MakeHandler(s Service, logger kitlog.Logger) http.Handler {
+	opts := []kithttp.ServerOption{
+		kithttp.ServerErrorHandler(errs.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorEncoder(errs.EncodeError),
+		kithttp.ServerBefore(errs.PopulateRequestContext),
+	}
+
+	subscribeHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("webhook.subscribe")(makeSubscribeEndpoint(s)),
+		decodeSubscribeRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	subscriptionsHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("webhook.subscriptions")(makeSubscriptionsEndpoint(s)),
+		decodeSubscriptionsRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	unsubscribeHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("webhook.unsubscribe")(makeUnsubscribeEndpoint(s)),
+		decodeIDRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	deliveriesHandler := kithttp.NewServer(
+		tracing.EndpointMiddleware("webhook.deliveries")(makeDeliveriesEndpoint(s)),
+		decodeIDRequest,
+		errs.EncodeResponse,
+		opts...,
+	)
+
+	router := mux.NewRouter()
+
+	router.Handle("/api/webhooks/v1/subscriptions", subscribeHandler).Methods("POST")
+	router.Handle("/api/webhooks/v1/subscriptions", subscriptionsHandler).Methods("GET")
+	router.Handle("/api/webhooks/v1/subscriptions/{id}", unsubscribeHandler).Methods("DELETE")
+	router.Handle("/api/webhooks/v1/subscriptions/{id}/deliveries", deliveriesHandler).Methods("GET")
+
+	return router
+}
+
+func decodeSubscribeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var body subscribeRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errs.MalformedRequestError{Err: err}
+	}
+	if _, err := govalidator.ValidateStruct(body); err != nil {
+		return nil, errs.ValidationError{Err: err}
+	}
+	return body, nil
+}
+
+func decodeSubscriptionsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeIDRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		return nil, errs.ErrBadRoute
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, errs.ErrUnknownSubscription
+	}
+	return idField{ID: uid}, nil
+}
@@ -0,0 +1,198 @@
+// Package webhook provides outbound notifications about events in the system to subscribed HTTP receivers.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otetz/payments/errs"
+)
+
+// EventType is a kind of event, which subscriber may be interested in.
+type EventType string
+
+const (
+	AccountCreated  EventType = "account.created"
+	AccountDeleted  EventType = "account.deleted"
+	AccountUpdated  EventType = "account.updated"
+	AccountRestored EventType = "account.restored"
+	PaymentCreated  EventType = "payment.created"
+	PaymentReversed EventType = "payment.reversed"
+)
+
+// EventTypes is a list of all event types known by the system.
+var EventTypes = []EventType{AccountCreated, AccountDeleted, AccountUpdated, AccountRestored, PaymentCreated,
+	PaymentReversed}
+
+// DeliveryStatus is a state of the event delivery to the subscriber.
+type DeliveryStatus string
+
+const (
+	// Pending delivery is waiting for the next attempt.
+	Pending DeliveryStatus = "pending"
+	// Delivered means that receiver accepted the event with 2xx status.
+	Delivered DeliveryStatus = "delivered"
+	// DeadLetter means that all delivery attempts are exhausted.
+	DeadLetter DeliveryStatus = "dead_letter"
+)
+
+// Subscription is a receiver of events, registered in the system.
+type Subscription struct {
+	TableName struct{}    `json:"-" sql:"webhook_subscriptions"`
+	ID        uuid.UUID   `json:"id" sql:"id,pk,type:varchar(36)"`
+	URL       string      `json:"url" sql:"url,notnull"`
+	Events    []EventType `json:"events" sql:"events,array,type:'varchar(64)[]'"`
+	Secret    string      `json:"-" sql:"secret,notnull"`
+	CreatedAt time.Time   `json:"created_at" sql:"created_at,notnull"`
+	Deleted   bool        `json:"-" sql:"deleted,notnull"`
+}
+
+// Accepts reports whether subscription is interested in specified event type.
+func (s *Subscription) Accepts(t EventType) bool {
+	for _, val := range s.Events {
+		if val == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a notification about something happened in the system.
+type Event struct {
+	ID        uuid.UUID   `json:"id"`
+	Type      EventType   `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Delivery is an attempt (or series of attempts) to send an event to the subscriber.
+type Delivery struct {
+	TableName      struct{}       `json:"-" sql:"webhook_deliveries"`
+	ID             uuid.UUID      `json:"id" sql:"id,pk,type:varchar(36)"`
+	Subscription   uuid.UUID      `json:"subscription" sql:"subscription,notnull,type:varchar(36)"`
+	Event          uuid.UUID      `json:"event" sql:"event,notnull,type:varchar(36)"`
+	EventType      EventType      `json:"event_type" sql:"event_type,notnull,type:varchar(64)"`
+	Payload        string         `json:"payload" sql:"payload,notnull"`
+	Status         DeliveryStatus `json:"status" sql:"status,notnull,type:varchar(16)"`
+	Attempts       int            `json:"attempts" sql:"attempts,notnull"`
+	LastStatusCode int            `json:"last_status_code,omitempty" sql:"last_status_code"`
+	LastError      string         `json:"last_error,omitempty" sql:"last_error"`
+	NextAttemptAt  time.Time      `json:"next_attempt_at,omitempty" sql:"next_attempt_at"`
+	CreatedAt      time.Time      `json:"created_at" sql:"created_at,notnull"`
+	UpdatedAt      time.Time      `json:"updated_at" sql:"updated_at,notnull"`
+}
+
+// Service is the interface that provides webhook subscriptions management.
+type Service interface {
+	// Subscribe registers a new subscription. Random secret is generated if it is not specified.
+	Subscribe(ctx context.Context, url string, events []EventType, secret string) (*Subscription, error)
+
+	// Subscriptions returns all active subscriptions.
+	Subscriptions(ctx context.Context) ([]*Subscription, error)
+
+	// Unsubscribe removes subscription. Actually mark it as deleted.
+	Unsubscribe(ctx context.Context, id uuid.UUID) error
+
+	// Deliveries returns delivery history of the subscription.
+	Deliveries(ctx context.Context, id uuid.UUID) ([]*Delivery, error)
+}
+
+type service struct {
+	webhooks Repository
+}
+
+// Subscribe registers a new subscription. Random secret is generated if it is not specified.
+func (s *service) Subscribe(ctx context.Context, url string, events []EventType, secret string) (*Subscription,
+	error) {
+	if len(events) == 0 {
+		return nil, errs.ErrInvalidArgument
+	}
+	for _, val := range events {
+		if !known(val) {
+			return nil, errs.ErrUnknownEventType
+		}
+	}
+	if secret == "" {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			return nil, err
+		}
+	}
+	sub := &Subscription{
+		ID:        uuid.New(),
+		URL:       url,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.webhooks.StoreSubscription(ctx, sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Subscriptions returns all active subscriptions.
+func (s *service) Subscriptions(ctx context.Context) ([]*Subscription, error) {
+	return s.webhooks.FindAllSubscriptions(ctx)
+}
+
+// Unsubscribe removes subscription. Actually mark it as deleted.
+func (s *service) Unsubscribe(ctx context.Context, id uuid.UUID) error {
+	return s.webhooks.MarkSubscriptionDeleted(ctx, id)
+}
+
+// Deliveries returns delivery history of the subscription.
+func (s *service) Deliveries(ctx context.Context, id uuid.UUID) ([]*Delivery, error) {
+	if _, err := s.webhooks.FindSubscription(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.webhooks.FindDeliveries(ctx, id)
+}
+
+// NewService creates a webhook service with necessary dependencies.
+func NewService(webhooks Repository) Service {
+	return &service{
+		webhooks: webhooks,
+	}
+}
+
+// Repository interface for subscriptions and deliveries storing.
+type Repository interface {
+	// StoreSubscription saves subscription in the repository.
+	StoreSubscription(ctx context.Context, s *Subscription) error
+
+	// FindSubscription returns active subscription with specified id.
+	FindSubscription(ctx context.Context, id uuid.UUID) (*Subscription, error)
+
+	// FindAllSubscriptions returns all active subscriptions.
+	FindAllSubscriptions(ctx context.Context) ([]*Subscription, error)
+
+	// MarkSubscriptionDeleted is mark as deleted specified subscription.
+	MarkSubscriptionDeleted(ctx context.Context, id uuid.UUID) error
+
+	// StoreDelivery inserts a new delivery or updates existing one.
+	StoreDelivery(ctx context.Context, d *Delivery) error
+
+	// FindDeliveries returns delivery history of the subscription, oldest first.
+	FindDeliveries(ctx context.Context, subscription uuid.UUID) ([]*Delivery, error)
+}
+
+func known(t EventType) bool {
+	for _, val := range EventTypes {
+		if val == t {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,380 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/uuid"
+	"github.com/otetz/payments/account"
+	"github.com/otetz/payments/inmem"
+	"github.com/otetz/payments/payment"
+	"github.com/otetz/payments/webhook"
+	"github.com/shopspring/decimal"
+)
+
+func OK(t *testing.T, err error) {
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+const (
+	EndpointURL = "/api/webhooks/v1/subscriptions"
+)
+
+type receiver struct {
+	mtx      sync.Mutex
+	failures int32
+	events   []webhook.Event
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if atomic.AddInt32(&r.failures, -1) >= 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	var e webhook.Event
+	_ = json.Unmarshal(body, &e)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.events = append(r.events, e)
+	r.headers = append(r.headers, req.Header)
+	r.bodies = append(r.bodies, body)
+}
+
+func (r *receiver) received() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.events)
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func setup(attempts int) (*webhook.Dispatcher, http.Handler) {
+	logger := log.NewLogfmtLogger(os.Stderr)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	webhooks := inmem.NewWebhookRepository()
+	dispatcher := webhook.NewDispatcher(webhooks, logger,
+		webhook.DispatcherMaxAttempts(attempts),
+		webhook.DispatcherBackoff(time.Millisecond, 4*time.Millisecond),
+	)
+	dispatcher.Start()
+	return dispatcher, webhook.MakeHandler(webhook.NewService(webhooks), logger)
+}
+
+func subscribe(t *testing.T, handler http.Handler, payload string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(http.MethodPost, EndpointURL, strings.NewReader(payload))
+	OK(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var result map[string]interface{}
+	OK(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	return rr.Code, result
+}
+
+func deliveries(t *testing.T, handler http.Handler, id string) []*webhook.Delivery {
+	req, err := http.NewRequest(http.MethodGet, EndpointURL+"/"+id+"/deliveries", nil)
+	OK(t, err)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("deliveries returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var result struct {
+		Deliveries []*webhook.Delivery `json:"deliveries"`
+	}
+	OK(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	return result.Deliveries
+}
+
+func TestWebhookApi(t *testing.T) {
+	dispatcher, handler := setup(3)
+	defer dispatcher.Stop()
+
+	t.Run("subscribe:validation:url", func(t *testing.T) {
+		status, result := subscribe(t, handler, `{"url": "not a url", "events": ["account.created"]}`)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+		}
+		params, _ := result["invalid_params"].([]interface{})
+		if result["code"] != "validation_failed" || len(params) != 1 {
+			t.Fatalf("handler returned wrong body: got %v", result)
+		}
+		if name := params[0].(map[string]interface{})["name"]; name != "url" {
+			t.Errorf("handler returned wrong invalid param: got %v want url", name)
+		}
+	})
+
+	t.Run("subscribe:unknown event type", func(t *testing.T) {
+		status, result := subscribe(t, handler, `{"url": "http://localhost/hook", "events": ["account.renamed"]}`)
+		if status != http.StatusUnprocessableEntity {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+		}
+		if result["code"] != "unknown_event_type" {
+			t.Errorf("handler returned wrong body: got %v", result)
+		}
+	})
+
+	status, result := subscribe(t, handler, `{"url": "http://localhost/hook", "events": ["account.created"]}`)
+	if status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	id := result["id"].(string)
+	if secret, _ := result["secret"].(string); len(secret) != 64 {
+		t.Errorf("generated secret expected, got %q", secret)
+	}
+
+	t.Run("list subscriptions", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, EndpointURL, nil)
+		OK(t, err)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var list []map[string]interface{}
+		OK(t, json.Unmarshal(rr.Body.Bytes(), &list))
+		if len(list) != 1 || list[0]["id"] != id {
+			t.Fatalf("unexpected subscriptions: %v", list)
+		}
+		if _, ok := list[0]["secret"]; ok {
+			t.Errorf("secret must not be listed")
+		}
+	})
+
+	t.Run("unsubscribe", func(t *testing.T) {
+		for _, expected := range []int{http.StatusOK, http.StatusNotFound} {
+			req, err := http.NewRequest(http.MethodDelete, EndpointURL+"/"+id, nil)
+			OK(t, err)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != expected {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, expected)
+			}
+		}
+	})
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	dispatcher, handler := setup(3)
+	defer dispatcher.Stop()
+
+	rcv := &receiver{}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	_, result := subscribe(t, handler, `{"url": "`+server.URL+`", "events": ["account.created", "payment.created"], `+
+		`"secret": "0123456789abcdef"}`)
+	id := result["id"].(string)
+
+	accounts := inmem.NewAccountRepository()
+	as := webhook.NewAccountService(dispatcher, account.NewService(accounts))
+	ps := webhook.NewPaymentService(dispatcher, payment.NewService(inmem.NewPaymentRepository(accounts), accounts,
+		payment.ServiceObservers(webhook.NewPaymentObserver(dispatcher))))
+	ctx := context.Background()
+
+	OK(t, as.New(ctx, "alice", account.CurrencyUSD, decimal.NewFromFloat(100), account.Details{}))
+	OK(t, as.New(ctx, "bob", account.CurrencyUSD, decimal.Zero, account.Details{}))
+	if _, err := ps.New(ctx, "alice", decimal.NewFromFloat(12.5), "bob", payment.Details{}); err != nil {
+		t.Fatal(err)
+	}
+	OK(t, as.Delete(ctx, "bob"))
+
+	eventually(t, func() bool { return rcv.received() == 3 })
+
+	rcv.mtx.Lock()
+	defer rcv.mtx.Unlock()
+	for idx, e := range rcv.events {
+		if e.Type == webhook.AccountDeleted {
+			t.Errorf("[%d] not subscribed event delivered", idx)
+		}
+		if e.Type == webhook.PaymentCreated {
+			// Receiver finds the transfer and its payments by IDs of payload.
+			data := e.Data.(map[string]interface{})
+			transfer, err := uuid.Parse(fmt.Sprint(data["transfer"]))
+			OK(t, err)
+			pp, err := ps.Search(ctx, payment.Filter{Transfer: transfer})
+			OK(t, err)
+			var ids []interface{}
+			for _, val := range pp {
+				ids = append(ids, val.ID.String())
+			}
+			if len(pp) != 2 || !reflect.DeepEqual(data["payments"], ids) {
+				t.Errorf("[%d] wrong payments of transfer: got %v want %v", idx, data["payments"], ids)
+			}
+		}
+		if rcv.headers[idx].Get(webhook.HeaderEvent) != string(e.Type) {
+			t.Errorf("[%d] wrong event header: %v", idx, rcv.headers[idx].Get(webhook.HeaderEvent))
+		}
+		if sign := webhook.Sign("0123456789abcdef", rcv.bodies[idx]); rcv.headers[idx].Get(webhook.HeaderSignature) != sign {
+			t.Errorf("[%d] wrong signature: got %v want %v", idx, rcv.headers[idx].Get(webhook.HeaderSignature), sign)
+		}
+	}
+
+	for _, d := range deliveries(t, handler, id) {
+		if d.Status != webhook.Delivered || d.Attempts != 1 || d.LastStatusCode != http.StatusOK {
+			t.Errorf("unexpected delivery: %+v", d)
+		}
+	}
+}
+
+func TestWebhookRestart(t *testing.T) {
+	logger := log.NewNopLogger()
+	webhooks := inmem.NewWebhookRepository()
+	rcv := &receiver{}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	_, result := subscribe(t, webhook.MakeHandler(webhook.NewService(webhooks), logger),
+		`{"url": "`+server.URL+`", "events": ["account.created", "account.deleted"]}`)
+	id, err := uuid.Parse(result["id"].(string))
+	OK(t, err)
+
+	dispatcher := webhook.NewDispatcher(webhooks, logger)
+	dispatcher.Start()
+	dispatcher.Notify(webhook.AccountCreated, map[string]interface{}{"id": "alice"})
+	eventually(t, func() bool { return rcv.received() == 1 })
+	dispatcher.Stop()
+
+	// Event stored while dispatcher is stopped is delivered after restart, delivered one is not sent again.
+	dispatcher.Notify(webhook.AccountDeleted, map[string]interface{}{"id": "alice"})
+	restarted := webhook.NewDispatcher(webhooks, logger)
+	restarted.Start()
+	defer restarted.Stop()
+	eventually(t, func() bool {
+		dd, err := webhooks.FindDeliveries(context.Background(), id)
+		OK(t, err)
+		return len(dd) == 2 && dd[1].Status == webhook.Delivered
+	})
+	rcv.mtx.Lock()
+	defer rcv.mtx.Unlock()
+	if len(rcv.events) != 2 || rcv.events[1].Type != webhook.AccountDeleted {
+		t.Errorf("pending event must be delivered once after restart, got %+v", rcv.events)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	dispatcher, handler := setup(3)
+	defer dispatcher.Stop()
+
+	t.Run("retry then deliver", func(t *testing.T) {
+		rcv := &receiver{failures: 2}
+		server := httptest.NewServer(rcv)
+		defer server.Close()
+
+		_, result := subscribe(t, handler, `{"url": "`+server.URL+`", "events": ["account.deleted"]}`)
+		id := result["id"].(string)
+
+		dispatcher.Notify(webhook.AccountDeleted, map[string]interface{}{"id": "alice"})
+
+		eventually(t, func() bool {
+			dd := deliveries(t, handler, id)
+			return len(dd) == 1 && dd[0].Status == webhook.Delivered
+		})
+		if d := deliveries(t, handler, id)[0]; d.Attempts != 3 {
+			t.Errorf("wrong number of attempts: got %v want %v", d.Attempts, 3)
+		}
+	})
+
+	t.Run("dead letter", func(t *testing.T) {
+		rcv := &receiver{failures: 100}
+		server := httptest.NewServer(rcv)
+		defer server.Close()
+
+		_, result := subscribe(t, handler, `{"url": "`+server.URL+`", "events": ["payment.created"]}`)
+		id := result["id"].(string)
+
+		dispatcher.Notify(webhook.PaymentCreated, map[string]interface{}{"from": "alice", "to": "bob"})
+
+		eventually(t, func() bool {
+			dd := deliveries(t, handler, id)
+			return len(dd) == 1 && dd[0].Status == webhook.DeadLetter
+		})
+		d := deliveries(t, handler, id)[0]
+		if d.Attempts != 3 || d.LastStatusCode != http.StatusServiceUnavailable || d.LastError == "" {
+			t.Errorf("unexpected dead letter: %+v", d)
+		}
+		if rcv.received() != 0 {
+			t.Errorf("nothing should be received")
+		}
+	})
+}
+
+func TestCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s := webhook.NewService(inmem.NewWebhookRepository())
+	_, err := s.Subscribe(ctx, "http://localhost", []webhook.EventType{webhook.AccountCreated}, "")
+	if err != context.Canceled {
+		t.Errorf("subscribe must fail with cancelled context, got %v", err)
+	}
+	if _, err := s.Subscriptions(ctx); err != context.Canceled {
+		t.Errorf("subscriptions must fail with cancelled context, got %v", err)
+	}
+}
+
+// slowStore records attempts of stored deliveries, storing takes longer than backoff.
+type slowStore struct {
+	webhook.Repository
+	mtx      sync.Mutex
+	attempts []int
+}
+
+func (s *slowStore) StoreDelivery(ctx context.Context, d *webhook.Delivery) error {
+	time.Sleep(5 * time.Millisecond)
+	s.mtx.Lock()
+	s.attempts = append(s.attempts, d.Attempts)
+	s.mtx.Unlock()
+	return s.Repository.StoreDelivery(ctx, d)
+}
+
+func TestRetryAfterStore(t *testing.T) {
+	rcv := &receiver{failures: 2}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	store := &slowStore{Repository: inmem.NewWebhookRepository()}
+	s := webhook.NewService(store)
+	sub, err := s.Subscribe(context.Background(), server.URL, []webhook.EventType{webhook.AccountDeleted}, "")
+	OK(t, err)
+	dispatcher := webhook.NewDispatcher(store, log.NewNopLogger(), webhook.DispatcherMaxAttempts(3),
+		webhook.DispatcherBackoff(time.Millisecond, time.Millisecond))
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	dispatcher.Notify(webhook.AccountDeleted, map[string]interface{}{"id": "alice"})
+	eventually(t, func() bool {
+		dd, err := s.Deliveries(context.Background(), sub.ID)
+		OK(t, err)
+		return len(dd) == 1 && dd[0].Status == webhook.Delivered
+	})
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+	if want := []int{0, 1, 2, 3}; !reflect.DeepEqual(store.attempts, want) {
+		t.Errorf("attempts must be stored in order: got %v want %v", store.attempts, want)
+	}
+}